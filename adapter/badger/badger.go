@@ -0,0 +1,108 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package badger
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	cache "github.com/rishikesh-parspec/echo-http-cache"
+)
+
+// Adapter is the Badger adapter data structure. The responses are stored
+// in an embedded Badger database, so they survive restarts.
+type Adapter struct {
+	db *badger.DB
+}
+
+// Get implements the cache Adapter interface Get method. Badger doesn't
+// return the expired responses, so they are misses.
+func (a *Adapter) Get(key uint64) ([]byte, bool) {
+	var value []byte
+	err := a.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(keyBytes(key))
+		if err != nil {
+			return err
+		}
+		value, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// Set implements the cache Adapter interface Set method. The response
+// expires with Badger's TTL, which has a one second resolution, so it may
+// expire up to one second before the expiration.
+func (a *Adapter) Set(key uint64, response []byte, expiration time.Time) {
+	ttl := time.Until(expiration)
+	if ttl <= 0 {
+		a.Release(key)
+		return
+	}
+	a.db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(badger.NewEntry(keyBytes(key), response).WithTTL(ttl))
+	})
+}
+
+// Release implements the cache Adapter interface Release method.
+func (a *Adapter) Release(key uint64) {
+	a.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(keyBytes(key))
+	})
+}
+
+// Purge implements the cache Adapter interface Purge method. All the keys
+// of the database are dropped, so it must not be shared with other data.
+func (a *Adapter) Purge() error {
+	return a.db.DropAll()
+}
+
+// Close closes the database. The adapter must not be used afterwards.
+func (a *Adapter) Close() error {
+	return a.db.Close()
+}
+
+func keyBytes(key uint64) []byte {
+	return []byte(cache.KeyAsString(key))
+}
+
+// NewAdapter initializes Badger adapter opening, or creating, the database
+// in the given directory with the given options, e.g.
+// badger.DefaultOptions(""). Their Dir and ValueDir are set to the
+// directory.
+func NewAdapter(dir string, opt badger.Options) (cache.Adapter, error) {
+	if dir == "" {
+		return nil, errors.New("badger adapter directory is not set")
+	}
+	db, err := badger.Open(opt.WithDir(dir).WithValueDir(dir))
+	if err != nil {
+		return nil, err
+	}
+	return &Adapter{db: db}, nil
+}
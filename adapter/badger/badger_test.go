@@ -0,0 +1,160 @@
+package badger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+)
+
+func newAdapter(t *testing.T, dir string) *Adapter {
+	a, err := NewAdapter(dir, badger.DefaultOptions("").WithLogger(nil))
+	if err != nil {
+		t.Fatalf("NewAdapter() error = %v", err)
+	}
+	return a.(*Adapter)
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "badger-adapter")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir() error = %v", err)
+	}
+	return dir
+}
+
+func TestGet(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	a := newAdapter(t, dir)
+	defer a.Close()
+	a.Set(1, []byte("value 1"), time.Now().Add(1*time.Minute))
+	a.Set(2, []byte("value 2"), time.Now().Add(1*time.Minute))
+	a.Set(2, []byte("value 2"), time.Now().Add(-1*time.Minute))
+
+	tests := []struct {
+		name string
+		key  uint64
+		want []byte
+		ok   bool
+	}{
+		{
+			"returns right response",
+			1,
+			[]byte("value 1"),
+			true,
+		},
+		{
+			"expired response is a miss",
+			2,
+			nil,
+			false,
+		},
+		{
+			"key does not exist",
+			3,
+			nil,
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, ok := a.Get(tt.key)
+			if ok != tt.ok {
+				t.Errorf("badger.Get() ok = %v, tt.ok %v", ok, tt.ok)
+			}
+			if !bytes.Equal(b, tt.want) {
+				t.Errorf("badger.Get() = %s, want %s", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestSet(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	a := newAdapter(t, dir)
+	a.Set(1, []byte("value 1"), time.Now().Add(1*time.Minute))
+	a.Set(1, []byte("value 2"), time.Now().Add(1*time.Minute))
+
+	if b, ok := a.Get(1); !ok || string(b) != "value 2" {
+		t.Errorf("badger.Get() = %s, %v, want value 2, true", b, ok)
+	}
+
+	if err := a.Close(); err != nil {
+		t.Fatalf("badger.Close() error = %v", err)
+	}
+	a = newAdapter(t, dir)
+	defer a.Close()
+	if b, ok := a.Get(1); !ok || string(b) != "value 2" {
+		t.Errorf("badger.Get() after reopening = %s, %v, want value 2, true", b, ok)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	a := newAdapter(t, dir)
+	defer a.Close()
+	a.Set(1, []byte("value 1"), time.Now().Add(1*time.Minute))
+
+	a.Release(1)
+	if _, ok := a.Get(1); ok {
+		t.Errorf("badger.Get() ok = true after Release()")
+	}
+	a.Release(2)
+}
+
+func TestPurge(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	a := newAdapter(t, dir)
+	defer a.Close()
+	a.Set(1, []byte("value 1"), time.Now().Add(1*time.Minute))
+	a.Set(2, []byte("value 2"), time.Now().Add(1*time.Minute))
+
+	if err := a.Purge(); err != nil {
+		t.Fatalf("badger.Purge() error = %v", err)
+	}
+	for _, key := range []uint64{1, 2} {
+		if _, ok := a.Get(key); ok {
+			t.Errorf("badger.Get(%v) ok = true after Purge()", key)
+		}
+	}
+}
+
+func TestNewAdapter(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		dir     string
+		wantErr bool
+	}{
+		{
+			"returns new adapter",
+			dir,
+			false,
+		},
+		{
+			"returns error without directory",
+			"",
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewAdapter(tt.dir, badger.DefaultOptions("").WithLogger(nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewAdapter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				got.(*Adapter).Close()
+			}
+		})
+	}
+}
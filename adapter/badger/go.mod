@@ -0,0 +1,10 @@
+module github.com/rishikesh-parspec/echo-http-cache/adapter/badger
+
+go 1.14
+
+require (
+	github.com/dgraph-io/badger/v2 v2.2007.2
+	github.com/rishikesh-parspec/echo-http-cache v0.0.0
+)
+
+replace github.com/rishikesh-parspec/echo-http-cache => ../../
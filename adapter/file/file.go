@@ -0,0 +1,216 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package file
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cache "github.com/rishikesh-parspec/echo-http-cache"
+)
+
+// tempPrefix is the name prefix of the files being written, renamed to
+// their key once complete.
+const tempPrefix = ".tmp-"
+
+// Response is the cached response data structure stored in a file.
+type Response struct {
+	// Value is the cached response value.
+	Value []byte
+
+	// Expiration is the cached response expiration date.
+	Expiration time.Time
+}
+
+// Adapter is the file adapter data structure. Each response is stored in
+// its own file of the directory, named by its key.
+type Adapter struct {
+	dir  string
+	perm os.FileMode
+
+	// locks serialize the accesses to the file of a key, hashed to one of
+	// them.
+	locks [64]sync.Mutex
+}
+
+// AdapterOptions is used to set Adapter settings.
+type AdapterOptions func(a *Adapter) error
+
+// Get implements the cache Adapter interface Get method. Expired responses
+// are deleted.
+func (a *Adapter) Get(key uint64) ([]byte, bool) {
+	lock := a.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	b, err := ioutil.ReadFile(a.path(key))
+	if err != nil {
+		return nil, false
+	}
+	response := BytesToResponse(b)
+	if !response.Expiration.After(time.Now()) {
+		os.Remove(a.path(key))
+		return nil, false
+	}
+	return response.Value, true
+}
+
+// Set implements the cache Adapter interface Set method. The response is
+// written to a temporary file first, then renamed, so it is never read
+// partially written.
+func (a *Adapter) Set(key uint64, response []byte, expiration time.Time) {
+	lock := a.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	f, err := ioutil.TempFile(a.dir, tempPrefix)
+	if err != nil {
+		return
+	}
+	_, err = f.Write(Response{Value: response, Expiration: expiration}.Bytes())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), a.perm)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), a.path(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// Release implements the cache Adapter interface Release method.
+func (a *Adapter) Release(key uint64) {
+	lock := a.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	os.Remove(a.path(key))
+}
+
+// Purge implements the cache Adapter interface Purge method. Only the
+// files of the adapter are removed from the directory.
+func (a *Adapter) Purge() error {
+	infos, err := ioutil.ReadDir(a.dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if !info.Mode().IsRegular() || (!isKeyName(name) && !strings.HasPrefix(name, tempPrefix)) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(a.dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// path returns the path of the file of the key. The file name is the key
+// in base 36, made of digits and letters only, so it can't escape the
+// directory.
+func (a *Adapter) path(key uint64) string {
+	return filepath.Join(a.dir, cache.KeyAsString(key))
+}
+
+func (a *Adapter) lock(key uint64) *sync.Mutex {
+	return &a.locks[key%uint64(len(a.locks))]
+}
+
+// isKeyName reports whether the file name is the name of a key file.
+func isKeyName(name string) bool {
+	key, err := strconv.ParseUint(name, 36, 64)
+	return err == nil && cache.KeyAsString(key) == name
+}
+
+// BytesToResponse converts bytes array into Response data structure.
+func BytesToResponse(b []byte) Response {
+	var r Response
+	dec := gob.NewDecoder(bytes.NewReader(b))
+	dec.Decode(&r)
+
+	return r
+}
+
+// Bytes converts Response data structure into bytes array.
+func (r Response) Bytes() []byte {
+	var b bytes.Buffer
+	enc := gob.NewEncoder(&b)
+	enc.Encode(&r)
+
+	return b.Bytes()
+}
+
+// NewAdapter initializes file adapter storing the responses in the given
+// directory, created if it doesn't exist.
+func NewAdapter(dir string, opts ...AdapterOptions) (cache.Adapter, error) {
+	if dir == "" {
+		return nil, errors.New("file adapter directory is not set")
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	a := &Adapter{
+		dir:  dir,
+		perm: 0600,
+	}
+
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(a.dir, 0700); err != nil {
+		return nil, fmt.Errorf("file adapter directory: %v", err)
+	}
+	return a, nil
+}
+
+// AdapterWithFileMode sets the permissions of the response files, 0600 by
+// default.
+func AdapterWithFileMode(perm os.FileMode) AdapterOptions {
+	return func(a *Adapter) error {
+		if perm&0600 != 0600 {
+			return fmt.Errorf("file adapter file mode %v must allow the owner to read and write", perm)
+		}
+		a.perm = perm
+		return nil
+	}
+}
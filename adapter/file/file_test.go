@@ -0,0 +1,244 @@
+package file
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	cache "github.com/rishikesh-parspec/echo-http-cache"
+)
+
+func newAdapter(t *testing.T) (*Adapter, string) {
+	dir, err := ioutil.TempDir("", "file-adapter")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir() error = %v", err)
+	}
+	a, err := NewAdapter(dir)
+	if err != nil {
+		t.Fatalf("NewAdapter() error = %v", err)
+	}
+	return a.(*Adapter), dir
+}
+
+func TestGet(t *testing.T) {
+	a, dir := newAdapter(t)
+	defer os.RemoveAll(dir)
+	a.Set(1, []byte("value 1"), time.Now().Add(1*time.Minute))
+	a.Set(2, []byte("value 2"), time.Now().Add(-1*time.Minute))
+
+	tests := []struct {
+		name        string
+		key         uint64
+		want        []byte
+		ok          bool
+		wantRemoved bool
+	}{
+		{
+			"returns right response",
+			1,
+			[]byte("value 1"),
+			true,
+			false,
+		},
+		{
+			"removes expired response",
+			2,
+			nil,
+			false,
+			true,
+		},
+		{
+			"key does not exist",
+			3,
+			nil,
+			false,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, ok := a.Get(tt.key)
+			if ok != tt.ok {
+				t.Errorf("file.Get() ok = %v, tt.ok %v", ok, tt.ok)
+			}
+			if !bytes.Equal(b, tt.want) {
+				t.Errorf("file.Get() = %s, want %s", b, tt.want)
+			}
+			_, err := os.Stat(filepath.Join(dir, cache.KeyAsString(tt.key)))
+			if removed := os.IsNotExist(err); removed != tt.wantRemoved {
+				t.Errorf("file.Get() file removed = %v, want %v", removed, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestSet(t *testing.T) {
+	a, dir := newAdapter(t)
+	defer os.RemoveAll(dir)
+	a.Set(1, []byte("value 1"), time.Now().Add(1*time.Minute))
+	a.Set(1, []byte("value 2"), time.Now().Add(1*time.Minute))
+
+	if b, ok := a.Get(1); !ok || string(b) != "value 2" {
+		t.Errorf("file.Get() = %s, %v, want value 2, true", b, ok)
+	}
+	info, err := os.Stat(filepath.Join(dir, cache.KeyAsString(1)))
+	if err != nil {
+		t.Fatalf("os.Stat() error = %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("file.Set() file mode = %v, want %v", perm, os.FileMode(0600))
+	}
+}
+
+func TestSetConcurrently(t *testing.T) {
+	a, dir := newAdapter(t)
+	defer os.RemoveAll(dir)
+	values := [][]byte{
+		bytes.Repeat([]byte("a"), 1<<16),
+		bytes.Repeat([]byte("b"), 1<<16),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(value []byte) {
+			defer wg.Done()
+			a.Set(1, value, time.Now().Add(1*time.Minute))
+		}(values[i%2])
+		go func() {
+			defer wg.Done()
+			if b, ok := a.Get(1); ok && !bytes.Equal(b, values[0]) && !bytes.Equal(b, values[1]) {
+				t.Errorf("file.Get() returned a partially written response of %v bytes", len(b))
+			}
+		}()
+	}
+	wg.Wait()
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ioutil.ReadDir() error = %v", err)
+	}
+	if len(infos) != 1 {
+		t.Errorf("file.Set() left %v files, want 1", len(infos))
+	}
+}
+
+func TestRelease(t *testing.T) {
+	a, dir := newAdapter(t)
+	defer os.RemoveAll(dir)
+	a.Set(1, []byte("value 1"), time.Now().Add(1*time.Minute))
+
+	a.Release(1)
+	if _, ok := a.Get(1); ok {
+		t.Errorf("file.Get() ok = true after Release()")
+	}
+	a.Release(2)
+}
+
+func TestPurge(t *testing.T) {
+	a, dir := newAdapter(t)
+	defer os.RemoveAll(dir)
+	a.Set(1, []byte("value 1"), time.Now().Add(1*time.Minute))
+	a.Set(2, []byte("value 2"), time.Now().Add(1*time.Minute))
+	other := filepath.Join(dir, "other.txt")
+	if err := ioutil.WriteFile(other, []byte("other"), 0600); err != nil {
+		t.Fatalf("ioutil.WriteFile() error = %v", err)
+	}
+
+	if err := a.Purge(); err != nil {
+		t.Fatalf("file.Purge() error = %v", err)
+	}
+	for _, key := range []uint64{1, 2} {
+		if _, ok := a.Get(key); ok {
+			t.Errorf("file.Get(%v) ok = true after Purge()", key)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("file.Purge() removed a file of another application, os.Stat() error = %v", err)
+	}
+}
+
+func TestIsKeyName(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want bool
+	}{
+		{"key name", cache.KeyAsString(14974843192121052621), true},
+		{"key name with leading zero", "0" + cache.KeyAsString(1), false},
+		{"parent directory", "..", false},
+		{"path traversal", "../1", false},
+		{"other file", "other.txt", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isKeyName(tt.file); got != tt.want {
+				t.Errorf("isKeyName(%q) = %v, want %v", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAdapter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-adapter")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		dir     string
+		opts    []AdapterOptions
+		wantErr bool
+	}{
+		{
+			"returns new adapter",
+			dir,
+			nil,
+			false,
+		},
+		{
+			"creates the directory",
+			filepath.Join(dir, "responses"),
+			nil,
+			false,
+		},
+		{
+			"returns new adapter with file mode",
+			dir,
+			[]AdapterOptions{AdapterWithFileMode(0640)},
+			false,
+		},
+		{
+			"returns error without directory",
+			"",
+			nil,
+			true,
+		},
+		{
+			"returns error with unreadable file mode",
+			dir,
+			[]AdapterOptions{AdapterWithFileMode(0200)},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewAdapter(tt.dir, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewAdapter() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				if info, err := os.Stat(tt.dir); err != nil || !info.IsDir() {
+					t.Errorf("NewAdapter() did not create the directory %v", tt.dir)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/rishikesh-parspec/echo-http-cache/adapter/memcached
+
+go 1.14
+
+require (
+	github.com/bradfitz/gomemcache v0.0.0-20190913173617-a41fca850d0b
+	github.com/rishikesh-parspec/echo-http-cache v0.0.0
+)
+
+replace github.com/rishikesh-parspec/echo-http-cache => ../../
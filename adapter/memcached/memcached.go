@@ -0,0 +1,94 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package memcached
+
+import (
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	cache "github.com/rishikesh-parspec/echo-http-cache"
+)
+
+// maxRelativeExpiration is the longest expiration Memcached takes as a
+// number of seconds, longer ones are taken as Unix times.
+const maxRelativeExpiration = 30 * 24 * time.Hour
+
+// Adapter is the Memcached adapter data structure.
+type Adapter struct {
+	client *memcache.Client
+}
+
+// Get implements the cache Adapter interface Get method.
+func (a *Adapter) Get(key uint64) ([]byte, bool) {
+	item, err := a.client.Get(cache.KeyAsString(key))
+	if err != nil {
+		return nil, false
+	}
+	return item.Value, true
+}
+
+// Set implements the cache Adapter interface Set method.
+func (a *Adapter) Set(key uint64, response []byte, expiration time.Time) {
+	a.client.Set(&memcache.Item{
+		Key:        cache.KeyAsString(key),
+		Value:      response,
+		Expiration: itemExpiration(expiration, time.Now()),
+	})
+}
+
+// Release implements the cache Adapter interface Release method.
+func (a *Adapter) Release(key uint64) {
+	a.client.Delete(cache.KeyAsString(key))
+}
+
+// Purge implements the cache Adapter interface Purge method. Memcached
+// has no key listing, so every key of the servers is flushed, including
+// the ones of other applications.
+func (a *Adapter) Purge() error {
+	return a.client.FlushAll()
+}
+
+// itemExpiration returns the Memcached expiration of an item expiring at
+// the given date: the seconds left, at least one as zero never expires, or
+// the Unix time beyond 30 days.
+func itemExpiration(expiration, now time.Time) int32 {
+	ttl := expiration.Sub(now)
+	if ttl > maxRelativeExpiration {
+		return int32(expiration.Unix())
+	}
+	seconds := int32(ttl / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// NewAdapter initializes Memcached adapter with the addresses of the
+// servers, the keys being spread over them.
+func NewAdapter(servers ...string) cache.Adapter {
+	return &Adapter{
+		client: memcache.New(servers...),
+	}
+}
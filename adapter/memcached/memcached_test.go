@@ -0,0 +1,186 @@
+package memcached
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// server is a Memcached server speaking the commands used by the adapter
+// with the text protocol, recording the expiration of the items.
+type server struct {
+	listener net.Listener
+
+	sync.Mutex
+	values      map[string][]byte
+	expirations map[string]int32
+}
+
+func newServer(t *testing.T) *server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	s := &server{
+		listener:    listener,
+		values:      map[string][]byte{},
+		expirations: map[string]int32{},
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *server) serve(conn net.Conn) {
+	defer conn.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return
+		}
+		s.Lock()
+		switch fields[0] {
+		case "gets":
+			for _, key := range fields[1:] {
+				if value, ok := s.values[key]; ok {
+					fmt.Fprintf(rw, "VALUE %s 0 %d 1\r\n%s\r\n", key, len(value), value)
+				}
+			}
+			fmt.Fprint(rw, "END\r\n")
+		case "set":
+			exp, _ := strconv.Atoi(fields[3])
+			size, _ := strconv.Atoi(fields[4])
+			value := make([]byte, size+2)
+			if _, err := io.ReadFull(rw, value); err != nil {
+				s.Unlock()
+				return
+			}
+			s.values[fields[1]] = value[:size]
+			s.expirations[fields[1]] = int32(exp)
+			fmt.Fprint(rw, "STORED\r\n")
+		case "delete":
+			if _, ok := s.values[fields[1]]; ok {
+				delete(s.values, fields[1])
+				fmt.Fprint(rw, "DELETED\r\n")
+			} else {
+				fmt.Fprint(rw, "NOT_FOUND\r\n")
+			}
+		case "flush_all":
+			s.values = map[string][]byte{}
+			fmt.Fprint(rw, "OK\r\n")
+		default:
+			fmt.Fprint(rw, "ERROR\r\n")
+		}
+		s.Unlock()
+		rw.Flush()
+	}
+}
+
+func (s *server) Close() {
+	s.listener.Close()
+}
+
+func TestAdapter(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+	a := NewAdapter(s.listener.Addr().String())
+
+	a.Set(1, []byte("value 1"), time.Now().Add(1*time.Minute))
+	a.Set(2, []byte("value 2"), time.Now().Add(1*time.Minute))
+	if b, ok := a.Get(1); !ok || string(b) != "value 1" {
+		t.Errorf("memcached.Get() = %s, %v, want value 1, true", b, ok)
+	}
+	if _, ok := a.Get(3); ok {
+		t.Error("memcached.Get() ok = true for a key never set")
+	}
+
+	a.Release(1)
+	if _, ok := a.Get(1); ok {
+		t.Error("memcached.Get() ok = true after Release()")
+	}
+	a.Release(3)
+	if b, ok := a.Get(2); !ok || string(b) != "value 2" {
+		t.Errorf("memcached.Get() = %s, %v, want value 2, true", b, ok)
+	}
+
+	if err := a.Purge(); err != nil {
+		t.Fatalf("memcached.Purge() error = %v", err)
+	}
+	if _, ok := a.Get(2); ok {
+		t.Error("memcached.Get() ok = true after Purge()")
+	}
+}
+
+func TestAdapterSetExpiration(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+	a := NewAdapter(s.listener.Addr().String())
+
+	a.Set(1, []byte("value 1"), time.Now().Add(1*time.Minute+500*time.Millisecond))
+	s.Lock()
+	defer s.Unlock()
+	if got := s.expirations["1"]; got != 60 {
+		t.Errorf("memcached.Set() expiration = %v, want 60", got)
+	}
+}
+
+func TestItemExpiration(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		expiration time.Time
+		want       int32
+	}{
+		{
+			"seconds left",
+			now.Add(1 * time.Minute),
+			60,
+		},
+		{
+			"at least a second",
+			now.Add(100 * time.Millisecond),
+			1,
+		},
+		{
+			"already expired",
+			now.Add(-1 * time.Minute),
+			1,
+		},
+		{
+			"30 days as seconds",
+			now.Add(30 * 24 * time.Hour),
+			30 * 24 * 3600,
+		},
+		{
+			"Unix time beyond 30 days",
+			now.Add(31 * 24 * time.Hour),
+			int32(now.Add(31 * 24 * time.Hour).Unix()),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := itemExpiration(tt.expiration, now); got != tt.want {
+				t.Errorf("itemExpiration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
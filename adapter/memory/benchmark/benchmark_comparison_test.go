@@ -1,13 +1,16 @@
 package main
 
 import (
+	"fmt"
 	"math/rand"
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/allegro/bigcache"
 	cache "github.com/rishikesh-parspec/echo-http-cache"
 	"github.com/rishikesh-parspec/echo-http-cache/adapter/memory"
-	"github.com/allegro/bigcache"
 )
 
 const maxEntrySize = 256
@@ -22,7 +25,7 @@ func BenchmarkHTTPCacheMamoryAdapterSet(b *testing.B) {
 func BenchmarkBigCacheSet(b *testing.B) {
 	cache := initBigCache(b.N)
 	for i := 0; i < b.N; i++ {
-		cache.Set(string(i), value())
+		cache.Set(strconv.Itoa(i), value())
 	}
 }
 
@@ -43,12 +46,12 @@ func BenchmarkBigCacheGet(b *testing.B) {
 	b.StopTimer()
 	cache := initBigCache(b.N)
 	for i := 0; i < b.N; i++ {
-		cache.Set(string(i), value())
+		cache.Set(strconv.Itoa(i), value())
 	}
 
 	b.StartTimer()
 	for i := 0; i < b.N; i++ {
-		cache.Get(string(i))
+		cache.Get(strconv.Itoa(i))
 	}
 }
 
@@ -74,7 +77,7 @@ func BenchmarkBigCacheSetParallel(b *testing.B) {
 		id := rand.Intn(1000)
 		counter := 0
 		for pb.Next() {
-			cache.Set(string(parallelKey(id, counter)), value())
+			cache.Set(strconv.FormatUint(parallelKey(id, counter), 10), value())
 			counter = counter + 1
 		}
 	})
@@ -101,19 +104,72 @@ func BenchmarkBigCacheGetParallel(b *testing.B) {
 	b.StopTimer()
 	cache := initBigCache(b.N)
 	for i := 0; i < b.N; i++ {
-		cache.Set(string(i), value())
+		cache.Set(strconv.Itoa(i), value())
 	}
 
 	b.StartTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		counter := 0
 		for pb.Next() {
-			cache.Get(string(counter))
+			cache.Get(strconv.Itoa(counter))
 			counter = counter + 1
 		}
 	})
 }
 
+func BenchmarkHTTPCacheMemoryAdapterSetAtCapacity(b *testing.B) {
+	const entries = 100000
+	for _, algorithm := range []memory.Algorithm{memory.LRU, memory.LFU} {
+		b.Run(string(algorithm), func(b *testing.B) {
+			b.StopTimer()
+			cache, _ := memory.NewAdapter(
+				memory.AdapterWithCapacity(entries),
+				memory.AdapterWithAlgorithm(algorithm),
+			)
+			expiration := time.Now().Add(1 * time.Minute)
+			for i := 0; i < entries; i++ {
+				cache.Set(uint64(i), value(), expiration)
+			}
+
+			b.StartTimer()
+			for i := 0; i < b.N; i++ {
+				cache.Set(uint64(entries+i), value(), expiration)
+			}
+		})
+	}
+}
+
+func BenchmarkHTTPCacheMemoryAdapterShardsParallel(b *testing.B) {
+	const entries = 100000
+	for _, shards := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			b.StopTimer()
+			cache, _ := memory.NewAdapter(
+				memory.AdapterWithCapacity(entries),
+				memory.AdapterWithAlgorithm(memory.LRU),
+				memory.AdapterWithShards(shards),
+			)
+			expiration := time.Now().Add(1 * time.Minute)
+			for i := 0; i < entries; i++ {
+				cache.Set(uint64(i), value(), expiration)
+			}
+			var counter uint64
+
+			b.StartTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					key := atomic.AddUint64(&counter, 1) % entries
+					if key%10 == 0 {
+						cache.Set(key, value(), expiration)
+					} else {
+						cache.Get(key)
+					}
+				}
+			})
+		})
+	}
+}
+
 func value() []byte {
 	return make([]byte, 100)
 }
@@ -0,0 +1,114 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package memory
+
+// The LRU and MRU algorithms keep the entries in an intrusive doubly
+// linked list, most recently accessed first, so the response to evict is
+// found at one of its ends without scanning the store. The list has its
+// own mutex, for Get to move the accessed entry to the front while only
+// holding the read lock of the store. It is always taken after the store
+// mutex.
+
+// ordered reports whether the caching algorithm evicts from the ends of
+// the entries list.
+func (a *Adapter) ordered() bool {
+	return a.algorithm == LRU || a.algorithm == MRU
+}
+
+// lazyInitList initializes the empty list. The list mutex must be held.
+func (a *Adapter) lazyInitList() {
+	if a.root.next == nil {
+		a.root.next = &a.root
+		a.root.prev = &a.root
+	}
+}
+
+// pushFront inserts the entry at the front of the list. The list mutex
+// must be held.
+func (a *Adapter) pushFront(e *entry) {
+	a.lazyInitList()
+	e.prev = &a.root
+	e.next = a.root.next
+	a.root.next.prev = e
+	a.root.next = e
+}
+
+// unlink removes the entry from the list, if it is in it. The list mutex
+// must be held.
+func (a *Adapter) unlink(e *entry) {
+	if e.next == nil {
+		return
+	}
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	e.prev = nil
+	e.next = nil
+}
+
+// touch moves the accessed entry to the front of the list, unless it was
+// released since it was read.
+func (a *Adapter) touch(e *entry) {
+	a.listMutex.Lock()
+	if e.next != nil {
+		a.unlink(e)
+		a.pushFront(e)
+	}
+	a.listMutex.Unlock()
+}
+
+// victims returns up to n keys satisfying the filter, or all of them if
+// it is nil, in eviction order: from the back of the list for LRU, from
+// the front for MRU.
+func (a *Adapter) victims(n int, filter func(key uint64) bool) []uint64 {
+	a.listMutex.Lock()
+	defer a.listMutex.Unlock()
+	a.lazyInitList()
+
+	keys := make([]uint64, 0, n)
+	next := func(e *entry) *entry { return e.prev }
+	if a.algorithm == MRU {
+		next = func(e *entry) *entry { return e.next }
+	}
+	for e := next(&a.root); e != &a.root && len(keys) < n; e = next(e) {
+		if filter == nil || filter(e.key) {
+			keys = append(keys, e.key)
+		}
+	}
+	return keys
+}
+
+// resetList empties the list, unlinking every entry for a concurrent
+// touch not to move it to the new list. The list mutex must be held.
+func (a *Adapter) resetList() {
+	a.lazyInitList()
+	for e := a.root.next; e != &a.root; {
+		next := e.next
+		e.prev = nil
+		e.next = nil
+		e = next
+	}
+	a.root.next = &a.root
+	a.root.prev = &a.root
+}
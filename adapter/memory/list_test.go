@@ -0,0 +1,80 @@
+package memory
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// listLength returns the length of the entries list, checking every entry
+// is the stored one.
+func (a *Adapter) listLength(t *testing.T) int {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	a.listMutex.Lock()
+	defer a.listMutex.Unlock()
+	a.lazyInitList()
+
+	length := 0
+	for e := a.root.next; e != &a.root; e = e.next {
+		if a.store[e.key] != e {
+			t.Errorf("listed entry of key %v is not the stored one", e.key)
+		}
+		length++
+	}
+	return length
+}
+
+func TestVictims(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm Algorithm
+		want      []uint64
+	}{
+		{
+			"lru returns least recently used first",
+			LRU,
+			[]uint64{3, 5, 1, 4, 2},
+		},
+		{
+			"mru returns most recently used first",
+			MRU,
+			[]uint64{2, 4, 1, 5, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewAdapter(
+				AdapterWithCapacity(10),
+				AdapterWithAlgorithm(tt.algorithm),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			adapter := a.(*Adapter)
+			expiration := time.Now().Add(1 * time.Minute)
+			for key := uint64(1); key <= 6; key++ {
+				a.Set(key, []byte("value"), expiration)
+			}
+			a.Get(1)
+			a.Set(4, []byte("value 4"), expiration)
+			a.Release(6)
+			a.Get(2)
+
+			adapter.mutex.Lock()
+			got := adapter.victims(10, nil)
+			adapter.mutex.Unlock()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("victims() = %v, want %v", got, tt.want)
+			}
+			if got := adapter.listLength(t); got != 5 {
+				t.Errorf("list length = %v, want 5", got)
+			}
+
+			a.Purge()
+			if got := adapter.listLength(t); got != 0 {
+				t.Errorf("list length after Purge() = %v, want 0", got)
+			}
+		})
+	}
+}
@@ -26,12 +26,19 @@ package memory
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"errors"
 	"fmt"
-	cache "github.com/rishikesh-parspec/echo-http-cache"
+	"math"
+	"os"
+	"runtime"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	cache "github.com/rishikesh-parspec/echo-http-cache"
 )
 
 // Algorithm is the string type for caching algorithms labels.
@@ -49,6 +56,10 @@ const (
 
 	// MFU is the constant for Most Frequently Used.
 	MFU Algorithm = "MFU"
+
+	// GDSF is the constant for Greedy Dual Size Frequency, evicting the
+	// cached responses with the lowest frequency times cost per byte.
+	GDSF Algorithm = "GDSF"
 )
 
 type Response struct {
@@ -65,6 +76,37 @@ type Response struct {
 	// Frequency is the count of times a cached response is accessed.
 	// Used for LFU and MFU algorithms.
 	Frequency int
+
+	// Priority is the cached response priority, evicted lowest first.
+	// Used for GDSF algorithm.
+	Priority float64
+}
+
+// entry is a cached response in the store. Its access metadata is kept
+// apart from the value and updated atomically, so a cache hit neither
+// takes the write lock nor re-encodes the response.
+type entry struct {
+	// lastAccess is the last date the response was accessed, in Unix
+	// nanoseconds, and frequency the count of times it was accessed. Both
+	// are accessed atomically, and first for their 64-bit alignment.
+	lastAccess int64
+	frequency  int64
+
+	key        uint64
+	value      []byte
+	expiration time.Time
+
+	// cost is the cost of producing the response, inflation the GDSF
+	// clock when it was stored, and weight its cost per byte. Its
+	// priority grows with its frequency.
+	cost      int
+	inflation float64
+	weight    float64
+
+	// prev and next link the entry in the list of the LRU and MRU
+	// algorithms, guarded by the list mutex. Both are nil once unlinked.
+	prev *entry
+	next *entry
 }
 
 // Adapter is the memory adapter data structure.
@@ -72,7 +114,83 @@ type Adapter struct {
 	mutex     sync.RWMutex
 	capacity  int
 	algorithm Algorithm
-	store     map[uint64][]byte
+	store     map[uint64]*entry
+
+	// root is the sentinel of the entries list, ordered by access for the
+	// LRU and MRU algorithms, guarded by listMutex.
+	listMutex sync.Mutex
+	root      entry
+
+	// shards are the adapters the keys are routed to, if more than one
+	// shard is set, the store being unused.
+	shards []*Adapter
+
+	// size is the total size of the response values of the store, and
+	// maxBytes its limit, if any.
+	size     int64
+	maxBytes int64
+
+	tenantClassifier func(key uint64) string
+	tenantQuota      int
+	tenants          map[string]int
+
+	// segments counts the cached responses of each segment, keySegments
+	// is the segment of each key, classified from its TTL when set.
+	segmentClassifier func(ttl time.Duration) string
+	segmentCaps       map[string]int
+	segments          map[string]int
+	keySegments       map[uint64]string
+
+	// highWatermark is the store length triggering a batch eviction down
+	// to lowWatermark, when set.
+	highWatermark int
+	lowWatermark  int
+
+	memoryPressure func() bool
+	skippedSets    int64
+	oversizeSets   int64
+	evictions      int64
+	walErrors      int64
+
+	// clock is the GDSF inflation value, the priority of the last
+	// evicted response, aging the responses not accessed since.
+	clock float64
+
+	// cleanupInterval is the period of the janitor evicting the expired
+	// responses, stopped by closing done.
+	cleanupInterval time.Duration
+	done            chan struct{}
+	closeOnce       sync.Once
+
+	// wal is the write-ahead log the Set and Release operations are
+	// appended to, when set, checkpointed every checkpointInterval.
+	walPath            string
+	walMutex           sync.Mutex
+	wal                *os.File
+	checkpointInterval time.Duration
+
+	// tags is the reverse index of the cached response tags, keyTags the
+	// tags of each key, both updated along the store.
+	tags    map[string]map[uint64]struct{}
+	keyTags map[uint64][]string
+}
+
+// Stats is the memory adapter statistics data structure.
+type Stats struct {
+	// Entries is the number of cached responses in the store.
+	Entries int
+
+	// SkippedSets is the number of Set calls skipped under memory
+	// pressure.
+	SkippedSets int64
+
+	// OversizeSets is the number of Set calls skipped because the
+	// response is larger than the max bytes.
+	OversizeSets int64
+
+	// WALErrors is the number of records that could not be appended to
+	// the write-ahead log.
+	WALErrors int64
 }
 
 // AdapterOptions is used to set Adapter settings.
@@ -98,21 +216,24 @@ func (r Response) Bytes() []byte {
 
 // Get implements the cache Adapter interface Get method.
 func (a *Adapter) Get(key uint64) ([]byte, bool) {
+	if a.shards != nil {
+		return a.shard(key).Get(key)
+	}
 	a.mutex.RLock()
-	res, ok := a.store[key]
+	e, ok := a.store[key]
 	a.mutex.RUnlock()
 	if !ok {
 		return nil, false
 	}
 
-	response := BytesToResponse(res)
-	if response.Expiration.After(time.Now()) { // Cache is still valid
-		response.LastAccess = time.Now()
-		response.Frequency++
-		a.mutex.Lock()
-		a.store[key] = response.Bytes()
-		a.mutex.Unlock()
-		return response.Value, true
+	now := time.Now()
+	if e.expiration.After(now) { // Cache is still valid
+		atomic.StoreInt64(&e.lastAccess, now.UnixNano())
+		atomic.AddInt64(&e.frequency, 1)
+		if a.ordered() {
+			a.touch(e)
+		}
+		return e.value, true
 	}
 
 	// Cache is expired, remove it
@@ -120,87 +241,593 @@ func (a *Adapter) Get(key uint64) ([]byte, bool) {
 	return nil, false
 }
 
-// Set implements the cache Adapter interface Set method.
+// Set implements the cache Adapter interface Set method. The response is
+// stored without tags, at the lowest cost.
 func (a *Adapter) Set(key uint64, response []byte, expiration time.Time) {
+	a.set(key, response, expiration, nil, 0)
+}
+
+// SetWithTags implements the cache TaggedAdapter interface SetWithTags
+// method. The response is not cached if the context is done before taking
+// the lock.
+func (a *Adapter) SetWithTags(ctx context.Context, key uint64, response []byte, expiration time.Time, tags []string, cost int) {
+	if ctx.Err() != nil {
+		return
+	}
+	a.set(key, response, expiration, tags, cost)
+}
+
+// set caches the response of the key with its tags and cost. The limits
+// are checked, the responses evicted and the new one stored atomically,
+// under the same lock.
+func (a *Adapter) set(key uint64, response []byte, expiration time.Time, tags []string, cost int) {
+	if a.shards != nil {
+		a.shard(key).set(key, response, expiration, tags, cost)
+		return
+	}
+	if a.memoryPressure != nil && a.memoryPressure() {
+		atomic.AddInt64(&a.skippedSets, 1)
+		return
+	}
+
 	now := time.Now()
-	res := Response{
-		Value:      response,
-		Expiration: expiration,
-		LastAccess: now,
-		Frequency:  1,
+
+	e := &entry{
+		lastAccess: now.UnixNano(),
+		key:        key,
+		value:      response,
+		expiration: expiration,
+		cost:       cost,
 	}
-	a.mutex.RLock()
-	length := len(a.store)
-	a.mutex.RUnlock()
-	if length > 0 && length == a.capacity {
-		a.evict()
+	size := int64(len(response))
+	if a.maxBytes > 0 && size > a.maxBytes {
+		atomic.AddInt64(&a.oversizeSets, 1)
+		return
+	}
+	segment := ""
+	if a.segmentClassifier != nil {
+		segment = a.segmentClassifier(expiration.Sub(now))
 	}
 
 	a.mutex.Lock()
-	a.store[key] = res.Bytes()
+	// The response overwritten keeps its access count.
+	e.frequency = 1
+	if previous, ok := a.store[key]; ok {
+		e.frequency = atomic.LoadInt64(&previous.frequency)
+	}
+	evicted := a.makeRoom(key, segment, size)
+	if a.algorithm == GDSF {
+		e.inflation = a.clock
+		e.weight = costPerByte(cost, len(response))
+	}
+	if _, exists := a.store[key]; !exists && a.tenantClassifier != nil {
+		a.tenants[a.tenantClassifier(key)]++
+	}
+	if a.segmentClassifier != nil {
+		a.unsegment(key)
+		a.segments[segment]++
+		a.keySegments[key] = segment
+	}
+	a.untag(key)
+	a.tag(key, tags)
+	a.put(key, e)
+	for _, k := range evicted {
+		a.logWAL(walRecord{op: walRelease, key: k})
+	}
+	a.logWAL(walRecord{op: walSet, key: key, expiration: expiration, value: response, tags: tags, cost: cost})
 	a.mutex.Unlock()
 }
 
+// makeRoom evicts the cached responses selected by the caching algorithm
+// for the response of the key to fit in the tenant quota, the segment cap,
+// the max bytes, the watermarks and the capacity. It returns the evicted
+// keys. The mutex must be held.
+func (a *Adapter) makeRoom(key uint64, segment string, size int64) []uint64 {
+	evicted := []uint64{}
+	evict := func(k uint64, ok bool) bool {
+		if ok {
+			evicted = append(evicted, k)
+		}
+		return ok
+	}
+
+	_, exists := a.store[key]
+	if a.tenantClassifier != nil && !exists {
+		tenant := a.tenantClassifier(key)
+		if a.tenants[tenant] >= a.tenantQuota {
+			evict(a.evictWhere(func(k uint64) bool {
+				return a.tenantClassifier(k) == tenant
+			}))
+		}
+	}
+	if a.segmentClassifier != nil {
+		current, segmented := a.keySegments[key]
+		if limit, ok := a.segmentCaps[segment]; ok && (!segmented || current != segment) && a.segments[segment] >= limit {
+			evict(a.evictWhere(func(k uint64) bool {
+				return a.keySegments[k] == segment
+			}))
+		}
+	}
+	if a.maxBytes > 0 {
+		for a.size-a.store[key].size()+size > a.maxBytes {
+			if !evict(a.evictWhere(func(k uint64) bool { return k != key })) {
+				break
+			}
+		}
+	}
+
+	// Overwriting a response never evicts, as the store length doesn't
+	// change; a new one past the high watermark leaves room for itself
+	// below the low one.
+	_, exists = a.store[key]
+	if exists {
+		return evicted
+	}
+	if a.highWatermark > 0 && len(a.store) >= a.highWatermark {
+		evicted = append(evicted, a.evictDown(a.lowWatermark-1)...)
+	} else if a.capacity > 0 && len(a.store) >= a.capacity {
+		evict(a.evict())
+	}
+	return evicted
+}
+
 // Release implements the Adapter interface Release method.
 func (a *Adapter) Release(key uint64) {
-	a.mutex.RLock()
-	_, ok := a.store[key]
-	a.mutex.RUnlock()
+	if a.shards != nil {
+		a.shard(key).Release(key)
+		return
+	}
+	a.mutex.Lock()
+	if a.release(key) {
+		a.logWAL(walRecord{op: walRelease, key: key})
+	}
+	a.mutex.Unlock()
+}
+
+// release removes the response of the key from the store and its indexes,
+// and reports whether there was one. The mutex must be held.
+func (a *Adapter) release(key uint64) bool {
+	if _, ok := a.store[key]; !ok {
+		return false
+	}
+	if a.tenantClassifier != nil {
+		a.tenants[a.tenantClassifier(key)]--
+	}
+	a.untag(key)
+	a.unsegment(key)
+	a.remove(key)
+	return true
+}
 
-	if ok {
-		a.mutex.Lock()
-		delete(a.store, key)
-		a.mutex.Unlock()
+// GetCtx implements the cache ContextAdapter interface GetCtx method. The
+// context is ignored, the store is only read.
+func (a *Adapter) GetCtx(ctx context.Context, key uint64) ([]byte, bool) {
+	return a.Get(key)
+}
+
+// SetCtx implements the cache ContextAdapter interface SetCtx method. The
+// response is not cached if the context is done before taking the lock.
+func (a *Adapter) SetCtx(ctx context.Context, key uint64, response []byte, expiration time.Time) {
+	if ctx.Err() != nil {
+		return
 	}
+	a.Set(key, response, expiration)
+}
+
+// ReleaseCtx implements the cache ContextAdapter interface ReleaseCtx
+// method. The key is not released if the context is done before taking
+// the lock.
+func (a *Adapter) ReleaseCtx(ctx context.Context, key uint64) {
+	if ctx.Err() != nil {
+		return
+	}
+	a.Release(key)
 }
 
 // Purge implements the Adapter interface Purge method
-func (a *Adapter) Purge() {
+func (a *Adapter) Purge() error {
+	for _, shard := range a.shards {
+		shard.Purge()
+	}
 	a.mutex.Lock()
-	defer a.mutex.Unlock()
+	a.store = make(map[uint64]*entry)
+	a.listMutex.Lock()
+	a.resetList()
+	a.listMutex.Unlock()
+	a.size = 0
+	if a.tenantClassifier != nil {
+		a.tenants = make(map[string]int)
+	}
+	if a.segmentClassifier != nil {
+		a.segments = make(map[string]int)
+		a.keySegments = make(map[uint64]string)
+	}
+	a.tags = nil
+	a.keyTags = nil
+	a.logWAL(walRecord{op: walPurge})
+	a.mutex.Unlock()
+
+	return nil
+}
+
+// ReleaseByTag frees every cached response with the given tag, and returns
+// how many were released.
+func (a *Adapter) ReleaseByTag(tag string) int {
+	if a.shards != nil {
+		return a.shardsReleaseByTag(tag)
+	}
+	a.mutex.RLock()
+	keys := make([]uint64, 0, len(a.tags[tag]))
+	for key := range a.tags[tag] {
+		keys = append(keys, key)
+	}
+	a.mutex.RUnlock()
+
+	for _, key := range keys {
+		a.Release(key)
+	}
+	return len(keys)
+}
+
+// tag indexes the key under the given tags. The mutex must be held.
+func (a *Adapter) tag(key uint64, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	if a.tags == nil {
+		a.tags = make(map[string]map[uint64]struct{})
+		a.keyTags = make(map[uint64][]string)
+	}
+	a.keyTags[key] = tags
+	for _, t := range tags {
+		keys, ok := a.tags[t]
+		if !ok {
+			keys = map[uint64]struct{}{}
+			a.tags[t] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// untag removes the key from the tags index. The mutex must be held.
+func (a *Adapter) untag(key uint64) {
+	for _, t := range a.keyTags[key] {
+		delete(a.tags[t], key)
+		if len(a.tags[t]) == 0 {
+			delete(a.tags, t)
+		}
+	}
+	delete(a.keyTags, key)
+}
+
+// janitor releases the expired cached responses every cleanup interval,
+// until the adapter is closed.
+func (a *Adapter) janitor() {
+	ticker := time.NewTicker(a.cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.releaseExpired()
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// releaseExpired releases every expired cached response.
+func (a *Adapter) releaseExpired() {
+	now := time.Now()
+	keys := []uint64{}
+	a.mutex.RLock()
+	for k, e := range a.store {
+		if e.expiration.Before(now) {
+			keys = append(keys, k)
+		}
+	}
+	a.mutex.RUnlock()
+
+	for _, k := range keys {
+		// The response may have been set again since the scan.
+		a.mutex.RLock()
+		e, ok := a.store[k]
+		a.mutex.RUnlock()
+		if ok && e.expiration.Before(now) {
+			a.Release(k)
+		}
+	}
+}
+
+// Close stops the janitor evicting the expired cached responses and the
+// write-ahead log checkpoints, if any, and closes the write-ahead log. The
+// cached responses are still served.
+func (a *Adapter) Close() error {
+	for _, shard := range a.shards {
+		shard.Close()
+	}
+	if a.done != nil {
+		a.closeOnce.Do(func() {
+			close(a.done)
+		})
+	}
+
+	a.walMutex.Lock()
+	defer a.walMutex.Unlock()
+	if a.wal == nil {
+		return nil
+	}
+	err := a.wal.Close()
+	a.wal = nil
+	return err
+}
+
+// put stores the response of the key, keeping track of the size of the
+// store. The mutex must be held.
+func (a *Adapter) put(key uint64, e *entry) {
+	a.size += e.size() - a.store[key].size()
+	if a.ordered() {
+		a.listMutex.Lock()
+		if previous, ok := a.store[key]; ok {
+			a.unlink(previous)
+		}
+		a.pushFront(e)
+		a.listMutex.Unlock()
+	}
+	a.store[key] = e
+}
+
+// remove deletes the response of the key, keeping track of the size of the
+// store. The mutex must be held.
+func (a *Adapter) remove(key uint64) {
+	a.size -= a.store[key].size()
+	if e, ok := a.store[key]; ok && a.ordered() {
+		a.listMutex.Lock()
+		a.unlink(e)
+		a.listMutex.Unlock()
+	}
+	delete(a.store, key)
+}
+
+// size returns the size of the response value, 0 for a nil entry.
+func (e *entry) size() int64 {
+	if e == nil {
+		return 0
+	}
+	return int64(len(e.value))
+}
+
+// unsegment removes the key from its segment, if any. The mutex must be
+// held.
+func (a *Adapter) unsegment(key uint64) {
+	if segment, ok := a.keySegments[key]; ok {
+		a.segments[segment]--
+		delete(a.keySegments, key)
+	}
+}
+
+// Migrate copies every non-expired cached response to the destination
+// adapter, preserving its expiration date, and its tags and cost if the
+// destination is a cache TaggedAdapter, and returns how many were copied.
+// The memory adapter store is left untouched.
+func (a *Adapter) Migrate(dst cache.Adapter) int {
+	if a.shards != nil {
+		migrated := 0
+		for _, shard := range a.shards {
+			migrated += shard.Migrate(dst)
+		}
+		return migrated
+	}
+	now := time.Now()
+	responses := map[uint64]*entry{}
+	tags := map[uint64][]string{}
+	a.mutex.RLock()
+	for k, e := range a.store {
+		if e.expiration.After(now) {
+			responses[k] = e
+			tags[k] = a.keyTags[k]
+		}
+	}
+	a.mutex.RUnlock()
+
+	ta, tagged := dst.(cache.TaggedAdapter)
+	for k, e := range responses {
+		if tagged {
+			ta.SetWithTags(context.Background(), k, e.value, e.expiration, tags[k], e.cost)
+		} else {
+			dst.Set(k, e.value, e.expiration)
+		}
+	}
+
+	return len(responses)
+}
+
+// TTLHistogram implements the cache TTLHistogramAdapter interface
+// TTLHistogram method. Expired responses are not counted.
+func (a *Adapter) TTLHistogram(buckets []time.Duration) map[time.Duration]int {
+	if a.shards != nil {
+		return a.shardsTTLHistogram(buckets)
+	}
+	now := time.Now()
+	ttls := []time.Duration{}
+	a.mutex.RLock()
+	for _, e := range a.store {
+		if ttl := e.expiration.Sub(now); ttl > 0 {
+			ttls = append(ttls, ttl)
+		}
+	}
+	a.mutex.RUnlock()
+
+	return cache.BuildTTLHistogram(buckets, ttls)
+}
+
+// AdapterStats implements the cache StatsAdapter interface AdapterStats
+// method.
+func (a *Adapter) AdapterStats() cache.AdapterStats {
+	if a.shards != nil {
+		return a.shardsAdapterStats()
+	}
+	a.mutex.RLock()
+	entries := len(a.store)
+	size := a.size
+	a.mutex.RUnlock()
+
+	return cache.AdapterStats{
+		Entries:   entries,
+		Evictions: atomic.LoadInt64(&a.evictions),
+		SizeBytes: size,
+	}
+}
+
+// Stats returns the memory adapter statistics.
+func (a *Adapter) Stats() Stats {
+	if a.shards != nil {
+		return a.shardsStats()
+	}
+	a.mutex.RLock()
+	entries := len(a.store)
+	a.mutex.RUnlock()
+
+	return Stats{
+		Entries:      entries,
+		SkippedSets:  atomic.LoadInt64(&a.skippedSets),
+		OversizeSets: atomic.LoadInt64(&a.oversizeSets),
+		WALErrors:    atomic.LoadInt64(&a.walErrors),
+	}
+}
 
-	a.store = make(map[uint64][]byte)
+// evict evicts the cached response selected by the caching algorithm. The
+// mutex must be held.
+func (a *Adapter) evict() (uint64, bool) {
+	return a.evictWhere(nil)
 }
 
-func (a *Adapter) evict() {
+// evictWhere evicts a cached response among the ones whose key satisfies
+// the filter, or among all of them if the filter is nil. It returns the
+// evicted key, false if there was none to evict. The LRU and MRU
+// algorithms take it from the end of the entries list, the others scan
+// the store. The mutex must be held.
+func (a *Adapter) evictWhere(filter func(key uint64) bool) (uint64, bool) {
+	if a.ordered() {
+		keys := a.victims(1, filter)
+		if len(keys) == 0 {
+			return 0, false
+		}
+		atomic.AddInt64(&a.evictions, 1)
+		a.release(keys[0])
+		return keys[0], true
+	}
+
 	selectedKey := uint64(0)
-	lastAccess := time.Now()
-	frequency := 2147483647
+	selected := false
+	frequency := int64(math.MaxInt64)
+	priority := math.Inf(1)
 
-	if a.algorithm == MRU {
-		lastAccess = time.Time{}
-	} else if a.algorithm == MFU {
+	if a.algorithm == MFU {
 		frequency = 0
 	}
 
-	for k, v := range a.store {
-		r := cache.BytesToResponse(v)
+	for k, e := range a.store {
+		if filter != nil && !filter(k) {
+			continue
+		}
 		switch a.algorithm {
-		case LRU:
-			if r.LastAccess.Before(lastAccess) {
+		case LFU:
+			if f := atomic.LoadInt64(&e.frequency); f < frequency || !selected {
 				selectedKey = k
-				lastAccess = r.LastAccess
+				frequency = f
 			}
-		case MRU:
-			if r.LastAccess.After(lastAccess) ||
-				r.LastAccess.Equal(lastAccess) {
+		case MFU:
+			if f := atomic.LoadInt64(&e.frequency); f >= frequency || !selected {
 				selectedKey = k
-				lastAccess = r.LastAccess
+				frequency = f
 			}
-		case LFU:
-			if r.Frequency < frequency {
+		case GDSF:
+			if p := e.priority(); p < priority || !selected {
 				selectedKey = k
-				frequency = r.Frequency
+				priority = p
 			}
+		}
+		selected = true
+	}
+	if !selected {
+		return 0, false
+	}
+
+	atomic.AddInt64(&a.evictions, 1)
+	if a.algorithm == GDSF && !math.IsInf(priority, 1) {
+		a.clock = priority
+	}
+	a.release(selectedKey)
+	return selectedKey, true
+}
+
+// evictDown evicts the cached responses selected by the caching
+// algorithm until at most length remain, ranking them in a single scan.
+// It returns the evicted keys. The mutex must be held.
+func (a *Adapter) evictDown(length int) []uint64 {
+	type candidate struct {
+		key       uint64
+		frequency int64
+		priority  float64
+	}
+	if len(a.store) <= length {
+		return nil
+	}
+	if a.ordered() {
+		evicted := a.victims(len(a.store)-length, nil)
+		for _, k := range evicted {
+			a.release(k)
+		}
+		atomic.AddInt64(&a.evictions, int64(len(evicted)))
+		return evicted
+	}
+	// The access metadata is loaded once, as it may change while sorting.
+	candidates := make([]candidate, 0, len(a.store))
+	for k, e := range a.store {
+		candidates = append(candidates, candidate{
+			key:       k,
+			frequency: atomic.LoadInt64(&e.frequency),
+			priority:  e.priority(),
+		})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		ci, cj := candidates[i], candidates[j]
+		switch a.algorithm {
+		case LFU:
+			return ci.frequency < cj.frequency
 		case MFU:
-			if r.Frequency >= frequency {
-				selectedKey = k
-				frequency = r.Frequency
-			}
+			return ci.frequency > cj.frequency
+		default:
+			return ci.priority < cj.priority
 		}
+	})
+	evicted := make([]uint64, 0, len(candidates)-length)
+	for _, c := range candidates[:len(candidates)-length] {
+		a.release(c.key)
+		evicted = append(evicted, c.key)
 	}
+	atomic.AddInt64(&a.evictions, int64(len(evicted)))
+	if a.algorithm == GDSF {
+		a.clock = candidates[len(evicted)-1].priority
+	}
+	return evicted
+}
+
+// costPerByte returns the cost of a cached response of the given size per
+// byte. Responses without cost have a cost of 1.
+func costPerByte(cost int, size int) float64 {
+	if cost < 1 {
+		cost = 1
+	}
+	return float64(cost) / float64(size+1)
+}
 
-	a.Release(selectedKey)
+// priority returns the GDSF priority of the cached response, its access
+// frequency times its cost per byte, inflated by the clock when stored.
+func (e *entry) priority() float64 {
+	return e.inflation + float64(atomic.LoadInt64(&e.frequency))*e.weight
 }
 
 // NewAdapter initializes memory adapter.
@@ -213,7 +840,7 @@ func NewAdapter(opts ...AdapterOptions) (cache.Adapter, error) {
 		}
 	}
 
-	if a.capacity <= 1 {
+	if a.capacity < 1 && a.maxBytes == 0 {
 		return nil, errors.New("memory adapter capacity is not set")
 	}
 
@@ -221,12 +848,62 @@ func NewAdapter(opts ...AdapterOptions) (cache.Adapter, error) {
 		return nil, errors.New("memory adapter caching algorithm is not set")
 	}
 
-	a.mutex = sync.RWMutex{}
-	a.store = make(map[uint64][]byte, a.capacity)
+	if a.highWatermark > a.capacity {
+		return nil, fmt.Errorf("memory adapter high watermark %v is above the capacity %v", a.highWatermark, a.capacity)
+	}
+
+	if a.checkpointInterval > 0 && a.walPath == "" {
+		return nil, errors.New("memory adapter write-ahead log checkpoints require a write-ahead log")
+	}
 
+	if len(a.shards) > 0 {
+		if a.walPath != "" {
+			return nil, errors.New("memory adapter write-ahead log requires a single shard")
+		}
+		if err := a.startShards(); err != nil {
+			return nil, err
+		}
+		return a, nil
+	}
+
+	if err := a.start(); err != nil {
+		return nil, err
+	}
 	return a, nil
 }
 
+// start initializes the adapter store and starts its background tasks.
+func (a *Adapter) start() error {
+	a.mutex = sync.RWMutex{}
+	a.store = make(map[uint64]*entry, a.capacity)
+	if a.tenantClassifier != nil {
+		a.tenants = make(map[string]int)
+	}
+	if a.segmentClassifier != nil {
+		a.segments = make(map[string]int)
+		a.keySegments = make(map[uint64]string)
+	}
+
+	if a.walPath != "" {
+		wal, err := openWAL(a.walPath)
+		if err != nil {
+			return err
+		}
+		a.wal = wal
+	}
+	if a.cleanupInterval > 0 || a.checkpointInterval > 0 {
+		a.done = make(chan struct{})
+	}
+	if a.cleanupInterval > 0 {
+		go a.janitor()
+	}
+	if a.checkpointInterval > 0 {
+		go a.checkpointer()
+	}
+
+	return nil
+}
+
 // AdapterWithAlgorithm sets the approach used to select a cached
 // response to be evicted when the capacity is reached.
 func AdapterWithAlgorithm(alg Algorithm) AdapterOptions {
@@ -236,10 +913,11 @@ func AdapterWithAlgorithm(alg Algorithm) AdapterOptions {
 	}
 }
 
-// AdapterWithCapacity sets the maximum number of cached responses.
+// AdapterWithCapacity sets the maximum number of cached responses, at
+// least 1.
 func AdapterWithCapacity(cap int) AdapterOptions {
 	return func(a *Adapter) error {
-		if cap <= 1 {
+		if cap < 1 {
 			return fmt.Errorf("memory adapter requires a capacity greater than %v", cap)
 		}
 
@@ -248,3 +926,160 @@ func AdapterWithCapacity(cap int) AdapterOptions {
 		return nil
 	}
 }
+
+// AdapterWithMaxBytes sets the maximum total size of the cached response
+// values. Responses are evicted by the caching algorithm
+// until a new one fits, and responses larger than the limit are not
+// cached, counted by Stats().OversizeSets. It can replace or coexist with AdapterWithCapacity, whichever
+// limit is reached first triggering evictions.
+func AdapterWithMaxBytes(n int64) AdapterOptions {
+	return func(a *Adapter) error {
+		if n <= 0 {
+			return fmt.Errorf("memory adapter requires a max bytes greater than %v", n)
+		}
+
+		a.maxBytes = n
+
+		return nil
+	}
+}
+
+// AdapterWithTenantQuota sets the maximum number of cached responses per
+// tenant, as classified from the cache key. A tenant at its quota evicts
+// one of its own cached responses, using the caching algorithm. The
+// capacity should be large enough for every tenant quota, otherwise the
+// global eviction still applies to any tenant.
+func AdapterWithTenantQuota(classifier func(key uint64) string, perTenant int) AdapterOptions {
+	return func(a *Adapter) error {
+		if classifier == nil {
+			return errors.New("memory adapter tenant classifier must not be nil")
+		}
+		if perTenant < 1 {
+			return fmt.Errorf("memory adapter tenant quota %v is invalid", perTenant)
+		}
+
+		a.tenantClassifier = classifier
+		a.tenantQuota = perTenant
+
+		return nil
+	}
+}
+
+// AdapterWithSegments splits the cached responses in segments, e.g. short
+// and long lived ones, as classified from their TTL when set. A segment
+// at its capacity evicts one of its own cached responses, using the
+// caching algorithm, so the churn of a segment doesn't evict the others.
+// The segments without capacity are only bounded by the adapter capacity,
+// which should be large enough for every segment capacity.
+func AdapterWithSegments(classifier func(ttl time.Duration) string, caps map[string]int) AdapterOptions {
+	return func(a *Adapter) error {
+		if classifier == nil {
+			return errors.New("memory adapter segment classifier must not be nil")
+		}
+		for segment, c := range caps {
+			if c < 1 {
+				return fmt.Errorf("memory adapter segment %s capacity %v is invalid", segment, c)
+			}
+		}
+
+		a.segmentClassifier = classifier
+		a.segmentCaps = caps
+
+		return nil
+	}
+}
+
+// AdapterWithCleanupInterval starts a janitor releasing the expired
+// cached responses at the given interval, rather than only when they are
+// requested again. It runs until Close is called. Zero, the default,
+// means no janitor.
+func AdapterWithCleanupInterval(d time.Duration) AdapterOptions {
+	return func(a *Adapter) error {
+		if d < 0 {
+			return fmt.Errorf("memory adapter cleanup interval %v is invalid", d)
+		}
+		a.cleanupInterval = d
+		return nil
+	}
+}
+
+// AdapterWithWatermarks evicts the cached responses in batches: once the
+// store holds high responses, a new one makes the caching algorithm
+// evict them until low remain with it, amortizing the eviction scans over
+// the next writes. Overwriting a cached response never evicts. The high
+// watermark must not exceed the capacity.
+func AdapterWithWatermarks(high, low int) AdapterOptions {
+	return func(a *Adapter) error {
+		if low < 1 || high <= low {
+			return fmt.Errorf("memory adapter watermarks %v and %v are invalid", high, low)
+		}
+		a.highWatermark = high
+		a.lowWatermark = low
+		return nil
+	}
+}
+
+// AdapterWithWAL appends the Set, Release and Purge operations to a
+// write-ahead log at the given path, so the cached responses survive a
+// process crash: call Recover on startup to replay it. The file is
+// created if missing. The appends are not synced to disk, so the latest
+// operations may be lost on a power loss, and the failed ones are counted
+// in Stats.
+func AdapterWithWAL(path string) AdapterOptions {
+	return func(a *Adapter) error {
+		if path == "" {
+			return errors.New("memory adapter write-ahead log path must not be empty")
+		}
+		a.walPath = path
+		return nil
+	}
+}
+
+// AdapterWithWALCheckpointInterval rewrites the write-ahead log with only
+// the cached responses at the given interval, bounding its size and replay
+// time. It runs until Close is called. Zero, the default, means the log is
+// only checkpointed by Recover and Checkpoint.
+func AdapterWithWALCheckpointInterval(d time.Duration) AdapterOptions {
+	return func(a *Adapter) error {
+		if d < 0 {
+			return fmt.Errorf("memory adapter write-ahead log checkpoint interval %v is invalid", d)
+		}
+		a.checkpointInterval = d
+		return nil
+	}
+}
+
+// AdapterWithMemoryPressureFunc sets the function consulted on each Set to
+// detect memory pressure. While it returns true, new responses are not
+// cached, the cached ones are still served. See HeapAllocAbove.
+func AdapterWithMemoryPressureFunc(pressure func() bool) AdapterOptions {
+	return func(a *Adapter) error {
+		if pressure == nil {
+			return errors.New("memory adapter memory pressure function must not be nil")
+		}
+		a.memoryPressure = pressure
+		return nil
+	}
+}
+
+// HeapAllocAbove returns a memory pressure function reporting whether the
+// allocated heap is above the given number of bytes. It reads the runtime
+// memory statistics, which stops the world, at most once per interval.
+func HeapAllocAbove(bytes uint64, interval time.Duration) func() bool {
+	var mutex sync.Mutex
+	var readAt time.Time
+	var above bool
+
+	return func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if time.Since(readAt) >= interval {
+			var m runtime.MemStats
+			runtime.ReadMemStats(&m)
+			above = m.HeapAlloc > bytes
+			readAt = time.Now()
+		}
+		return above
+	}
+}
@@ -1,26 +1,42 @@
 package memory
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo/v4"
 	cache "github.com/rishikesh-parspec/echo-http-cache"
+	"github.com/rishikesh-parspec/echo-http-cache/adapter/redis"
 )
 
 func TestGet(t *testing.T) {
 	a := &Adapter{
-		sync.RWMutex{},
-		2,
-		LRU,
-		map[uint64][]byte{
-			14974843192121052621: cache.Response{
-				Value:      []byte("value 1"),
-				Expiration: time.Now(),
-				LastAccess: time.Now(),
-				Frequency:  1,
-			}.Bytes(),
+		mutex:     sync.RWMutex{},
+		capacity:  2,
+		algorithm: LRU,
+		store: map[uint64]*entry{
+			14974843192121052621: {
+				lastAccess: time.Now().UnixNano(),
+				frequency:  1,
+				value: cache.Response{
+					Value:      []byte("value 1"),
+					Expiration: time.Now().Add(1 * time.Minute),
+				}.Bytes(),
+				expiration: time.Now().Add(1 * time.Minute),
+			},
 		},
 	}
 
@@ -58,12 +74,51 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestGetAccess(t *testing.T) {
+	a, err := NewAdapter(
+		AdapterWithCapacity(2),
+		AdapterWithAlgorithm(LFU),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter := a.(*Adapter)
+	a.Set(1, []byte("value 1"), time.Now().Add(1*time.Minute))
+	e := adapter.store[1]
+	set := atomic.LoadInt64(&e.lastAccess)
+
+	// A hit only takes the read lock, so it completes while it is held.
+	adapter.mutex.RLock()
+	done := make(chan struct{})
+	go func() {
+		a.Get(1)
+		a.Get(1)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("Get() waited for the write lock")
+	}
+	adapter.mutex.RUnlock()
+
+	if adapter.store[1] != e {
+		t.Errorf("Get() replaced the stored entry")
+	}
+	if got := atomic.LoadInt64(&e.frequency); got != 3 {
+		t.Errorf("frequency = %v, want 3", got)
+	}
+	if got := atomic.LoadInt64(&e.lastAccess); got < set {
+		t.Errorf("last access = %v, want at least %v", got, set)
+	}
+}
+
 func TestSet(t *testing.T) {
 	a := &Adapter{
-		sync.RWMutex{},
-		2,
-		LRU,
-		make(map[uint64][]byte),
+		mutex:     sync.RWMutex{},
+		capacity:  2,
+		algorithm: LRU,
+		store:     make(map[uint64]*entry),
 	}
 
 	tests := []struct {
@@ -99,7 +154,7 @@ func TestSet(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			a.Set(tt.key, tt.response.Bytes(), tt.response.Expiration)
-			if cache.BytesToResponse(a.store[tt.key]).Value == nil {
+			if cache.BytesToResponse(a.store[tt.key].value).Value == nil {
 				t.Errorf(
 					"memory.Set() error = store[%v] response is not %s", tt.key, tt.response.Value,
 				)
@@ -108,24 +163,46 @@ func TestSet(t *testing.T) {
 	}
 }
 
+func TestSetKeepsFrequency(t *testing.T) {
+	a, err := NewAdapter(AdapterWithCapacity(2), AdapterWithAlgorithm(LFU))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiration := time.Now().Add(1 * time.Minute)
+	a.Set(1, []byte("value 1"), expiration)
+	for i := 0; i < 3; i++ {
+		a.Get(1)
+	}
+	a.Set(1, []byte("value 1 updated"), expiration)
+
+	if got := a.(*Adapter).store[1].frequency; got != 4 {
+		t.Errorf("memory.Set() frequency of an overwritten response = %v, want 4", got)
+	}
+	a.Set(2, []byte("value 2"), expiration)
+	a.Set(3, []byte("value 3"), expiration)
+	if _, ok := a.Get(1); !ok {
+		t.Error("memory.Set() evicted the overwritten response of highest frequency")
+	}
+}
+
 func TestRelease(t *testing.T) {
 	a := &Adapter{
-		sync.RWMutex{},
-		2,
-		LRU,
-		map[uint64][]byte{
-			14974843192121052621: cache.Response{
-				Expiration: time.Now().Add(1 * time.Minute),
-				Value:      []byte("value 1"),
-			}.Bytes(),
-			14974839893586167988: cache.Response{
-				Expiration: time.Now(),
-				Value:      []byte("value 2"),
-			}.Bytes(),
-			14974840993097796199: cache.Response{
-				Expiration: time.Now(),
-				Value:      []byte("value 3"),
-			}.Bytes(),
+		mutex:     sync.RWMutex{},
+		capacity:  2,
+		algorithm: LRU,
+		store: map[uint64]*entry{
+			14974843192121052621: {
+				value:      []byte("value 1"),
+				expiration: time.Now().Add(1 * time.Minute),
+			},
+			14974839893586167988: {
+				value:      []byte("value 2"),
+				expiration: time.Now(),
+			},
+			14974840993097796199: {
+				value:      []byte("value 3"),
+				expiration: time.Now(),
+			},
 		},
 	}
 
@@ -185,29 +262,36 @@ func TestEvict(t *testing.T) {
 		count++
 
 		a := &Adapter{
-			sync.RWMutex{},
-			2,
-			tt.algorithm,
-			map[uint64][]byte{
-				14974843192121052621: cache.Response{
-					Value:      []byte("value 1"),
-					Expiration: time.Now().Add(1 * time.Minute),
-					LastAccess: time.Now().Add(-1 * time.Minute),
-					Frequency:  2,
-				}.Bytes(),
-				14974839893586167988: cache.Response{
-					Value:      []byte("value 2"),
-					Expiration: time.Now().Add(1 * time.Minute),
-					LastAccess: time.Now().Add(-2 * time.Minute),
-					Frequency:  1,
-				}.Bytes(),
-				14974840993097796199: cache.Response{
-					Value:      []byte("value 3"),
-					Expiration: time.Now().Add(1 * time.Minute),
-					LastAccess: time.Now().Add(-3 * time.Minute),
-					Frequency:  3,
-				}.Bytes(),
+			mutex:     sync.RWMutex{},
+			capacity:  2,
+			algorithm: tt.algorithm,
+			store:     make(map[uint64]*entry),
+		}
+		// The entries are stored from the least recently accessed.
+		for _, e := range []*entry{
+			{
+				lastAccess: time.Now().Add(-3 * time.Minute).UnixNano(),
+				frequency:  3,
+				key:        14974840993097796199,
+				value:      []byte("value 3"),
+				expiration: time.Now().Add(1 * time.Minute),
+			},
+			{
+				lastAccess: time.Now().Add(-2 * time.Minute).UnixNano(),
+				frequency:  1,
+				key:        14974839893586167988,
+				value:      []byte("value 2"),
+				expiration: time.Now().Add(1 * time.Minute),
+			},
+			{
+				lastAccess: time.Now().Add(-1 * time.Minute).UnixNano(),
+				frequency:  2,
+				key:        14974843192121052621,
+				value:      []byte("value 1"),
+				expiration: time.Now().Add(1 * time.Minute),
 			},
+		} {
+			a.put(e.key, e)
 		}
 		t.Run(tt.name, func(t *testing.T) {
 			a.evict()
@@ -238,6 +322,150 @@ func TestEvict(t *testing.T) {
 	}
 }
 
+func TestEvictKeyZero(t *testing.T) {
+	tests := []struct {
+		name        string
+		algorithm   Algorithm
+		frequencies map[uint64]int64
+		filter      func(key uint64) bool
+		wantKey     uint64
+		wantOK      bool
+	}{
+		{
+			"lfu keeps most frequently used key 0",
+			LFU,
+			map[uint64]int64{0: 5, 1: 1, 2: 3},
+			nil,
+			1,
+			true,
+		},
+		{
+			"mfu keeps least frequently used key 0",
+			MFU,
+			map[uint64]int64{0: 1, 1: 5, 2: 3},
+			nil,
+			1,
+			true,
+		},
+		{
+			"gdsf keeps key 0 of highest priority",
+			GDSF,
+			map[uint64]int64{0: 5, 1: 1, 2: 3},
+			nil,
+			1,
+			true,
+		},
+		{
+			"lru keeps most recently used key 0",
+			LRU,
+			map[uint64]int64{1: 1, 2: 1, 0: 1},
+			nil,
+			1,
+			true,
+		},
+		{
+			"lfu evicts key 0",
+			LFU,
+			map[uint64]int64{0: 1, 1: 5},
+			nil,
+			0,
+			true,
+		},
+		{
+			"lfu keeps key 0 when nothing matches the filter",
+			LFU,
+			map[uint64]int64{0: 1, 1: 5},
+			func(key uint64) bool { return key > 1 },
+			0,
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &Adapter{
+				mutex:     sync.RWMutex{},
+				capacity:  10,
+				algorithm: tt.algorithm,
+				store:     make(map[uint64]*entry),
+			}
+			// The LRU entries are stored from the least recently used.
+			for _, key := range []uint64{1, 2, 0} {
+				if f, ok := tt.frequencies[key]; ok {
+					a.put(key, &entry{
+						frequency:  f,
+						key:        key,
+						value:      []byte("value"),
+						expiration: time.Now().Add(1 * time.Minute),
+						weight:     1,
+					})
+				}
+			}
+			length := len(a.store)
+
+			key, ok := a.evictWhere(tt.filter)
+			if key != tt.wantKey || ok != tt.wantOK {
+				t.Errorf("evictWhere() = %v, %v, want %v, %v", key, ok, tt.wantKey, tt.wantOK)
+			}
+			if _, stored := a.store[0]; stored != (!tt.wantOK || tt.wantKey != 0) {
+				t.Errorf("key 0 stored = %v after evicting %v", stored, key)
+			}
+			wantLength := length
+			if tt.wantOK {
+				wantLength--
+			}
+			if len(a.store) != wantLength {
+				t.Errorf("store length = %v, want %v", len(a.store), wantLength)
+			}
+		})
+	}
+}
+
+func TestCapacityBoundaries(t *testing.T) {
+	tests := []struct {
+		name      string
+		capacity  int
+		algorithm Algorithm
+		want      []uint64
+	}{
+		{"lru keeps last response at capacity 1", 1, LRU, []uint64{3}},
+		{"mru keeps last response at capacity 1", 1, MRU, []uint64{3}},
+		{"lfu keeps last response at capacity 1", 1, LFU, []uint64{3}},
+		{"mfu keeps last response at capacity 1", 1, MFU, []uint64{3}},
+		{"gdsf keeps last response at capacity 1", 1, GDSF, []uint64{3}},
+		{"lru evicts least recently used at capacity 2", 2, LRU, []uint64{1, 3}},
+		{"mru evicts most recently used at capacity 2", 2, MRU, []uint64{2, 3}},
+		{"lfu evicts least frequently used at capacity 2", 2, LFU, []uint64{1, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewAdapter(
+				AdapterWithCapacity(tt.capacity),
+				AdapterWithAlgorithm(tt.algorithm),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expiration := time.Now().Add(1 * time.Minute)
+			a.Set(1, []byte("value 1"), expiration)
+			a.Set(2, []byte("value 2"), expiration)
+			a.Get(1)
+			a.Set(3, []byte("value 3"), expiration)
+			// Setting a stored key again evicts nothing.
+			a.Set(3, []byte("value 3"), expiration)
+
+			got := []uint64{}
+			for key := uint64(1); key <= 3; key++ {
+				if _, ok := a.(*Adapter).store[key]; ok {
+					got = append(got, key)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stored keys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestNewAdapter(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -252,16 +480,56 @@ func TestNewAdapter(t *testing.T) {
 				AdapterWithAlgorithm(LRU),
 			},
 			&Adapter{
-				sync.RWMutex{},
-				4,
-				LRU,
-				make(map[uint64][]byte),
+				mutex:     sync.RWMutex{},
+				capacity:  4,
+				algorithm: LRU,
+				store:     make(map[uint64]*entry),
+			},
+			false,
+		},
+		{
+			"returns new Adapter with max bytes",
+			[]AdapterOptions{
+				AdapterWithMaxBytes(1024),
+				AdapterWithAlgorithm(LRU),
+			},
+			&Adapter{
+				mutex:     sync.RWMutex{},
+				maxBytes:  1024,
+				algorithm: LRU,
+				store:     make(map[uint64]*entry),
 			},
 			false,
 		},
 		{
 			"returns error",
 			[]AdapterOptions{
+				AdapterWithMaxBytes(0),
+				AdapterWithAlgorithm(LRU),
+			},
+			nil,
+			true,
+		},
+		{
+			"returns error",
+			[]AdapterOptions{
+				AdapterWithAlgorithm(LRU),
+			},
+			nil,
+			true,
+		},
+		{
+			"returns error",
+			[]AdapterOptions{
+				AdapterWithCapacity(4),
+			},
+			nil,
+			true,
+		},
+		{
+			"returns error",
+			[]AdapterOptions{
+				AdapterWithCapacity(0),
 				AdapterWithAlgorithm(LRU),
 			},
 			nil,
@@ -271,6 +539,8 @@ func TestNewAdapter(t *testing.T) {
 			"returns error",
 			[]AdapterOptions{
 				AdapterWithCapacity(4),
+				AdapterWithAlgorithm(LRU),
+				AdapterWithSegments(func(ttl time.Duration) string { return "hot" }, map[string]int{"hot": 0}),
 			},
 			nil,
 			true,
@@ -278,7 +548,19 @@ func TestNewAdapter(t *testing.T) {
 		{
 			"returns error",
 			[]AdapterOptions{
-				AdapterWithCapacity(1),
+				AdapterWithCapacity(4),
+				AdapterWithAlgorithm(LRU),
+				AdapterWithWatermarks(3, 3),
+			},
+			nil,
+			true,
+		},
+		{
+			"returns error",
+			[]AdapterOptions{
+				AdapterWithCapacity(4),
+				AdapterWithAlgorithm(LRU),
+				AdapterWithWatermarks(5, 3),
 			},
 			nil,
 			true,
@@ -297,3 +579,1019 @@ func TestNewAdapter(t *testing.T) {
 		})
 	}
 }
+
+func TestStats(t *testing.T) {
+	a, _ := NewAdapter(
+		AdapterWithCapacity(4),
+		AdapterWithAlgorithm(LRU),
+	)
+	expiration := time.Now().Add(1 * time.Minute)
+
+	a.Set(1, []byte("value 1"), expiration)
+	a.Set(2, []byte("value 22"), expiration)
+	a.Set(3, []byte("value 333"), expiration)
+
+	want := Stats{
+		Entries: 3,
+	}
+	if got := a.(*Adapter).Stats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestAdapterStats(t *testing.T) {
+	a, err := NewAdapter(
+		AdapterWithCapacity(2),
+		AdapterWithAlgorithm(LRU),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiration := time.Now().Add(1 * time.Minute)
+	for key := uint64(1); key <= 4; key++ {
+		a.Set(key, []byte("value"), expiration)
+	}
+
+	got := a.(cache.StatsAdapter).AdapterStats()
+	if got.Entries != 2 || got.Evictions != 2 {
+		t.Errorf("AdapterStats() = %+v, want 2 entries and 2 evictions", got)
+	}
+	if want := 2 * int64(len("value")); got.SizeBytes != want {
+		t.Errorf("AdapterStats() SizeBytes = %v, want the stored responses size %v", got.SizeBytes, want)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	src, _ := NewAdapter(
+		AdapterWithCapacity(4),
+		AdapterWithAlgorithm(LRU),
+	)
+	dst := redis.NewAdapter(&redis.RingOptions{
+		Addrs: map[string]string{
+			"server": s.Addr(),
+		},
+	})
+
+	src.Set(1, []byte("value 1"), time.Now().Add(1*time.Minute))
+	src.Set(2, []byte("value 2"), time.Now().Add(2*time.Minute))
+	src.(*Adapter).store[3] = &entry{
+		value:      []byte("value 3"),
+		expiration: time.Now().Add(-1 * time.Minute),
+	}
+
+	if n := src.(*Adapter).Migrate(dst); n != 2 {
+		t.Errorf("Migrate() = %v, want 2", n)
+	}
+
+	tests := []struct {
+		name string
+		key  uint64
+		want []byte
+		ok   bool
+	}{
+		{
+			"migrates response",
+			1,
+			[]byte("value 1"),
+			true,
+		},
+		{
+			"migrates response",
+			2,
+			[]byte("value 2"),
+			true,
+		},
+		{
+			"skips expired response",
+			3,
+			nil,
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := dst.Get(tt.key)
+			if ok != tt.ok {
+				t.Errorf("redis.Get() ok = %v, want %v", ok, tt.ok)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("redis.Get() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	ttl := s.TTL(cache.KeyAsString(2))
+	if ttl <= 1*time.Minute || ttl > 2*time.Minute {
+		t.Errorf("migrated response TTL = %v, want the remaining 2 minutes", ttl)
+	}
+}
+
+func TestTTLHistogram(t *testing.T) {
+	a, _ := NewAdapter(
+		AdapterWithCapacity(10),
+		AdapterWithAlgorithm(LRU),
+	)
+	now := time.Now()
+	a.Set(1, []byte("value 1"), now.Add(30*time.Second))
+	a.Set(2, []byte("value 2"), now.Add(45*time.Second))
+	a.Set(3, []byte("value 3"), now.Add(5*time.Minute))
+	a.Set(4, []byte("value 4"), now.Add(2*time.Hour))
+	a.Set(5, []byte("value 5"), now.Add(-1*time.Minute))
+
+	want := map[time.Duration]int{
+		time.Minute:       2,
+		10 * time.Minute:  1,
+		time.Hour:         0,
+		cache.TTLInfinity: 1,
+	}
+	got := a.(cache.TTLHistogramAdapter).TTLHistogram([]time.Duration{time.Minute, 10 * time.Minute, time.Hour})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TTLHistogram() = %v, want %v", got, want)
+	}
+}
+
+func TestTenantQuota(t *testing.T) {
+	classifier := func(key uint64) string {
+		if key < 100 {
+			return "quiet"
+		}
+		return "noisy"
+	}
+	a, err := NewAdapter(
+		AdapterWithCapacity(10),
+		AdapterWithAlgorithm(LRU),
+		AdapterWithTenantQuota(classifier, 3),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiration := time.Now().Add(1 * time.Minute)
+
+	a.Set(1, []byte("value 1"), expiration)
+	a.Set(2, []byte("value 2"), expiration)
+	for key := uint64(100); key < 120; key++ {
+		a.Set(key, []byte("noisy value"), expiration)
+	}
+
+	for _, key := range []uint64{1, 2} {
+		if _, ok := a.Get(key); !ok {
+			t.Errorf("noisy tenant flood evicted quiet tenant key %v", key)
+		}
+	}
+	for key := uint64(117); key < 120; key++ {
+		if _, ok := a.Get(key); !ok {
+			t.Errorf("noisy tenant latest key %v should be cached", key)
+		}
+	}
+
+	adapter := a.(*Adapter)
+	if got := adapter.tenants["noisy"]; got != 3 {
+		t.Errorf("noisy tenant count = %v, want 3", got)
+	}
+	if got := len(adapter.store); got != 5 {
+		t.Errorf("store length = %v, want 5", got)
+	}
+
+	a.Release(1)
+	if got := adapter.tenants["quiet"]; got != 1 {
+		t.Errorf("quiet tenant count after Release() = %v, want 1", got)
+	}
+}
+
+func TestSegments(t *testing.T) {
+	classifier := func(ttl time.Duration) string {
+		if ttl < time.Hour {
+			return "hot"
+		}
+		return "cold"
+	}
+	a, err := NewAdapter(
+		AdapterWithCapacity(10),
+		AdapterWithAlgorithm(LRU),
+		AdapterWithSegments(classifier, map[string]int{"hot": 3, "cold": 5}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	short := time.Now().Add(1 * time.Minute)
+	long := time.Now().Add(24 * time.Hour)
+
+	a.Set(1, []byte("value 1"), long)
+	a.Set(2, []byte("value 2"), long)
+	for key := uint64(100); key < 120; key++ {
+		a.Set(key, []byte("hot value"), short)
+	}
+
+	for _, key := range []uint64{1, 2} {
+		if _, ok := a.Get(key); !ok {
+			t.Errorf("hot segment churn evicted cold key %v", key)
+		}
+	}
+	for key := uint64(117); key < 120; key++ {
+		if _, ok := a.Get(key); !ok {
+			t.Errorf("hot segment latest key %v should be cached", key)
+		}
+	}
+
+	adapter := a.(*Adapter)
+	if got := adapter.segments["hot"]; got != 3 {
+		t.Errorf("hot segment count = %v, want 3", got)
+	}
+	if got := len(adapter.store); got != 5 {
+		t.Errorf("store length = %v, want 5", got)
+	}
+
+	a.Set(119, []byte("now cold"), long)
+	if got := adapter.segments["hot"]; got != 2 {
+		t.Errorf("hot segment count after moving a key = %v, want 2", got)
+	}
+	if got := adapter.segments["cold"]; got != 3 {
+		t.Errorf("cold segment count after moving a key = %v, want 3", got)
+	}
+
+	a.Release(1)
+	if got := adapter.segments["cold"]; got != 2 {
+		t.Errorf("cold segment count after Release() = %v, want 2", got)
+	}
+}
+
+func TestWatermarks(t *testing.T) {
+	a, err := NewAdapter(
+		AdapterWithCapacity(10),
+		AdapterWithAlgorithm(LRU),
+		AdapterWithWatermarks(10, 7),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter := a.(*Adapter)
+	expiration := time.Now().Add(1 * time.Minute)
+
+	for key := uint64(1); key <= 10; key++ {
+		a.Set(key, []byte(fmt.Sprintf("value %v", key)), expiration)
+	}
+	if got := len(adapter.store); got != 10 {
+		t.Fatalf("store length at the high watermark = %v, want 10", got)
+	}
+
+	a.Set(10, []byte("value 10 updated"), expiration)
+	if got := adapter.AdapterStats().Evictions; got != 0 {
+		t.Errorf("evictions overwriting a response at the high watermark = %v, want 0", got)
+	}
+	if got, ok := a.Get(10); !ok || string(got) != "value 10 updated" {
+		t.Errorf("Get() of the overwritten key = %s, %v, want the new value", got, ok)
+	}
+
+	a.Set(11, []byte("value 11"), expiration)
+	if got := adapter.AdapterStats().Evictions; got != 4 {
+		t.Errorf("evictions = %v, want 4 down to the low watermark", got)
+	}
+	if got := len(adapter.store); got != 7 {
+		t.Errorf("store length = %v, want the low watermark 7 with the new response", got)
+	}
+	for key := uint64(1); key <= 4; key++ {
+		if _, ok := a.Get(key); ok {
+			t.Errorf("least recently used key %v should be evicted", key)
+		}
+	}
+	for key := uint64(5); key <= 11; key++ {
+		if _, ok := a.Get(key); !ok {
+			t.Errorf("key %v should be cached", key)
+		}
+	}
+
+	a.Set(12, []byte("value 12"), expiration)
+	if got := adapter.AdapterStats().Evictions; got != 4 {
+		t.Errorf("evictions below the high watermark = %v, want 4", got)
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	expiration := time.Now().Add(1 * time.Minute)
+	value := bytes.Repeat([]byte("v"), 100)
+	entrySize := int64(len(value))
+
+	a, err := NewAdapter(
+		AdapterWithMaxBytes(entrySize*5/2),
+		AdapterWithAlgorithm(LRU),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter := a.(*Adapter)
+	storeSize := func() int64 {
+		var size int64
+		for _, e := range adapter.store {
+			size += int64(len(e.value))
+		}
+		return size
+	}
+
+	a.Set(1, value, expiration)
+	a.Set(2, value, expiration)
+	a.Get(1)
+	a.Set(3, value, expiration)
+	if _, ok := a.Get(2); ok {
+		t.Errorf("least recently used key 2 should be evicted")
+	}
+	for _, key := range []uint64{1, 3} {
+		if _, ok := a.Get(key); !ok {
+			t.Errorf("key %v should be cached", key)
+		}
+	}
+	if got := adapter.AdapterStats().Evictions; got != 1 {
+		t.Errorf("evictions = %v, want 1", got)
+	}
+
+	a.Set(3, value[:50], expiration)
+	if got := adapter.AdapterStats().Evictions; got != 1 {
+		t.Errorf("evictions replacing a response with a smaller one = %v, want 1", got)
+	}
+
+	a.Set(4, bytes.Repeat(value, 5), expiration)
+	if _, ok := a.Get(4); ok {
+		t.Errorf("response larger than the max bytes should not be cached")
+	}
+	if got := adapter.Stats().OversizeSets; got != 1 {
+		t.Errorf("oversize sets = %v, want 1", got)
+	}
+	if got := adapter.Stats().SkippedSets; got != 0 {
+		t.Errorf("skipped sets = %v, want 0 without memory pressure", got)
+	}
+
+	if got, want := adapter.AdapterStats().SizeBytes, storeSize(); got != want {
+		t.Errorf("tracked size = %v, want %v", got, want)
+	}
+	if got := adapter.AdapterStats().SizeBytes; got > adapter.maxBytes {
+		t.Errorf("size = %v over the max bytes %v", got, adapter.maxBytes)
+	}
+	a.Release(1)
+	if got, want := adapter.AdapterStats().SizeBytes, storeSize(); got != want {
+		t.Errorf("tracked size after Release() = %v, want %v", got, want)
+	}
+	a.Purge()
+	if got := adapter.AdapterStats().SizeBytes; got != 0 {
+		t.Errorf("tracked size after Purge() = %v, want 0", got)
+	}
+}
+
+func TestSetConcurrently(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []AdapterOptions
+	}{
+		{
+			"stays within the capacity",
+			[]AdapterOptions{AdapterWithCapacity(10)},
+		},
+		{
+			"stays within the max bytes",
+			[]AdapterOptions{AdapterWithMaxBytes(2048)},
+		},
+		{
+			"stays within the watermarks",
+			[]AdapterOptions{AdapterWithCapacity(10), AdapterWithWatermarks(10, 5)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewAdapter(append(tt.opts, AdapterWithAlgorithm(LRU))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			adapter := a.(*Adapter)
+			expiration := time.Now().Add(1 * time.Minute)
+			within := func() bool {
+				adapter.mutex.RLock()
+				defer adapter.mutex.RUnlock()
+				return (adapter.capacity == 0 || len(adapter.store) <= adapter.capacity) &&
+					(adapter.maxBytes == 0 || adapter.size <= adapter.maxBytes)
+			}
+
+			done := make(chan struct{})
+			exceeded := make(chan struct{}, 1)
+			go func() {
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					if !within() {
+						select {
+						case exceeded <- struct{}{}:
+						default:
+						}
+					}
+					time.Sleep(10 * time.Microsecond)
+				}
+			}()
+
+			var wg sync.WaitGroup
+			for g := 0; g < 8; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for i := 0; i < 50; i++ {
+						key := uint64(g*100 + i)
+						a.Set(key, []byte(fmt.Sprintf("value %v", key)), expiration)
+						a.Get(uint64(g*100 + i/2))
+					}
+				}(g)
+			}
+			wg.Wait()
+			close(done)
+
+			select {
+			case <-exceeded:
+				t.Errorf("store exceeded its limits during concurrent sets")
+			default:
+			}
+			if !within() {
+				t.Errorf("store length = %v, size = %v, exceeds its limits", len(adapter.store), adapter.size)
+			}
+			var size int64
+			for _, e := range adapter.store {
+				size += int64(len(e.value))
+			}
+			if size != adapter.size {
+				t.Errorf("tracked size = %v, want %v", adapter.size, size)
+			}
+			if got := adapter.listLength(t); got != len(adapter.store) {
+				t.Errorf("list length = %v, want the store length %v", got, len(adapter.store))
+			}
+		})
+	}
+}
+
+func TestMaxBytesWithCapacity(t *testing.T) {
+	a, err := NewAdapter(
+		AdapterWithCapacity(2),
+		AdapterWithMaxBytes(1<<20),
+		AdapterWithAlgorithm(LRU),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiration := time.Now().Add(1 * time.Minute)
+	for key := uint64(1); key <= 3; key++ {
+		a.Set(key, []byte(fmt.Sprintf("value %v", key)), expiration)
+	}
+
+	if got := a.(*Adapter).AdapterStats().Entries; got != 2 {
+		t.Errorf("entries = %v, want the capacity 2 reached before the max bytes", got)
+	}
+}
+
+func TestWAL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.wal")
+	newAdapter := func() *Adapter {
+		a, err := NewAdapter(AdapterWithCapacity(10), AdapterWithAlgorithm(LRU), AdapterWithWAL(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a.(*Adapter)
+	}
+	countRecords := func() int {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := bytes.NewReader(b)
+		n := 0
+		for {
+			if _, err := readWALRecord(r); err == io.EOF {
+				return n
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			n++
+		}
+	}
+
+	a := newAdapter()
+	expiration := time.Now().Add(1 * time.Minute)
+	a.SetWithTags(context.Background(), 1, []byte("value 1"), expiration, []string{"catalog", "product:1"}, 7)
+	a.Set(2, []byte("value 2"), expiration)
+	a.Set(2, []byte("value 2 updated"), expiration)
+	a.Set(3, []byte("value 3"), time.Now().Add(-1*time.Second))
+	a.Set(4, []byte("value 4"), expiration)
+	a.Release(4)
+	a.Close()
+
+	// A crash while appending leaves a truncated record.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(walRecord{op: walSet, key: 5, expiration: expiration, value: []byte("value 5")}.bytes()[:walHeaderSize+2])
+	f.Close()
+
+	recovered := newAdapter()
+	defer recovered.Close()
+	if err := recovered.Recover(); err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	tests := []struct {
+		key       uint64
+		wantValue string
+		wantOk    bool
+	}{
+		{1, "value 1", true},
+		{2, "value 2 updated", true},
+		{3, "", false},
+		{4, "", false},
+		{5, "", false},
+	}
+	for _, tt := range tests {
+		b, ok := recovered.Get(tt.key)
+		if ok != tt.wantOk || string(b) != tt.wantValue {
+			t.Errorf("Get(%v) after Recover() = %v, %v, want %v, %v", tt.key, string(b), ok, tt.wantValue, tt.wantOk)
+		}
+	}
+	if got := recovered.keyTags[1]; !reflect.DeepEqual(got, []string{"catalog", "product:1"}) {
+		t.Errorf("tags of key 1 after Recover() = %v, want [catalog product:1]", got)
+	}
+	if got := recovered.store[1].cost; got != 7 {
+		t.Errorf("cost of key 1 after Recover() = %v, want 7", got)
+	}
+	if got := countRecords(); got != 2 {
+		t.Errorf("records after the Recover() checkpoint = %v, want 2", got)
+	}
+
+	recovered.Set(6, []byte("value 6"), expiration)
+	if got := countRecords(); got != 3 {
+		t.Errorf("records after a Set() following Recover() = %v, want 3", got)
+	}
+	recovered.Purge()
+	purged := newAdapter()
+	defer purged.Close()
+	if err := purged.Recover(); err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	if got := len(purged.store); got != 0 {
+		t.Errorf("entries recovered after Purge() = %v, want 0", got)
+	}
+}
+
+func TestWALCheckpointInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.wal")
+
+	if _, err := NewAdapter(AdapterWithCapacity(10), AdapterWithAlgorithm(LRU), AdapterWithWALCheckpointInterval(time.Second)); err == nil {
+		t.Error("NewAdapter() error = nil with checkpoints without write-ahead log, want an error")
+	}
+
+	a, err := NewAdapter(
+		AdapterWithCapacity(10),
+		AdapterWithAlgorithm(LRU),
+		AdapterWithWAL(path),
+		AdapterWithWALCheckpointInterval(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.(*Adapter).Close()
+	expiration := time.Now().Add(1 * time.Minute)
+	for i := 0; i < 10; i++ {
+		a.Set(1, []byte(fmt.Sprintf("value %v", i)), expiration)
+	}
+	written, _ := os.Stat(path)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		info, err := os.Stat(path)
+		if err == nil && info.Size() < written.Size() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("write-ahead log was not checkpointed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWALConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.wal")
+	newAdapter := func() *Adapter {
+		a, err := NewAdapter(AdapterWithCapacity(1000), AdapterWithAlgorithm(LRU), AdapterWithWAL(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a.(*Adapter)
+	}
+
+	a := newAdapter()
+	expiration := time.Now().Add(1 * time.Minute)
+	var wg sync.WaitGroup
+	for key := uint64(0); key < 200; key++ {
+		wg.Add(2)
+		go func(key uint64) {
+			defer wg.Done()
+			a.Set(key, []byte("value"), expiration)
+		}(key)
+		go func(key uint64) {
+			defer wg.Done()
+			a.Release(key)
+		}(key)
+	}
+	wg.Wait()
+	a.Close()
+
+	recovered := newAdapter()
+	defer recovered.Close()
+	if err := recovered.Recover(); err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	for key := uint64(0); key < 200; key++ {
+		_, want := a.store[key]
+		if _, ok := recovered.store[key]; ok != want {
+			t.Errorf("key %v recovered = %v, want %v", key, ok, want)
+		}
+	}
+
+	// The appends to a log open read-only fail.
+	a.wal, _ = os.Open(path)
+	defer a.Close()
+	a.Set(1, []byte("value"), expiration)
+	if got := a.Stats().WALErrors; got != 1 {
+		t.Errorf("Stats().WALErrors = %v, want 1", got)
+	}
+}
+
+func TestCleanupInterval(t *testing.T) {
+	a, err := NewAdapter(
+		AdapterWithCapacity(10),
+		AdapterWithAlgorithm(LRU),
+		AdapterWithCleanupInterval(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter := a.(*Adapter)
+	defer adapter.Close()
+
+	a.Set(1, []byte("value 1"), time.Now().Add(20*time.Millisecond))
+	a.Set(2, []byte("value 2"), time.Now().Add(1*time.Minute))
+
+	deadline := time.Now().Add(1 * time.Second)
+	for {
+		adapter.mutex.RLock()
+		_, ok := adapter.store[1]
+		adapter.mutex.RUnlock()
+		if !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expired key 1 was not released by the janitor")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, ok := a.Get(2); !ok {
+		t.Errorf("janitor released the fresh key 2")
+	}
+
+	adapter.Close()
+	adapter.Close()
+	a.Set(3, []byte("value 3"), time.Now().Add(-1*time.Minute))
+	time.Sleep(30 * time.Millisecond)
+	adapter.mutex.RLock()
+	_, ok := adapter.store[3]
+	adapter.mutex.RUnlock()
+	if !ok {
+		t.Errorf("closed janitor released key 3")
+	}
+
+	noJanitor, _ := NewAdapter(AdapterWithCapacity(10), AdapterWithAlgorithm(LRU))
+	if noJanitor.(*Adapter).done != nil {
+		t.Errorf("janitor started without cleanup interval")
+	}
+	noJanitor.(*Adapter).Close()
+}
+
+func TestContextAdapter(t *testing.T) {
+	a, _ := NewAdapter(AdapterWithCapacity(10), AdapterWithAlgorithm(LRU))
+	ca := a.(cache.ContextAdapter)
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expiration := time.Now().Add(1 * time.Minute)
+
+	ca.SetCtx(canceled, 1, []byte("value 1"), expiration)
+	if _, ok := a.Get(1); ok {
+		t.Errorf("SetCtx() cached a response with a canceled context")
+	}
+
+	ca.SetCtx(context.Background(), 1, []byte("value 1"), expiration)
+	if b, ok := ca.GetCtx(canceled, 1); !ok || string(b) != "value 1" {
+		t.Errorf("GetCtx() = %v, %v, want value 1, true whatever the context", string(b), ok)
+	}
+
+	ca.ReleaseCtx(canceled, 1)
+	if _, ok := a.Get(1); !ok {
+		t.Errorf("ReleaseCtx() released a response with a canceled context")
+	}
+	ca.ReleaseCtx(context.Background(), 1)
+	if _, ok := a.Get(1); ok {
+		t.Errorf("ReleaseCtx() did not release the response")
+	}
+}
+
+func TestGDSF(t *testing.T) {
+	a, err := NewAdapter(
+		AdapterWithCapacity(3),
+		AdapterWithAlgorithm(GDSF),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiration := time.Now().Add(1 * time.Minute)
+	set := func(key uint64, cost int) {
+		a.(*Adapter).SetWithTags(context.Background(), key, []byte("value"), expiration, nil, cost)
+	}
+
+	set(1, 2000)
+	for key := uint64(2); key < 10; key++ {
+		set(key, 5)
+	}
+
+	if _, ok := a.Get(1); !ok {
+		t.Error("low-cost responses pressure evicted the high-cost response")
+	}
+	for _, key := range []uint64{8, 9} {
+		if _, ok := a.Get(key); !ok {
+			t.Errorf("latest low-cost key %v should be cached", key)
+		}
+	}
+	if got := len(a.(*Adapter).store); got != 3 {
+		t.Errorf("store length = %v, want 3", got)
+	}
+}
+
+func TestMiddlewareCostFunc(t *testing.T) {
+	a, err := NewAdapter(
+		AdapterWithCapacity(2),
+		AdapterWithAlgorithm(GDSF),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _ := cache.NewClient(
+		cache.ClientWithAdapter(a),
+		cache.ClientWithTTL(1*time.Minute),
+		cache.ClientWithCostFunc(func(c echo.Context, genDuration time.Duration) int {
+			return int(genDuration / time.Millisecond)
+		}),
+	)
+	handler := func(c echo.Context) error {
+		if c.Request().URL.Path == "/expensive" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		return c.String(http.StatusOK, "value")
+	}
+	mw := client.Middleware()(handler)
+	e := echo.New()
+	get := func(path string) string {
+		r := httptest.NewRequest(http.MethodGet, "http://foo.bar"+path, nil)
+		w := httptest.NewRecorder()
+		mw(e.NewContext(r, w))
+		return w.Header().Get("X-Cache")
+	}
+
+	get("/expensive")
+	for i := 0; i < 5; i++ {
+		get(fmt.Sprintf("/cheap-%v", i))
+	}
+
+	if got := get("/expensive"); got != "HIT" {
+		t.Errorf("cheap responses pressure evicted the expensive response, X-Cache = %v", got)
+	}
+}
+
+func TestReleaseByTag(t *testing.T) {
+	a, err := NewAdapter(
+		AdapterWithCapacity(10),
+		AdapterWithAlgorithm(LRU),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter := a.(*Adapter)
+	expiration := time.Now().Add(1 * time.Minute)
+	set := func(key uint64, expiration time.Time, tags ...string) {
+		adapter.SetWithTags(context.Background(), key, []byte("value"), expiration, tags, 0)
+	}
+
+	set(1, expiration, "product:1", "catalog")
+	set(2, expiration, "product:2", "catalog")
+	set(3, expiration, "user:1")
+
+	if got := adapter.ReleaseByTag("catalog"); got != 2 {
+		t.Errorf("ReleaseByTag() = %v, want 2", got)
+	}
+	for key, want := range map[uint64]bool{1: false, 2: false, 3: true} {
+		if _, ok := a.Get(key); ok != want {
+			t.Errorf("Get(%v) ok = %v, want %v", key, ok, want)
+		}
+	}
+	if got := adapter.ReleaseByTag("catalog"); got != 0 {
+		t.Errorf("ReleaseByTag() again = %v, want 0", got)
+	}
+	if _, ok := adapter.tags["product:1"]; ok {
+		t.Errorf("tags index = %v, want product:1 removed", adapter.tags)
+	}
+
+	// Retagging a key replaces its tags.
+	set(3, expiration, "user:2")
+	if got := adapter.ReleaseByTag("user:1"); got != 0 {
+		t.Errorf("ReleaseByTag() stale tag = %v, want 0", got)
+	}
+
+	// Expired responses leave the index when released.
+	set(4, time.Now().Add(-1*time.Minute), "expired")
+	a.Get(4)
+	if _, ok := adapter.tags["expired"]; ok {
+		t.Errorf("tags index = %v, want expired tag removed", adapter.tags)
+	}
+}
+
+func TestTagsIndexEviction(t *testing.T) {
+	a, err := NewAdapter(
+		AdapterWithCapacity(2),
+		AdapterWithAlgorithm(LRU),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter := a.(*Adapter)
+	expiration := time.Now().Add(1 * time.Minute)
+	for key := uint64(1); key <= 100; key++ {
+		adapter.SetWithTags(context.Background(), key, []byte("value"), expiration, []string{fmt.Sprintf("tag:%v", key), "shared"}, 0)
+	}
+
+	if got := len(adapter.keyTags); got != len(adapter.store) {
+		t.Errorf("tagged keys = %v, want %v", got, len(adapter.store))
+	}
+	if got := len(adapter.tags); got != 3 {
+		t.Errorf("tags = %v, want 3", got)
+	}
+	if got := len(adapter.tags["shared"]); got != 2 {
+		t.Errorf("shared tag keys = %v, want 2", got)
+	}
+}
+
+func TestMiddlewareTagsEncrypted(t *testing.T) {
+	a, err := NewAdapter(
+		AdapterWithCapacity(10),
+		AdapterWithAlgorithm(LRU),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := cache.NewClient(
+		cache.ClientWithAdapter(a),
+		cache.ClientWithTTL(1*time.Minute),
+		cache.ClientWithEncryption([]byte("0123456789abcdef0123456789abcdef")),
+		cache.ClientWithCompression(true),
+		cache.ClientWithCachePlan(func(c echo.Context) (*cache.CachePlan, bool) {
+			return &cache.CachePlan{Tags: []string{"catalog"}}, true
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := func(c echo.Context) error {
+		return c.String(http.StatusOK, "value")
+	}
+	mw := client.Middleware()(handler)
+	e := echo.New()
+	for _, path := range []string{"/1", "/2"} {
+		r := httptest.NewRequest(http.MethodGet, "http://foo.bar"+path, nil)
+		mw(e.NewContext(r, httptest.NewRecorder()))
+	}
+
+	if got := a.(*Adapter).ReleaseByTag("catalog"); got != 2 {
+		t.Errorf("ReleaseByTag() of encrypted responses = %v, want 2", got)
+	}
+}
+
+func TestMemoryPressure(t *testing.T) {
+	pressure := false
+	a, _ := NewAdapter(
+		AdapterWithCapacity(4),
+		AdapterWithAlgorithm(LRU),
+		AdapterWithMemoryPressureFunc(func() bool {
+			return pressure
+		}),
+	)
+	expiration := time.Now().Add(1 * time.Minute)
+
+	a.Set(1, []byte("value 1"), expiration)
+
+	pressure = true
+	a.Set(2, []byte("value 2"), expiration)
+	if _, ok := a.Get(2); ok {
+		t.Error("Set() should be skipped under memory pressure")
+	}
+	if _, ok := a.Get(1); !ok {
+		t.Error("Get() should serve cached responses under memory pressure")
+	}
+	if got := a.(*Adapter).Stats().SkippedSets; got != 1 {
+		t.Errorf("Stats().SkippedSets = %v, want 1", got)
+	}
+
+	pressure = false
+	a.Set(2, []byte("value 2"), expiration)
+	if _, ok := a.Get(2); !ok {
+		t.Error("Set() should resume without memory pressure")
+	}
+}
+
+func TestHeapAllocAbove(t *testing.T) {
+	if HeapAllocAbove(0, time.Minute)() != true {
+		t.Error("HeapAllocAbove(0) should report pressure")
+	}
+	if HeapAllocAbove(1<<62, time.Minute)() != false {
+		t.Error("HeapAllocAbove(1<<62) should not report pressure")
+	}
+}
+
+func BenchmarkWatermarks(b *testing.B) {
+	const capacity = 1000
+	for _, gap := range []int{0, 10, 100, 500} {
+		b.Run(fmt.Sprintf("gap=%v", gap), func(b *testing.B) {
+			opts := []AdapterOptions{
+				AdapterWithCapacity(capacity),
+				AdapterWithAlgorithm(LRU),
+			}
+			if gap > 0 {
+				opts = append(opts, AdapterWithWatermarks(capacity, capacity-gap))
+			}
+			a, err := NewAdapter(opts...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			value := []byte("value")
+			expiration := time.Now().Add(1 * time.Minute)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				a.Set(uint64(i), value, expiration)
+			}
+		})
+	}
+}
+
+func TestMiddlewareInvalidate(t *testing.T) {
+	a, err := NewAdapter(
+		AdapterWithCapacity(10),
+		AdapterWithAlgorithm(LRU),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _ := cache.NewClient(
+		cache.ClientWithAdapter(a),
+		cache.ClientWithTTL(1*time.Minute),
+	)
+	mw := client.Middleware()(func(c echo.Context) error {
+		return c.String(http.StatusOK, "value")
+	})
+	get := func() string {
+		r := httptest.NewRequest(http.MethodGet, "http://foo.bar/records/1", nil)
+		w := httptest.NewRecorder()
+		mw(echo.New().NewContext(r, w))
+		return w.Header().Get("X-Cache")
+	}
+
+	get()
+	if got := get(); got != "HIT" {
+		t.Fatalf("*Client.Middleware() X-Cache = %v, want HIT", got)
+	}
+	if err := client.Invalidate(httptest.NewRequest(http.MethodGet, "http://foo.bar/records/1", nil)); err != nil {
+		t.Fatalf("*Client.Invalidate() error = %v", err)
+	}
+	if got := get(); got != "" {
+		t.Errorf("*Client.Middleware() X-Cache = %v after Invalidate(), want none", got)
+	}
+}
@@ -0,0 +1,142 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package memory
+
+import (
+	"fmt"
+	"time"
+
+	cache "github.com/rishikesh-parspec/echo-http-cache"
+)
+
+// AdapterWithShards splits the store in n shards, each with its own lock,
+// the keys being routed to them by key % n, to reduce the lock contention
+// under concurrency. Each shard evicts on its own: the capacity, max
+// bytes, watermarks, tenant quota and segment caps are divided between
+// the shards, rounded up, so the responses evicted are the ones selected
+// by the caching algorithm within a shard, not the whole store. A single
+// shard is used by default, and is required by the write-ahead log.
+func AdapterWithShards(n int) AdapterOptions {
+	return func(a *Adapter) error {
+		if n < 1 {
+			return fmt.Errorf("memory adapter requires at least 1 shard, got %v", n)
+		}
+
+		a.shards = nil
+		if n > 1 {
+			a.shards = make([]*Adapter, n)
+		}
+
+		return nil
+	}
+}
+
+// startShards initializes the shards with their part of the limits.
+func (a *Adapter) startShards() error {
+	n := len(a.shards)
+	var segmentCaps map[string]int
+	if a.segmentCaps != nil {
+		segmentCaps = make(map[string]int, len(a.segmentCaps))
+		for segment, limit := range a.segmentCaps {
+			segmentCaps[segment] = divideUp(limit, n)
+		}
+	}
+
+	for i := range a.shards {
+		shard := &Adapter{
+			algorithm:         a.algorithm,
+			capacity:          divideUp(a.capacity, n),
+			maxBytes:          (a.maxBytes + int64(n) - 1) / int64(n),
+			tenantClassifier:  a.tenantClassifier,
+			tenantQuota:       divideUp(a.tenantQuota, n),
+			segmentClassifier: a.segmentClassifier,
+			segmentCaps:       segmentCaps,
+			highWatermark:     divideUp(a.highWatermark, n),
+			lowWatermark:      divideUp(a.lowWatermark, n),
+			memoryPressure:    a.memoryPressure,
+			cleanupInterval:   a.cleanupInterval,
+		}
+		if err := shard.start(); err != nil {
+			return err
+		}
+		a.shards[i] = shard
+	}
+	return nil
+}
+
+// shard returns the shard of the key.
+func (a *Adapter) shard(key uint64) *Adapter {
+	return a.shards[key%uint64(len(a.shards))]
+}
+
+// divideUp returns n divided by d, rounded up.
+func divideUp(n, d int) int {
+	return (n + d - 1) / d
+}
+
+// shardsReleaseByTag releases the responses with the given tag from every
+// shard, and returns how many were released.
+func (a *Adapter) shardsReleaseByTag(tag string) int {
+	released := 0
+	for _, shard := range a.shards {
+		released += shard.ReleaseByTag(tag)
+	}
+	return released
+}
+
+// shardsTTLHistogram merges the TTL histograms of the shards.
+func (a *Adapter) shardsTTLHistogram(buckets []time.Duration) map[time.Duration]int {
+	histogram := map[time.Duration]int{}
+	for _, shard := range a.shards {
+		for bucket, count := range shard.TTLHistogram(buckets) {
+			histogram[bucket] += count
+		}
+	}
+	return histogram
+}
+
+// shardsAdapterStats sums the statistics of the shards.
+func (a *Adapter) shardsAdapterStats() cache.AdapterStats {
+	stats := cache.AdapterStats{}
+	for _, shard := range a.shards {
+		s := shard.AdapterStats()
+		stats.Entries += s.Entries
+		stats.Evictions += s.Evictions
+		stats.SizeBytes += s.SizeBytes
+	}
+	return stats
+}
+
+// shardsStats sums the memory adapter statistics of the shards.
+func (a *Adapter) shardsStats() Stats {
+	stats := Stats{}
+	for _, shard := range a.shards {
+		s := shard.Stats()
+		stats.Entries += s.Entries
+		stats.SkippedSets += s.SkippedSets
+		stats.OversizeSets += s.OversizeSets
+	}
+	return stats
+}
@@ -0,0 +1,138 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestShards(t *testing.T) {
+	a, err := NewAdapter(
+		AdapterWithCapacity(8),
+		AdapterWithAlgorithm(LRU),
+		AdapterWithShards(4),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter := a.(*Adapter)
+	expiration := time.Now().Add(1 * time.Minute)
+	set := func(key uint64, tags ...string) {
+		adapter.SetWithTags(context.Background(), key, []byte(fmt.Sprintf("value %v", key)), expiration, tags, 0)
+	}
+
+	for key := uint64(1); key <= 4; key++ {
+		set(key, "catalog")
+	}
+	for key := uint64(1); key <= 4; key++ {
+		if _, ok := adapter.shard(key).store[key]; !ok {
+			t.Errorf("key %v is not in shard %v", key, key%4)
+		}
+		if _, ok := a.Get(key); !ok {
+			t.Errorf("key %v should be cached", key)
+		}
+	}
+
+	set(8)
+	if got := len(adapter.shard(0).store); got != 2 {
+		t.Errorf("shard 0 length = %v, want its capacity 2", got)
+	}
+	if got := adapter.AdapterStats(); got.Entries != 5 || got.Evictions != 0 {
+		t.Errorf("AdapterStats() = %+v, want 5 entries and no eviction", got)
+	}
+	set(12)
+	if _, ok := a.Get(4); ok {
+		t.Errorf("least recently used key 4 of shard 0 should be evicted")
+	}
+	if got := adapter.AdapterStats(); got.Entries != 5 || got.Evictions != 1 {
+		t.Errorf("AdapterStats() = %+v, want 5 entries and 1 eviction", got)
+	}
+
+	if got := adapter.ReleaseByTag("catalog"); got != 3 {
+		t.Errorf("ReleaseByTag() = %v, want 3", got)
+	}
+	if got := adapter.Stats().Entries; got != 2 {
+		t.Errorf("Stats() entries = %v, want 2", got)
+	}
+	if got := adapter.TTLHistogram([]time.Duration{time.Hour})[time.Hour]; got != 2 {
+		t.Errorf("TTLHistogram() = %v, want 2", got)
+	}
+
+	a.Release(12)
+	if _, ok := a.Get(12); ok {
+		t.Errorf("key 12 should be released")
+	}
+	if err := a.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	if got := adapter.AdapterStats().Entries; got != 0 {
+		t.Errorf("AdapterStats() entries after Purge() = %v, want 0", got)
+	}
+}
+
+func TestNewAdapterShards(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []AdapterOptions
+		wantShards int
+		wantErr    bool
+	}{
+		{
+			"returns adapter with shards",
+			[]AdapterOptions{AdapterWithShards(4)},
+			4,
+			false,
+		},
+		{
+			"returns adapter without shards for a single shard",
+			[]AdapterOptions{AdapterWithShards(1)},
+			0,
+			false,
+		},
+		{
+			"returns error without shard",
+			[]AdapterOptions{AdapterWithShards(0)},
+			0,
+			true,
+		},
+		{
+			"returns adapter with shards and watermarks",
+			[]AdapterOptions{AdapterWithShards(4), AdapterWithWatermarks(10, 2)},
+			4,
+			false,
+		},
+		{
+			"returns error with shards and write-ahead log",
+			[]AdapterOptions{AdapterWithShards(4), AdapterWithWAL("cache.wal")},
+			0,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewAdapter(append([]AdapterOptions{
+				AdapterWithCapacity(10),
+				AdapterWithAlgorithm(LRU),
+			}, tt.opts...)...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewAdapter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			adapter := a.(*Adapter)
+			if got := len(adapter.shards); got != tt.wantShards {
+				t.Errorf("NewAdapter() shards = %v, want %v", got, tt.wantShards)
+			}
+			for _, shard := range adapter.shards {
+				if shard.capacity != 3 {
+					t.Errorf("NewAdapter() shard capacity = %v, want 3", shard.capacity)
+				}
+				if shard.highWatermark > 0 && (shard.lowWatermark < 1 || shard.lowWatermark > shard.highWatermark) {
+					t.Errorf("NewAdapter() shard watermarks = %v, %v, want a low one between 1 and the high one", shard.highWatermark, shard.lowWatermark)
+				}
+			}
+		})
+	}
+}
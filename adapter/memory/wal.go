@@ -0,0 +1,256 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package memory
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// The write-ahead log operations.
+const (
+	walSet byte = iota + 1
+	walRelease
+	walPurge
+)
+
+// walHeaderSize is the size of a write-ahead log record before its value:
+// the operation, the key, the expiration in Unix nanoseconds, the cost,
+// the tags length and the value length. The tags follow the value, each
+// prefixed by its length.
+const walHeaderSize = 1 + 8 + 8 + 8 + 4 + 4
+
+// walRecord is a write-ahead log record.
+type walRecord struct {
+	op         byte
+	key        uint64
+	expiration time.Time
+	value      []byte
+	tags       []string
+	cost       int
+}
+
+// bytes encodes the record.
+func (r walRecord) bytes() []byte {
+	tags := []byte{}
+	size := make([]byte, binary.MaxVarintLen64)
+	for _, t := range r.tags {
+		tags = append(tags, size[:binary.PutUvarint(size, uint64(len(t)))]...)
+		tags = append(tags, t...)
+	}
+	b := make([]byte, walHeaderSize+len(r.value)+len(tags))
+	b[0] = r.op
+	binary.BigEndian.PutUint64(b[1:], r.key)
+	binary.BigEndian.PutUint64(b[9:], uint64(r.expiration.UnixNano()))
+	binary.BigEndian.PutUint64(b[17:], uint64(r.cost))
+	binary.BigEndian.PutUint32(b[25:], uint32(len(tags)))
+	binary.BigEndian.PutUint32(b[29:], uint32(len(r.value)))
+	copy(b[walHeaderSize:], r.value)
+	copy(b[walHeaderSize+len(r.value):], tags)
+	return b
+}
+
+// readWALRecord decodes the next record of the reader. A record truncated
+// by a crash is reported as io.EOF.
+func readWALRecord(r io.Reader) (walRecord, error) {
+	header := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return walRecord{}, io.EOF
+		}
+		return walRecord{}, err
+	}
+	record := walRecord{
+		op:         header[0],
+		key:        binary.BigEndian.Uint64(header[1:]),
+		expiration: time.Unix(0, int64(binary.BigEndian.Uint64(header[9:]))),
+		cost:       int(binary.BigEndian.Uint64(header[17:])),
+		value:      make([]byte, binary.BigEndian.Uint32(header[29:])),
+	}
+	tags := make([]byte, binary.BigEndian.Uint32(header[25:]))
+	for _, b := range [][]byte{record.value, tags} {
+		if _, err := io.ReadFull(r, b); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return walRecord{}, io.EOF
+			}
+			return walRecord{}, err
+		}
+	}
+	for len(tags) > 0 {
+		n, size := binary.Uvarint(tags)
+		if size <= 0 || uint64(len(tags)-size) < n {
+			return walRecord{}, errors.New("memory adapter write-ahead log record tags are corrupted")
+		}
+		record.tags = append(record.tags, string(tags[size:size+int(n)]))
+		tags = tags[size+int(n):]
+	}
+	return record, nil
+}
+
+// openWAL opens the write-ahead log for appending, creating it if needed.
+func openWAL(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+}
+
+// logWAL appends the record to the write-ahead log, if any. The mutex
+// must be held, for the records to be in the order of the store changes.
+// Failed writes are counted, the cache is still served from memory.
+func (a *Adapter) logWAL(record walRecord) {
+	a.walMutex.Lock()
+	defer a.walMutex.Unlock()
+	if a.wal != nil {
+		if _, err := a.wal.Write(record.bytes()); err != nil {
+			atomic.AddInt64(&a.walErrors, 1)
+		}
+	}
+}
+
+// Recover replays the write-ahead log set by AdapterWithWAL, caching again
+// the responses it holds that are not expired, then checkpoints it. It
+// must be called on startup, before the adapter is used.
+func (a *Adapter) Recover() error {
+	if a.walPath == "" {
+		return errors.New("memory adapter write-ahead log is not set")
+	}
+	f, err := os.Open(a.walPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	records := map[uint64]walRecord{}
+	order := []uint64{}
+	r := bufio.NewReader(f)
+	for {
+		record, err := readWALRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch record.op {
+		case walSet:
+			if _, ok := records[record.key]; !ok {
+				order = append(order, record.key)
+			}
+			records[record.key] = record
+		case walRelease:
+			delete(records, record.key)
+		case walPurge:
+			records = map[uint64]walRecord{}
+			order = order[:0]
+		}
+	}
+
+	// The replayed responses are already in the log.
+	a.walMutex.Lock()
+	wal := a.wal
+	a.wal = nil
+	a.walMutex.Unlock()
+	now := time.Now()
+	for _, key := range order {
+		if record, ok := records[key]; ok && record.expiration.After(now) {
+			a.set(key, record.value, record.expiration, record.tags, record.cost)
+		}
+	}
+	a.walMutex.Lock()
+	a.wal = wal
+	a.walMutex.Unlock()
+
+	return a.Checkpoint()
+}
+
+// Checkpoint rewrites the write-ahead log set by AdapterWithWAL with only
+// the cached responses that are not expired, bounding its replay time.
+func (a *Adapter) Checkpoint() error {
+	if a.walPath == "" {
+		return errors.New("memory adapter write-ahead log is not set")
+	}
+	// The store is locked first, as by the operations appending to the
+	// log, and until the new log is open, for none to be lost.
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	a.walMutex.Lock()
+	defer a.walMutex.Unlock()
+
+	tmp := a.walPath + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	now := time.Now()
+	for k, e := range a.store {
+		if e.expiration.After(now) {
+			w.Write(walRecord{op: walSet, key: k, expiration: e.expiration, value: e.value, tags: a.keyTags[k], cost: e.cost}.bytes())
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, a.walPath); err != nil {
+		return err
+	}
+
+	wal, err := openWAL(a.walPath)
+	if err != nil {
+		return err
+	}
+	if a.wal != nil {
+		a.wal.Close()
+	}
+	a.wal = wal
+	return nil
+}
+
+// checkpointer checkpoints the write-ahead log every checkpoint interval,
+// until the adapter is closed.
+func (a *Adapter) checkpointer() {
+	ticker := time.NewTicker(a.checkpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.Checkpoint()
+		case <-a.done:
+			return
+		}
+	}
+}
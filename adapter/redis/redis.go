@@ -26,56 +26,516 @@ package redis
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	cache "github.com/rishikesh-parspec/echo-http-cache"
 	redisCache "github.com/go-redis/cache/v8"
 	"github.com/go-redis/redis/v8"
+	cache "github.com/rishikesh-parspec/echo-http-cache"
+)
+
+const (
+	defaultScanCount   = 100
+	defaultMaxScanKeys = 10000
 )
 
 // Adapter is the memory adapter data structure.
 type Adapter struct {
-	store *redisCache.Cache
+	store       *redisCache.Cache
+	client      shardedClient
+	scanCount   int64
+	maxScanKeys int
+	chunkSize   int
+	prefix      string
+	created     bool
+	now         func() time.Time
+
+	// onError is called with the errors of the Get and Set calls other
+	// than misses, if set.
+	onError func(err error)
 }
 
+// AdapterOptions is used to set Adapter settings.
+type AdapterOptions func(a *Adapter)
+
 // RingOptions exports go-redis RingOptions type.
 type RingOptions redis.RingOptions
 
+// ClusterOptions exports go-redis ClusterOptions type.
+type ClusterOptions redis.ClusterOptions
+
+// shardedClient is implemented by the go-redis Ring and Cluster clients.
+type shardedClient interface {
+	redis.Cmdable
+	ForEachShard(ctx context.Context, fn func(ctx context.Context, client *redis.Client) error) error
+}
+
 // Get implements the cache Adapter interface Get method.
 func (a *Adapter) Get(key uint64) ([]byte, bool) {
+	return a.GetCtx(context.Background(), key)
+}
+
+// GetCtx implements the cache ContextAdapter interface GetCtx method. Any
+// error is treated as a miss, and reported to the error handler unless it
+// is one.
+func (a *Adapter) GetCtx(ctx context.Context, key uint64) ([]byte, bool) {
 	var c []byte
-	if err := a.store.Get(context.Background(), cache.KeyAsString(key), &c); err == nil {
+	err := a.store.Get(ctx, a.prefix+cache.KeyAsString(key), &c)
+	if err == nil {
 		return c, true
 	}
+	if err != redisCache.ErrCacheMiss {
+		a.reportError(err)
+	}
 
 	return nil, false
 }
 
+// reportError calls the error handler with the error, if set.
+func (a *Adapter) reportError(err error) {
+	if a.onError != nil {
+		a.onError(err)
+	}
+}
+
 // Set implements the cache Adapter interface Set method.
 func (a *Adapter) Set(key uint64, response []byte, expiration time.Time) {
-	a.store.Set(&redisCache.Item{
-		Key:   cache.KeyAsString(key),
+	a.SetCtx(context.Background(), key, response, expiration)
+}
+
+// SetCtx implements the cache ContextAdapter interface SetCtx method. It
+// blocks until Redis confirms the write, so every cached response costs
+// the request a round trip, retries included. The errors, e.g. a reset
+// connection, are reported to the error handler.
+func (a *Adapter) SetCtx(ctx context.Context, key uint64, response []byte, expiration time.Time) {
+	err := a.store.Set(&redisCache.Item{
+		Ctx:   ctx,
+		Key:   a.prefix + cache.KeyAsString(key),
 		Value: response,
 		TTL:   expiration.Sub(time.Now()),
 	})
+	if err != nil {
+		a.reportError(err)
+		return
+	}
+	if a.created {
+		if err := a.client.Set(ctx, a.createdKey(key), a.now().UnixNano(), expiration.Sub(time.Now())).Err(); err != nil {
+			a.reportError(err)
+		}
+	}
+}
+
+// createdKey returns the key holding the creation time of the value stored
+// for the given key.
+func (a *Adapter) createdKey(key uint64) string {
+	return a.prefix + "created:" + cache.KeyAsString(key)
 }
 
 // Release implements the cache Adapter interface Release method.
 func (a *Adapter) Release(key uint64) {
-	a.store.Delete(context.Background(), cache.KeyAsString(key))
+	a.ReleaseCtx(context.Background(), key)
 }
 
-// Purge implements the Adapter interface Purge method
-func (a *Adapter) Purge() {
-	panic("not implemented")
+// ReleaseCtx implements the cache ContextAdapter interface ReleaseCtx
+// method. The chunks of the value stored apart for the key, if any, are
+// deleted too.
+func (a *Adapter) ReleaseCtx(ctx context.Context, key uint64) {
+	a.store.Delete(ctx, a.prefix+cache.KeyAsString(key))
+	if a.created {
+		a.client.Del(ctx, a.createdKey(key))
+	}
+	if a.chunkSize == 0 {
+		return
+	}
+
+	streamKey := a.prefix + "stream:" + cache.KeyAsString(key)
+	count, err := a.client.Get(ctx, streamKey).Int()
+	if err != nil {
+		return
+	}
+	keys := []string{streamKey}
+	for i := 0; i < count; i++ {
+		keys = append(keys, chunkKey(streamKey, i))
+	}
+	// The chunks may be on different shards, they are deleted one by one.
+	for _, k := range keys {
+		a.client.Del(ctx, k)
+	}
+}
+
+// errPurgeWithoutPrefix is returned by Purge if no key prefix is set, not
+// to delete the keys of other applications.
+var errPurgeWithoutPrefix = errors.New("redis adapter key prefix is not set, purge would delete every key")
+
+// Purge implements the cache Adapter interface Purge method. Every key
+// with the adapter key prefix is deleted with UNLINK, by batches found
+// with non-blocking SCAN MATCH calls on every shard.
+func (a *Adapter) Purge() error {
+	if a.prefix == "" {
+		return errPurgeWithoutPrefix
+	}
+
+	return a.client.ForEachShard(context.Background(), func(ctx context.Context, client *redis.Client) error {
+		iter := client.Scan(ctx, 0, matchPrefix(a.prefix), a.scanCount).Iterator()
+		keys := []string{}
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+			if int64(len(keys)) >= a.scanCount {
+				if err := client.Unlink(ctx, keys...).Err(); err != nil {
+					return err
+				}
+				keys = keys[:0]
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			return client.Unlink(ctx, keys...).Err()
+		}
+		return nil
+	})
+}
+
+// errPurgeWithoutCreationTimes is returned by PurgeOlderThan if creation
+// times are not stored.
+var errPurgeWithoutCreationTimes = errors.New("redis adapter creation times are not stored, set AdapterWithCreationTimes")
+
+// PurgeOlderThan releases the values stored more than the given duration
+// ago, found by scanning their creation times on every shard. It requires
+// AdapterWithCreationTimes and, as Purge, a key prefix. Values stored
+// before creation times were enabled are left untouched.
+func (a *Adapter) PurgeOlderThan(ctx context.Context, d time.Duration) error {
+	if a.prefix == "" {
+		return errPurgeWithoutPrefix
+	}
+	if !a.created {
+		return errPurgeWithoutCreationTimes
+	}
+
+	createdPrefix := a.prefix + "created:"
+	cutoff := a.now().Add(-d).UnixNano()
+	return a.client.ForEachShard(ctx, func(ctx context.Context, client *redis.Client) error {
+		iter := client.Scan(ctx, 0, matchPrefix(createdPrefix), a.scanCount).Iterator()
+		for iter.Next(ctx) {
+			created, err := client.Get(ctx, iter.Val()).Int64()
+			if err == redis.Nil {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if created >= cutoff {
+				continue
+			}
+			key, err := strconv.ParseUint(strings.TrimPrefix(iter.Val(), createdPrefix), 36, 64)
+			if err != nil {
+				continue
+			}
+			a.ReleaseCtx(ctx, key)
+		}
+		return iter.Err()
+	})
+}
+
+// matchPrefix returns the SCAN MATCH pattern of the keys with the given
+// prefix, its glob special characters escaped.
+func matchPrefix(prefix string) string {
+	var b strings.Builder
+	for _, r := range prefix {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteRune('*')
+	return b.String()
+}
+
+// unlockScript deletes the lock key only if it still holds the token of
+// the lock holder, not to release a lock taken after it expired.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// Lock implements the cache Locker interface Lock method with SET NX PX.
+// The key is considered unlocked if Redis can't be reached.
+func (a *Adapter) Lock(key uint64, ttl time.Duration) (func(), bool) {
+	ctx := context.Background()
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return func() {}, true
+	}
+	lockKey := a.prefix + "lock:" + cache.KeyAsString(key)
+	token := hex.EncodeToString(b)
+
+	ok, err := a.client.SetNX(ctx, lockKey, token, ttl).Result()
+	if err != nil {
+		return func() {}, true
+	}
+	if !ok {
+		return nil, false
+	}
+
+	return func() {
+		unlockScript.Run(ctx, a.client, []string{lockKey}, token)
+	}, true
+}
+
+// errChunkingDisabled is returned by SetStream if no chunk size is set.
+var errChunkingDisabled = errors.New("redis adapter chunk size is not set")
+
+// SetStream implements the cache StreamAdapter interface SetStream method.
+// The value is stored in chunks of the configured size, each in its own
+// key, the count of chunks is stored last.
+func (a *Adapter) SetStream(key uint64, value io.Reader, expiration time.Time) error {
+	if a.chunkSize == 0 {
+		return errChunkingDisabled
+	}
+	ctx := context.Background()
+	ttl := expiration.Sub(time.Now())
+	streamKey := a.prefix + "stream:" + cache.KeyAsString(key)
+
+	buf := make([]byte, a.chunkSize)
+	count := 0
+	for {
+		n, err := io.ReadFull(value, buf)
+		if n > 0 {
+			if err := a.client.Set(ctx, chunkKey(streamKey, count), buf[:n], ttl).Err(); err != nil {
+				return err
+			}
+			count++
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return a.client.Set(ctx, streamKey, count, ttl).Err()
+}
+
+// GetStream implements the cache StreamAdapter interface GetStream method.
+// The chunks are fetched one at a time, as the value is read.
+func (a *Adapter) GetStream(key uint64) (io.ReadCloser, bool) {
+	streamKey := a.prefix + "stream:" + cache.KeyAsString(key)
+	count, err := a.client.Get(context.Background(), streamKey).Int()
+	if err != nil {
+		if err != redis.Nil {
+			a.reportError(err)
+		}
+		return nil, false
+	}
+
+	return &chunkReader{client: a.client, key: streamKey, count: count}, true
+}
+
+func chunkKey(streamKey string, i int) string {
+	return streamKey + ":" + strconv.Itoa(i)
+}
+
+// chunkReader reads a value stored in chunks, holding at most one chunk
+// in memory.
+type chunkReader struct {
+	client shardedClient
+	key    string
+	count  int
+	next   int
+	chunk  []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.next == r.count {
+			return 0, io.EOF
+		}
+		b, err := r.client.Get(context.Background(), chunkKey(r.key, r.next)).Bytes()
+		if err != nil {
+			return 0, err
+		}
+		r.chunk = b
+		r.next++
+	}
+
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+func (r *chunkReader) Close() error {
+	r.chunk = nil
+	r.next = r.count
+	return nil
+}
+
+// Generation implements the cache GenerationAdapter interface Generation
+// method.
+func (a *Adapter) Generation(key string) (int64, error) {
+	g, err := a.client.Get(context.Background(), a.prefix+key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return g, err
+}
+
+// IncrGeneration implements the cache GenerationAdapter interface
+// IncrGeneration method with INCR.
+func (a *Adapter) IncrGeneration(key string) (int64, error) {
+	return a.client.Incr(context.Background(), a.prefix+key).Result()
+}
+
+// KeysMatching returns the keys matching the given pattern, scanning every
+// shard with non-blocking SCAN MATCH calls. It fails once more keys than
+// the configured maximum are found.
+func (a *Adapter) KeysMatching(ctx context.Context, pattern string) ([]string, error) {
+	var mutex sync.Mutex
+	keys := []string{}
+	err := a.client.ForEachShard(ctx, func(ctx context.Context, client *redis.Client) error {
+		iter := client.Scan(ctx, 0, pattern, a.scanCount).Iterator()
+		for iter.Next(ctx) {
+			mutex.Lock()
+			keys = append(keys, iter.Val())
+			found := len(keys)
+			mutex.Unlock()
+			if found > a.maxScanKeys {
+				return fmt.Errorf("redis adapter found more than %v keys matching %s", a.maxScanKeys, pattern)
+			}
+		}
+		return iter.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// TTLHistogram implements the cache TTLHistogramAdapter interface
+// TTLHistogram method. Keys are sampled with SCAN, up to the configured
+// maximum, and keys without expiration are counted in cache.TTLInfinity.
+// An empty histogram is returned if Redis can't be scanned.
+func (a *Adapter) TTLHistogram(buckets []time.Duration) map[time.Duration]int {
+	ctx := context.Background()
+	ttls := []time.Duration{}
+	keys, err := a.KeysMatching(ctx, matchPrefix(a.prefix))
+	if err != nil {
+		return cache.BuildTTLHistogram(buckets, ttls)
+	}
+
+	for _, key := range keys {
+		ttl, err := a.client.TTL(ctx, key).Result()
+		if err != nil || ttl == -2 {
+			continue
+		}
+		if ttl == -1 {
+			ttl = cache.TTLInfinity
+		}
+		ttls = append(ttls, ttl)
+	}
+
+	return cache.BuildTTLHistogram(buckets, ttls)
 }
 
 // NewAdapter initializes Redis adapter.
-func NewAdapter(opt *RingOptions) cache.Adapter {
+func NewAdapter(opt *RingOptions, opts ...AdapterOptions) cache.Adapter {
 	ropt := redis.RingOptions(*opt)
-	return &Adapter{
-		redisCache.New(&redisCache.Options{
-			Redis: redis.NewRing(&ropt),
+	return newAdapter(redis.NewRing(&ropt), opts...)
+}
+
+// NewClusterAdapter initializes Redis adapter for a Redis Cluster.
+func NewClusterAdapter(opt *ClusterOptions, opts ...AdapterOptions) cache.Adapter {
+	copt := redis.ClusterOptions(*opt)
+	return newAdapter(redis.NewClusterClient(&copt), opts...)
+}
+
+func newAdapter(client shardedClient, opts ...AdapterOptions) *Adapter {
+	a := &Adapter{
+		store: redisCache.New(&redisCache.Options{
+			Redis: client,
 		}),
+		client:      client,
+		scanCount:   defaultScanCount,
+		maxScanKeys: defaultMaxScanKeys,
+		now:         time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return a
+}
+
+// AdapterWithScanCount sets the COUNT hint of the SCAN calls used to
+// iterate over keys. Values lower than 1 are ignored. Default is 100.
+func AdapterWithScanCount(count int64) AdapterOptions {
+	return func(a *Adapter) {
+		if count > 0 {
+			a.scanCount = count
+		}
+	}
+}
+
+// AdapterWithMaxScanKeys sets the maximum number of keys a scan may
+// return. Values lower than 1 are ignored. Default is 10000.
+func AdapterWithMaxScanKeys(max int) AdapterOptions {
+	return func(a *Adapter) {
+		if max > 0 {
+			a.maxScanKeys = max
+		}
+	}
+}
+
+// AdapterWithChunkSize enables storing large values apart in chunks of
+// the given size, so the cache client can stream them with
+// cache.ClientWithStreamThreshold. Values lower than 1 are ignored.
+// Disabled by default.
+func AdapterWithChunkSize(size int) AdapterOptions {
+	return func(a *Adapter) {
+		if size > 0 {
+			a.chunkSize = size
+		}
+	}
+}
+
+// AdapterWithKeyPrefix prefixes every key the adapter creates, so Purge
+// deletes them, and only them. Purge fails without prefix.
+func AdapterWithKeyPrefix(prefix string) AdapterOptions {
+	return func(a *Adapter) {
+		a.prefix = prefix
+	}
+}
+
+// AdapterWithErrorHandler sets the function called with the errors of the
+// Get, GetStream and Set calls, e.g. when Redis is unreachable, to log or
+// alert on them. Failed reads are still treated as misses and failed
+// writes are not retried by the cache client. Actual misses are not
+// errors. A nil handler is ignored.
+func AdapterWithErrorHandler(handler func(err error)) AdapterOptions {
+	return func(a *Adapter) {
+		if handler != nil {
+			a.onError = handler
+		}
+	}
+}
+
+// AdapterWithCreationTimes stores the creation time of every value next
+// to it, with the same expiration, so PurgeOlderThan can release the
+// values stored before a cutoff. Disabled by default, as it doubles the
+// writes.
+func AdapterWithCreationTimes() AdapterOptions {
+	return func(a *Adapter) {
+		a.created = true
 	}
 }
@@ -1,22 +1,51 @@
 package redis
 
 import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo/v4"
 	cache "github.com/rishikesh-parspec/echo-http-cache"
 )
 
-var a cache.Adapter
+var (
+	a cache.Adapter
+	s *miniredis.Miniredis
+)
+
+func TestMain(m *testing.M) {
+	var err error
+	s, err = miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
 
-func TestSet(t *testing.T) {
 	a = NewAdapter(&RingOptions{
 		Addrs: map[string]string{
-			"server": ":6379",
+			"server": s.Addr(),
 		},
 	})
 
+	code := m.Run()
+	s.Close()
+	os.Exit(code)
+}
+
+func TestSet(t *testing.T) {
+
 	tests := []struct {
 		name     string
 		key      uint64
@@ -126,3 +155,594 @@ func TestRelease(t *testing.T) {
 		})
 	}
 }
+
+func TestKeysMatching(t *testing.T) {
+	s.FlushAll()
+	for _, key := range []string{"page:1", "page:2", "page:3", "user:1", "pages"} {
+		s.Set(key, "value")
+	}
+
+	tests := []struct {
+		name    string
+		opts    []AdapterOptions
+		pattern string
+		want    []string
+		wantErr bool
+	}{
+		{
+			"returns matching keys",
+			[]AdapterOptions{AdapterWithScanCount(1)},
+			"page:*",
+			[]string{"page:1", "page:2", "page:3"},
+			false,
+		},
+		{
+			"returns no keys",
+			nil,
+			"session:*",
+			[]string{},
+			false,
+		},
+		{
+			"returns error over max keys",
+			[]AdapterOptions{AdapterWithMaxScanKeys(2)},
+			"page:*",
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := NewAdapter(&RingOptions{
+				Addrs: map[string]string{
+					"server": s.Addr(),
+				},
+			}, tt.opts...).(*Adapter)
+
+			got, err := adapter.KeysMatching(context.Background(), tt.pattern)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KeysMatching() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KeysMatching() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTTLHistogram(t *testing.T) {
+	s.FlushAll()
+	s.Set("1", "value")
+	s.SetTTL("1", 30*time.Second)
+	s.Set("2", "value")
+	s.SetTTL("2", 5*time.Minute)
+	s.Set("3", "value")
+
+	want := map[time.Duration]int{
+		time.Minute:       1,
+		10 * time.Minute:  1,
+		cache.TTLInfinity: 1,
+	}
+	got := a.(cache.TTLHistogramAdapter).TTLHistogram([]time.Duration{time.Minute, 10 * time.Minute})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TTLHistogram() = %v, want %v", got, want)
+	}
+}
+
+func TestLock(t *testing.T) {
+	s.FlushAll()
+	locker := a.(cache.Locker)
+
+	unlock, ok := locker.Lock(1, time.Minute)
+	if !ok {
+		t.Fatalf("Lock() ok = false, want true")
+	}
+	if _, ok := locker.Lock(1, time.Minute); ok {
+		t.Errorf("Lock() ok = true on a locked key, want false")
+	}
+	if _, ok := locker.Lock(2, time.Minute); !ok {
+		t.Errorf("Lock() ok = false on another key, want true")
+	}
+
+	unlock()
+	if _, ok := locker.Lock(1, time.Minute); !ok {
+		t.Errorf("Lock() ok = false after unlock, want true")
+	}
+
+	s.FastForward(2 * time.Minute)
+	relock, ok := locker.Lock(1, time.Minute)
+	if !ok {
+		t.Fatalf("Lock() ok = false after expiration, want true")
+	}
+	unlock()
+	if _, ok := locker.Lock(1, time.Minute); ok {
+		t.Errorf("Lock() ok = true, a stale unlock must not release a newer lock")
+	}
+	relock()
+}
+
+func TestDistributedLock(t *testing.T) {
+	s.FlushAll()
+	var calls int32
+	handler := func(c echo.Context) error {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		return c.String(http.StatusOK, "value")
+	}
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 2)
+	for i := range bodies {
+		client, err := cache.NewClient(
+			cache.ClientWithAdapter(NewAdapter(&RingOptions{
+				Addrs: map[string]string{
+					"server": s.Addr(),
+				},
+			})),
+			cache.ClientWithTTL(time.Minute),
+			cache.ClientWithDistributedLock(time.Second),
+		)
+		if err != nil {
+			t.Fatalf("NewClient() error = %v", err)
+		}
+
+		wg.Add(1)
+		go func(i int, mw echo.HandlerFunc) {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+			w := httptest.NewRecorder()
+			mw(echo.New().NewContext(r, w))
+			bodies[i] = w.Body.String()
+		}(i, client.Middleware()(handler))
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("handler calls = %v, want 1", calls)
+	}
+	for _, body := range bodies {
+		if body != "value" {
+			t.Errorf("*Client.Middleware() = %v, want value", body)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	s.FlushAll()
+	const chunkSize = 64 << 10
+	adapter := NewAdapter(&RingOptions{
+		Addrs: map[string]string{
+			"server": s.Addr(),
+		},
+	}, AdapterWithChunkSize(chunkSize)).(*Adapter)
+
+	value := bytes.Repeat([]byte("0123456789abcdef"), 5<<20/16+1)
+	if err := adapter.SetStream(1, bytes.NewReader(value), time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("SetStream() error = %v", err)
+	}
+
+	r, ok := adapter.GetStream(1)
+	if !ok {
+		t.Fatalf("GetStream() ok = false, want true")
+	}
+	cr := r.(*chunkReader)
+	got := new(bytes.Buffer)
+	p := make([]byte, 1<<20)
+	for {
+		n, err := r.Read(p)
+		if n > chunkSize {
+			t.Fatalf("Read() = %v bytes, want at most one chunk of %v", n, chunkSize)
+		}
+		got.Write(p[:n])
+		// Only the chunks read so far, and the one being read, are fetched.
+		if fetched := cr.next * chunkSize; fetched > got.Len()+chunkSize {
+			t.Fatalf("fetched %v bytes after reading %v", fetched, got.Len())
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+	}
+	r.Close()
+	if !bytes.Equal(got.Bytes(), value) {
+		t.Errorf("GetStream() read %v bytes, want the %v bytes stored", got.Len(), len(value))
+	}
+
+	adapter.Release(1)
+	if _, ok := adapter.GetStream(1); ok {
+		t.Errorf("GetStream() ok = true after Release(), want false")
+	}
+	if keys := s.Keys(); len(keys) != 0 {
+		t.Errorf("keys after Release() = %v, want none", keys)
+	}
+
+	noChunks := NewAdapter(&RingOptions{
+		Addrs: map[string]string{
+			"server": s.Addr(),
+		},
+	}).(*Adapter)
+	if err := noChunks.SetStream(1, bytes.NewReader(value), time.Now().Add(time.Minute)); err == nil {
+		t.Errorf("SetStream() error = nil without chunk size, want an error")
+	}
+}
+
+func TestMiddlewareStream(t *testing.T) {
+	s.FlushAll()
+	value := bytes.Repeat([]byte("x"), 1<<20)
+	adapter := NewAdapter(&RingOptions{
+		Addrs: map[string]string{
+			"server": s.Addr(),
+		},
+	}, AdapterWithChunkSize(16<<10))
+	client, err := cache.NewClient(
+		cache.ClientWithAdapter(adapter),
+		cache.ClientWithTTL(time.Minute),
+		cache.ClientWithStreamThreshold(512<<10),
+	)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	calls := 0
+	handler := client.Middleware()(func(c echo.Context) error {
+		calls++
+		return c.Blob(http.StatusOK, "application/octet-stream", value)
+	})
+
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodGet, "http://foo.bar/large", nil)
+		w := httptest.NewRecorder()
+		c := echo.New().NewContext(r, w)
+		handler(c)
+		if !bytes.Equal(w.Body.Bytes(), value) {
+			t.Errorf("*Client.Middleware() body = %v bytes, want %v", w.Body.Len(), len(value))
+		}
+		if i == 1 {
+			if got := w.Header().Get("X-Cache"); got != "HIT" {
+				t.Errorf("X-Cache = %v, want HIT", got)
+			}
+			if got := w.Header().Get("Content-Length"); got != "1048576" {
+				t.Errorf("Content-Length = %v, want 1048576", got)
+			}
+		}
+	}
+	if calls != 1 {
+		t.Errorf("handler calls = %v, want 1", calls)
+	}
+
+	var key string
+	for _, k := range s.Keys() {
+		if !strings.HasPrefix(k, "stream:") {
+			key = k
+		}
+	}
+	b, err := s.Get(key)
+	if err != nil {
+		t.Fatalf("cached response not found: %v", err)
+	}
+	if response := cache.BytesToResponse([]byte(b)); len(response.Value) != 0 || response.StreamSize != int64(len(value)) {
+		t.Errorf("stored response value = %v bytes, StreamSize = %v, want 0 and %v", len(response.Value), response.StreamSize, len(value))
+	}
+
+	s.Del("stream:" + key)
+	r := httptest.NewRequest(http.MethodGet, "http://foo.bar/large", nil)
+	w := httptest.NewRecorder()
+	if err := handler(echo.New().NewContext(r, w)); err == nil {
+		t.Errorf("*Client.Middleware() error = nil with the streamed value gone, want an error")
+	}
+	if s.Exists(key) {
+		t.Errorf("cached response exists, it must be released once its value is gone")
+	}
+}
+
+func TestGeneration(t *testing.T) {
+	s.FlushAll()
+	generations := a.(cache.GenerationAdapter)
+
+	if g, err := generations.Generation("generation"); g != 0 || err != nil {
+		t.Errorf("Generation() = %v, %v, want 0, nil", g, err)
+	}
+	for want := int64(1); want <= 2; want++ {
+		if g, err := generations.IncrGeneration("generation"); g != want || err != nil {
+			t.Errorf("IncrGeneration() = %v, %v, want %v, nil", g, err, want)
+		}
+	}
+	if g, err := generations.Generation("generation"); g != 2 || err != nil {
+		t.Errorf("Generation() = %v, %v, want 2, nil", g, err)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	s.FlushAll()
+	s.Set("foreign", "value")
+	adapter := NewAdapter(&RingOptions{
+		Addrs: map[string]string{
+			"server": s.Addr(),
+		},
+	}, AdapterWithKeyPrefix("cache:"), AdapterWithScanCount(2)).(*Adapter)
+
+	expiration := time.Now().Add(time.Minute)
+	for key := uint64(1); key <= 5; key++ {
+		adapter.Set(key, []byte("value"), expiration)
+	}
+	if _, err := adapter.IncrGeneration("generation"); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(s.Keys()); got != 7 {
+		t.Fatalf("keys before Purge() = %v, want 7", got)
+	}
+
+	if err := adapter.Purge(); err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+	if got := s.Keys(); !reflect.DeepEqual(got, []string{"foreign"}) {
+		t.Errorf("keys after Purge() = %v, want [foreign]", got)
+	}
+	if _, ok := adapter.Get(1); ok {
+		t.Errorf("Get() ok = true after Purge(), want false")
+	}
+
+	if err := a.Purge(); err == nil {
+		t.Errorf("Purge() error = nil without key prefix, want an error")
+	}
+	if got := s.Keys(); !reflect.DeepEqual(got, []string{"foreign"}) {
+		t.Errorf("keys after Purge() without key prefix = %v, want [foreign]", got)
+	}
+}
+
+func TestMatchPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"", "*"},
+		{"cache:", "cache:*"},
+		{"a*b?[c]", `a\*b\?\[c\]*`},
+	}
+	for _, tt := range tests {
+		if got := matchPrefix(tt.prefix); got != tt.want {
+			t.Errorf("matchPrefix(%q) = %v, want %v", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestClusterAdapter(t *testing.T) {
+	s.FlushAll()
+	adapter := NewClusterAdapter(&ClusterOptions{
+		Addrs: []string{s.Addr()},
+	}, AdapterWithKeyPrefix("cache:"))
+
+	expiration := time.Now().Add(time.Minute)
+	adapter.Set(1, []byte("value 1"), expiration)
+	adapter.Set(2, []byte("value 2"), expiration)
+
+	if got, ok := adapter.Get(1); !ok || string(got) != "value 1" {
+		t.Errorf("Get() = %v, %v, want value 1, true", string(got), ok)
+	}
+	adapter.Release(1)
+	if _, ok := adapter.Get(1); ok {
+		t.Errorf("Get() ok = true after Release(), want false")
+	}
+
+	if err := adapter.Purge(); err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+	if _, ok := adapter.Get(2); ok {
+		t.Errorf("Get() ok = true after Purge(), want false")
+	}
+}
+
+func TestContextAdapter(t *testing.T) {
+	s.FlushAll()
+	ca := a.(cache.ContextAdapter)
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expiration := time.Now().Add(1 * time.Minute)
+
+	ca.SetCtx(canceled, 1, []byte("value 1"), expiration)
+	if _, ok := a.Get(1); ok {
+		t.Errorf("SetCtx() cached a response with a canceled context")
+	}
+
+	ca.SetCtx(context.Background(), 1, []byte("value 1"), expiration)
+	if _, ok := ca.GetCtx(canceled, 1); ok {
+		t.Errorf("GetCtx() ok = true with a canceled context, want false")
+	}
+	if b, ok := ca.GetCtx(context.Background(), 1); !ok || string(b) != "value 1" {
+		t.Errorf("GetCtx() = %v, %v, want value 1, true", string(b), ok)
+	}
+
+	ca.ReleaseCtx(context.Background(), 1)
+	if _, ok := a.Get(1); ok {
+		t.Errorf("ReleaseCtx() did not release the response")
+	}
+}
+
+func TestPurgeOlderThan(t *testing.T) {
+	s.FlushAll()
+	now := time.Now()
+	adapter := NewAdapter(&RingOptions{
+		Addrs: map[string]string{
+			"server": s.Addr(),
+		},
+	}, AdapterWithKeyPrefix("cache:"), AdapterWithCreationTimes(), AdapterWithScanCount(2)).(*Adapter)
+
+	ages := map[uint64]time.Duration{
+		1: 2 * time.Hour,
+		2: 90 * time.Minute,
+		3: 30 * time.Minute,
+		4: time.Minute,
+		5: 0,
+	}
+	expiration := now.Add(24 * time.Hour)
+	for key, age := range ages {
+		adapter.now = func() time.Time { return now.Add(-age) }
+		adapter.Set(key, []byte("value"), expiration)
+	}
+	adapter.now = func() time.Time { return now }
+
+	if err := adapter.PurgeOlderThan(context.Background(), time.Hour); err != nil {
+		t.Fatalf("PurgeOlderThan() error = %v", err)
+	}
+	for key, age := range ages {
+		_, ok := adapter.Get(key)
+		if want := age <= time.Hour; ok != want {
+			t.Errorf("Get(%v) of a value stored %v ago ok = %v, want %v", key, age, ok, want)
+		}
+	}
+	if got := len(s.Keys()); got != 6 {
+		t.Errorf("keys after PurgeOlderThan() = %v, want 6", got)
+	}
+
+	withoutCreationTimes := NewAdapter(&RingOptions{
+		Addrs: map[string]string{
+			"server": s.Addr(),
+		},
+	}, AdapterWithKeyPrefix("cache:")).(*Adapter)
+	if err := withoutCreationTimes.PurgeOlderThan(context.Background(), time.Hour); err == nil {
+		t.Errorf("PurgeOlderThan() error = nil without creation times, want an error")
+	}
+	withoutPrefix := NewAdapter(&RingOptions{
+		Addrs: map[string]string{
+			"server": s.Addr(),
+		},
+	}, AdapterWithCreationTimes()).(*Adapter)
+	if err := withoutPrefix.PurgeOlderThan(context.Background(), time.Hour); err == nil {
+		t.Errorf("PurgeOlderThan() error = nil without key prefix, want an error")
+	}
+}
+
+func TestMiddlewareInvalidate(t *testing.T) {
+	s.FlushAll()
+	client, _ := cache.NewClient(
+		cache.ClientWithAdapter(a),
+		cache.ClientWithTTL(1*time.Minute),
+	)
+	mw := client.Middleware()(func(c echo.Context) error {
+		return c.String(http.StatusOK, "value")
+	})
+	get := func() string {
+		r := httptest.NewRequest(http.MethodGet, "http://foo.bar/records/1", nil)
+		w := httptest.NewRecorder()
+		mw(echo.New().NewContext(r, w))
+		return w.Header().Get("X-Cache")
+	}
+
+	get()
+	if got := get(); got != "HIT" {
+		t.Fatalf("*Client.Middleware() X-Cache = %v, want HIT", got)
+	}
+	if err := client.Invalidate(httptest.NewRequest(http.MethodGet, "http://foo.bar/records/1", nil)); err != nil {
+		t.Fatalf("*Client.Invalidate() error = %v", err)
+	}
+	if got := get(); got != "" {
+		t.Errorf("*Client.Middleware() X-Cache = %v after Invalidate(), want none", got)
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	var errs []error
+	a := NewAdapter(&RingOptions{
+		Addrs: map[string]string{
+			"server": s.Addr(),
+		},
+	}, AdapterWithErrorHandler(func(err error) {
+		errs = append(errs, err)
+	}), AdapterWithChunkSize(4)).(*Adapter)
+
+	a.Set(1, []byte("value 1"), time.Now().Add(1*time.Minute))
+	if _, ok := a.Get(1); !ok {
+		t.Fatal("Get() should be a hit")
+	}
+	if _, ok := a.Get(2); ok {
+		t.Fatal("Get() should be a miss")
+	}
+	if _, ok := a.GetStream(2); ok {
+		t.Fatal("GetStream() should be a miss")
+	}
+	if len(errs) != 0 {
+		t.Fatalf("error handler called with %v on misses, want no call", errs)
+	}
+
+	s.SetError("LOADING Redis is loading the dataset in memory")
+	if _, ok := a.Get(1); ok {
+		t.Error("Get() should be a miss while Redis fails")
+	}
+	if _, ok := a.GetStream(1); ok {
+		t.Error("GetStream() should be a miss while Redis fails")
+	}
+	a.Set(2, []byte("value 2"), time.Now().Add(1*time.Minute))
+	if len(errs) != 3 {
+		t.Errorf("error handler called with %v, want 3 errors", errs)
+	}
+
+	s.SetError("")
+	if _, ok := a.Get(2); ok {
+		t.Error("Get() should be a miss after a failed Set()")
+	}
+	if len(errs) != 3 {
+		t.Errorf("error handler called with %v, want 3 errors", errs)
+	}
+}
+
+func TestPoisonGuardKeepsStaleResponseForTTL(t *testing.T) {
+	s.FlushAll()
+	adapter := NewAdapter(&RingOptions{
+		Addrs: map[string]string{
+			"server": s.Addr(),
+		},
+	})
+	client, err := cache.NewClient(
+		cache.ClientWithAdapter(adapter),
+		cache.ClientWithTTL(time.Minute),
+		cache.ClientWithPoisonGuard(true),
+	)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	contentType := echo.MIMETextPlain
+	handler := func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, contentType)
+		return c.String(http.StatusOK, "value")
+	}
+	mw := client.Middleware()(handler)
+	get := func() {
+		r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+		mw(echo.New().NewContext(r, httptest.NewRecorder()))
+	}
+
+	get()
+	keys := s.Keys()
+	if len(keys) != 1 {
+		t.Fatalf("stored keys = %v, want 1", keys)
+	}
+	// Expire the cached response while Redis still holds it.
+	key, _ := strconv.ParseUint(keys[0], 36, 64)
+	b, _ := adapter.Get(key)
+	response := cache.BytesToResponse(b)
+	response.Expiration = time.Now().Add(-time.Second)
+	adapter.Set(key, response.Bytes(), time.Now().Add(time.Hour))
+
+	contentType = echo.MIMEApplicationJSON
+	get()
+	b, ok := adapter.Get(key)
+	if !ok {
+		t.Fatal("Get() ok = false, want the previous response kept")
+	}
+	if got := cache.BytesToResponse(b); got.Header.Get(echo.HeaderContentType) != echo.MIMETextPlain || got.Expiration.After(time.Now()) {
+		t.Errorf("kept response = %v expiring %v, want the stale previous one", got.Header, got.Expiration)
+	}
+	if ttl := s.TTL(keys[0]); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("kept response TTL = %v, want within the client TTL", ttl)
+	}
+}
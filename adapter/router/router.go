@@ -0,0 +1,124 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package router
+
+import (
+	"errors"
+	"time"
+
+	cache "github.com/rishikesh-parspec/echo-http-cache"
+)
+
+// Selector returns the index of the adapter a response is stored in. It is
+// called on Set with the key, the encoded response and its expiration date,
+// so it can route on the response size or on anything decoded from it with
+// cache.BytesToResponse.
+type Selector func(key uint64, response []byte, expiration time.Time) int
+
+// Adapter is the router adapter data structure. Responses are stored in
+// the adapter picked by the selector and looked up in every adapter, in
+// the order they were given.
+type Adapter struct {
+	adapters []cache.Adapter
+	selector Selector
+}
+
+// Get implements the cache Adapter interface Get method.
+func (a *Adapter) Get(key uint64) ([]byte, bool) {
+	for _, adapter := range a.adapters {
+		if b, ok := adapter.Get(key); ok {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+// Set implements the cache Adapter interface Set method. The key is
+// released from the other adapters so a response moving from one adapter
+// to another is not served stale from the previous one. Responses for
+// which the selector returns an out of range index are not stored.
+func (a *Adapter) Set(key uint64, response []byte, expiration time.Time) {
+	i := a.selector(key, response, expiration)
+	if i < 0 || i >= len(a.adapters) {
+		return
+	}
+	for j, adapter := range a.adapters {
+		if j != i {
+			adapter.Release(key)
+		}
+	}
+	a.adapters[i].Set(key, response, expiration)
+}
+
+// Release implements the cache Adapter interface Release method.
+func (a *Adapter) Release(key uint64) {
+	for _, adapter := range a.adapters {
+		adapter.Release(key)
+	}
+}
+
+// Purge implements the cache Adapter interface Purge method. Every adapter
+// is purged even if a previous one fails, and the first error is returned.
+func (a *Adapter) Purge() error {
+	var err error
+	for _, adapter := range a.adapters {
+		if err2 := adapter.Purge(); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// NewAdapter initializes router adapter with the given selector and the
+// adapters it picks from, in lookup order.
+func NewAdapter(selector Selector, adapters ...cache.Adapter) (cache.Adapter, error) {
+	if selector == nil {
+		return nil, errors.New("router adapter selector must not be nil")
+	}
+	if len(adapters) == 0 {
+		return nil, errors.New("router adapter requires at least one adapter")
+	}
+	for _, adapter := range adapters {
+		if adapter == nil {
+			return nil, errors.New("router adapter adapters must not be nil")
+		}
+	}
+	return &Adapter{
+		adapters: adapters,
+		selector: selector,
+	}, nil
+}
+
+// SizeSelector returns a selector storing responses of at most size bytes
+// in the first adapter and larger ones in the second adapter, e.g. small
+// responses in memory and large ones in Redis.
+func SizeSelector(size int) Selector {
+	return func(key uint64, response []byte, expiration time.Time) int {
+		if len(response) <= size {
+			return 0
+		}
+		return 1
+	}
+}
@@ -0,0 +1,144 @@
+package router
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	cache "github.com/rishikesh-parspec/echo-http-cache"
+	"github.com/rishikesh-parspec/echo-http-cache/adapter/memory"
+	"github.com/rishikesh-parspec/echo-http-cache/adapter/redis"
+)
+
+func TestRouting(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("miniredis.Run() error = %v", err)
+	}
+	defer s.Close()
+
+	small, err := memory.NewAdapter(
+		memory.AdapterWithAlgorithm(memory.LRU),
+		memory.AdapterWithCapacity(10),
+	)
+	if err != nil {
+		t.Fatalf("memory.NewAdapter() error = %v", err)
+	}
+	large := redis.NewAdapter(&redis.RingOptions{
+		Addrs: map[string]string{
+			"server": s.Addr(),
+		},
+	})
+	a, err := NewAdapter(SizeSelector(512), small, large)
+	if err != nil {
+		t.Fatalf("router.NewAdapter() error = %v", err)
+	}
+
+	expiration := time.Now().Add(1 * time.Minute)
+	a.Set(1, cache.Response{Value: []byte("small"), Expiration: expiration}.Bytes(), expiration)
+	a.Set(2, cache.Response{Value: []byte(strings.Repeat("large", 200)), Expiration: expiration}.Bytes(), expiration)
+
+	tests := []struct {
+		name      string
+		key       uint64
+		wantValue string
+		inMemory  bool
+		inRedis   bool
+	}{
+		{
+			"small response is stored in memory",
+			1,
+			"small",
+			true,
+			false,
+		},
+		{
+			"large response is stored in redis",
+			2,
+			strings.Repeat("large", 200),
+			false,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, ok := a.Get(tt.key)
+			if !ok {
+				t.Fatalf("router.Get() ok = false, want true")
+			}
+			if got := string(cache.BytesToResponse(b).Value); got != tt.wantValue {
+				t.Errorf("router.Get() = %v, want %v", got, tt.wantValue)
+			}
+			if _, ok := small.Get(tt.key); ok != tt.inMemory {
+				t.Errorf("memory.Get() ok = %v, want %v", ok, tt.inMemory)
+			}
+			if _, ok := large.Get(tt.key); ok != tt.inRedis {
+				t.Errorf("redis.Get() ok = %v, want %v", ok, tt.inRedis)
+			}
+		})
+	}
+
+	a.Set(1, cache.Response{Value: []byte(strings.Repeat("large", 200)), Expiration: expiration}.Bytes(), expiration)
+	if _, ok := small.Get(1); ok {
+		t.Errorf("memory.Get() ok = true after the response moved to redis")
+	}
+	if _, ok := large.Get(1); !ok {
+		t.Errorf("redis.Get() ok = false after the response moved to redis")
+	}
+
+	a.Release(2)
+	if _, ok := a.Get(2); ok {
+		t.Errorf("router.Get() ok = true after Release()")
+	}
+}
+
+func TestNewAdapter(t *testing.T) {
+	m, err := memory.NewAdapter(
+		memory.AdapterWithAlgorithm(memory.LRU),
+		memory.AdapterWithCapacity(10),
+	)
+	if err != nil {
+		t.Fatalf("memory.NewAdapter() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		selector Selector
+		adapters []cache.Adapter
+		wantErr  bool
+	}{
+		{
+			"returns new adapter",
+			SizeSelector(128),
+			[]cache.Adapter{m},
+			false,
+		},
+		{
+			"returns error on nil selector",
+			nil,
+			[]cache.Adapter{m},
+			true,
+		},
+		{
+			"returns error without adapters",
+			SizeSelector(128),
+			nil,
+			true,
+		},
+		{
+			"returns error on nil adapter",
+			SizeSelector(128),
+			[]cache.Adapter{m, nil},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewAdapter(tt.selector, tt.adapters...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("router.NewAdapter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tiered
+
+import (
+	"time"
+
+	cache "github.com/rishikesh-parspec/echo-http-cache"
+)
+
+const (
+	// L1 is the layer label of the first, fastest, cache layer.
+	L1 = "L1"
+
+	// L2 is the layer label of the second cache layer.
+	L2 = "L2"
+)
+
+// Adapter is the tiered adapter data structure. Responses are looked up
+// in the first layer, then in the second one, and promoted to the first
+// layer when found in the second one.
+type Adapter struct {
+	l1 cache.Adapter
+	l2 cache.Adapter
+}
+
+// Get implements the cache Adapter interface Get method.
+func (a *Adapter) Get(key uint64) ([]byte, bool) {
+	b, _, ok := a.GetLayer(key)
+	return b, ok
+}
+
+// GetLayer implements the cache LayeredAdapter interface GetLayer method.
+// Responses found in the second layer are promoted to the first one until
+// their expiration date.
+func (a *Adapter) GetLayer(key uint64) ([]byte, string, bool) {
+	if b, ok := a.l1.Get(key); ok {
+		return b, L1, true
+	}
+
+	b, ok := a.l2.Get(key)
+	if !ok {
+		return nil, "", false
+	}
+	if expiration := cache.BytesToResponse(b).Expiration; expiration.After(time.Now()) {
+		a.l1.Set(key, b, expiration)
+	}
+	return b, L2, true
+}
+
+// Set implements the cache Adapter interface Set method.
+func (a *Adapter) Set(key uint64, response []byte, expiration time.Time) {
+	a.l1.Set(key, response, expiration)
+	a.l2.Set(key, response, expiration)
+}
+
+// Release implements the cache Adapter interface Release method.
+func (a *Adapter) Release(key uint64) {
+	a.l1.Release(key)
+	a.l2.Release(key)
+}
+
+// Purge implements the cache Adapter interface Purge method. The second
+// layer is purged even if the first one fails.
+func (a *Adapter) Purge() error {
+	err := a.l1.Purge()
+	if err2 := a.l2.Purge(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// NewAdapter initializes tiered adapter with the given first and second
+// cache layers, e.g. a memory adapter in front of a Redis adapter.
+func NewAdapter(l1, l2 cache.Adapter) cache.Adapter {
+	return &Adapter{
+		l1: l1,
+		l2: l2,
+	}
+}
@@ -0,0 +1,114 @@
+package tiered
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	cache "github.com/rishikesh-parspec/echo-http-cache"
+	"github.com/rishikesh-parspec/echo-http-cache/adapter/memory"
+)
+
+func newMemoryAdapter(t *testing.T) cache.Adapter {
+	a, err := memory.NewAdapter(
+		memory.AdapterWithAlgorithm(memory.LRU),
+		memory.AdapterWithCapacity(10),
+	)
+	if err != nil {
+		t.Fatalf("memory.NewAdapter() error = %v", err)
+	}
+	return a
+}
+
+func TestGetLayer(t *testing.T) {
+	l1, l2 := newMemoryAdapter(t), newMemoryAdapter(t)
+	a := NewAdapter(l1, l2).(*Adapter)
+	expiration := time.Now().Add(1 * time.Minute)
+	l1.Set(1, cache.Response{Value: []byte("value 1"), Expiration: expiration}.Bytes(), expiration)
+	l2.Set(2, cache.Response{Value: []byte("value 2"), Expiration: expiration}.Bytes(), expiration)
+
+	tests := []struct {
+		name      string
+		key       uint64
+		wantValue string
+		wantLayer string
+		ok        bool
+	}{
+		{
+			"returns L1 response",
+			1,
+			"value 1",
+			L1,
+			true,
+		},
+		{
+			"returns L2 response",
+			2,
+			"value 2",
+			L2,
+			true,
+		},
+		{
+			"returns promoted L2 response from L1",
+			2,
+			"value 2",
+			L1,
+			true,
+		},
+		{
+			"key does not exist",
+			3,
+			"",
+			"",
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, layer, ok := a.GetLayer(tt.key)
+			if ok != tt.ok {
+				t.Errorf("tiered.GetLayer() ok = %v, tt.ok %v", ok, tt.ok)
+				return
+			}
+			if got := string(cache.BytesToResponse(b).Value); got != tt.wantValue {
+				t.Errorf("tiered.GetLayer() = %v, want %v", got, tt.wantValue)
+			}
+			if layer != tt.wantLayer {
+				t.Errorf("tiered.GetLayer() layer = %v, want %v", layer, tt.wantLayer)
+			}
+		})
+	}
+}
+
+func TestMiddlewareCacheLayer(t *testing.T) {
+	l1, l2 := newMemoryAdapter(t), newMemoryAdapter(t)
+	client, _ := cache.NewClient(
+		cache.ClientWithAdapter(NewAdapter(l1, l2)),
+		cache.ClientWithTTL(1*time.Minute),
+		cache.ClientWithDebug(true),
+	)
+	handler := func(c echo.Context) error {
+		return c.String(http.StatusOK, "value")
+	}
+	mw := client.Middleware()(handler)
+	e := echo.New()
+
+	// Fill the cache, then drop the response from L1 only.
+	mw(e.NewContext(httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil), httptest.NewRecorder()))
+	l1.Purge()
+
+	for _, want := range []string{L2, L1} {
+		r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+		w := httptest.NewRecorder()
+		mw(e.NewContext(r, w))
+
+		if got := w.Header().Get("X-Cache"); got != "HIT" {
+			t.Errorf("*Client.Middleware() X-Cache = %v, want HIT", got)
+		}
+		if got := w.Header().Get("X-Cache-Layer"); got != want {
+			t.Errorf("*Client.Middleware() X-Cache-Layer = %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// Caching decisions of the audit records.
+const (
+	DecisionBypass  = "bypass"
+	DecisionHit     = "hit"
+	DecisionMiss    = "miss"
+	DecisionStored  = "stored"
+	DecisionSkipped = "skipped"
+)
+
+const auditContextKey = "echo-http-cache.audit"
+
+// DecisionRecord is the audit record of the caching decision made for a
+// request.
+type DecisionRecord struct {
+	// Key is the cache key of the request, zero if it was not computed.
+	Key uint64
+
+	// Method is the request method.
+	Method string
+
+	// URL is the request URL.
+	URL string
+
+	// StatusCode is the response status code.
+	StatusCode int
+
+	// Directives are the Cache-Control directives of the response.
+	Directives map[string]string
+
+	// Decision is the caching decision, e.g. DecisionHit.
+	Decision string
+
+	// Reason explains the caching decision.
+	Reason string
+}
+
+// startAudit returns the audit record of the request if it is sampled,
+// nil otherwise.
+func (c *Client) startAudit(ctx echo.Context) *DecisionRecord {
+	if c.auditLogger == nil || c.auditRand() >= c.auditSampleRate {
+		return nil
+	}
+	record := &DecisionRecord{
+		Method:   c.effectiveMethod(ctx.Request()),
+		URL:      ctx.Request().URL.String(),
+		Decision: DecisionMiss,
+	}
+	ctx.Set(auditContextKey, record)
+	return record
+}
+
+// finishAudit completes the audit record with the response and logs it.
+func (c *Client) finishAudit(ctx echo.Context, record *DecisionRecord) {
+	record.StatusCode = ctx.Response().Status
+	record.Directives = parseCacheControl(ctx.Response().Header())
+	c.auditLogger(*record)
+}
+
+// decide sets the caching decision of the request, and of its audit
+// record if the request is sampled.
+func decide(ctx echo.Context, key uint64, decision, reason string) {
+	ctx.Set(decisionContextKey, decision)
+	if record, ok := ctx.Get(auditContextKey).(*DecisionRecord); ok {
+		if key != 0 {
+			record.Key = key
+		}
+		record.Decision = decision
+		record.Reason = reason
+	}
+}
@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddlewareDecisionAuditRate(t *testing.T) {
+	tests := []struct {
+		name       string
+		sampleRate float64
+		wantMin    int
+		wantMax    int
+	}{
+		{"disabled", 0, 0, 0},
+		{"every request", 1, 1000, 1000},
+		{"quarter of requests", 0.25, 150, 350},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			records := 0
+			client, _ := NewClient(
+				ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+				ClientWithTTL(1*time.Minute),
+				ClientWithDecisionAudit(tt.sampleRate, func(record DecisionRecord) {
+					mutex.Lock()
+					records++
+					mutex.Unlock()
+				}),
+			)
+			handler := func(c echo.Context) error {
+				return c.String(http.StatusOK, "value")
+			}
+			mw := client.Middleware()(handler)
+			e := echo.New()
+			for i := 0; i < 1000; i++ {
+				r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+				mw(e.NewContext(r, httptest.NewRecorder()))
+			}
+
+			if records < tt.wantMin || records > tt.wantMax {
+				t.Errorf("audit records = %v, want between %v and %v", records, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestMiddlewareDecisionAuditReason(t *testing.T) {
+	var records []DecisionRecord
+	client, _ := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithDecisionAudit(1, func(record DecisionRecord) {
+			records = append(records, record)
+		}),
+	)
+	handler := func(c echo.Context) error {
+		if c.Request().URL.Path == "/error" {
+			c.Response().Header().Set("Cache-Control", "max-age=60")
+			return c.String(http.StatusInternalServerError, "error")
+		}
+		return c.String(http.StatusOK, "value")
+	}
+	mw := client.Middleware()(handler)
+	e := echo.New()
+
+	tests := []struct {
+		name         string
+		method       string
+		url          string
+		wantDecision string
+		wantReason   string
+		wantKey      bool
+		wantStatus   int
+	}{
+		{
+			"stores cacheable response",
+			http.MethodGet,
+			"http://foo.bar/test",
+			DecisionStored,
+			"cacheable response",
+			true,
+			http.StatusOK,
+		},
+		{
+			"hits fresh response",
+			http.MethodGet,
+			"http://foo.bar/test",
+			DecisionHit,
+			"fresh",
+			true,
+			http.StatusOK,
+		},
+		{
+			"skips error response",
+			http.MethodGet,
+			"http://foo.bar/error",
+			DecisionSkipped,
+			"status code or directives not cacheable",
+			true,
+			http.StatusInternalServerError,
+		},
+		{
+			"bypasses method not cacheable",
+			http.MethodPut,
+			"http://foo.bar/test",
+			DecisionBypass,
+			"method not cacheable",
+			false,
+			http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records = nil
+			r := httptest.NewRequest(tt.method, tt.url, nil)
+			mw(e.NewContext(r, httptest.NewRecorder()))
+
+			if len(records) != 1 {
+				t.Fatalf("audit records = %v, want 1", len(records))
+			}
+			record := records[0]
+			if record.Decision != tt.wantDecision || record.Reason != tt.wantReason {
+				t.Errorf("audit decision = %v (%v), want %v (%v)", record.Decision, record.Reason, tt.wantDecision, tt.wantReason)
+			}
+			if (record.Key != 0) != tt.wantKey {
+				t.Errorf("audit key = %v, want key %v", record.Key, tt.wantKey)
+			}
+			if record.Method != tt.method || record.URL != tt.url || record.StatusCode != tt.wantStatus {
+				t.Errorf("audit record = %+v, want %v %v %v", record, tt.method, tt.url, tt.wantStatus)
+			}
+			if cc := record.Directives["max-age"]; (cc == "60") != (tt.wantStatus == http.StatusInternalServerError) {
+				t.Errorf("audit directives = %v", record.Directives)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const authLeakContextKey = "echo-http-cache.auth-leak"
+
+// guardAuthLeak downgrades Cache-Control: public to private on the
+// responses carrying user-specific data, i.e. setting a cookie or
+// answering an authenticated request, and flags them not to be cached.
+func (c *Client) guardAuthLeak(ctx echo.Context) {
+	ctx.Response().Before(func() {
+		header := ctx.Response().Header()
+		if !parseCacheControl(header).has("public") {
+			return
+		}
+		if header.Get("Set-Cookie") == "" && ctx.Request().Header.Get("Authorization") == "" {
+			return
+		}
+
+		values := header.Values("Cache-Control")
+		header.Del("Cache-Control")
+		for _, value := range values {
+			directives := strings.Split(value, ",")
+			for i, d := range directives {
+				if strings.EqualFold(strings.TrimSpace(d), "public") {
+					directives[i] = "private"
+				}
+			}
+			header.Add("Cache-Control", strings.Join(directives, ","))
+		}
+
+		ctx.Logger().Warnf("cache: refused to cache public response with user-specific data for %s", ctx.Request().URL)
+		ctx.Set(authLeakContextKey, true)
+	})
+}
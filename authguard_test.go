@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddlewareAuthLeakGuard(t *testing.T) {
+	tests := []struct {
+		name             string
+		authorization    string
+		setCookie        string
+		cacheControl     string
+		wantCacheControl string
+		wantStored       bool
+	}{
+		{
+			"caches genuinely public response",
+			"",
+			"",
+			"public, max-age=60",
+			"public, max-age=60",
+			true,
+		},
+		{
+			"blocks public response setting a cookie",
+			"",
+			"session=secret",
+			"public, max-age=60",
+			"private, max-age=60",
+			false,
+		},
+		{
+			"blocks public response to authenticated request",
+			"Bearer token",
+			"",
+			"public",
+			"private",
+			false,
+		},
+		{
+			"caches authenticated response not claiming public",
+			"Bearer token",
+			"",
+			"max-age=60",
+			"max-age=60",
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := &adapterMock{store: map[uint64][]byte{}}
+			client, _ := NewClient(
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1*time.Minute),
+				ClientWithAuthLeakGuard(true),
+			)
+			handler := func(c echo.Context) error {
+				c.Response().Header().Set("Cache-Control", tt.cacheControl)
+				if tt.setCookie != "" {
+					c.Response().Header().Set("Set-Cookie", tt.setCookie)
+				}
+				return c.String(http.StatusOK, "value")
+			}
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			client.Middleware()(handler)(echo.New().NewContext(r, w))
+
+			if got := w.Header().Get("Cache-Control"); got != tt.wantCacheControl {
+				t.Errorf("*Client.Middleware() Cache-Control = %v, want %v", got, tt.wantCacheControl)
+			}
+			if got := len(adapter.store) > 0; got != tt.wantStored {
+				t.Errorf("*Client.Middleware() stored = %v, want %v", got, tt.wantStored)
+			}
+		})
+	}
+}
@@ -27,23 +27,74 @@ package cache
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/gob"
 	"errors"
 	"fmt"
 	"hash/fnv"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/api/metric"
+	"golang.org/x/sync/singleflight"
 )
 
+const (
+	// ResponseVersion1 is the serialization version of the responses
+	// cached before versioning: gob encoded, without prefix.
+	ResponseVersion1 = 1
+
+	// ResponseVersion2 is the serialization version of the gob encoded
+	// responses prefixed with their version.
+	ResponseVersion2 = 2
+
+	// ResponseVersion is the current serialization version.
+	ResponseVersion = ResponseVersion2
+)
+
+// versionMarker starts the serialized responses prefixed with their
+// version. Gob streams never start with a zero byte, telling them apart
+// from the version 1 responses.
+const versionMarker = 0x00
+
+// hopByHopHeaders are the headers meaningful for a single connection only,
+// never stored nor replayed. The Trailer header is kept, declaring the
+// cached trailers.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// defaultCacheableStatusCodes are the status codes cacheable by default
+// per RFC 7231, cached unless ClientWithCacheableStatusCodes is set.
+var defaultCacheableStatusCodes = []int{
+	http.StatusOK,
+	http.StatusNonAuthoritativeInfo,
+	http.StatusNoContent,
+	http.StatusPartialContent,
+	http.StatusMultipleChoices,
+	http.StatusMovedPermanently,
+	http.StatusNotFound,
+	http.StatusGone,
+}
+
 // Response is the cached response data structure.
 type Response struct {
 	// Value is the cached response value.
@@ -52,26 +103,184 @@ type Response struct {
 	// Header is the cached response header.
 	Header http.Header
 
+	// StatusCode is the cached response status code.
+	StatusCode int
+
+	// Tags are the invalidation tags of the cached response.
+	Tags []string
+
+	// Vary are the names of the request headers and classifiers the
+	// handler declared the response varies on. An entry with Vary set
+	// only points to the responses cached for each of their values.
+	Vary []string
+
 	// Expiration is the cached response expiration date.
 	Expiration time.Time
 
-	// LastAccess is the last date a cached response was accessed.
-	// Used by LRU and MRU algorithms.
+	// Created is the date the response was cached.
+	Created time.Time
+
+	// LastAccess is the date a cached response was stored, or first hit
+	// with the adaptive TTL. The hits are not stored, the adapters keep
+	// their own access metadata for the LRU and MRU algorithms.
 	LastAccess time.Time
 
-	// Frequency is the count of times a cached response is accessed.
-	// Used for LFU and MFU algorithms.
+	// Frequency is 1 once a cached response is stored, and 2 once it is
+	// hit with the adaptive TTL. The adapters keep their own access count
+	// for the LFU and MFU algorithms.
 	Frequency int
+
+	// Cost is the cost of producing the response, as given by the client
+	// cost function. Used for cost-aware algorithms.
+	Cost int
+
+	// StreamSize is the size of the response value stored apart by a
+	// StreamAdapter, in which case Value is empty. Zero otherwise.
+	StreamSize int64
+
+	// Metadata are the application values stored with the cached
+	// response. Their custom types must be registered with RegisterType.
+	Metadata map[string]interface{}
+
+	// ContentKey is the key the response value is stored under, shared by
+	// the responses with the same content, in which case Value is empty.
+	// Zero otherwise.
+	ContentKey uint64
+
+	// ContentDigest is the SHA-256 digest of the content the response
+	// value is stored for under ContentKey, checked against the stored
+	// one. Empty otherwise.
+	ContentDigest []byte
+
+	// VaryKey is the key of the entry pointing to the response, a variant
+	// of the responses cached for the same request URL. Zero otherwise.
+	VaryKey uint64
+
+	// Trailer are the trailer values of the cached response, sent after
+	// its body, if caching trailers is enabled.
+	Trailer http.Header
+
+	// ETag is the entity tag of the cached response, served in the ETag
+	// header and matched against the If-None-Match request header. Empty
+	// unless ETag generation is enabled.
+	ETag string
+
+	// Compressed tells whether Value is gzipped, if compression is
+	// enabled. The value is decompressed before the response is served.
+	Compressed bool
+
+	// Key is the string key of the request the response is cached for,
+	// if set by ClientWithStringHasher. Empty otherwise.
+	Key string
 }
 
 // Client data structure for HTTP cache middleware.
 type Client struct {
+	// hits, misses, sets, releases and inflight are accessed atomically,
+	// first in the struct to be 64-bit aligned.
+	hits     int64
+	misses   int64
+	sets     int64
+	releases int64
+	inflight int64
+
 	adapter         Adapter
 	ttl             time.Duration
+	ttlByPath       map[string]time.Duration
 	refreshKey      string
 	methods         []string
 	restrictedPaths []string
 	headers         []string
+	languages       []string
+	queryPredicate  func(params url.Values) bool
+	statusCodes     []int
+	absoluteMaxAge  time.Duration
+	hasher          func(b []byte) uint64
+	stringHasher    func(b []byte) string
+	skipEmptyBody   bool
+	revalidation    bool
+	encryptionKeys  []encryptionKey
+	compression     bool
+	adaptiveTTL     *ttlBounds
+
+	maxReplayHeaders     int
+	maxReplayHeaderBytes int
+	cachePlan            func(c echo.Context) (*CachePlan, bool)
+	staleTransform       func(body []byte) []byte
+	precompress          []string
+	locker               Locker
+	lockTTL              time.Duration
+	singleflightTimeout  time.Duration
+	singleflightPolicy   SingleflightPolicy
+	classifiers          map[string]func(r *http.Request) string
+	poisonGuard          bool
+	poisonSizeRatio      float64
+	debug                bool
+	serializationVersion int
+	authLeakGuard        bool
+	costFunc             func(c echo.Context, genDuration time.Duration) int
+	auditSampleRate      float64
+	auditLogger          func(record DecisionRecord)
+	auditRand            func() float64
+	surrogateControl     bool
+	ttlHeader            string
+	forwardSurrogate     bool
+	buffers              chan struct{}
+	claim                string
+	claimParser          func(token string) (map[string]interface{}, error)
+	hitHeaders           map[string]string
+	invalidateKey        string
+	methodOverride       bool
+	keyPathTemplates     [][]string
+	streamThreshold      int
+	contentAddressed     bool
+	varyDeduplication    bool
+	vary                 bool
+	keyGenerator         func(r *http.Request) uint64
+	keepQueryOrder       bool
+	minTTL               time.Duration
+	ignoredParams        map[string]struct{}
+	urlCanonicalization  bool
+	statusHeader         bool
+	hook                 func(c echo.Context, status CacheStatus)
+	onMiss               func(c echo.Context, reason MissReason)
+	trailers             bool
+	maxInflight          int64
+	staleAllowance       time.Duration
+	instruments          *instruments
+	generationKey        string
+	generations          GenerationAdapter
+	stats                StatsAdapter
+	generationRefresh    time.Duration
+	criticalURLs         []string
+	warmFetch            func(URL string) (*http.Response, error)
+	warmed               chan struct{}
+	warmErr              error
+	requestCacheControl  bool
+	botDetector          func(c echo.Context) bool
+	skipper              func(c echo.Context) bool
+	cacheControl         bool
+	etag                 bool
+	asyncFirstFill       func(c echo.Context) error
+	group                *flightGroup
+	staleWhileRevalidate time.Duration
+
+	indexMutex sync.Mutex
+	index      map[uint64]indexEntry
+
+	generationMutex  sync.Mutex
+	generationValue  int64
+	generationReadAt time.Time
+
+	fillMutex sync.Mutex
+	filling   map[uint64]struct{}
+
+	refreshes singleflight.Group
+}
+
+type ttlBounds struct {
+	min time.Duration
+	max time.Duration
 }
 
 type bodyDumpResponseWriter struct {
@@ -97,6 +306,34 @@ func (w *bodyDumpResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return w.ResponseWriter.(http.Hijacker).Hijack()
 }
 
+// CachePlan describes how a request is cached.
+type CachePlan struct {
+	// Key is the cache key. If zero, the key is generated from the
+	// request as usual.
+	Key uint64
+
+	// TTL is how long the response is cached. If zero, the client TTL is
+	// used.
+	TTL time.Duration
+
+	// Tags are the invalidation tags stored with the cached response.
+	Tags []string
+
+	// Vary are the names of the request headers whose values are part of
+	// the generated cache key.
+	Vary []string
+
+	// Metadata are the application values stored with the cached
+	// response. Their custom types must be registered with RegisterType.
+	Metadata map[string]interface{}
+}
+
+const (
+	cachePlanContextKey = "echo-http-cache.plan"
+	costContextKey      = "echo-http-cache.cost"
+	stringKeyContextKey = "echo-http-cache.string-key"
+)
+
 // ClientOption is used to set Client settings.
 type ClientOption func(c *Client) error
 
@@ -113,103 +350,304 @@ type Adapter interface {
 	Release(key uint64)
 
 	// Purges the entire cache.
-	Purge()
+	Purge() error
+}
+
+// ContextAdapter is implemented by the adapters honoring the cancellation
+// and deadline of the request context. The middleware uses it, if
+// implemented, rather than the Adapter methods.
+type ContextAdapter interface {
+	// GetCtx retrieves the cached response by a given key. It also
+	// returns true or false, whether it exists or not.
+	GetCtx(ctx context.Context, key uint64) ([]byte, bool)
+
+	// SetCtx caches a response for a given key until an expiration date.
+	SetCtx(ctx context.Context, key uint64, response []byte, expiration time.Time)
+
+	// ReleaseCtx frees cache for a given key.
+	ReleaseCtx(ctx context.Context, key uint64)
+}
+
+// LayeredAdapter is implemented by the adapters made of several cache
+// layers, to report which layer served a cached response.
+type LayeredAdapter interface {
+	// GetLayer retrieves the cached response by a given key, along with
+	// the label of the layer it was found in. It also returns true or
+	// false, whether it exists or not.
+	GetLayer(key uint64) (response []byte, layer string, ok bool)
+}
+
+// TaggedAdapter is implemented by the adapters indexing the cached
+// responses by tag or weighing them by cost, which they must not read from
+// the encoded, possibly encrypted or compressed, response. The middleware
+// uses it, if implemented, rather than the Set and SetCtx methods.
+type TaggedAdapter interface {
+	// SetWithTags caches a response for a given key until an expiration
+	// date, along with its invalidation tags and the cost of producing
+	// it, as given by the client cost function.
+	SetWithTags(ctx context.Context, key uint64, response []byte, expiration time.Time, tags []string, cost int)
 }
 
 // Middleware is the HTTP cache middleware handler.
 func (client *Client) Middleware() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			if client.skipper != nil && client.skipper(c) {
+				c.Response().Before(func() {
+					if c.Response().Header().Get("X-Cache") == "" {
+						c.Response().Header().Set("X-Cache", string(CacheStatusBypass))
+					}
+				})
+				return next(c)
+			}
+			if record := client.startAudit(c); record != nil {
+				defer client.finishAudit(c, record)
+			}
+			defer client.trackStatus(c)()
+			if client.maxInflight > 0 {
+				atomic.AddInt64(&client.inflight, 1)
+				defer atomic.AddInt64(&client.inflight, -1)
+			}
 			if !client.isAllowedPathToCache(c.Request().URL.String()) {
+				decide(c, 0, DecisionBypass, "restricted path")
+				missBecause(c, MissExcludedPath)
 				next(c)
 				return nil
 			}
-			headers := []string{}
-			if client.headers != nil {
-				for _, h := range client.headers {
-					if c.Request().Header.Get(h) != "" {
-						headers = append(headers, c.Request().Header.Get(h))
-					}
+			if client.queryPredicate != nil && !client.queryPredicate(c.QueryParams()) {
+				decide(c, 0, DecisionBypass, "query predicate")
+				missBecause(c, MissExcludedRequest)
+				next(c)
+				return nil
+			}
+			var plan *CachePlan
+			if client.cachePlan != nil {
+				p, ok := client.cachePlan(c)
+				if !ok {
+					decide(c, 0, DecisionBypass, "cache plan")
+					missBecause(c, MissExcludedRequest)
+					next(c)
+					return nil
+				}
+				if p != nil {
+					plan = p
+					c.Set(cachePlanContextKey, plan)
 				}
 			}
+			method := client.effectiveMethod(c.Request())
+			headers := client.keyHeaders(c.Request(), method, plan)
 
-			if client.cacheableMethod(c.Request().Method) {
-				sortURLParams(c.Request().URL)
-				key := generateKey(c.Request().URL.String(), headers)
-				if c.Request().Method == http.MethodPost && c.Request().Body != nil {
-					body, err := ioutil.ReadAll(c.Request().Body)
+			if client.cacheableMethod(method) {
+				var requestCC cacheControl
+				if client.requestCacheControl {
+					requestCC = parseCacheControl(c.Request().Header)
+					if requestCC.has("no-store") {
+						decide(c, 0, DecisionBypass, "request no-store")
+						missBecause(c, MissNoStore)
+						if err := next(c); err != nil {
+							c.Error(err)
+						}
+						return nil
+					}
+				}
+				bot := client.botDetector != nil && client.botDetector(c)
+				client.canonicalizeURL(c.Request().URL)
+				var body []byte
+				if method == http.MethodPost && c.Request().Body != nil {
+					var err error
+					body, err = ioutil.ReadAll(c.Request().Body)
 					defer c.Request().Body.Close()
 					if err != nil {
+						decide(c, 0, DecisionBypass, "unreadable body")
+						missBecause(c, MissUnreadableBody)
 						next(c)
 						return nil
 					}
-					reader := ioutil.NopCloser(bytes.NewBuffer(body))
-					key = generateKeyWithBody(c.Request().URL.String(), headers, body)
-					c.Request().Body = reader
+					c.Request().Body = ioutil.NopCloser(bytes.NewBuffer(body))
+				}
+				key, stringKey := client.requestKeys(c.Request(), headers, body)
+				if body != nil {
+					// The key generator may have read the body.
+					c.Request().Body = ioutil.NopCloser(bytes.NewBuffer(body))
+				}
+
+				if plan != nil && plan.Key != 0 {
+					key, stringKey = plan.Key, ""
+				}
+				if stringKey != "" {
+					c.Set(stringKeyContextKey, stringKey)
+				}
+
+				if client.authLeakGuard {
+					client.guardAuthLeak(c)
+				}
+				if client.surrogateControl {
+					client.captureSurrogateControl(c)
+				}
+				if client.ttlHeader != "" {
+					client.captureTTLHeader(c)
 				}
 
+				var previous *Response
+				cold := false
 				params := c.Request().URL.Query()
 				if _, ok := params[client.refreshKey]; ok {
 					delete(params, client.refreshKey)
 
 					c.Request().URL.RawQuery = params.Encode()
-					key = generateKey(c.Request().URL.String(), headers)
+					key, stringKey = client.requestKeys(c.Request(), headers, nil)
+					if plan != nil && plan.Key != 0 {
+						key, stringKey = plan.Key, ""
+					}
+					if stringKey != "" {
+						c.Set(stringKeyContextKey, stringKey)
+					}
 
-					client.adapter.Release(key)
+					client.releaseKey(c.Request().Context(), key)
+					client.captureVary(c, key)
+					missBecause(c, MissRefresh)
+				} else if requestCC.has("no-cache") {
+					client.captureVary(c, key)
+					missBecause(c, MissNoCache)
 				} else {
-					b, ok := client.adapter.Get(key)
+					client.captureVary(c, key)
+					start := time.Now()
+					b, layer, ok := client.get(c.Request().Context(), key)
+					client.recordDuration(c, "get", start)
 					response := BytesToResponse(b)
+					if ok && response.Key != stringKey {
+						// Cached for another request of the same key.
+						ok, response = false, Response{}
+					}
+					if ok && len(response.Vary) > 0 {
+						key = client.varyKey(c.Request(), key, response.Vary)
+						b, layer, ok = client.get(c.Request().Context(), key)
+						response = BytesToResponse(b)
+					}
+
+					if len(client.precompress) > 0 {
+						if e := client.negotiateEncoding(c.Request().Header.Get("Accept-Encoding")); e != "" {
+							variantKey := client.variantKey(key, e)
+							if b, ok := client.getCtx(c.Request().Context(), variantKey); ok {
+								response := BytesToResponse(b)
+								if client.isFresh(response) {
+									if !bot {
+										client.markAccessed(c.Request().Context(), variantKey, response)
+									}
+
+									client.hit(c, variantKey, "fresh "+e+" variant")
+									return client.writeResponse(c, variantKey, response, false)
+								}
+							}
+						}
+					}
+
 					if ok {
-						if response.Expiration.After(time.Now()) {
-							response.LastAccess = time.Now()
-							response.Frequency++
-							client.adapter.Set(key, response.Bytes(), response.Expiration)
-
-							//w.WriteHeader(http.StatusNotModified)
-							for k, v := range response.Header {
-								c.Response().Header().Set(k, strings.Join(v, ","))
+						if client.isFresh(response) {
+							if !bot {
+								client.markAccessed(c.Request().Context(), key, response)
+							}
+
+							if layer != "" {
+								c.Response().Header().Set("X-Cache-Layer", layer)
 							}
-							// write a custom header X-Cache: HIT
-							c.Response().Header().Set("X-Cache", "HIT")
-							c.Response().WriteHeader(http.StatusOK)
-							c.Response().WriteHeader(http.StatusOK)
-							c.Response().Write(response.Value)
-							return nil
+							client.hit(c, key, "fresh")
+
+							return client.writeResponse(c, key, response, false)
+						}
+						if client.servesStaleWhileRevalidate(response) {
+							client.hit(c, key, "stale while revalidating")
+							client.revalidateAsync(c, next, key, response)
+							return client.writeResponse(c, key, response, true)
+						}
+						if client.acceptsStale(c.Request(), response) {
+							client.hit(c, key, "within request max-stale")
+							return client.writeResponse(c, key, response, true)
 						}
+						if client.shedsLoad(response) {
+							client.hit(c, key, "stale under load shedding")
+							return client.writeResponse(c, key, response, true)
+						}
+
+						missBecause(c, MissExpired)
+						if client.revalidation && hasValidators(response) {
+							return client.revalidate(c, next, key, response)
+						}
+						previous = &response
+						client.releaseCtx(c.Request().Context(), key)
+					} else {
+						cold = true
+						client.unindexKey(key)
+						missBecause(c, MissNotCached)
+					}
+				}
 
-						client.adapter.Release(key)
+				if bot {
+					client.miss(c)
+					decide(c, key, DecisionSkipped, "bot request")
+					if err := next(c); err != nil {
+						c.Error(err)
 					}
+					return nil
 				}
 
-				resBody := new(bytes.Buffer)
-				mw := io.MultiWriter(c.Response().Writer, resBody)
-				writer := &bodyDumpResponseWriter{Writer: mw, ResponseWriter: c.Response().Writer}
-				c.Response().Writer = writer
-				if err := next(c); err != nil {
-					c.Error(err)
+				if cold && client.asyncFirstFill != nil {
+					client.miss(c)
+					decide(c, key, DecisionMiss, "placeholder during first fill")
+					client.fillAsync(c, next, key)
+					return client.asyncFirstFill(c)
 				}
 
-				statusCode := writer.statusCode
-				value := resBody.Bytes()
-				if statusCode < 400 {
-					now := time.Now()
-
-					response := Response{
-						Value:      value,
-						Header:     writer.Header(),
-						Expiration: now.Add(client.ttl),
-						LastAccess: now,
-						Frequency:  1,
+				fill := func(next echo.HandlerFunc) error {
+					if !client.acquireBuffer() {
+						decide(c, key, DecisionBypass, "too many concurrent buffers")
+						missBecause(c, MissOverloaded)
+						if err := next(c); err != nil {
+							c.Error(err)
+						}
+						return nil
+					}
+					defer client.releaseBuffer()
+
+					if client.locker != nil {
+						if unlock, ok := client.locker.Lock(key, client.lockTTL); ok {
+							defer unlock()
+						} else if response, ok := client.awaitFill(key); ok {
+							client.hit(c, key, "filled by lock holder")
+							return client.writeResponse(c, key, response, false)
+						} else if client.singleflightPolicy == SingleflightUnavailable {
+							decide(c, key, DecisionBypass, "singleflight timeout")
+							missBecause(c, MissOverloaded)
+							return echo.NewHTTPError(http.StatusServiceUnavailable, "cache fill in progress")
+						}
+					}
+
+					client.miss(c)
+					resBody := new(bytes.Buffer)
+					mw := io.MultiWriter(c.Response().Writer, resBody)
+					writer := &bodyDumpResponseWriter{Writer: mw, ResponseWriter: c.Response().Writer}
+					c.Response().Writer = writer
+					start := time.Now()
+					if err := next(c); err != nil {
+						c.Error(err)
 					}
-					client.adapter.Set(key, response.Bytes(), response.Expiration)
+					client.measureCost(c, start)
+
+					client.storeResponse(c, key, writer.statusCode, writer.Header(), resBody.Bytes(), previous)
+					//for k, v := range writer.Header() {
+					//	c.Response().Header().Set(k, strings.Join(v, ","))
+					//}
+					//c.Response().WriteHeader(statusCode)
+					//c.Response().Write(value)
+					return nil
 				}
-				//for k, v := range writer.Header() {
-				//	c.Response().Header().Set(k, strings.Join(v, ","))
-				//}
-				//c.Response().WriteHeader(statusCode)
-				//c.Response().Write(value)
-				return nil
+				if client.group != nil {
+					return client.coalesce(c, next, key, fill)
+				}
+				return fill(next)
 			}
+			decide(c, 0, DecisionBypass, "method not cacheable")
+			missBecause(c, MissMethodNotCacheable)
 			if err := next(c); err != nil {
 				c.Error(err)
 			}
@@ -218,162 +656,2061 @@ func (client *Client) Middleware() echo.MiddlewareFunc {
 	}
 }
 
-func (c *Client) cacheableMethod(method string) bool {
-	for _, m := range c.methods {
-		if method == m {
-			return true
-		}
+// GetResponse returns the cached response of a GET request to the given
+// URL as an *http.Response. Only the responses keyed by the URL alone are
+// found, not the ones varying by request headers or body. It returns false
+// if there is no fresh cached response.
+func (c *Client) GetResponse(URL string) (*http.Response, bool) {
+	u, err := url.Parse(URL)
+	if err != nil {
+		return nil, false
 	}
-	return false
+	c.canonicalizeURL(u)
+
+	key, stringKey := c.urlKeys(u)
+	b, ok := c.adapter.Get(key)
+	if !ok {
+		return nil, false
+	}
+	response := BytesToResponse(b)
+	if response.Key != stringKey || !response.Expiration.After(time.Now()) || c.exceedsAbsoluteMaxAge(response) {
+		return nil, false
+	}
+
+	statusCode := response.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	body, err := c.openStream(key, response)
+	if err != nil {
+		return nil, false
+	}
+	size := response.StreamSize
+	if size == 0 {
+		size = int64(len(response.Value))
+	}
+	header := http.Header{}
+	for k, v := range response.Header {
+		header[k] = append([]string{}, v...)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          body,
+		ContentLength: size,
+		Request:       &http.Request{Method: http.MethodGet, URL: u},
+	}, true
 }
 
-func (c *Client) isAllowedPathToCache(URL string) bool {
-	for _, p := range c.restrictedPaths {
-		if strings.Contains(URL, p) {
-			return false
+// storeResponse caches the response returned by the handler, if it is
+// cacheable. The previous cached response for the same key, if any, is
+// used by the adaptive TTL and the poison guard.
+func (c *Client) storeResponse(ctx echo.Context, key uint64, statusCode int, header http.Header, value []byte, previous *Response) {
+	if c.invalidated(ctx) {
+		c.releaseKey(ctx.Request().Context(), key)
+		decide(ctx, key, DecisionSkipped, "invalidated by handler")
+		return
+	}
+	if !c.cacheableStatusCode(statusCode, parseCacheControl(header)) {
+		decide(ctx, key, DecisionSkipped, "status code or directives not cacheable")
+		return
+	}
+	if len(value) == 0 && c.skipEmptyBody {
+		decide(ctx, key, DecisionSkipped, "empty body")
+		return
+	}
+	if varyAny, _ := ctx.Get(varyAnyContextKey).(bool); varyAny {
+		decide(ctx, key, DecisionSkipped, "Vary: *")
+		return
+	}
+	if !c.trailers && hasTrailers(header) {
+		decide(ctx, key, DecisionSkipped, "response trailers")
+		return
+	}
+	if leak, _ := ctx.Get(authLeakContextKey).(bool); leak {
+		decide(ctx, key, DecisionSkipped, "public response with user-specific data")
+		return
+	}
+
+	if c.poisonGuard && previous != nil && c.suspiciousOverwrite(previous, header, value) {
+		decide(ctx, key, DecisionSkipped, "suspicious overwrite")
+		ctx.Logger().Warnf("cache: rejected suspicious overwrite of %s", ctx.Request().URL)
+		// The previous response is kept to check the next overwrites
+		// against, until a refresh is explicitly requested or for a TTL.
+		// It has expired, so it is kept as is, stale, rather than with its
+		// expiration, which the adapter may take as no expiration at all.
+		keep := time.Now().Add(c.pathTTL(ctx.Path(), ctx.Request().URL.Path))
+		c.setCtx(ctx.Request().Context(), key, *previous, keep)
+		return
+	}
+
+	ttl := c.entryTTL(c.pathTTL(ctx.Path(), ctx.Request().URL.Path), previous, value)
+	if c.cacheControl {
+		cc := parseCacheControl(header)
+		if cc.has("no-store") || cc.has("private") {
+			decide(ctx, key, DecisionSkipped, "response no-store or private")
+			return
+		}
+		if maxAge, ok := cc.sharedMaxAge(); ok {
+			ttl = maxAge
 		}
 	}
-	return true
-}
+	if c.surrogateControl {
+		if sc, ok := ctx.Get(surrogateContextKey).(cacheControl); ok {
+			if sc.has("no-store") {
+				decide(ctx, key, DecisionSkipped, "surrogate no-store")
+				return
+			}
+			if maxAge, ok := sc.maxAge(); ok {
+				ttl = maxAge
+			}
+		}
+		if !c.forwardSurrogate && header.Get("Surrogate-Control") != "" {
+			header = header.Clone()
+			header.Del("Surrogate-Control")
+		}
+	}
+	if c.ttlHeader != "" {
+		if headerTTL, ok := ctx.Get(ttlHeaderContextKey).(time.Duration); ok {
+			ttl = headerTTL
+		}
+		if header.Get(c.ttlHeader) != "" {
+			header = header.Clone()
+			header.Del(c.ttlHeader)
+		}
+	}
+	var tags []string
+	var metadata map[string]interface{}
+	if plan, ok := ctx.Get(cachePlanContextKey).(*CachePlan); ok {
+		if plan.TTL > 0 {
+			ttl = plan.TTL
+		}
+		tags = plan.Tags
+		metadata = plan.Metadata
+	}
+	ttl = c.withMinTTL(ttl)
 
-// BytesToResponse converts bytes array into Response data structure.
-func BytesToResponse(b []byte) Response {
-	var r Response
-	dec := gob.NewDecoder(bytes.NewReader(b))
-	dec.Decode(&r)
+	if c.statusHeader {
+		header = header.Clone()
+		header.Del("X-Cache")
+	}
+	header = withoutHopByHop(header)
+	if len(c.precompress) > 0 {
+		header = header.Clone()
+		header.Add("Vary", "Accept-Encoding")
+	}
 
-	return r
-}
+	header, trailer := splitTrailers(header)
 
-// Bytes converts Response data structure into bytes array.
-func (r Response) Bytes() []byte {
-	var b bytes.Buffer
-	enc := gob.NewEncoder(&b)
-	enc.Encode(&r)
+	now := time.Now()
+	stringKey, _ := ctx.Get(stringKeyContextKey).(string)
+	var varyKey uint64
+	if names, ok := ctx.Get(varyContextKey).([]string); ok {
+		base := ctx.Get(cacheKeyContextKey).(uint64)
+		varyKey = base
+		record := Response{Vary: names, Expiration: now.Add(ttl), Created: now, Key: stringKey}
+		c.setCtx(ctx.Request().Context(), base, record, record.Expiration)
+		c.indexKey(base, ctx.Request().URL.String(), record.Expiration)
+		key = c.varyKey(ctx.Request(), base, names)
+	}
 
-	return b.Bytes()
+	cost, _ := ctx.Get(costContextKey).(int)
+	response := Response{
+		Value:      value,
+		Header:     header,
+		StatusCode: statusCode,
+		Tags:       tags,
+		Expiration: now.Add(ttl),
+		Created:    now,
+		LastAccess: now,
+		Frequency:  1,
+		Cost:       cost,
+		Metadata:   metadata,
+		VaryKey:    varyKey,
+		Trailer:    trailer,
+		Key:        stringKey,
+	}
+	if c.etag {
+		response.ETag = responseETag(response)
+	}
+	start := time.Now()
+	stored := c.storeStream(key, response)
+	c.setCtx(ctx.Request().Context(), key, stored, stored.Expiration)
+	c.recordDuration(ctx, "set", start)
+	c.recordStore(ctx)
+	c.indexKey(key, ctx.Request().URL.String(), stored.Expiration)
+	c.storeVariants(ctx, key, response)
+	decide(ctx, key, DecisionStored, "cacheable response")
 }
 
-func sortURLParams(URL *url.URL) {
-	params := URL.Query()
-	for _, param := range params {
-		sort.Slice(param, func(i, j int) bool {
-			return param[i] < param[j]
-		})
+// measureCost gives the cost of the response produced by the handler
+// since start to storeResponse, if a cost function is set.
+func (c *Client) measureCost(ctx echo.Context, start time.Time) {
+	if c.costFunc != nil {
+		ctx.Set(costContextKey, c.costFunc(ctx, time.Since(start)))
 	}
-	URL.RawQuery = params.Encode()
 }
 
-// KeyAsString can be used by adapters to convert the cache key from uint64 to string.
-func KeyAsString(key uint64) string {
-	return strconv.FormatUint(key, 36)
+// acquireBuffer reports whether a response can be buffered for caching,
+// without exceeding the maximum concurrent buffers. Each successful call
+// must be followed by releaseBuffer once the response is cached.
+func (c *Client) acquireBuffer() bool {
+	if c.buffers == nil {
+		return true
+	}
+	select {
+	case c.buffers <- struct{}{}:
+		return true
+	default:
+		return false
+	}
 }
 
-func generateKey(URL string, headers []string) uint64 {
-	hash := fnv.New64a()
-	bytes := []byte(URL)
-	for _, h := range headers {
-		bytes = append(bytes, []byte(h)...)
+func (c *Client) releaseBuffer() {
+	if c.buffers != nil {
+		<-c.buffers
 	}
-	hash.Write(bytes)
-
-	return hash.Sum64()
 }
 
-func generateKeyWithBody(URL string, headers []string, body []byte) uint64 {
-	hash := fnv.New64a()
-	bytes := []byte(URL)
-	for _, h := range headers {
-		bytes = append(bytes, []byte(h)...)
+// get retrieves the cached response by a given key. In debug mode, the
+// label of the adapter layer it was found in is also returned, if the
+// adapter has layers.
+func (c *Client) get(ctx context.Context, key uint64) ([]byte, string, bool) {
+	if c.debug {
+		if la, ok := c.adapter.(LayeredAdapter); ok {
+			return la.GetLayer(key)
+		}
 	}
-	body = append(bytes, body...)
-	hash.Write(body)
-	return hash.Sum64()
+	b, ok := c.getCtx(ctx, key)
+	return b, "", ok
 }
 
-// NewClient initializes the cache HTTP middleware client with the given
-// options.
-func NewClient(opts ...ClientOption) (*Client, error) {
-	c := &Client{}
+// getCtx retrieves the cached response by a given key, with the request
+// context if the adapter is a ContextAdapter.
+func (c *Client) getCtx(ctx context.Context, key uint64) ([]byte, bool) {
+	return adapterGetCtx(c.adapter, ctx, key)
+}
 
-	for _, opt := range opts {
-		if err := opt(c); err != nil {
-			return nil, err
-		}
+// setCtx caches a response for a given key, with its tags and cost if the
+// adapter is a TaggedAdapter, and the request context if the adapter is a
+// ContextAdapter.
+func (c *Client) setCtx(ctx context.Context, key uint64, response Response, expiration time.Time) {
+	adapterSetWithTags(c.adapter, ctx, key, c.encode(response), c.storedUntil(expiration), response.Tags, response.Cost)
+}
+
+// releaseCtx frees cache for a given key, with the request context if the
+// adapter is a ContextAdapter.
+func (c *Client) releaseCtx(ctx context.Context, key uint64) {
+	atomic.AddInt64(&c.releases, 1)
+	c.unindexKey(key)
+	adapterReleaseCtx(c.adapter, ctx, key)
+}
+
+// adapterGetCtx calls the GetCtx method of the adapter if it is a
+// ContextAdapter, its Get method otherwise. The adapters wrapping another
+// one use it, along the other adapter functions below, to implement the
+// optional interfaces whether the wrapped adapter does or not.
+func adapterGetCtx(a Adapter, ctx context.Context, key uint64) ([]byte, bool) {
+	if ca, ok := a.(ContextAdapter); ok {
+		return ca.GetCtx(ctx, key)
 	}
+	return a.Get(key)
+}
 
-	if c.adapter == nil {
-		return nil, errors.New("cache client adapter is not set")
+// adapterSetCtx calls the SetCtx method of the adapter if it is a
+// ContextAdapter, its Set method otherwise.
+func adapterSetCtx(a Adapter, ctx context.Context, key uint64, response []byte, expiration time.Time) {
+	if ca, ok := a.(ContextAdapter); ok {
+		ca.SetCtx(ctx, key, response, expiration)
+		return
 	}
-	if int64(c.ttl) < 1 {
-		return nil, errors.New("cache client ttl is not set")
+	a.Set(key, response, expiration)
+}
+
+// adapterSetWithTags calls the SetWithTags method of the adapter if it is
+// a TaggedAdapter, adapterSetCtx otherwise, without the tags and cost.
+func adapterSetWithTags(a Adapter, ctx context.Context, key uint64, response []byte, expiration time.Time, tags []string, cost int) {
+	if ta, ok := a.(TaggedAdapter); ok {
+		ta.SetWithTags(ctx, key, response, expiration, tags, cost)
+		return
 	}
-	if c.methods == nil {
-		c.methods = []string{http.MethodGet}
+	adapterSetCtx(a, ctx, key, response, expiration)
+}
+
+// adapterReleaseCtx calls the ReleaseCtx method of the adapter if it is a
+// ContextAdapter, its Release method otherwise.
+func adapterReleaseCtx(a Adapter, ctx context.Context, key uint64) {
+	if ca, ok := a.(ContextAdapter); ok {
+		ca.ReleaseCtx(ctx, key)
+		return
 	}
+	a.Release(key)
+}
 
-	return c, nil
+// adapterGetLayer calls the GetLayer method of the adapter if it is a
+// LayeredAdapter, its Get method otherwise, without layer.
+func adapterGetLayer(a Adapter, key uint64) ([]byte, string, bool) {
+	if la, ok := a.(LayeredAdapter); ok {
+		return la.GetLayer(key)
+	}
+	b, ok := a.Get(key)
+	return b, "", ok
 }
 
-// ClientWithAdapter sets the adapter type for the HTTP cache
-// middleware client.
-func ClientWithAdapter(a Adapter) ClientOption {
-	return func(c *Client) error {
-		c.adapter = a
-		return nil
+// defaultInvalidateContextKey is the default context key handlers set to
+// true to invalidate the cached response of the request.
+const defaultInvalidateContextKey = "cache_invalidate"
+
+// invalidated reports whether the handler asked for the cached response
+// of the request to be invalidated.
+func (c *Client) invalidated(ctx echo.Context) bool {
+	key := c.invalidateKey
+	if key == "" {
+		key = defaultInvalidateContextKey
 	}
+	invalidate, _ := ctx.Get(key).(bool)
+	return invalidate
 }
 
-// ClientWithTTL sets how long each response is going to be cached.
-func ClientWithTTL(ttl time.Duration) ClientOption {
-	return func(c *Client) error {
-		if int64(ttl) < 1 {
-			return fmt.Errorf("cache client ttl %v is invalid", ttl)
-		}
+// releaseKey frees the cached response of the key and its precompressed
+// variants.
+func (c *Client) releaseKey(ctx context.Context, key uint64) {
+	c.releaseCtx(ctx, key)
+	for _, e := range c.precompress {
+		c.releaseCtx(ctx, c.variantKey(key, e))
+	}
+}
 
-		c.ttl = ttl
+// isFresh reports whether the cached response can be served as is.
+func (c *Client) isFresh(r Response) bool {
+	return r.Expiration.After(time.Now()) && !c.exceedsAbsoluteMaxAge(r) && c.withinReplayLimits(r)
+}
 
-		return nil
+// acceptsStale reports whether the expired cached response can be served
+// as is, the request accepting a response that stale with max-stale. The
+// response must not require revalidation once stale.
+func (c *Client) acceptsStale(r *http.Request, response Response) bool {
+	maxStale, ok := parseCacheControl(r.Header).maxStale()
+	if !ok {
+		return false
 	}
+	return c.servableStale(response, maxStale)
 }
 
-// ClientWithRefreshKey sets the parameter key used to free a request
-// cached response. Optional setting.
-func ClientWithRefreshKey(refreshKey string) ClientOption {
-	return func(c *Client) error {
-		c.refreshKey = refreshKey
-		return nil
+// shedsLoad reports whether the expired cached response is served as is,
+// without calling the handler, the in-flight requests exceeding the load
+// shedding threshold and the response being stale within the allowance.
+func (c *Client) shedsLoad(response Response) bool {
+	if c.maxInflight == 0 || atomic.LoadInt64(&c.inflight) <= c.maxInflight {
+		return false
 	}
+	return c.servableStale(response, c.staleAllowance)
 }
 
-// ClientWithMethods sets the acceptable HTTP methods to be cached.
-// Optional setting. If not set, default is "GET".
-func ClientWithMethods(methods []string) ClientOption {
-	return func(c *Client) error {
-		for _, method := range methods {
-			if method != http.MethodGet && method != http.MethodPost && method != http.MethodOptions {
-				return fmt.Errorf("invalid method %s", method)
-			}
+// servableStale reports whether the expired cached response is stale by
+// at most maxStale and doesn't require revalidation once stale.
+func (c *Client) servableStale(response Response, maxStale time.Duration) bool {
+	cc := parseCacheControl(response.Header)
+	if cc.has("must-revalidate") || cc.has("proxy-revalidate") || cc.has("no-cache") {
+		return false
+	}
+	return time.Since(response.Expiration) <= maxStale && !c.exceedsAbsoluteMaxAge(response) && c.withinReplayLimits(response)
+}
+
+// pathTTL returns the TTL set by ClientWithTTLByPath for the echo route
+// pattern, or else for the longest prefix of the request path, or the
+// client TTL if none is set.
+func (c *Client) pathTTL(route, path string) time.Duration {
+	if ttl, ok := c.ttlByPath[route]; ok && route != "" {
+		return ttl
+	}
+	ttl, longest := c.ttl, -1
+	for prefix, t := range c.ttlByPath {
+		if len(prefix) > longest && strings.HasPrefix(path, prefix) {
+			ttl, longest = t, len(prefix)
 		}
-		c.methods = methods
-		return nil
 	}
+	return ttl
 }
 
-// ClientWithRestrictedPaths sets the restricted HTTP paths for caching.
-// Optional setting.
-func ClientWithRestrictedPaths(paths []string) ClientOption {
-	return func(c *Client) error {
-		c.restrictedPaths = paths
-		return nil
+// markAccessed records the first hit of the cached response of the key, for
+// the adaptive TTL to tell it was accessed. The later hits are not
+// stored, the adapters keeping their own access metadata, e.g. for the
+// LRU or LFU eviction, without rewriting the response.
+func (c *Client) markAccessed(ctx context.Context, key uint64, response Response) {
+	if c.adaptiveTTL == nil || response.Frequency > 1 {
+		return
 	}
+	response.LastAccess = time.Now()
+	response.Frequency = 2
+	c.setCtx(ctx, key, response, response.Expiration)
 }
 
-// ClientWithHeaders sets the headers to be considered when caching.
-// Optional setting.
-func ClientWithHeaders(headers []string) ClientOption {
-	return func(c *Client) error {
-		c.headers = headers
+// entryTTL returns how long the response is cached, from the given base
+// TTL. With the adaptive TTL, the previous cached response TTL is halved
+// if the response changed, and doubled if it did not and was accessed
+// since it was cached.
+func (c *Client) entryTTL(base time.Duration, previous *Response, value []byte) time.Duration {
+	if c.adaptiveTTL == nil {
+		return base
+	}
+
+	ttl := base
+	if previous != nil && previous.Expiration.After(previous.Created) && !previous.Created.IsZero() {
+		ttl = previous.Expiration.Sub(previous.Created)
+		if !bytes.Equal(previous.Value, value) {
+			ttl /= 2
+		} else if previous.Frequency > 1 {
+			ttl *= 2
+		}
+	}
+
+	if ttl < c.adaptiveTTL.min {
+		return c.adaptiveTTL.min
+	}
+	if ttl > c.adaptiveTTL.max {
+		return c.adaptiveTTL.max
+	}
+	return ttl
+}
+
+// withoutHopByHop returns the header without the hop-by-hop headers, nor
+// the ones listed in its Connection header. The header is cloned if any
+// is removed.
+func withoutHopByHop(header http.Header) http.Header {
+	names := hopByHopHeaders
+	for _, v := range header["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names[:len(names):len(names)], name)
+			}
+		}
+	}
+	cloned := false
+	for _, name := range names {
+		if _, ok := header[http.CanonicalHeaderKey(name)]; !ok {
+			continue
+		}
+		if !cloned {
+			header = header.Clone()
+			cloned = true
+		}
+		header.Del(name)
+	}
+	return header
+}
+
+// withMinTTL raises the TTL to the client minimum TTL, if set.
+func (c *Client) withMinTTL(ttl time.Duration) time.Duration {
+	if ttl < c.minTTL {
+		return c.minTTL
+	}
+	return ttl
+}
+
+// writeResponse writes the cached response of the given key to the client.
+// A stale response carries a Warning header, and its body goes through the
+// stale transform, if set, unless the response forbids transformations
+// with no-transform. A fresh response carries the hit headers, if set,
+// with the Cache-Control max-age of its remaining lifetime. A response
+// value stored apart is streamed, it is only loaded in memory to be
+// transformed.
+func (c *Client) writeResponse(ctx echo.Context, key uint64, response Response, stale bool) error {
+	notModified := !stale && response.ETag != "" && etagMatches(ctx.Request().Header.Get("If-None-Match"), response.ETag)
+	body := ioutil.NopCloser(bytes.NewReader(nil))
+	if !notModified {
+		var err error
+		body, err = c.openStream(key, response)
+		if err != nil {
+			c.releaseKey(ctx.Request().Context(), key)
+			return err
+		}
+	}
+	defer body.Close()
+	size := response.StreamSize
+	if size == 0 {
+		size = int64(len(response.Value))
+	}
+
+	header := ctx.Response().Header()
+	// Responses cached before the hop-by-hop headers were stripped may
+	// still have some.
+	for k, v := range withoutHopByHop(response.Header) {
+		header.Set(k, strings.Join(v, ","))
+	}
+	if response.ETag != "" {
+		header.Set("ETag", response.ETag)
+	}
+	// write a custom header X-Cache: HIT, or STALE
+	if stale {
+		header.Set("X-Cache", string(CacheStatusStale))
+		header.Set("Warning", `110 - "Response is Stale"`)
+		if c.staleTransform != nil && !parseCacheControl(response.Header).has("no-transform") {
+			value, err := ioutil.ReadAll(body)
+			if err != nil {
+				return err
+			}
+			value = c.staleTransform(value)
+			body = ioutil.NopCloser(bytes.NewReader(value))
+			size = int64(len(value))
+		}
+	} else {
+		header.Set("X-Cache", string(CacheStatusHit))
+	}
+
+	now := time.Now()
+	if !stale && c.hitHeaders != nil {
+		for k, v := range c.hitHeaders {
+			header.Set(k, v)
+		}
+		header.Set("Cache-Control", withMaxAge(header.Get("Cache-Control"), response.Expiration.Sub(now)))
+	}
+
+	// The Date header is the time of serving, the Age header tells how old
+	// the cached response is.
+	header.Set("Date", now.UTC().Format(http.TimeFormat))
+	if !response.Created.IsZero() {
+		header.Set("Age", strconv.FormatInt(int64(now.Sub(response.Created)/time.Second), 10))
+	}
+
+	statusCode := response.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	if notModified {
+		statusCode = http.StatusNotModified
+	}
+	// The stored Content-Length may be stale, it must match the replayed body.
+	if statusCode == http.StatusNoContent || statusCode == http.StatusNotModified {
+		header.Del("Content-Length")
+	} else {
+		header.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+
+	ctx.Response().WriteHeader(statusCode)
+	if _, err := io.Copy(ctx.Response(), body); err != nil {
+		return err
+	}
+	if !notModified {
+		writeTrailers(header, response.Trailer)
+	}
+	return nil
+}
+
+// effectiveMethod returns the request method, overridden by the
+// X-HTTP-Method-Override header of POST requests if enabled.
+func (c *Client) effectiveMethod(r *http.Request) string {
+	if c.methodOverride && r.Method == http.MethodPost {
+		if override := r.Header.Get("X-HTTP-Method-Override"); override != "" {
+			return strings.ToUpper(strings.TrimSpace(override))
+		}
+	}
+	return r.Method
+}
+
+func (c *Client) cacheableMethod(method string) bool {
+	for _, m := range c.methods {
+		if method == m {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheableStatusCode reports whether a response with the given status
+// code and Cache-Control directives can be stored. With must-understand,
+// only the status codes explicitly set by ClientWithCacheableStatusCodes
+// are understood, any other response is treated as no-store.
+func (c *Client) cacheableStatusCode(code int, cc cacheControl) bool {
+	codes := c.statusCodes
+	if codes == nil {
+		if cc.has("must-understand") {
+			return false
+		}
+		codes = defaultCacheableStatusCodes
+	}
+	for _, sc := range codes {
+		if code == sc {
+			return true
+		}
+	}
+	return false
+}
+
+// exceedsAbsoluteMaxAge reports whether the cached response is older than
+// the absolute max age. Responses without a creation date are considered
+// too old.
+func (c *Client) exceedsAbsoluteMaxAge(r Response) bool {
+	if c.absoluteMaxAge == 0 {
+		return false
+	}
+	return r.Created.IsZero() || time.Since(r.Created) > c.absoluteMaxAge
+}
+
+// withinReplayLimits reports whether the cached response header is small
+// enough to be replayed.
+func (c *Client) withinReplayLimits(r Response) bool {
+	if c.maxReplayHeaders > 0 && len(r.Header) > c.maxReplayHeaders {
+		return false
+	}
+	if c.maxReplayHeaderBytes > 0 {
+		size := 0
+		for k, values := range r.Header {
+			for _, v := range values {
+				size += len(k) + len(v)
+			}
+		}
+		if size > c.maxReplayHeaderBytes {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Client) isAllowedPathToCache(URL string) bool {
+	for _, p := range c.restrictedPaths {
+		if strings.Contains(URL, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// CommonQueryCombinations returns a query predicate that only allows
+// caching when the set of query parameter names is exactly one of the
+// given combinations. Every other, long-tail, combination shares the
+// "not cached" decision.
+func CommonQueryCombinations(combinations ...[]string) func(params url.Values) bool {
+	common := make(map[string]struct{}, len(combinations))
+	for _, combination := range combinations {
+		common[queryCombinationKey(combination)] = struct{}{}
+	}
+
+	return func(params url.Values) bool {
+		names := make([]string, 0, len(params))
+		for name := range params {
+			names = append(names, name)
+		}
+		_, ok := common[queryCombinationKey(names)]
+		return ok
+	}
+}
+
+func queryCombinationKey(names []string) string {
+	sorted := append([]string{}, names...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, "&")
+}
+
+// preflightKeyHeaders returns the request values a CORS preflight response
+// depends on. The method is included so a preflight never shares a cache
+// entry with another request to the same URL.
+func preflightKeyHeaders(r *http.Request) []string {
+	return []string{
+		http.MethodOptions,
+		r.Header.Get("Origin"),
+		r.Header.Get("Access-Control-Request-Method"),
+		r.Header.Get("Access-Control-Request-Headers"),
+	}
+}
+
+// anonymousClaim is the key component of the requests without a valid
+// bearer token or claim.
+const anonymousClaim = "anonymous"
+
+// claimKeyComponent returns the key component of the claim parsed from
+// the request bearer token.
+func (c *Client) claimKeyComponent(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return c.claim + "=" + anonymousClaim
+	}
+	claims, err := c.claimParser(strings.TrimSpace(auth[7:]))
+	if err != nil {
+		return c.claim + "=" + anonymousClaim
+	}
+	value, ok := claims[c.claim]
+	if !ok || value == nil {
+		return c.claim + "=" + anonymousClaim
+	}
+	return c.claim + "=" + fmt.Sprint(value)
+}
+
+// negotiateLanguage picks the best match for the given Accept-Language
+// header among the client supported languages. The first supported
+// language is used as the default when nothing matches.
+func (c *Client) negotiateLanguage(acceptLanguage string) string {
+	type weightedTag struct {
+		tag string
+		q   float64
+	}
+
+	tags := []weightedTag{}
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					v = 0
+				}
+				q = v
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		tags = append(tags, weightedTag{tag, q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].q > tags[j].q
+	})
+
+	for _, t := range tags {
+		if t.tag == "*" {
+			return c.languages[0]
+		}
+		for _, l := range c.languages {
+			if strings.EqualFold(t.tag, l) {
+				return l
+			}
+		}
+		primary := strings.SplitN(t.tag, "-", 2)[0]
+		for _, l := range c.languages {
+			if strings.EqualFold(primary, strings.SplitN(l, "-", 2)[0]) {
+				return l
+			}
+		}
+	}
+
+	return c.languages[0]
+}
+
+// BytesToResponse converts bytes array into Response data structure.
+// Responses of every known serialization version are decoded, the other
+// ones are returned empty, like expired responses.
+func BytesToResponse(b []byte) Response {
+	r, _ := decodeResponse(b)
+	return r
+}
+
+// RegisterType registers the concrete type of the given value to encode
+// and decode it in the cached response metadata, like gob.Register. The
+// custom types stored as metadata must be registered once, e.g. at init,
+// by every application sharing the cache, or their responses can't be
+// decoded. It is safe to call concurrently with decoding.
+func RegisterType(value interface{}) {
+	gob.Register(value)
+}
+
+// Bytes converts Response data structure into bytes array, with the
+// current serialization version.
+func (r Response) Bytes() []byte {
+	return r.bytesVersion(ResponseVersion)
+}
+
+func (r Response) bytesVersion(version int) []byte {
+	var b bytes.Buffer
+	if version != ResponseVersion1 {
+		b.Write([]byte{versionMarker, byte(version)})
+	}
+	enc := gob.NewEncoder(&b)
+	enc.Encode(&r)
+
+	return b.Bytes()
+}
+
+func decodeResponse(b []byte) (Response, error) {
+	var r Response
+	if len(b) > 0 && b[0] != versionMarker {
+		err := gob.NewDecoder(bytes.NewReader(b)).Decode(&r)
+		migrateResponseV1(&r)
+		return r, err
+	}
+	if len(b) < 2 {
+		return r, errors.New("cached response is too short")
+	}
+	if b[1] != ResponseVersion2 {
+		return r, fmt.Errorf("unknown cached response version %d", b[1])
+	}
+	err := gob.NewDecoder(bytes.NewReader(b[2:])).Decode(&r)
+	return r, err
+}
+
+// migrateResponseV1 fills the fields a version 1 response has no value
+// for. Without a creation date, it is treated as older than any absolute
+// max age.
+func migrateResponseV1(r *Response) {
+	if r.StatusCode == 0 {
+		r.StatusCode = http.StatusOK
+	}
+}
+
+// encode converts the response into bytes array with the client
+// serialization version.
+func (c *Client) encode(r Response) []byte {
+	if c.serializationVersion == 0 {
+		return r.Bytes()
+	}
+	return r.bytesVersion(c.serializationVersion)
+}
+
+// canonicalizeURL sorts the query parameters of the URL, unless disabled,
+// so equivalent URLs share their cached responses.
+func (c *Client) canonicalizeURL(u *url.URL) {
+	if !c.keepQueryOrder {
+		sortURLParams(u)
+	}
+}
+
+// sortURLParams sorts the query parameters by name, then by value, and
+// normalizes their percent-encoding.
+func sortURLParams(URL *url.URL) {
+	params := URL.Query()
+	for _, param := range params {
+		sort.Slice(param, func(i, j int) bool {
+			return param[i] < param[j]
+		})
+	}
+	URL.RawQuery = params.Encode()
+}
+
+// keyURL returns the URL used in the cache key, with the current cache
+// generation, if a generation counter is set.
+func (c *Client) keyURL(u *url.URL) string {
+	URL := c.templateURL(u)
+	if c.generations != nil {
+		URL += "#generation=" + strconv.FormatInt(c.generation(), 10)
+	}
+	return URL
+}
+
+// templateURL returns the URL with the path segments ignored by the first
+// matching key path template replaced by the template segment.
+func (c *Client) templateURL(u *url.URL) string {
+	if len(c.keyPathTemplates) == 0 {
+		return u.String()
+	}
+	segments := strings.Split(u.Path, "/")
+	for _, template := range c.keyPathTemplates {
+		if len(template) != len(segments) {
+			continue
+		}
+		matched := true
+		for i, t := range template {
+			if !strings.HasPrefix(t, ":") && t != segments[i] {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		keyed := *u
+		keyed.Path = strings.Join(template, "/")
+		keyed.RawPath = ""
+		return keyed.String()
+	}
+	return u.String()
+}
+
+// KeyAsString can be used by adapters to convert the cache key from uint64 to string.
+func KeyAsString(key uint64) string {
+	return strconv.FormatUint(key, 36)
+}
+
+func generateKey(URL string, headers []string) uint64 {
+	return fnvHash(keyBytes(URL, headers, nil))
+}
+
+func generateKeyWithBody(URL string, headers []string, body []byte) uint64 {
+	return fnvHash(keyBytes(URL, headers, body))
+}
+
+// keyHeaders returns the request values the cache key of the request is
+// derived from, besides its URL and body.
+func (c *Client) keyHeaders(r *http.Request, method string, plan *CachePlan) []string {
+	headers := []string{}
+	if c.headers != nil {
+		for _, h := range c.headers {
+			if r.Header.Get(h) != "" {
+				headers = append(headers, r.Header.Get(h))
+			}
+		}
+	}
+	if method == http.MethodOptions {
+		headers = append(headers, preflightKeyHeaders(r)...)
+	}
+	if c.languages != nil {
+		headers = append(headers, c.negotiateLanguage(r.Header.Get("Accept-Language")))
+	}
+	if c.claimParser != nil {
+		headers = append(headers, c.claimKeyComponent(r))
+	}
+	if plan != nil {
+		for _, h := range plan.Vary {
+			headers = append(headers, r.Header.Get(h))
+		}
+	}
+	return headers
+}
+
+// requestKey returns the cache key of the request, from its URL, the
+// given header values and body, or from the client key generator if set.
+func (c *Client) requestKey(r *http.Request, headers []string, body []byte) uint64 {
+	key, _ := c.requestKeys(r, headers, body)
+	return key
+}
+
+// requestKeys returns the cache key of the request, along with its string
+// key if the client string hasher is set and not the key generator.
+func (c *Client) requestKeys(r *http.Request, headers []string, body []byte) (uint64, string) {
+	if c.ignoredParams != nil || c.urlCanonicalization {
+		keyed := *r
+		keyed.URL = c.keyedURL(r.URL)
+		r = &keyed
+	}
+	if c.keyGenerator != nil {
+		return c.keyGenerator(r), ""
+	}
+	return c.generateKeys(c.keyURL(r.URL), headers, body)
+}
+
+// urlKey returns the cache key of a GET request to the given URL, without
+// header values nor body.
+func (c *Client) urlKey(u *url.URL) uint64 {
+	key, _ := c.urlKeys(u)
+	return key
+}
+
+// urlKeys returns the cache key of a GET request to the given URL, along
+// with its string key as requestKeys does.
+func (c *Client) urlKeys(u *url.URL) (uint64, string) {
+	u = c.keyedURL(u)
+	if c.keyGenerator != nil {
+		if r, err := http.NewRequest(http.MethodGet, u.String(), nil); err == nil {
+			return c.keyGenerator(r), ""
+		}
+	}
+	return c.generateKeys(c.keyURL(u), []string{}, nil)
+}
+
+// keyedURL returns the URL the cache key is derived from, without the
+// ignored query parameters, and canonicalized if enabled.
+func (c *Client) keyedURL(u *url.URL) *url.URL {
+	u = c.withoutIgnoredParams(u)
+	if c.urlCanonicalization {
+		u = canonicalURL(u)
+	}
+	return u
+}
+
+// withoutIgnoredParams returns a copy of the URL without the ignored query
+// parameters, every occurrence of them, keeping the order of the others.
+func (c *Client) withoutIgnoredParams(u *url.URL) *url.URL {
+	if c.ignoredParams == nil || u.RawQuery == "" {
+		return u
+	}
+	kept := []string{}
+	for _, param := range strings.Split(u.RawQuery, "&") {
+		name := param
+		if i := strings.IndexByte(param, '='); i >= 0 {
+			name = param[:i]
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if _, ok := c.ignoredParams[name]; !ok {
+			kept = append(kept, param)
+		}
+	}
+	keyed := *u
+	keyed.RawQuery = strings.Join(kept, "&")
+	return &keyed
+}
+
+// generateKey hashes the key bytes with the client hasher, if set.
+func (c *Client) generateKey(URL string, headers []string, body []byte) uint64 {
+	key, _ := c.generateKeys(URL, headers, body)
+	return key
+}
+
+// generateKeys hashes the key bytes with the client hasher, if set. With
+// the string hasher, the key bytes are hashed into the string key first,
+// which is returned along the key hashed from it.
+func (c *Client) generateKeys(URL string, headers []string, body []byte) (uint64, string) {
+	b := keyBytes(URL, headers, body)
+	var stringKey string
+	if c.stringHasher != nil {
+		stringKey = c.stringHasher(b)
+		b = []byte(stringKey)
+	}
+	if c.hasher == nil {
+		return fnvHash(b), stringKey
+	}
+	return c.hasher(b), stringKey
+}
+
+func keyBytes(URL string, headers []string, body []byte) []byte {
+	bytes := []byte(URL)
+	for _, h := range headers {
+		bytes = append(bytes, []byte(h)...)
+	}
+	return append(bytes, body...)
+}
+
+func fnvHash(b []byte) uint64 {
+	hash := fnv.New64a()
+	hash.Write(b)
+	return hash.Sum64()
+}
+
+// NewClient initializes the cache HTTP middleware client with the given
+// options.
+func NewClient(opts ...ClientOption) (*Client, error) {
+	c := &Client{}
+
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.adapter == nil {
+		return nil, errors.New("cache client adapter is not set")
+	}
+	if int64(c.ttl) < 1 {
+		return nil, errors.New("cache client ttl is not set")
+	}
+	if c.methods == nil {
+		c.methods = []string{http.MethodGet}
+	}
+	if c.singleflightTimeout > 0 && c.lockTTL == 0 && c.group == nil {
+		return nil, errors.New("cache client singleflight timeout requires singleflight or a distributed lock")
+	}
+	if c.lockTTL > 0 {
+		locker, ok := c.adapter.(Locker)
+		if !ok {
+			return nil, errors.New("cache client adapter does not support distributed locks")
+		}
+		c.locker = locker
+	}
+	if c.generationKey != "" {
+		generations, ok := c.adapter.(GenerationAdapter)
+		if !ok {
+			return nil, errors.New("cache client adapter does not support generation counters")
+		}
+		c.generations = generations
+	}
+	if sa, ok := c.adapter.(StatsAdapter); ok {
+		c.stats = sa
+	}
+	if c.streamThreshold > 0 {
+		if _, ok := c.adapter.(StreamAdapter); !ok {
+			return nil, errors.New("cache client adapter does not support streaming")
+		}
+		if c.encryptionKeys != nil {
+			return nil, errors.New("cache client cannot stream encrypted responses")
+		}
+		if c.compression {
+			return nil, errors.New("cache client cannot stream compressed responses")
+		}
+		if c.contentAddressed || c.varyDeduplication {
+			return nil, errors.New("cache client cannot stream content-addressed responses")
+		}
+	}
+	if c.encryptionKeys != nil {
+		c.adapter = &encryptedAdapter{adapter: c.adapter, keys: c.encryptionKeys}
+	}
+	// The values are compressed before being encrypted, which makes them
+	// incompressible.
+	if c.compression {
+		c.adapter = &compressedAdapter{adapter: c.adapter, encode: c.encode}
+	}
+	if c.contentAddressed {
+		c.adapter = newContentAdapter(c.adapter, c.encode)
+	} else if c.varyDeduplication {
+		a := newContentAdapter(c.adapter, c.encode)
+		a.variantsOnly = true
+		c.adapter = a
+	}
+	if c.criticalURLs != nil {
+		c.warmed = make(chan struct{})
+		c.warm()
+	}
+
+	return c, nil
+}
+
+// ClientWithAdapter sets the adapter type for the HTTP cache
+// middleware client.
+func ClientWithAdapter(a Adapter) ClientOption {
+	return func(c *Client) error {
+		c.adapter = a
+		return nil
+	}
+}
+
+// ClientWithTTL sets how long each response is going to be cached.
+func ClientWithTTL(ttl time.Duration) ClientOption {
+	return func(c *Client) error {
+		if int64(ttl) < 1 {
+			return fmt.Errorf("cache client ttl %v is invalid", ttl)
+		}
+
+		c.ttl = ttl
+
+		return nil
+	}
+}
+
+// ClientWithTTLByPath sets how long the responses are cached per path,
+// overriding the client TTL, which remains the default. A path is matched
+// against the echo route pattern of the request, e.g. /users/:id, or else
+// as a prefix of the request path, the longest matching prefix winning.
+// The Cache-Control, Surrogate-Control and cache plan TTLs still take
+// precedence. Optional setting.
+func ClientWithTTLByPath(ttls map[string]time.Duration) ClientOption {
+	return func(c *Client) error {
+		for path, ttl := range ttls {
+			if !strings.HasPrefix(path, "/") {
+				return fmt.Errorf("cache client ttl path %q must start with /", path)
+			}
+			if int64(ttl) < 1 {
+				return fmt.Errorf("cache client ttl %v of path %s is invalid", ttl, path)
+			}
+		}
+		c.ttlByPath = ttls
+		return nil
+	}
+}
+
+// ClientWithRefreshKey sets the parameter key used to free a request
+// cached response. Optional setting.
+func ClientWithRefreshKey(refreshKey string) ClientOption {
+	return func(c *Client) error {
+		c.refreshKey = refreshKey
+		return nil
+	}
+}
+
+// ClientWithMethods sets the acceptable HTTP methods to be cached.
+// Optional setting. If not set, default is "GET". Cached "OPTIONS"
+// responses are keyed by the CORS preflight request headers. Cached "POST"
+// responses are keyed by the request body as well: it is read whole into
+// memory to be hashed, then handed to the handler from that buffer, so
+// every POST request holds a copy of its body for its whole duration.
+// Large request bodies should be bounded upstream, e.g. with the echo
+// BodyLimit middleware.
+func ClientWithMethods(methods []string) ClientOption {
+	return func(c *Client) error {
+		for _, method := range methods {
+			if method != http.MethodGet && method != http.MethodPost && method != http.MethodOptions {
+				return fmt.Errorf("invalid method %s", method)
+			}
+		}
+		c.methods = methods
+		return nil
+	}
+}
+
+// ClientWithCacheableMethods sets the acceptable HTTP methods to be cached,
+// as ClientWithMethods does. Optional setting. If not set, default is
+// "GET".
+func ClientWithCacheableMethods(methods ...string) ClientOption {
+	return ClientWithMethods(methods)
+}
+
+// ClientWithRestrictedPaths sets the restricted HTTP paths for caching.
+// Optional setting.
+func ClientWithRestrictedPaths(paths []string) ClientOption {
+	return func(c *Client) error {
+		c.restrictedPaths = paths
+		return nil
+	}
+}
+
+// ClientWithHeaders sets the headers to be considered when caching.
+// Optional setting.
+func ClientWithHeaders(headers []string) ClientOption {
+	return func(c *Client) error {
+		c.headers = headers
+		return nil
+	}
+}
+
+// ClientWithVaryLanguage sets the languages the responses are negotiated
+// to from the request Accept-Language header. The negotiated language is
+// part of the cache key, so requests negotiating to the same language
+// share a cached response. The first language is the default one.
+// Optional setting.
+func ClientWithVaryLanguage(languages []string) ClientOption {
+	return func(c *Client) error {
+		if len(languages) == 0 {
+			return errors.New("cache client vary languages must not be empty")
+		}
+		c.languages = languages
+		return nil
+	}
+}
+
+// ClientWithQueryPredicate sets a predicate deciding from the request
+// parsed query parameters whether the request is cacheable. See
+// CommonQueryCombinations for a ready to use predicate.
+// Optional setting.
+func ClientWithQueryPredicate(predicate func(params url.Values) bool) ClientOption {
+	return func(c *Client) error {
+		if predicate == nil {
+			return errors.New("cache client query predicate must not be nil")
+		}
+		c.queryPredicate = predicate
+		return nil
+	}
+}
+
+// ClientWithCacheableStatusCodes sets the HTTP status codes of the
+// responses to be cached. Optional setting. If not set, the status codes
+// cacheable by default per RFC 7231 are cached: 200, 203, 204, 206, 300,
+// 301, 404 and 410. Server errors, redirects other than 301 and the
+// status codes implying a mutation, e.g. 201 Created, are only cached
+// when explicitly set.
+func ClientWithCacheableStatusCodes(codes ...int) ClientOption {
+	return func(c *Client) error {
+		for _, code := range codes {
+			if code < 100 || code > 599 {
+				return fmt.Errorf("invalid status code %v", code)
+			}
+		}
+		c.statusCodes = codes
+		return nil
+	}
+}
+
+// ClientWithAbsoluteMaxAge sets the maximum age of a cached response,
+// regardless of its expiration date. Older responses are released and
+// treated as misses. Optional setting.
+func ClientWithAbsoluteMaxAge(maxAge time.Duration) ClientOption {
+	return func(c *Client) error {
+		if int64(maxAge) < 1 {
+			return fmt.Errorf("cache client absolute max age %v is invalid", maxAge)
+		}
+		c.absoluteMaxAge = maxAge
+		return nil
+	}
+}
+
+// ClientWithHasher sets the hash function used to generate the cache keys.
+// Optional setting. If not set, default is 64-bit FNV-1a.
+func ClientWithHasher(hasher func(b []byte) uint64) ClientOption {
+	return func(c *Client) error {
+		if hasher == nil {
+			return errors.New("cache client hasher must not be nil")
+		}
+		c.hasher = hasher
+		return nil
+	}
+}
+
+// ClientWithStringHasher sets the hash function generating the string keys
+// of the requests, longer than the 64-bit keys of the adapters, e.g. with
+// a 128-bit hash for collision safety on huge key spaces. The adapter key
+// is hashed from the string key, which is stored with the cached response:
+// a response cached for another string key with the same adapter key is a
+// miss rather than served. Optional setting.
+func ClientWithStringHasher(hasher func(b []byte) string) ClientOption {
+	return func(c *Client) error {
+		if hasher == nil {
+			return errors.New("cache client string hasher must not be nil")
+		}
+		c.stringHasher = hasher
+		return nil
+	}
+}
+
+// ClientWithCacheEmptyBody sets whether responses with an empty body are
+// cached. Optional setting. If not set, default is true.
+func ClientWithCacheEmptyBody(cacheEmptyBody bool) ClientOption {
+	return func(c *Client) error {
+		c.skipEmptyBody = !cacheEmptyBody
+		return nil
+	}
+}
+
+// ClientWithRevalidation sets whether an expired cached response holding
+// an ETag or a Last-Modified header is revalidated with the handler,
+// instead of being released. Optional setting.
+//
+// The handler receives the If-None-Match and If-Modified-Since request
+// headers. When it responds 304 Not Modified, the cached response is
+// refreshed and served. When it fails, or responds with a 5xx status code,
+// the stale cached response is served. Otherwise its response replaces the
+// cached one.
+func ClientWithRevalidation(revalidation bool) ClientOption {
+	return func(c *Client) error {
+		c.revalidation = revalidation
+		return nil
+	}
+}
+
+// ClientWithRespectRequestCacheControl sets whether the Cache-Control
+// header of the requests is honored. Requests with no-cache skip the
+// cached response, and their fresh response replaces it. Requests with
+// no-store neither read nor write the cache. Optional setting.
+func ClientWithRespectRequestCacheControl(respect bool) ClientOption {
+	return func(c *Client) error {
+		c.requestCacheControl = respect
+		return nil
+	}
+}
+
+// ClientWithBotDetector sets the function identifying the requests made
+// by bots. Bots are served the cached responses, but their requests never
+// store a response nor count as an access for the eviction algorithm, not
+// to pollute the cache with one-time requests. Optional setting.
+func ClientWithBotDetector(detector func(c echo.Context) bool) ClientOption {
+	return func(c *Client) error {
+		if detector == nil {
+			return errors.New("cache client bot detector must not be nil")
+		}
+		c.botDetector = detector
+		return nil
+	}
+}
+
+// ClientWithSkipper sets the function skipping the middleware, following
+// the echo Skipper pattern: a middleware.Skipper can be given, e.g. to
+// skip the authenticated requests. Skipped requests go straight to the
+// handler, whose error is returned as is, without reading nor storing a
+// cached response, nor counting in the statistics. Their responses get
+// the X-Cache: BYPASS header, unless the handler set one. Optional
+// setting.
+func ClientWithSkipper(skipper func(c echo.Context) bool) ClientOption {
+	return func(c *Client) error {
+		if skipper == nil {
+			return errors.New("cache client skipper must not be nil")
+		}
+		c.skipper = skipper
+		return nil
+	}
+}
+
+// ClientWithCacheControl sets whether the Cache-Control header of the
+// handler responses is honored. Their s-maxage, or else max-age, directive
+// sets how long they are cached, instead of the client TTL, which remains
+// the fallback. Responses with no-store or private are not cached.
+// Surrogate-Control, TTL header and cache plan TTLs still take precedence.
+// Optional setting.
+func ClientWithCacheControl(cacheControl bool) ClientOption {
+	return func(c *Client) error {
+		c.cacheControl = cacheControl
+		return nil
+	}
+}
+
+// ClientWithETag sets whether the cached responses get an ETag header,
+// the one set by the handler or else a strong one generated from their
+// content. Requests whose If-None-Match header matches it are answered
+// 304 Not Modified, without body. Optional setting.
+func ClientWithETag(etag bool) ClientOption {
+	return func(c *Client) error {
+		c.etag = etag
+		return nil
+	}
+}
+
+// ClientWithAsyncFirstFill sets the handler of the requests missing a
+// cached response that was never stored, or has been released. It serves
+// a placeholder, e.g. a 202 Accepted with a Retry-After header, while the
+// response is fetched and cached in the background, once per key at a
+// time. Expired responses are still fetched synchronously. Optional
+// setting.
+func ClientWithAsyncFirstFill(placeholder func(c echo.Context) error) ClientOption {
+	return func(c *Client) error {
+		if placeholder == nil {
+			return errors.New("cache client first fill placeholder must not be nil")
+		}
+		c.asyncFirstFill = placeholder
+		return nil
+	}
+}
+
+// ClientWithEncryption sets the AES key, of 16, 24 or 32 bytes, used to
+// encrypt the cached responses with AES-GCM. Responses encrypted with one
+// of the previous keys can still be decrypted, to allow key rotation.
+// Responses that can't be decrypted are released and treated as misses.
+// Optional setting.
+func ClientWithEncryption(key []byte, previousKeys ...[]byte) ClientOption {
+	return func(c *Client) error {
+		keys := []encryptionKey{}
+		for _, k := range append([][]byte{key}, previousKeys...) {
+			ek, err := newEncryptionKey(k)
+			if err != nil {
+				return fmt.Errorf("cache client encryption key is invalid: %v", err)
+			}
+			keys = append(keys, ek)
+		}
+		c.encryptionKeys = keys
+		return nil
+	}
+}
+
+// ClientWithCompression enables the gzip compression of the cached
+// response values, decompressed before being served with a matching
+// Content-Length. Responses cached before it was enabled are still
+// served. Optional setting.
+func ClientWithCompression(enabled bool) ClientOption {
+	return func(c *Client) error {
+		c.compression = enabled
+		return nil
+	}
+}
+
+// ClientWithAdaptiveTTL enables the adaptive TTL, bounded by min and max.
+// When a cached response expires, the new one is cached for half the
+// previous TTL if its body changed, and for twice the previous TTL if it
+// did not and the previous one was accessed. Optional setting.
+func ClientWithAdaptiveTTL(min, max time.Duration) ClientOption {
+	return func(c *Client) error {
+		if int64(min) < 1 || max < min {
+			return fmt.Errorf("cache client adaptive ttl bounds %v and %v are invalid", min, max)
+		}
+		c.adaptiveTTL = &ttlBounds{min: min, max: max}
+		return nil
+	}
+}
+
+// ClientWithMaxReplayHeaders sets the maximum number of header fields of a
+// cached response. A cached response with more header fields is released
+// and treated as a miss. Optional setting.
+func ClientWithMaxReplayHeaders(n int) ClientOption {
+	return func(c *Client) error {
+		if n < 1 {
+			return fmt.Errorf("cache client max replay headers %v is invalid", n)
+		}
+		c.maxReplayHeaders = n
+		return nil
+	}
+}
+
+// ClientWithMaxReplayHeaderBytes sets the maximum total size of the header
+// names and values of a cached response. A cached response with a bigger
+// header is released and treated as a miss. Optional setting.
+func ClientWithMaxReplayHeaderBytes(n int) ClientOption {
+	return func(c *Client) error {
+		if n < 1 {
+			return fmt.Errorf("cache client max replay header bytes %v is invalid", n)
+		}
+		c.maxReplayHeaderBytes = n
+		return nil
+	}
+}
+
+// ClientWithCachePlan sets the function planning how each request is
+// cached. When it returns false, the request is not cached. Optional
+// setting.
+func ClientWithCachePlan(plan func(c echo.Context) (*CachePlan, bool)) ClientOption {
+	return func(c *Client) error {
+		if plan == nil {
+			return errors.New("cache client cache plan must not be nil")
+		}
+		c.cachePlan = plan
+		return nil
+	}
+}
+
+// ClientWithStaleTransform sets a function transforming the body of the
+// stale cached responses before they are served, e.g. to flag the data as
+// possibly outdated. Fresh cached responses, and the ones with
+// Cache-Control: no-transform, are served untouched. Optional setting.
+func ClientWithStaleTransform(transform func(body []byte) []byte) ClientOption {
+	return func(c *Client) error {
+		if transform == nil {
+			return errors.New("cache client stale transform must not be nil")
+		}
+		c.staleTransform = transform
+		return nil
+	}
+}
+
+// ClientWithPrecompressVariants sets the content encodings, gzip or
+// deflate, the cached responses are also stored compressed with. The
+// variants are compressed once, when the response is cached, and served to
+// the clients accepting the encoding. Each variant is an extra entry in
+// the adapter, a variant not smaller than the response is not stored.
+// Responses with Cache-Control: no-transform have no variants. Optional
+// setting.
+func ClientWithPrecompressVariants(encodings []string) ClientOption {
+	return func(c *Client) error {
+		for _, e := range encodings {
+			if _, ok := precompressors[e]; !ok {
+				return fmt.Errorf("cache client precompress encoding %q is not supported", e)
+			}
+		}
+		c.precompress = encodings
+		return nil
+	}
+}
+
+// ClientWithSingleflight coalesces the concurrent missed requests of a key
+// within the instance, so that only the first one runs the handler. The
+// others wait for it and are sent its response if it was cached, e.g. not
+// private, for every request of the key. Otherwise they run the handler
+// themselves. Optional setting.
+func ClientWithSingleflight(enabled bool) ClientOption {
+	return func(c *Client) error {
+		if enabled {
+			c.group = &flightGroup{}
+		} else {
+			c.group = nil
+		}
+		return nil
+	}
+}
+
+// ClientWithSingleflightTimeout sets how long the requests of a key wait
+// for the request filling it, either coalesced by ClientWithSingleflight or
+// locked by ClientWithDistributedLock, if shorter than the lock TTL. The
+// filling request is not interrupted. What the waiting requests do once it
+// elapses is set by ClientWithSingleflightTimeoutPolicy. Optional setting.
+func ClientWithSingleflightTimeout(d time.Duration) ClientOption {
+	return func(c *Client) error {
+		if d <= 0 {
+			return errors.New("cache client singleflight timeout must be positive")
+		}
+		c.singleflightTimeout = d
+		return nil
+	}
+}
+
+// ClientWithSingleflightTimeoutPolicy sets what the requests waiting for
+// the key to be filled do once they time out: run the handler, the default, or
+// respond with 503 Service Unavailable. Optional setting.
+func ClientWithSingleflightTimeoutPolicy(p SingleflightPolicy) ClientOption {
+	return func(c *Client) error {
+		if p != SingleflightProceed && p != SingleflightUnavailable {
+			return fmt.Errorf("cache client singleflight policy %v is invalid", p)
+		}
+		c.singleflightPolicy = p
+		return nil
+	}
+}
+
+// ClientWithDistributedLock locks a missed key across every instance
+// sharing the cache, so that a single instance runs the handler to fill
+// it. The other instances wait for the response to be cached, until the
+// lock TTL elapses, then run the handler anyway. The adapter must
+// implement Locker. Optional setting.
+func ClientWithDistributedLock(ttl time.Duration) ClientOption {
+	return func(c *Client) error {
+		if ttl <= 0 {
+			return errors.New("cache client distributed lock ttl must be positive")
+		}
+		c.lockTTL = ttl
+		return nil
+	}
+}
+
+// ClientWithClassifier registers a classifier deriving a value from the
+// request, e.g. the device type from the User-Agent. The handler can
+// declare the response varies on it by name in the X-Cache-Vary header,
+// like on request headers. Optional setting.
+func ClientWithClassifier(name string, classifier func(r *http.Request) string) ClientOption {
+	return func(c *Client) error {
+		if name == "" || classifier == nil {
+			return errors.New("cache client classifier must have a name and a function")
+		}
+		if c.classifiers == nil {
+			c.classifiers = map[string]func(r *http.Request) string{}
+		}
+		c.classifiers[strings.ToLower(name)] = classifier
+		return nil
+	}
+}
+
+// ClientWithPoisonGuard rejects, and logs, the responses overwriting a
+// cached response with a different content type or a drastically
+// different size, since they may come from a cache poisoning attack. A
+// refresh explicitly requested with the refresh key is always allowed.
+// Optional setting.
+func ClientWithPoisonGuard(poisonGuard bool) ClientOption {
+	return func(c *Client) error {
+		c.poisonGuard = poisonGuard
+		return nil
+	}
+}
+
+// ClientWithPoisonGuardSizeRatio sets the ratio between the sizes of a
+// cached response and the response overwriting it above which the poison
+// guard rejects the overwrite. Default is 10.
+func ClientWithPoisonGuardSizeRatio(ratio float64) ClientOption {
+	return func(c *Client) error {
+		if ratio <= 1 {
+			return errors.New("cache client poison guard size ratio must be greater than 1")
+		}
+		c.poisonSizeRatio = ratio
+		return nil
+	}
+}
+
+// ClientWithDebug sets the debug mode, in which the cached responses
+// carry debugging headers, like X-Cache-Layer with the label of the
+// adapter layer they were served from. Optional setting.
+func ClientWithDebug(debug bool) ClientOption {
+	return func(c *Client) error {
+		c.debug = debug
+		return nil
+	}
+}
+
+// ClientWithSerializationVersion sets the serialization version of the
+// cached responses, e.g. ResponseVersion1 during a rolling deploy, until
+// no instance is left unable to decode the current version. Responses of
+// every known version are decoded anyway. Default is ResponseVersion.
+func ClientWithSerializationVersion(version int) ClientOption {
+	return func(c *Client) error {
+		if version != ResponseVersion1 && version != ResponseVersion2 {
+			return fmt.Errorf("cache client serialization version %d is not supported", version)
+		}
+		c.serializationVersion = version
+		return nil
+	}
+}
+
+// ClientWithAuthLeakGuard refuses to cache, and logs, the responses
+// claiming Cache-Control: public while carrying user-specific data: a
+// Set-Cookie header, or an answer to a request with an Authorization
+// header. Their public directive is rewritten to private. Optional
+// setting.
+func ClientWithAuthLeakGuard(authLeakGuard bool) ClientOption {
+	return func(c *Client) error {
+		c.authLeakGuard = authLeakGuard
+		return nil
+	}
+}
+
+// ClientWithCostFunc sets the function giving the cost of producing a
+// response, from the time the handler took to produce it. The cost is
+// stored with the cached response, for cost-aware adapters to keep the
+// most expensive responses. Optional setting.
+func ClientWithCostFunc(cost func(c echo.Context, genDuration time.Duration) int) ClientOption {
+	return func(c *Client) error {
+		if cost == nil {
+			return errors.New("cache client cost function must not be nil")
+		}
+		c.costFunc = cost
+		return nil
+	}
+}
+
+// ClientWithDecisionAudit logs the caching decision made for a sampled
+// fraction of the requests, from 0 to 1, with the given logger. Optional
+// setting.
+func ClientWithDecisionAudit(sampleRate float64, logger func(record DecisionRecord)) ClientOption {
+	return func(c *Client) error {
+		if sampleRate < 0 || sampleRate > 1 {
+			return errors.New("cache client decision audit sample rate must be between 0 and 1")
+		}
+		if logger == nil {
+			return errors.New("cache client decision audit logger must not be nil")
+		}
+		c.auditSampleRate = sampleRate
+		c.auditLogger = logger
+		c.auditRand = rand.Float64
+		return nil
+	}
+}
+
+// ClientWithSurrogateControl makes the Surrogate-Control header, meant for
+// shared caches, take precedence over the client TTL: its max-age sets
+// how long the response is cached, and no-store prevents caching it. The
+// header is stripped from the responses, unless it is forwarded. Optional
+// setting.
+func ClientWithSurrogateControl(surrogateControl bool) ClientOption {
+	return func(c *Client) error {
+		c.surrogateControl = surrogateControl
+		return nil
+	}
+}
+
+// ClientWithTTLHeader sets the name of the response header, e.g.
+// X-Cache-TTL, the handler sets to the number of seconds its response is
+// cached for. The header is stripped from the responses. Its TTL takes
+// precedence over the client TTL, the per-path TTLs and the Cache-Control
+// and Surrogate-Control max-age, as the most specific one; the cache plan
+// TTL and the minimum TTL still apply. Invalid values are ignored.
+// Optional setting.
+func ClientWithTTLHeader(name string) ClientOption {
+	return func(c *Client) error {
+		if name == "" {
+			return errors.New("cache client ttl header must not be empty")
+		}
+		c.ttlHeader = http.CanonicalHeaderKey(name)
+		return nil
+	}
+}
+
+// ClientWithForwardSurrogateControl forwards the Surrogate-Control header
+// to the clients, e.g. to a downstream CDN, instead of stripping it.
+// Optional setting.
+func ClientWithForwardSurrogateControl(forward bool) ClientOption {
+	return func(c *Client) error {
+		c.forwardSurrogate = forward
+		return nil
+	}
+}
+
+// ClientWithMaxConcurrentBuffers sets the maximum number of responses
+// buffered for caching at once, to bound the memory used under a burst.
+// The requests over the limit are passed through without being cached.
+// Optional setting.
+func ClientWithMaxConcurrentBuffers(n int) ClientOption {
+	return func(c *Client) error {
+		if n < 1 {
+			return errors.New("cache client max concurrent buffers must be positive")
+		}
+		c.buffers = make(chan struct{}, n)
+		return nil
+	}
+}
+
+// ClientWithStreamThreshold stores the response values of at least the
+// given size apart, with an adapter implementing StreamAdapter, so they
+// are streamed to the client on hits instead of being loaded in memory.
+// Optional setting.
+func ClientWithStreamThreshold(size int) ClientOption {
+	return func(c *Client) error {
+		if size < 1 {
+			return errors.New("cache client stream threshold must be positive")
+		}
+		c.streamThreshold = size
+		return nil
+	}
+}
+
+// ClientWithGenerationCounter folds the cache generation, a counter stored
+// by the adapter under the given key, in every cache key generated from
+// the request URL. Bumping it with Client.BumpGeneration orphans every
+// cached response of the instances sharing the adapter at once. The
+// generation is read again at most once per second. Optional setting.
+func ClientWithGenerationCounter(key string) ClientOption {
+	return func(c *Client) error {
+		if key == "" {
+			return errors.New("cache client generation counter key is not set")
+		}
+		c.generationKey = key
+		return nil
+	}
+}
+
+// ClientWithContentAddressedStorage stores the cached response values
+// under a key derived from their content, i.e. their status code, value
+// and content headers, so the responses of different requests with the
+// same content share the stored value. A value is kept until the last
+// response stored pointing to it expires, even if they are all released
+// before. Optional setting.
+func ClientWithContentAddressedStorage(enabled bool) ClientOption {
+	return func(c *Client) error {
+		c.contentAddressed = enabled
+		return nil
+	}
+}
+
+// ClientWithVaryDeduplication stores once the values of the Vary variants
+// of the same request URL that are identical, e.g. when the handler
+// ignored the request header it declared the response varies on. The
+// variants point to the shared value, kept until the last of them
+// expires. Implied by ClientWithContentAddressedStorage. Optional setting.
+func ClientWithVaryDeduplication(enabled bool) ClientOption {
+	return func(c *Client) error {
+		c.varyDeduplication = enabled
+		return nil
+	}
+}
+
+// ClientWithVary sets whether the standard Vary header of the handler
+// responses is honored, like the VaryHeader one: the request headers it
+// names are stored along the response and part of its cache key on the
+// next lookups. Responses with Vary: * are not cached. Optional setting.
+func ClientWithVary(vary bool) ClientOption {
+	return func(c *Client) error {
+		c.vary = vary
+		return nil
+	}
+}
+
+// ClientWithKeyGenerator sets the function deriving the cache key of the
+// requests, instead of their URL, the configured request headers and the
+// body of POST requests, e.g. to ignore tracking query parameters. The
+// cache plan keys and the Vary variants still apply on top of it. The
+// functions taking a URL, like Release, derive the key of a GET request
+// to it. Optional setting.
+func ClientWithKeyGenerator(generator func(r *http.Request) uint64) ClientOption {
+	return func(c *Client) error {
+		if generator == nil {
+			return errors.New("cache client key generator must not be nil")
+		}
+		c.keyGenerator = generator
+		return nil
+	}
+}
+
+// ClientWithQueryParamSort sets whether the query parameters are sorted by
+// name, then by value, and their percent-encoding normalized, so that
+// e.g. ?a=1&b=2 and ?b=2&a=1 share their cached responses. The handler
+// receives the sorted query. Optional setting, enabled by default as the
+// queries have always been sorted: ClientWithQueryParamSort(false) keys
+// the query as received.
+func ClientWithQueryParamSort(sort bool) ClientOption {
+	return func(c *Client) error {
+		c.keepQueryOrder = !sort
+		return nil
+	}
+}
+
+// ClientWithMinTTL sets the minimum time the responses are cached for,
+// raising the shorter TTLs, e.g. given by the Cache-Control header of the
+// response, to avoid refetching them constantly. Responses not to be
+// stored, e.g. with no-store, are still not cached. Optional setting.
+func ClientWithMinTTL(d time.Duration) ClientOption {
+	return func(c *Client) error {
+		if d < 0 {
+			return fmt.Errorf("cache client minimum TTL %v is invalid", d)
+		}
+		c.minTTL = d
+		return nil
+	}
+}
+
+// ClientWithIgnoredQueryParams sets the query parameters left out of the
+// cache keys, e.g. timestamps, signatures or tracking parameters, so they
+// don't fragment the cache. Every occurrence of a repeated parameter is
+// left out. The handler still receives them, and a custom key generator
+// receives the request without them. Names are case-sensitive. Optional
+// setting.
+func ClientWithIgnoredQueryParams(params ...string) ClientOption {
+	return func(c *Client) error {
+		if c.ignoredParams == nil {
+			c.ignoredParams = map[string]struct{}{}
+		}
+		for _, param := range params {
+			if param == "" {
+				return errors.New("cache client ignored query parameter must not be empty")
+			}
+			c.ignoredParams[param] = struct{}{}
+		}
+		return nil
+	}
+}
+
+// ClientWithURLCanonicalization sets whether equivalent request URLs share
+// their cache key: their path percent-encoding is normalized, the dot
+// segments removed and their query parameters sorted, whatever the query
+// sorting option. The handler still receives the request URL as is.
+// Optional setting.
+func ClientWithURLCanonicalization(canonicalize bool) ClientOption {
+	return func(c *Client) error {
+		c.urlCanonicalization = canonicalize
+		return nil
+	}
+}
+
+// ClientWithStatusHeader sets whether the X-Cache header is set on every
+// response, to MISS or BYPASS when it comes from the handler, besides
+// HIT and STALE on the cached responses. Optional setting.
+func ClientWithStatusHeader(statusHeader bool) ClientOption {
+	return func(c *Client) error {
+		c.statusHeader = statusHeader
+		return nil
+	}
+}
+
+// ClientWithHook sets the function called after each request with its
+// cache status, the same as the X-Cache header, e.g. to record metrics.
+// Optional setting.
+func ClientWithHook(hook func(c echo.Context, status CacheStatus)) ClientOption {
+	return func(c *Client) error {
+		if hook == nil {
+			return errors.New("cache client hook must not be nil")
+		}
+		c.hook = hook
+		return nil
+	}
+}
+
+// ClientWithOnMiss sets the function called after each request not served
+// from the cache with the reason why, e.g. to break misses down by cause.
+// Optional setting.
+func ClientWithOnMiss(onMiss func(c echo.Context, reason MissReason)) ClientOption {
+	return func(c *Client) error {
+		if onMiss == nil {
+			return errors.New("cache client on miss callback must not be nil")
+		}
+		c.onMiss = onMiss
+		return nil
+	}
+}
+
+// ClientWithLoadShedding serves the expired cached responses, stale by at
+// most the given allowance, without calling the handler nor revalidating
+// them, while more than maxInflight requests are being served, to shed
+// the handler load. Responses requiring revalidation once stale, e.g.
+// with must-revalidate, are not served. Optional setting.
+func ClientWithLoadShedding(maxInflight int, staleAllowance time.Duration) ClientOption {
+	return func(c *Client) error {
+		if maxInflight < 1 {
+			return fmt.Errorf("cache client load shedding threshold %v is invalid", maxInflight)
+		}
+		if staleAllowance <= 0 {
+			return fmt.Errorf("cache client load shedding stale allowance %v is invalid", staleAllowance)
+		}
+		c.maxInflight = int64(maxInflight)
+		c.staleAllowance = staleAllowance
+		return nil
+	}
+}
+
+// ClientWithStaleWhileRevalidate serves the expired cached responses, stale
+// by at most d, while the handler refreshes them in the background, once
+// per key at a time. The adapter keeps the responses d past their
+// expiration. Responses requiring revalidation once stale, e.g. with
+// must-revalidate, are not served stale. Optional setting.
+func ClientWithStaleWhileRevalidate(d time.Duration) ClientOption {
+	return func(c *Client) error {
+		if d <= 0 {
+			return errors.New("cache client stale-while-revalidate window must be positive")
+		}
+		c.staleWhileRevalidate = d
+		return nil
+	}
+}
+
+// ClientWithTrailers caches the responses declaring trailers, with the
+// Trailer header or the http.TrailerPrefix, and replays the trailers after
+// the cached body. By default, such responses are not cached. Optional
+// setting.
+func ClientWithTrailers(enabled bool) ClientOption {
+	return func(c *Client) error {
+		c.trailers = enabled
+		return nil
+	}
+}
+
+// ClientWithMeter records the cache hits, misses and stores, the adapter
+// evictions if it reports them, and the adapter operations duration, with
+// the instruments of the given OpenTelemetry meter. Optional setting.
+func ClientWithMeter(meter metric.Meter) ClientOption {
+	return func(c *Client) error {
+		i, err := c.newInstruments(meter)
+		if err != nil {
+			return fmt.Errorf("cache client meter instruments can't be created: %v", err)
+		}
+		c.instruments = i
+		return nil
+	}
+}
+
+// ClientWithCriticalURLs warms the cache with the responses of the given
+// URLs, fetched in the background with the given function once the client
+// is created, e.g. http.Get. They are cached as the responses of GET
+// requests without headers. Client.WaitWarm waits for them to be cached.
+// Optional setting.
+func ClientWithCriticalURLs(URLs []string, fetch func(URL string) (*http.Response, error)) ClientOption {
+	return func(c *Client) error {
+		if fetch == nil {
+			return errors.New("cache client critical URLs fetch function is not set")
+		}
+		for _, URL := range URLs {
+			if _, err := url.Parse(URL); err != nil {
+				return fmt.Errorf("cache client critical URL %s is invalid: %v", URL, err)
+			}
+		}
+		c.criticalURLs = append([]string{}, URLs...)
+		c.warmFetch = fetch
+		return nil
+	}
+}
+
+// ClientWithClaimKeyFunc varies the cache key on a claim of the request
+// Authorization bearer token, e.g. the user subscription tier of a JWT.
+// The token is parsed, and usually verified, by the given function. The
+// requests without a valid token or claim share an anonymous key.
+// Optional setting.
+func ClientWithClaimKeyFunc(claim string, parse func(token string) (map[string]interface{}, error)) ClientOption {
+	return func(c *Client) error {
+		if claim == "" || parse == nil {
+			return errors.New("cache client claim key func must have a claim and a parse function")
+		}
+		c.claim = claim
+		c.claimParser = parse
+		return nil
+	}
+}
+
+// ClientWithHitHeaders sets headers added to every cache hit, e.g.
+// X-Served-By. The Cache-Control max-age of the hits is also set to the
+// remaining lifetime of the cached response, for the downstream caches
+// not to keep it longer. Optional setting.
+func ClientWithHitHeaders(headers map[string]string) ClientOption {
+	return func(c *Client) error {
+		c.hitHeaders = map[string]string{}
+		for k, v := range headers {
+			c.hitHeaders[k] = v
+		}
+		return nil
+	}
+}
+
+// ClientWithInvalidateContextKey sets the context key a handler sets to
+// true, e.g. after detecting inconsistent data, to release the cached
+// response of the request. The response returned along is not cached.
+// Default is cache_invalidate.
+func ClientWithInvalidateContextKey(key string) ClientOption {
+	return func(c *Client) error {
+		if key == "" {
+			return errors.New("cache client invalidate context key must not be empty")
+		}
+		c.invalidateKey = key
+		return nil
+	}
+}
+
+// ClientWithMethodOverride makes the caching decisions and keys use the
+// method tunneled by POST requests in the X-HTTP-Method-Override header,
+// e.g. to cache a POST overriding GET like a GET. The header is ignored
+// unless enabled, as it must only be honored if the application routes
+// the request by the same method, e.g. with the Echo MethodOverride
+// middleware. Otherwise, the response of the POST handler would be
+// served to the GET requests. Optional setting, disabled by default.
+func ClientWithMethodOverride(methodOverride bool) ClientOption {
+	return func(c *Client) error {
+		c.methodOverride = methodOverride
+		return nil
+	}
+}
+
+// ClientWithKeyPathTemplate excludes path segments from the cache key of
+// the requests matching the template, e.g. with /trace/:id/resource, the
+// requests differing only by the trace id share the cached response.
+// Segments prefixed with a colon are ignored. It can be set several
+// times, the first matching template is used. Optional setting.
+func ClientWithKeyPathTemplate(pattern string) ClientOption {
+	return func(c *Client) error {
+		if !strings.HasPrefix(pattern, "/") {
+			return fmt.Errorf("cache client key path template %q must start with /", pattern)
+		}
+		c.keyPathTemplates = append(c.keyPathTemplates, strings.Split(pattern, "/"))
 		return nil
 	}
 }
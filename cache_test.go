@@ -2,15 +2,25 @@ package cache
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/labstack/echo/v4"
 )
 
 type adapterMock struct {
@@ -41,15 +51,22 @@ func (a *adapterMock) Release(key uint64) {
 	delete(a.store, key)
 }
 
+func (a *adapterMock) Purge() error {
+	a.Lock()
+	defer a.Unlock()
+	a.store = make(map[uint64][]byte)
+	return nil
+}
+
 func (errReader) Read(p []byte) (n int, err error) {
 	return 0, errors.New("readAll error")
 }
 
 func TestMiddleware(t *testing.T) {
 	counter := 0
-	httpTestHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(fmt.Sprintf("new value %v", counter)))
-	})
+	httpTestHandler := func(c echo.Context) error {
+		return c.String(http.StatusOK, fmt.Sprintf("new value %v", counter))
+	}
 
 	adapter := &adapterMock{
 		store: map[uint64][]byte{
@@ -79,7 +96,8 @@ func TestMiddleware(t *testing.T) {
 		ClientWithMethods([]string{http.MethodGet, http.MethodPost}),
 	)
 
-	handler := client.Middleware(httpTestHandler)
+	e := echo.New()
+	handler := client.Middleware()(httpTestHandler)
 
 	tests := []struct {
 		name     string
@@ -208,7 +226,7 @@ func TestMiddleware(t *testing.T) {
 			}
 
 			w := httptest.NewRecorder()
-			handler.ServeHTTP(w, r)
+			handler(e.NewContext(r, w))
 
 			if !reflect.DeepEqual(w.Code, tt.wantCode) {
 				t.Errorf("*Client.Middleware() = %v, want %v", w.Code, tt.wantCode)
@@ -221,24 +239,67 @@ func TestMiddleware(t *testing.T) {
 	}
 }
 
+// responseV1 is the Response data structure before versioning.
+type responseV1 struct {
+	Value      []byte
+	Header     http.Header
+	Expiration time.Time
+	LastAccess time.Time
+	Frequency  int
+}
+
 func TestBytesToResponse(t *testing.T) {
 	r := Response{
 		Value:      []byte("value 1"),
+		StatusCode: http.StatusNotFound,
 		Expiration: time.Time{},
 		Frequency:  0,
 		LastAccess: time.Time{},
 	}
 
+	var v1 bytes.Buffer
+	gob.NewEncoder(&v1).Encode(responseV1{
+		Value:      []byte("value 2"),
+		Expiration: time.Now().Add(1 * time.Minute),
+		Frequency:  2,
+	})
+
 	tests := []struct {
-		name      string
-		b         []byte
-		wantValue string
+		name           string
+		b              []byte
+		wantValue      string
+		wantStatusCode int
 	}{
 
 		{
 			"convert bytes array to response",
 			r.Bytes(),
 			"value 1",
+			http.StatusNotFound,
+		},
+		{
+			"convert version 1 bytes array to response",
+			v1.Bytes(),
+			"value 2",
+			http.StatusOK,
+		},
+		{
+			"convert version 1 response bytes array to response",
+			r.bytesVersion(ResponseVersion1),
+			"value 1",
+			http.StatusNotFound,
+		},
+		{
+			"unknown version is empty",
+			append([]byte{versionMarker, 99}, r.Bytes()[2:]...),
+			"",
+			0,
+		},
+		{
+			"truncated bytes array is empty",
+			[]byte{versionMarker},
+			"",
+			0,
 		},
 	}
 	for _, tt := range tests {
@@ -248,6 +309,85 @@ func TestBytesToResponse(t *testing.T) {
 				t.Errorf("BytesToResponse() Value = %v, want %v", got, tt.wantValue)
 				return
 			}
+			if got.StatusCode != tt.wantStatusCode {
+				t.Errorf("BytesToResponse() StatusCode = %v, want %v", got.StatusCode, tt.wantStatusCode)
+			}
+		})
+	}
+}
+
+type metadataMock struct {
+	Owner string
+	Score int
+}
+
+func TestRegisterType(t *testing.T) {
+	RegisterType(metadataMock{})
+
+	r := Response{
+		Value:      []byte("value 1"),
+		StatusCode: http.StatusOK,
+		Metadata: map[string]interface{}{
+			"custom": metadataMock{Owner: "foo", Score: 3},
+			"plain":  "bar",
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			RegisterType(metadataMock{})
+		}()
+		go func() {
+			defer wg.Done()
+			if got := BytesToResponse(r.Bytes()); !reflect.DeepEqual(got, r) {
+				t.Errorf("BytesToResponse() = %v, want %v", got, r)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestMiddlewareSerializationVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		version    int
+		wantPrefix bool
+		wantErr    bool
+	}{
+		{"writes current version", ResponseVersion2, true, false},
+		{"writes version 1", ResponseVersion1, false, false},
+		{"unknown version", 3, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := &adapterMock{store: map[uint64][]byte{}}
+			client, err := NewClient(
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1*time.Minute),
+				ClientWithSerializationVersion(tt.version),
+			)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			handler := func(c echo.Context) error {
+				return c.String(http.StatusOK, "value")
+			}
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+			client.Middleware()(handler)(echo.New().NewContext(r, httptest.NewRecorder()))
+
+			b := adapter.store[generateKey("http://foo.bar/test", []string{})]
+			if got := b[0] == versionMarker; got != tt.wantPrefix {
+				t.Errorf("stored version prefix = %v, want %v", got, tt.wantPrefix)
+			}
+			if got := string(BytesToResponse(b).Value); got != "value" {
+				t.Errorf("stored response = %v, want value", got)
+			}
 		})
 	}
 }
@@ -304,6 +444,109 @@ func TestSortURLParams(t *testing.T) {
 	}
 }
 
+func TestMiddlewareQueryParamSort(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []ClientOption
+		first     string
+		second    string
+		wantCache string
+	}{
+		{"shares reordered parameters by default", nil, "?a=1&b=2", "?b=2&a=1", "HIT"},
+		{"shares reordered values by default", nil, "?a=2&a=1", "?a=1&a=2", "HIT"},
+		{"shares percent-encoded values by default", nil, "?q=a%2Fb", "?q=a/b", "HIT"},
+		{"keeps order if disabled", []ClientOption{ClientWithQueryParamSort(false)}, "?a=1&b=2", "?b=2&a=1", ""},
+		{"shares identical query if disabled", []ClientOption{ClientWithQueryParamSort(false)}, "?b=2&a=1", "?b=2&a=1", "HIT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]ClientOption{
+				ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+				ClientWithTTL(1 * time.Minute),
+			}, tt.opts...)
+			client, _ := NewClient(opts...)
+			handler := client.Middleware()(func(c echo.Context) error {
+				return c.String(http.StatusOK, "value")
+			})
+
+			serve := func(query string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test"+query, nil)
+				w := httptest.NewRecorder()
+				handler(echo.New().NewContext(r, w))
+				return w
+			}
+
+			serve(tt.first)
+			if got := serve(tt.second).Header().Get("X-Cache"); got != tt.wantCache {
+				t.Errorf("*Client.Middleware() X-Cache of %s after %s = %v, want %v", tt.second, tt.first, got, tt.wantCache)
+			}
+		})
+	}
+}
+
+func TestMiddlewareIgnoredQueryParams(t *testing.T) {
+	generated := []string{}
+	tests := []struct {
+		name      string
+		opts      []ClientOption
+		first     string
+		second    string
+		wantCache string
+	}{
+		{"ignores parameter", nil, "?page=1&timestamp=1", "?timestamp=2&page=1", "HIT"},
+		{"ignores every occurrence", nil, "?utm_source=a&page=1&utm_source=b", "?page=1", "HIT"},
+		{"keeps other parameters", nil, "?page=1&timestamp=1", "?page=2&timestamp=1", ""},
+		{"composes with sorting disabled", []ClientOption{ClientWithQueryParamSort(false)}, "?a=1&signature=x&b=2", "?a=1&b=2", "HIT"},
+		{
+			"applies before key generator",
+			[]ClientOption{ClientWithKeyGenerator(func(r *http.Request) uint64 {
+				generated = append(generated, r.URL.RawQuery)
+				return fnvHash([]byte(r.URL.RawQuery))
+			})},
+			"?page=1&signature=x",
+			"?signature=y&page=1",
+			"HIT",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]ClientOption{
+				ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+				ClientWithTTL(1 * time.Minute),
+				ClientWithIgnoredQueryParams("timestamp", "signature", "utm_source"),
+			}, tt.opts...)
+			client, _ := NewClient(opts...)
+			received := ""
+			handler := client.Middleware()(func(c echo.Context) error {
+				received = c.Request().URL.RawQuery
+				return c.String(http.StatusOK, "value")
+			})
+			serve := func(query string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test"+query, nil)
+				w := httptest.NewRecorder()
+				handler(echo.New().NewContext(r, w))
+				return w
+			}
+
+			serve(tt.first)
+			if strings.Contains(tt.first, "timestamp") && !strings.Contains(received, "timestamp") {
+				t.Errorf("handler received query %v, want the ignored parameters kept", received)
+			}
+			if got := serve(tt.second).Header().Get("X-Cache"); got != tt.wantCache {
+				t.Errorf("*Client.Middleware() X-Cache of %s after %s = %v, want %v", tt.second, tt.first, got, tt.wantCache)
+			}
+		})
+	}
+	for _, query := range generated {
+		if strings.Contains(query, "signature") {
+			t.Errorf("key generator received query %v, want the ignored parameters removed", query)
+		}
+	}
+	if len(generated) != 2 {
+		t.Errorf("key generator calls = %v, want 2", len(generated))
+	}
+}
+
 func TestGenerateKeyString(t *testing.T) {
 	urls := []string{
 		"http://localhost:8080/category",
@@ -313,7 +556,7 @@ func TestGenerateKeyString(t *testing.T) {
 
 	keys := make(map[string]string, len(urls))
 	for _, u := range urls {
-		rawKey := generateKey(u)
+		rawKey := generateKey(u, nil)
 		key := KeyAsString(rawKey)
 
 		if otherURL, found := keys[key]; found {
@@ -347,7 +590,7 @@ func TestGenerateKey(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := generateKey(tt.URL); got != tt.want {
+			if got := generateKey(tt.URL, nil); got != tt.want {
 				t.Errorf("generateKey() = %v, want %v", got, tt.want)
 			}
 		})
@@ -382,7 +625,7 @@ func TestGenerateKeyWithBody(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := generateKeyWithBody(tt.URL, tt.body); got != tt.want {
+			if got := generateKeyWithBody(tt.URL, nil, tt.body); got != tt.want {
 				t.Errorf("generateKeyWithBody() = %v, want %v", got, tt.want)
 			}
 		})
@@ -413,6 +656,20 @@ func TestNewClient(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"returns new client with cacheable methods",
+			[]ClientOption{
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1 * time.Millisecond),
+				ClientWithCacheableMethods(http.MethodGet, http.MethodPost),
+			},
+			&Client{
+				adapter: adapter,
+				ttl:     1 * time.Millisecond,
+				methods: []string{http.MethodGet, http.MethodPost},
+			},
+			false,
+		},
 		{
 			"returns new client with refresh key",
 			[]ClientOption{
@@ -465,6 +722,66 @@ func TestNewClient(t *testing.T) {
 			nil,
 			true,
 		},
+		{
+			"returns error",
+			[]ClientOption{
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1 * time.Millisecond),
+				ClientWithCacheableMethods(http.MethodGet, http.MethodPut),
+			},
+			nil,
+			true,
+		},
+		{
+			"returns error",
+			[]ClientOption{
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1 * time.Millisecond),
+				ClientWithCacheableStatusCodes(http.StatusOK, 42),
+			},
+			nil,
+			true,
+		},
+		{
+			"returns error",
+			[]ClientOption{
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1 * time.Millisecond),
+				ClientWithTTLByPath(map[string]time.Duration{"feed": 30 * time.Second}),
+			},
+			nil,
+			true,
+		},
+		{
+			"returns error",
+			[]ClientOption{
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1 * time.Millisecond),
+				ClientWithTTLByPath(map[string]time.Duration{"/feed": 0}),
+			},
+			nil,
+			true,
+		},
+		{
+			"returns error",
+			[]ClientOption{
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1 * time.Millisecond),
+				ClientWithHasher(nil),
+			},
+			nil,
+			true,
+		},
+		{
+			"returns error",
+			[]ClientOption{
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1 * time.Millisecond),
+				ClientWithStringHasher(nil),
+			},
+			nil,
+			true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -479,3 +796,1802 @@ func TestNewClient(t *testing.T) {
 		})
 	}
 }
+
+func TestNegotiateLanguage(t *testing.T) {
+	client := &Client{languages: []string{"en", "fr", "de"}}
+
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		want           string
+	}{
+		{
+			"exact match",
+			"fr",
+			"fr",
+		},
+		{
+			"matches primary subtag",
+			"de-CH",
+			"de",
+		},
+		{
+			"highest q-value wins",
+			"en;q=0.5, de;q=0.9, fr;q=0.7",
+			"de",
+		},
+		{
+			"skips unsupported languages",
+			"es, pt;q=0.9, fr;q=0.8",
+			"fr",
+		},
+		{
+			"ignores q=0",
+			"fr;q=0, de;q=0.1",
+			"de",
+		},
+		{
+			"wildcard falls back to default",
+			"es, *;q=0.5",
+			"en",
+		},
+		{
+			"falls back to default language",
+			"es, pt",
+			"en",
+		},
+		{
+			"empty header falls back to default language",
+			"",
+			"en",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := client.negotiateLanguage(tt.acceptLanguage); got != tt.want {
+				t.Errorf("negotiateLanguage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareVaryLanguage(t *testing.T) {
+	counter := 0
+	handler := func(c echo.Context) error {
+		counter++
+		return c.String(http.StatusOK, fmt.Sprintf("value %v", counter))
+	}
+
+	client, _ := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithVaryLanguage([]string{"en", "fr", "de"}),
+	)
+	e := echo.New()
+	mw := client.Middleware()(handler)
+
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		wantBody       string
+	}{
+		{
+			"caches french response",
+			"fr-FR, fr;q=0.9",
+			"value 1",
+		},
+		{
+			"shares entry with same negotiated language",
+			"es;q=0.9, fr;q=0.8",
+			"value 1",
+		},
+		{
+			"caches default language response",
+			"es",
+			"value 2",
+		},
+		{
+			"shares entry with default language",
+			"en-GB",
+			"value 2",
+		},
+		{
+			"caches german response",
+			"de",
+			"value 3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/i18n", nil)
+			r.Header.Set("Accept-Language", tt.acceptLanguage)
+			w := httptest.NewRecorder()
+			mw(e.NewContext(r, w))
+
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("*Client.Middleware() = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestMiddlewareQueryPredicate(t *testing.T) {
+	counter := 0
+	handler := func(c echo.Context) error {
+		counter++
+		return c.String(http.StatusOK, fmt.Sprintf("value %v", counter))
+	}
+
+	client, _ := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithQueryPredicate(CommonQueryCombinations(
+			[]string{"color"},
+			[]string{"color", "size"},
+		)),
+	)
+	e := echo.New()
+	mw := client.Middleware()(handler)
+
+	tests := []struct {
+		name     string
+		url      string
+		wantBody string
+	}{
+		{
+			"caches common combination",
+			"http://foo.bar/search?size=m&color=red",
+			"value 1",
+		},
+		{
+			"returns cached common combination",
+			"http://foo.bar/search?color=red&size=m",
+			"value 1",
+		},
+		{
+			"does not cache rare combination",
+			"http://foo.bar/search?color=red&brand=foo",
+			"value 2",
+		},
+		{
+			"rare combination stays uncached",
+			"http://foo.bar/search?color=red&brand=foo",
+			"value 3",
+		},
+		{
+			"does not cache missing query",
+			"http://foo.bar/search",
+			"value 4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			w := httptest.NewRecorder()
+			mw(e.NewContext(r, w))
+
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("*Client.Middleware() = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestMiddlewareMustUnderstand(t *testing.T) {
+	tests := []struct {
+		name        string
+		statusCodes []int
+		statusCode  int
+		wantCached  bool
+	}{
+		{
+			"caches known status code",
+			[]int{http.StatusOK, http.StatusNotFound},
+			http.StatusOK,
+			true,
+		},
+		{
+			"does not cache unknown status code",
+			[]int{http.StatusOK, http.StatusNotFound},
+			http.StatusIMUsed,
+			false,
+		},
+		{
+			"does not cache without explicit status codes",
+			nil,
+			http.StatusOK,
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := func(c echo.Context) error {
+				c.Response().Header().Set("Cache-Control", "max-age=60, must-understand")
+				return c.String(tt.statusCode, "value")
+			}
+
+			opts := []ClientOption{
+				ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+				ClientWithTTL(1 * time.Minute),
+			}
+			if tt.statusCodes != nil {
+				opts = append(opts, ClientWithCacheableStatusCodes(tt.statusCodes...))
+			}
+			client, _ := NewClient(opts...)
+
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+			w := httptest.NewRecorder()
+			client.Middleware()(handler)(echo.New().NewContext(r, w))
+
+			_, cached := client.adapter.Get(generateKey(r.URL.String(), []string{}))
+			if cached != tt.wantCached {
+				t.Errorf("*Client.Middleware() cached = %v, want %v", cached, tt.wantCached)
+			}
+		})
+	}
+}
+
+func TestMiddlewareAbsoluteMaxAge(t *testing.T) {
+	adapter := &adapterMock{
+		store: map[uint64][]byte{
+			14974843192121052621: Response{
+				Value:      []byte("value 1"),
+				Expiration: time.Now().Add(1 * time.Hour),
+				Created:    time.Now().Add(-30 * time.Minute),
+			}.Bytes(),
+			14974839893586167988: Response{
+				Value:      []byte("value 2"),
+				Expiration: time.Now().Add(1 * time.Hour),
+				Created:    time.Now().Add(-1 * time.Minute),
+			}.Bytes(),
+		},
+	}
+
+	client, _ := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Hour),
+		ClientWithAbsoluteMaxAge(10*time.Minute),
+	)
+	handler := func(c echo.Context) error {
+		return c.String(http.StatusOK, "new value")
+	}
+	mw := client.Middleware()(handler)
+	e := echo.New()
+
+	tests := []struct {
+		name     string
+		url      string
+		wantBody string
+	}{
+		{
+			"refetches response older than absolute max age",
+			"http://foo.bar/test-1",
+			"new value",
+		},
+		{
+			"returns cached response younger than absolute max age",
+			"http://foo.bar/test-2",
+			"value 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			w := httptest.NewRecorder()
+			mw(e.NewContext(r, w))
+
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("*Client.Middleware() = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+
+	response := BytesToResponse(adapter.store[14974843192121052621])
+	if time.Since(response.Created) > time.Minute {
+		t.Errorf("*Client.Middleware() should replace the too old response, created %v", response.Created)
+	}
+}
+
+func TestMiddlewarePreflight(t *testing.T) {
+	counter := 0
+	handler := func(c echo.Context) error {
+		counter++
+		if c.Request().Method != http.MethodOptions {
+			return c.String(http.StatusOK, fmt.Sprintf("value %v", counter))
+		}
+		h := c.Response().Header()
+		h.Set("Access-Control-Allow-Origin", c.Request().Header.Get("Origin"))
+		h.Set("Access-Control-Allow-Methods", c.Request().Header.Get("Access-Control-Request-Method"))
+		h.Set("Access-Control-Allow-Headers", c.Request().Header.Get("Access-Control-Request-Headers"))
+		h.Set("Access-Control-Max-Age", "600")
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	client, _ := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithMethods([]string{http.MethodGet, http.MethodOptions}),
+	)
+	e := echo.New()
+	mw := client.Middleware()(handler)
+
+	tests := []struct {
+		name        string
+		method      string
+		origin      string
+		wantCode    int
+		wantCounter int
+		wantCache   string
+	}{
+		{
+			"caches preflight response",
+			http.MethodOptions,
+			"http://a.com",
+			http.StatusNoContent,
+			1,
+			"",
+		},
+		{
+			"returns cached preflight response",
+			http.MethodOptions,
+			"http://a.com",
+			http.StatusNoContent,
+			1,
+			"HIT",
+		},
+		{
+			"caches preflight response for another origin",
+			http.MethodOptions,
+			"http://b.com",
+			http.StatusNoContent,
+			2,
+			"",
+		},
+		{
+			"does not share entry with GET",
+			http.MethodGet,
+			"http://a.com",
+			http.StatusOK,
+			3,
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "http://foo.bar/resource", nil)
+			r.Header.Set("Origin", tt.origin)
+			r.Header.Set("Access-Control-Request-Method", http.MethodPut)
+			r.Header.Set("Access-Control-Request-Headers", "Content-Type")
+			w := httptest.NewRecorder()
+			mw(e.NewContext(r, w))
+
+			if w.Code != tt.wantCode {
+				t.Errorf("*Client.Middleware() code = %v, want %v", w.Code, tt.wantCode)
+			}
+			if counter != tt.wantCounter {
+				t.Errorf("*Client.Middleware() handler calls = %v, want %v", counter, tt.wantCounter)
+			}
+			if got := w.Header().Get("X-Cache"); got != tt.wantCache {
+				t.Errorf("*Client.Middleware() X-Cache = %v, want %v", got, tt.wantCache)
+			}
+			if tt.method != http.MethodOptions {
+				return
+			}
+			wantHeaders := map[string]string{
+				"Access-Control-Allow-Origin":  tt.origin,
+				"Access-Control-Allow-Methods": http.MethodPut,
+				"Access-Control-Allow-Headers": "Content-Type",
+				"Access-Control-Max-Age":       "600",
+			}
+			for k, v := range wantHeaders {
+				if got := w.Header().Get(k); got != v {
+					t.Errorf("*Client.Middleware() %s = %v, want %v", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestMiddlewareHasher(t *testing.T) {
+	hashed := []string{}
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	client, _ := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithHasher(func(b []byte) uint64 {
+			hashed = append(hashed, string(b))
+			return 42
+		}),
+	)
+	handler := func(c echo.Context) error {
+		return c.String(http.StatusOK, "value")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+	client.Middleware()(handler)(echo.New().NewContext(r, httptest.NewRecorder()))
+
+	if !reflect.DeepEqual(hashed, []string{"http://foo.bar/test-1"}) {
+		t.Errorf("hasher called with %v, want [http://foo.bar/test-1]", hashed)
+	}
+	if _, ok := adapter.store[42]; !ok {
+		t.Error("*Client.Middleware() should store the response under the custom hasher key")
+	}
+}
+
+func TestMiddlewareStringHasher(t *testing.T) {
+	stringHasher := func(b []byte) string {
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:16])
+	}
+	stringKey := stringHasher([]byte("http://foo.bar/test-1"))
+	key := fnvHash([]byte(stringKey))
+	tests := []struct {
+		name      string
+		cached    *Response
+		wantCalls int
+	}{
+		{
+			"caches the response with its string key",
+			nil,
+			1,
+		},
+		{
+			"misses the response cached for another string key",
+			&Response{Value: []byte("other"), Key: "another key"},
+			1,
+		},
+		{
+			"serves the response cached for the string key",
+			&Response{Value: []byte("value"), Key: stringKey},
+			0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := &adapterMock{store: map[uint64][]byte{}}
+			if tt.cached != nil {
+				tt.cached.Expiration = time.Now().Add(time.Minute)
+				adapter.store[key] = tt.cached.Bytes()
+			}
+			client, _ := NewClient(
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1*time.Minute),
+				ClientWithStringHasher(stringHasher),
+			)
+			calls := 0
+			handler := func(c echo.Context) error {
+				calls++
+				return c.String(http.StatusOK, "value")
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+			w := httptest.NewRecorder()
+			client.Middleware()(handler)(echo.New().NewContext(r, w))
+
+			if calls != tt.wantCalls {
+				t.Errorf("handler calls = %v, want %v", calls, tt.wantCalls)
+			}
+			if w.Body.String() != "value" {
+				t.Errorf("*Client.Middleware() body = %v, want value", w.Body.String())
+			}
+			b, ok := adapter.store[key]
+			if !ok {
+				t.Fatal("*Client.Middleware() should store the response under the hash of the string key")
+			}
+			if got := BytesToResponse(b).Key; got != stringKey {
+				t.Errorf("cached response key = %v, want %v", got, stringKey)
+			}
+		})
+	}
+}
+
+func TestMiddlewareBotDetector(t *testing.T) {
+	cachedKey := generateKey("http://foo.bar/cached", []string{})
+	tests := []struct {
+		name        string
+		url         string
+		userAgent   string
+		wantBody    string
+		wantStored  bool
+		wantEntries int
+	}{
+		{
+			"serves cached response to bot",
+			"http://foo.bar/cached",
+			"Googlebot",
+			"cached",
+			false,
+			1,
+		},
+		{
+			"does not store response fetched by bot",
+			"http://foo.bar/uncached",
+			"Googlebot",
+			"fresh",
+			false,
+			1,
+		},
+		{
+			"stores response fetched by other clients",
+			"http://foo.bar/uncached",
+			"Mozilla",
+			"fresh",
+			true,
+			2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cached := Response{
+				Value:      []byte("cached"),
+				Expiration: time.Now().Add(1 * time.Minute),
+			}.Bytes()
+			adapter := &adapterMock{store: map[uint64][]byte{cachedKey: cached}}
+			client, _ := NewClient(
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1*time.Minute),
+				ClientWithBotDetector(func(c echo.Context) bool {
+					return strings.Contains(c.Request().UserAgent(), "bot")
+				}),
+			)
+			handler := func(c echo.Context) error {
+				return c.String(http.StatusOK, "fresh")
+			}
+
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			r.Header.Set("User-Agent", tt.userAgent)
+			w := httptest.NewRecorder()
+			client.Middleware()(handler)(echo.New().NewContext(r, w))
+
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("*Client.Middleware() = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+			if _, ok := adapter.store[generateKey("http://foo.bar/uncached", []string{})]; ok != tt.wantStored {
+				t.Errorf("*Client.Middleware() stored = %v, want %v", ok, tt.wantStored)
+			}
+			if len(adapter.store) != tt.wantEntries {
+				t.Errorf("*Client.Middleware() entries = %v, want %v", len(adapter.store), tt.wantEntries)
+			}
+			if !bytes.Equal(adapter.store[cachedKey], cached) {
+				t.Errorf("*Client.Middleware() updated the cached response accessed by a bot")
+			}
+		})
+	}
+}
+
+func TestMiddlewareKeyGenerator(t *testing.T) {
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	generator := func(r *http.Request) uint64 {
+		params := r.URL.Query()
+		for name := range params {
+			if strings.HasPrefix(name, "utm_") {
+				params.Del(name)
+			}
+		}
+		return fnvHash([]byte(r.URL.Path + "?" + params.Encode() + "#" + r.Header.Get("X-Tenant")))
+	}
+	client, _ := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithKeyGenerator(generator),
+	)
+	calls := 0
+	handler := client.Middleware()(func(c echo.Context) error {
+		calls++
+		return c.String(http.StatusOK, fmt.Sprintf("value %v", calls))
+	})
+
+	tests := []struct {
+		name      string
+		url       string
+		tenant    string
+		wantBody  string
+		wantCache string
+	}{
+		{"misses first request", "http://foo.bar/test?page=1&utm_source=a", "1", "value 1", ""},
+		{"ignores tracking parameters", "http://foo.bar/test?utm_source=b&page=1", "1", "value 1", "HIT"},
+		{"varies on tenant", "http://foo.bar/test?page=1", "2", "value 2", ""},
+		{"varies on other parameters", "http://foo.bar/test?page=2", "1", "value 3", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			r.Header.Set("X-Tenant", tt.tenant)
+			w := httptest.NewRecorder()
+			handler(echo.New().NewContext(r, w))
+
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("*Client.Middleware() = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+			if got := w.Header().Get("X-Cache"); got != tt.wantCache {
+				t.Errorf("*Client.Middleware() X-Cache = %v, want %v", got, tt.wantCache)
+			}
+		})
+	}
+
+	if _, ok := adapter.store[fnvHash([]byte("/test?page=1#1"))]; !ok {
+		t.Error("*Client.Middleware() should store the response under the generated key")
+	}
+}
+
+func BenchmarkGenerateKey(b *testing.B) {
+	key := keyBytes("http://foo.bar/category/morisco?page=1&size=20", []string{"en", "gzip"}, nil)
+
+	b.Run("fnv", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			fnvHash(key)
+		}
+	})
+	b.Run("xxhash", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			xxhash.Sum64(key)
+		}
+	})
+	b.Run("sha256-128", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			sum := sha256.Sum256(key)
+			fnvHash([]byte(hex.EncodeToString(sum[:16])))
+		}
+	})
+}
+
+func TestMiddlewareEmptyBody(t *testing.T) {
+	tests := []struct {
+		name              string
+		cacheEmptyBody    bool
+		statusCode        int
+		wantCached        bool
+		wantContentLength string
+	}{
+		{
+			"caches empty 200",
+			true,
+			http.StatusOK,
+			true,
+			"0",
+		},
+		{
+			"caches 204",
+			true,
+			http.StatusNoContent,
+			true,
+			"",
+		},
+		{
+			"does not cache empty 200",
+			false,
+			http.StatusOK,
+			false,
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := func(c echo.Context) error {
+				// A stale Content-Length must not be replayed.
+				c.Response().Header().Set("Content-Length", "5")
+				return c.NoContent(tt.statusCode)
+			}
+			client, _ := NewClient(
+				ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+				ClientWithTTL(1*time.Minute),
+				ClientWithCacheEmptyBody(tt.cacheEmptyBody),
+			)
+			mw := client.Middleware()(handler)
+			e := echo.New()
+
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/empty", nil)
+			mw(e.NewContext(r, httptest.NewRecorder()))
+
+			w := httptest.NewRecorder()
+			mw(e.NewContext(r, w))
+
+			if cached := w.Header().Get("X-Cache") == "HIT"; cached != tt.wantCached {
+				t.Errorf("*Client.Middleware() cached = %v, want %v", cached, tt.wantCached)
+				return
+			}
+			if !tt.wantCached {
+				return
+			}
+			if w.Code != tt.statusCode {
+				t.Errorf("*Client.Middleware() code = %v, want %v", w.Code, tt.statusCode)
+			}
+			if got := w.Header().Get("Content-Length"); got != tt.wantContentLength {
+				t.Errorf("*Client.Middleware() Content-Length = %q, want %q", got, tt.wantContentLength)
+			}
+			if w.Body.Len() != 0 {
+				t.Errorf("*Client.Middleware() body = %q, want empty", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestMiddlewareDateHeader(t *testing.T) {
+	created := time.Now().Add(-90 * time.Second)
+	header := http.Header{}
+	header.Set("Date", created.UTC().Format(http.TimeFormat))
+	client, _ := NewClient(
+		ClientWithAdapter(&adapterMock{
+			store: map[uint64][]byte{
+				14974843192121052621: Response{
+					Value:      []byte("value 1"),
+					Header:     header,
+					Expiration: time.Now().Add(1 * time.Minute),
+					Created:    created,
+				}.Bytes(),
+			},
+		}),
+		ClientWithTTL(1*time.Minute),
+	)
+	handler := func(c echo.Context) error {
+		return c.String(http.StatusOK, "new value")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+	w := httptest.NewRecorder()
+	client.Middleware()(handler)(echo.New().NewContext(r, w))
+
+	date, err := http.ParseTime(w.Header().Get("Date"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(date) > 2*time.Second {
+		t.Errorf("*Client.Middleware() Date = %v, want current time", date)
+	}
+	if got := w.Header().Get("Age"); got != "90" {
+		t.Errorf("*Client.Middleware() Age = %v, want 90", got)
+	}
+}
+
+func TestMiddlewareCacheableStatusCodes(t *testing.T) {
+	tests := []struct {
+		name        string
+		statusCodes []int
+		statusCode  int
+		wantCached  bool
+	}{
+		{
+			"caches 200 by default",
+			nil,
+			http.StatusOK,
+			true,
+		},
+		{
+			"caches 206 by default",
+			nil,
+			http.StatusPartialContent,
+			true,
+		},
+		{
+			"does not cache 201 by default",
+			nil,
+			http.StatusCreated,
+			false,
+		},
+		{
+			"does not cache 202 by default",
+			nil,
+			http.StatusAccepted,
+			false,
+		},
+		{
+			"does not cache 207 by default",
+			nil,
+			http.StatusMultiStatus,
+			false,
+		},
+		{
+			"caches 301 by default",
+			nil,
+			http.StatusMovedPermanently,
+			true,
+		},
+		{
+			"caches 404 by default",
+			nil,
+			http.StatusNotFound,
+			true,
+		},
+		{
+			"caches 410 by default",
+			nil,
+			http.StatusGone,
+			true,
+		},
+		{
+			"does not cache 302 by default",
+			nil,
+			http.StatusFound,
+			false,
+		},
+		{
+			"does not cache 500 by default",
+			nil,
+			http.StatusInternalServerError,
+			false,
+		},
+		{
+			"does not cache 503 by default",
+			nil,
+			http.StatusServiceUnavailable,
+			false,
+		},
+		{
+			"does not cache 404 when not set",
+			[]int{http.StatusOK},
+			http.StatusNotFound,
+			false,
+		},
+		{
+			"caches explicitly set 500",
+			[]int{http.StatusOK, http.StatusInternalServerError},
+			http.StatusInternalServerError,
+			true,
+		},
+		{
+			"caches explicitly set 202",
+			[]int{http.StatusOK, http.StatusAccepted},
+			http.StatusAccepted,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := func(c echo.Context) error {
+				return c.String(tt.statusCode, "value")
+			}
+			opts := []ClientOption{
+				ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+				ClientWithTTL(1 * time.Minute),
+			}
+			if tt.statusCodes != nil {
+				opts = append(opts, ClientWithCacheableStatusCodes(tt.statusCodes...))
+			}
+			client, _ := NewClient(opts...)
+
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+			client.Middleware()(handler)(echo.New().NewContext(r, httptest.NewRecorder()))
+
+			_, cached := client.adapter.Get(generateKey(r.URL.String(), []string{}))
+			if cached != tt.wantCached {
+				t.Errorf("*Client.Middleware() cached = %v, want %v", cached, tt.wantCached)
+			}
+		})
+	}
+}
+
+func TestWithoutHopByHop(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   http.Header
+	}{
+		{
+			"keeps end-to-end headers",
+			http.Header{"Content-Type": {"text/html"}, "Trailer": {"X-Checksum"}},
+			http.Header{"Content-Type": {"text/html"}, "Trailer": {"X-Checksum"}},
+		},
+		{
+			"strips hop-by-hop headers",
+			http.Header{
+				"Content-Type":      {"text/html"},
+				"Connection":        {"keep-alive"},
+				"Keep-Alive":        {"timeout=5"},
+				"Transfer-Encoding": {"chunked"},
+				"Upgrade":           {"websocket"},
+			},
+			http.Header{"Content-Type": {"text/html"}},
+		},
+		{
+			"strips headers listed in Connection",
+			http.Header{
+				"Content-Type": {"text/html"},
+				"Connection":   {"X-Hop, x-other"},
+				"X-Hop":        {"1"},
+				"X-Other":      {"2"},
+			},
+			http.Header{"Content-Type": {"text/html"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := tt.header.Clone()
+			if got := withoutHopByHop(tt.header); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withoutHopByHop() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.header, original) {
+				t.Errorf("withoutHopByHop() modified the header to %v", tt.header)
+			}
+		})
+	}
+}
+
+func TestMiddlewareReplaysHeaders(t *testing.T) {
+	client, _ := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+	)
+	handler := client.Middleware()(func(c echo.Context) error {
+		c.Response().Header().Set("X-Custom", "custom")
+		c.Response().Header().Set("Connection", "X-Hop")
+		c.Response().Header().Set("X-Hop", "1")
+		c.Response().Header().Set("Keep-Alive", "timeout=5")
+		return c.JSON(http.StatusNonAuthoritativeInfo, map[string]string{"foo": "bar"})
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+	handler(echo.New().NewContext(r, httptest.NewRecorder()))
+	stored := BytesToResponse(client.adapter.(*adapterMock).store[generateKey(r.URL.String(), []string{})])
+	for _, name := range []string{"Connection", "X-Hop", "Keep-Alive"} {
+		if got := stored.Header.Get(name); got != "" {
+			t.Errorf("*Client.Middleware() stored %s = %q, want none", name, got)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil), w))
+	if got := w.Header().Get("X-Cache"); got != string(CacheStatusHit) {
+		t.Fatalf("*Client.Middleware() X-Cache = %v, want HIT", got)
+	}
+	if w.Code != http.StatusNonAuthoritativeInfo {
+		t.Errorf("*Client.Middleware() status code = %v, want %v", w.Code, http.StatusNonAuthoritativeInfo)
+	}
+	if got := w.Header().Get("Content-Type"); got != echo.MIMEApplicationJSONCharsetUTF8 {
+		t.Errorf("*Client.Middleware() Content-Type = %v, want %v", got, echo.MIMEApplicationJSONCharsetUTF8)
+	}
+	if got := w.Header()["X-Custom"]; !reflect.DeepEqual(got, []string{"custom"}) {
+		t.Errorf("*Client.Middleware() X-Custom = %v, want [custom]", got)
+	}
+	for _, name := range []string{"Connection", "X-Hop", "Keep-Alive"} {
+		if got := w.Header().Get(name); got != "" {
+			t.Errorf("*Client.Middleware() %s = %q, want none", name, got)
+		}
+	}
+}
+
+func TestMiddlewareSkipper(t *testing.T) {
+	errHandler := errors.New("handler error")
+	tests := []struct {
+		name       string
+		URL        string
+		handlerErr error
+		wantCached bool
+		wantErr    error
+		wantStatus string
+	}{
+		{
+			"caches not skipped request",
+			"http://foo.bar/users/1",
+			nil,
+			true,
+			nil,
+			string(CacheStatusHit),
+		},
+		{
+			"skips request",
+			"http://foo.bar/admin/users",
+			nil,
+			false,
+			nil,
+			string(CacheStatusBypass),
+		},
+		{
+			"returns skipped request handler error",
+			"http://foo.bar/admin/users",
+			errHandler,
+			false,
+			errHandler,
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := NewClient(
+				ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+				ClientWithTTL(1*time.Minute),
+				ClientWithSkipper(func(c echo.Context) bool {
+					return strings.HasPrefix(c.Request().URL.Path, "/admin")
+				}),
+			)
+			calls := 0
+			handler := client.Middleware()(func(c echo.Context) error {
+				calls++
+				if tt.handlerErr != nil {
+					return tt.handlerErr
+				}
+				return c.String(http.StatusOK, "value")
+			})
+
+			var err error
+			var w *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				r := httptest.NewRequest(http.MethodGet, tt.URL, nil)
+				w = httptest.NewRecorder()
+				err = handler(echo.New().NewContext(r, w))
+			}
+
+			if cached := calls == 1; cached != tt.wantCached {
+				t.Errorf("*Client.Middleware() cached = %v, want %v", cached, tt.wantCached)
+			}
+			if err != tt.wantErr {
+				t.Errorf("*Client.Middleware() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := w.Header().Get("X-Cache"); got != tt.wantStatus {
+				t.Errorf("*Client.Middleware() X-Cache = %q, want %q", got, tt.wantStatus)
+			}
+			// Skipped requests are not counted as misses.
+			wantMisses := int64(0)
+			if tt.wantCached {
+				wantMisses = 1
+			}
+			if got := client.Stats().Misses; got != wantMisses {
+				t.Errorf("*Client.Middleware() misses = %v, want %v", got, wantMisses)
+			}
+		})
+	}
+
+	if _, err := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithSkipper(nil),
+	); err == nil {
+		t.Error("NewClient() with a nil skipper should fail")
+	}
+}
+
+func TestMiddlewareTTLByPath(t *testing.T) {
+	ttls := map[string]time.Duration{
+		"/config":          3 * time.Hour,
+		"/feed":            30 * time.Second,
+		"/feed/popular":    10 * time.Second,
+		"/users/:id/posts": 5 * time.Minute,
+	}
+
+	tests := []struct {
+		name    string
+		route   string
+		URL     string
+		wantTTL time.Duration
+	}{
+		{
+			"uses route pattern TTL",
+			"/users/:id/posts",
+			"http://foo.bar/users/1/posts",
+			5 * time.Minute,
+		},
+		{
+			"uses path TTL",
+			"",
+			"http://foo.bar/config",
+			3 * time.Hour,
+		},
+		{
+			"uses path prefix TTL",
+			"/feed/:page",
+			"http://foo.bar/feed/2",
+			30 * time.Second,
+		},
+		{
+			"uses longest path prefix TTL",
+			"",
+			"http://foo.bar/feed/popular?page=2",
+			10 * time.Second,
+		},
+		{
+			"uses client TTL",
+			"/users/:id",
+			"http://foo.bar/users/1",
+			1 * time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := NewClient(
+				ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+				ClientWithTTL(1*time.Minute),
+				ClientWithTTLByPath(ttls),
+			)
+			handler := func(c echo.Context) error {
+				return c.String(http.StatusOK, "value")
+			}
+
+			r := httptest.NewRequest(http.MethodGet, tt.URL, nil)
+			c := echo.New().NewContext(r, httptest.NewRecorder())
+			c.SetPath(tt.route)
+			start := time.Now()
+			client.Middleware()(handler)(c)
+
+			b, ok := client.adapter.Get(generateKey(r.URL.String(), []string{}))
+			if !ok {
+				t.Fatal("*Client.Middleware() should cache the response")
+			}
+			response := BytesToResponse(b)
+			if got := response.Expiration.Sub(response.Created); got != tt.wantTTL {
+				t.Errorf("*Client.Middleware() TTL = %v, want %v", got, tt.wantTTL)
+			}
+			if response.Created.Before(start) {
+				t.Errorf("*Client.Middleware() created = %v, want after %v", response.Created, start)
+			}
+		})
+	}
+}
+
+func TestGetResponse(t *testing.T) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("X-Custom", "custom")
+	client, _ := NewClient(
+		ClientWithAdapter(&adapterMock{
+			store: map[uint64][]byte{
+				generateKey("http://foo.bar/test-1?a=1&b=2", []string{}): Response{
+					Value:      []byte(`{"foo":"bar"}`),
+					Header:     header,
+					StatusCode: http.StatusNonAuthoritativeInfo,
+					Expiration: time.Now().Add(1 * time.Minute),
+				}.Bytes(),
+				14974839893586167988: Response{
+					Value:      []byte("value 2"),
+					Expiration: time.Now().Add(-1 * time.Minute),
+				}.Bytes(),
+			},
+		}),
+		ClientWithTTL(1*time.Minute),
+	)
+
+	res, ok := client.GetResponse("http://foo.bar/test-1?b=2&a=1")
+	if !ok {
+		t.Fatal("GetResponse() should find the cached response")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNonAuthoritativeInfo || res.Status != "203 Non-Authoritative Information" {
+		t.Errorf("GetResponse() status = %v %v, want 203", res.StatusCode, res.Status)
+	}
+	if res.Header.Get("Content-Type") != "application/json" || res.Header.Get("X-Custom") != "custom" {
+		t.Errorf("GetResponse() header = %v", res.Header)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	if string(body) != `{"foo":"bar"}` || res.ContentLength != int64(len(body)) {
+		t.Errorf("GetResponse() body = %s, content length %v", body, res.ContentLength)
+	}
+
+	if _, ok := client.GetResponse("http://foo.bar/test-2"); ok {
+		t.Error("GetResponse() should not return an expired response")
+	}
+	if _, ok := client.GetResponse("http://foo.bar/test-3"); ok {
+		t.Error("GetResponse() should not return a missing response")
+	}
+}
+
+type setCountingAdapterMock struct {
+	adapterMock
+	sets int
+}
+
+func (a *setCountingAdapterMock) Set(key uint64, response []byte, expiration time.Time) {
+	a.sets++
+	a.adapterMock.Set(key, response, expiration)
+}
+
+func TestMiddlewareHitDoesNotStore(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []ClientOption
+		wantSets int
+	}{
+		{
+			"stores on the miss only",
+			nil,
+			1,
+		},
+		{
+			"stores the first hit with the adaptive TTL",
+			[]ClientOption{ClientWithAdaptiveTTL(time.Second, time.Hour)},
+			2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := &setCountingAdapterMock{adapterMock: adapterMock{store: map[uint64][]byte{}}}
+			client, _ := NewClient(append([]ClientOption{
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1 * time.Minute),
+			}, tt.opts...)...)
+			handler := client.Middleware()(func(c echo.Context) error {
+				return c.String(http.StatusOK, "value")
+			})
+			for i := 0; i < 4; i++ {
+				r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+				handler(echo.New().NewContext(r, httptest.NewRecorder()))
+			}
+
+			if adapter.sets != tt.wantSets {
+				t.Errorf("adapter Set calls = %v, want %v", adapter.sets, tt.wantSets)
+			}
+		})
+	}
+}
+
+func TestMiddlewareAdaptiveTTL(t *testing.T) {
+	now := time.Now()
+	previous := func(value string) []byte {
+		return Response{
+			Value:      []byte(value),
+			Expiration: now.Add(-1 * time.Minute),
+			Created:    now.Add(-2 * time.Minute),
+			Frequency:  50,
+		}.Bytes()
+	}
+	adapter := &adapterMock{
+		store: map[uint64][]byte{
+			14974843192121052621: previous("same value"),
+			14974839893586167988: previous("old value"),
+		},
+	}
+	client, _ := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(5*time.Minute),
+		ClientWithAdaptiveTTL(10*time.Second, 10*time.Minute),
+	)
+	handler := func(c echo.Context) error {
+		return c.String(http.StatusOK, "same value")
+	}
+	mw := client.Middleware()(handler)
+	e := echo.New()
+
+	tests := []struct {
+		name    string
+		url     string
+		key     uint64
+		wantTTL time.Duration
+	}{
+		{
+			"lengthens hot stable response ttl",
+			"http://foo.bar/test-1",
+			14974843192121052621,
+			2 * time.Minute,
+		},
+		{
+			"shortens hot changing response ttl",
+			"http://foo.bar/test-2",
+			14974839893586167988,
+			30 * time.Second,
+		},
+		{
+			"uses client ttl without previous response",
+			"http://foo.bar/test-3",
+			14974840993097796199,
+			5 * time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			mw(e.NewContext(r, httptest.NewRecorder()))
+
+			response := BytesToResponse(adapter.store[tt.key])
+			if ttl := response.Expiration.Sub(response.Created); ttl != tt.wantTTL {
+				t.Errorf("cached response ttl = %v, want %v", ttl, tt.wantTTL)
+			}
+		})
+	}
+}
+
+func TestMiddlewareMaxReplayHeaders(t *testing.T) {
+	smallHeader := http.Header{}
+	smallHeader.Set("Content-Type", "text/plain")
+	bigHeader := http.Header{}
+	for i := 0; i < 10; i++ {
+		bigHeader.Set(fmt.Sprintf("X-Header-%v", i), "value")
+	}
+	hugeHeader := http.Header{}
+	hugeHeader.Set("X-Huge", string(make([]byte, 1000)))
+
+	adapter := &adapterMock{
+		store: map[uint64][]byte{
+			14974843192121052621: Response{
+				Value:      []byte("value 1"),
+				Header:     smallHeader,
+				Expiration: time.Now().Add(1 * time.Minute),
+			}.Bytes(),
+			14974839893586167988: Response{
+				Value:      []byte("value 2"),
+				Header:     bigHeader,
+				Expiration: time.Now().Add(1 * time.Minute),
+			}.Bytes(),
+			14974840993097796199: Response{
+				Value:      []byte("value 3"),
+				Header:     hugeHeader,
+				Expiration: time.Now().Add(1 * time.Minute),
+			}.Bytes(),
+		},
+	}
+	client, _ := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithMaxReplayHeaders(5),
+		ClientWithMaxReplayHeaderBytes(512),
+	)
+	handler := func(c echo.Context) error {
+		return c.String(http.StatusOK, "new value")
+	}
+	mw := client.Middleware()(handler)
+	e := echo.New()
+
+	tests := []struct {
+		name     string
+		url      string
+		wantBody string
+	}{
+		{
+			"replays cached response within limits",
+			"http://foo.bar/test-1",
+			"value 1",
+		},
+		{
+			"refetches cached response with too many headers",
+			"http://foo.bar/test-2",
+			"new value",
+		},
+		{
+			"refetches cached response with too big headers",
+			"http://foo.bar/test-3",
+			"new value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			w := httptest.NewRecorder()
+			mw(e.NewContext(r, w))
+
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("*Client.Middleware() = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestMiddlewareCachePlan(t *testing.T) {
+	counter := 0
+	handler := func(c echo.Context) error {
+		counter++
+		return c.String(http.StatusOK, fmt.Sprintf("value %v", counter))
+	}
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	client, _ := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithCachePlan(func(c echo.Context) (*CachePlan, bool) {
+			switch c.Request().URL.Path {
+			case "/keyed":
+				return &CachePlan{Key: 42, TTL: 1 * time.Hour, Tags: []string{"products"}}, true
+			case "/tenant":
+				return &CachePlan{Vary: []string{"X-Tenant"}}, true
+			case "/default":
+				return nil, true
+			}
+			return nil, false
+		}),
+	)
+	mw := client.Middleware()(handler)
+	e := echo.New()
+
+	tests := []struct {
+		name     string
+		url      string
+		tenant   string
+		wantBody string
+	}{
+		{
+			"caches with planned key",
+			"http://foo.bar/keyed",
+			"",
+			"value 1",
+		},
+		{
+			"returns cached response with planned key",
+			"http://foo.bar/keyed",
+			"",
+			"value 1",
+		},
+		{
+			"caches first tenant response",
+			"http://foo.bar/tenant",
+			"a",
+			"value 2",
+		},
+		{
+			"caches second tenant response",
+			"http://foo.bar/tenant",
+			"b",
+			"value 3",
+		},
+		{
+			"returns cached first tenant response",
+			"http://foo.bar/tenant",
+			"a",
+			"value 2",
+		},
+		{
+			"caches without plan",
+			"http://foo.bar/default",
+			"",
+			"value 4",
+		},
+		{
+			"returns cached response without plan",
+			"http://foo.bar/default",
+			"",
+			"value 4",
+		},
+		{
+			"does not cache",
+			"http://foo.bar/none",
+			"",
+			"value 5",
+		},
+		{
+			"still does not cache",
+			"http://foo.bar/none",
+			"",
+			"value 6",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			r.Header.Set("X-Tenant", tt.tenant)
+			w := httptest.NewRecorder()
+			mw(e.NewContext(r, w))
+
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("*Client.Middleware() = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+
+	response := BytesToResponse(adapter.store[42])
+	if !reflect.DeepEqual(response.Tags, []string{"products"}) {
+		t.Errorf("planned response tags = %v, want [products]", response.Tags)
+	}
+	if ttl := response.Expiration.Sub(response.Created); ttl != 1*time.Hour {
+		t.Errorf("planned response ttl = %v, want 1h", ttl)
+	}
+}
+
+func TestMiddlewareMaxConcurrentBuffers(t *testing.T) {
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	client, _ := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithMaxConcurrentBuffers(2),
+	)
+	var started sync.WaitGroup
+	gate := make(chan struct{})
+	handler := func(c echo.Context) error {
+		started.Done()
+		<-gate
+		return c.String(http.StatusOK, "value")
+	}
+	mw := client.Middleware()(handler)
+	e := echo.New()
+
+	const burst = 10
+	started.Add(burst)
+	var done sync.WaitGroup
+	recorders := make([]*httptest.ResponseRecorder, burst)
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		done.Add(1)
+		go func(w *httptest.ResponseRecorder, i int) {
+			defer done.Done()
+			r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("http://foo.bar/test-%v", i), nil)
+			mw(e.NewContext(r, w))
+		}(recorders[i], i)
+	}
+	started.Wait()
+	close(gate)
+	done.Wait()
+
+	for i, w := range recorders {
+		if w.Body.String() != "value" {
+			t.Errorf("request %v body = %v, want value", i, w.Body.String())
+		}
+	}
+	if got := len(adapter.store); got != 2 {
+		t.Errorf("stored entries = %v, want 2", got)
+	}
+	if got := len(client.buffers); got != 0 {
+		t.Errorf("buffers in use = %v, want 0", got)
+	}
+}
+
+func TestMiddlewareClaimKeyFunc(t *testing.T) {
+	token := func(claims string) string {
+		return "Bearer header." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".signature"
+	}
+	parse := func(token string) (map[string]interface{}, error) {
+		parts := strings.Split(token, ".")
+		if len(parts) != 3 {
+			return nil, errors.New("malformed token")
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		claims := map[string]interface{}{}
+		return claims, json.Unmarshal(payload, &claims)
+	}
+
+	calls := 0
+	handler := func(c echo.Context) error {
+		calls++
+		return c.String(http.StatusOK, fmt.Sprintf("value %v", calls))
+	}
+	client, _ := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithClaimKeyFunc("tier", parse),
+	)
+	mw := client.Middleware()(handler)
+	e := echo.New()
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantBody      string
+	}{
+		{
+			"caches first tier",
+			token(`{"sub":"1","tier":"free"}`),
+			"value 1",
+		},
+		{
+			"caches second tier",
+			token(`{"sub":"2","tier":"pro"}`),
+			"value 2",
+		},
+		{
+			"hits first tier for another user",
+			token(`{"sub":"3","tier":"free"}`),
+			"value 1",
+		},
+		{
+			"caches anonymous without token",
+			"",
+			"value 3",
+		},
+		{
+			"maps invalid token to anonymous",
+			"Bearer invalid",
+			"value 3",
+		},
+		{
+			"maps missing claim to anonymous",
+			token(`{"sub":"4"}`),
+			"value 3",
+		},
+		{
+			"hits second tier",
+			token(`{"sub":"5","tier":"pro"}`),
+			"value 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			mw(e.NewContext(r, w))
+
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("*Client.Middleware() = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestMiddlewareHitHeaders(t *testing.T) {
+	client, _ := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithHitHeaders(map[string]string{
+			"X-Served-By":   "cache",
+			"Cache-Control": "public",
+		}),
+	)
+	handler := func(c echo.Context) error {
+		c.Response().Header().Set("Cache-Control", "max-age=3600")
+		return c.String(http.StatusOK, "value")
+	}
+	mw := client.Middleware()(handler)
+	e := echo.New()
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mw(e.NewContext(httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil), w))
+		return w
+	}
+
+	if w := serve(); w.Header().Get("X-Served-By") != "" || w.Header().Get("Cache-Control") != "max-age=3600" {
+		t.Errorf("miss headers = %v, want handler headers only", w.Header())
+	}
+
+	maxAges := []int{}
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			time.Sleep(1100 * time.Millisecond)
+		}
+		w := serve()
+		if got := w.Header().Get("X-Served-By"); got != "cache" {
+			t.Errorf("hit X-Served-By = %v, want cache", got)
+		}
+		var maxAge int
+		if _, err := fmt.Sscanf(w.Header().Get("Cache-Control"), "public, max-age=%d", &maxAge); err != nil {
+			t.Fatalf("hit Cache-Control = %v, want public with max-age", w.Header().Get("Cache-Control"))
+		}
+		maxAges = append(maxAges, maxAge)
+	}
+	if maxAges[0] > 60 || maxAges[1] >= maxAges[0] {
+		t.Errorf("hit max-ages = %v, want decreasing remaining lifetime", maxAges)
+	}
+}
+
+func TestMiddlewareMethodOverride(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []ClientOption
+		method     string
+		override   string
+		wantCached bool
+	}{
+		{
+			"caches POST overriding GET",
+			[]ClientOption{ClientWithMethodOverride(true)},
+			http.MethodPost,
+			"GET",
+			true,
+		},
+		{
+			"does not cache POST overriding PUT",
+			[]ClientOption{ClientWithMethodOverride(true)},
+			http.MethodPost,
+			"PUT",
+			false,
+		},
+		{
+			"does not cache POST without override",
+			[]ClientOption{ClientWithMethodOverride(true)},
+			http.MethodPost,
+			"",
+			false,
+		},
+		{
+			"ignores override of other methods",
+			[]ClientOption{ClientWithMethodOverride(true)},
+			http.MethodPut,
+			"GET",
+			false,
+		},
+		{
+			"ignores override when disabled",
+			[]ClientOption{ClientWithMethodOverride(false)},
+			http.MethodPost,
+			"GET",
+			false,
+		},
+		{
+			"ignores override by default",
+			nil,
+			http.MethodPost,
+			"GET",
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []ClientOption{
+				ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+				ClientWithTTL(1 * time.Minute),
+			}
+			client, _ := NewClient(append(opts, tt.opts...)...)
+			handler := func(c echo.Context) error {
+				return c.String(http.StatusOK, "value")
+			}
+			mw := client.Middleware()(handler)
+			e := echo.New()
+
+			var w *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				r := httptest.NewRequest(tt.method, "http://foo.bar/test", bytes.NewBufferString(fmt.Sprintf("body %v", i)))
+				if tt.override != "" {
+					r.Header.Set("X-HTTP-Method-Override", tt.override)
+				}
+				w = httptest.NewRecorder()
+				mw(e.NewContext(r, w))
+			}
+
+			if got := w.Header().Get("X-Cache") == "HIT"; got != tt.wantCached {
+				t.Errorf("*Client.Middleware() cached = %v, want %v", got, tt.wantCached)
+			}
+		})
+	}
+}
+
+func TestMiddlewareKeyPathTemplate(t *testing.T) {
+	calls := 0
+	handler := func(c echo.Context) error {
+		calls++
+		return c.String(http.StatusOK, fmt.Sprintf("value %v", calls))
+	}
+	client, _ := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithKeyPathTemplate("/trace/:ignored/resource"),
+		ClientWithKeyPathTemplate("/users/:id/avatar/:size"),
+	)
+	mw := client.Middleware()(handler)
+	e := echo.New()
+
+	tests := []struct {
+		name     string
+		url      string
+		wantBody string
+	}{
+		{
+			"caches templated path",
+			"http://foo.bar/trace/abc123/resource",
+			"value 1",
+		},
+		{
+			"shares entry across ignored segment",
+			"http://foo.bar/trace/def456/resource",
+			"value 1",
+		},
+		{
+			"keeps query in key",
+			"http://foo.bar/trace/def456/resource?page=2",
+			"value 2",
+		},
+		{
+			"does not match other literal segment",
+			"http://foo.bar/trace/abc123/other",
+			"value 3",
+		},
+		{
+			"does not match other segment count",
+			"http://foo.bar/trace/abc123/resource/child",
+			"value 4",
+		},
+		{
+			"uses other template",
+			"http://foo.bar/users/1/avatar/small",
+			"value 5",
+		},
+		{
+			"shares entry of other template",
+			"http://foo.bar/users/2/avatar/large",
+			"value 5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			w := httptest.NewRecorder()
+			mw(e.NewContext(r, w))
+
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("*Client.Middleware() = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+
+	if _, err := NewClient(
+		ClientWithAdapter(&adapterMock{}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithKeyPathTemplate("trace/:id"),
+	); err == nil {
+		t.Error("NewClient() with relative key path template error = nil, want error")
+	}
+}
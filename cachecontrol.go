@@ -0,0 +1,132 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheControl holds the parsed directives of a Cache-Control header.
+// Directives without a value are mapped to an empty string.
+type cacheControl map[string]string
+
+// parseCacheControl parses every Cache-Control header value of the given
+// header. Directive names are case-insensitive and stored in lower case.
+func parseCacheControl(header http.Header) cacheControl {
+	return parseDirectives(header.Values("Cache-Control"))
+}
+
+// parseDirectives parses header values made of Cache-Control like
+// directives, e.g. Surrogate-Control ones.
+func parseDirectives(values []string) cacheControl {
+	cc := cacheControl{}
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			parts := strings.SplitN(directive, "=", 2)
+			name := strings.ToLower(strings.TrimSpace(parts[0]))
+			if len(parts) == 2 {
+				cc[name] = strings.Trim(strings.TrimSpace(parts[1]), `"`)
+			} else {
+				cc[name] = ""
+			}
+		}
+	}
+
+	return cc
+}
+
+// has reports whether the directive is present.
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// maxAge returns the max-age directive value, and false if it is missing
+// or invalid.
+func (cc cacheControl) maxAge() (time.Duration, bool) {
+	v, ok := cc["max-age"]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(v)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// sharedMaxAge returns the s-maxage directive value, or the max-age one
+// if it is missing or invalid, and false if both are.
+func (cc cacheControl) sharedMaxAge() (time.Duration, bool) {
+	if v, ok := cc["s-maxage"]; ok {
+		if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return cc.maxAge()
+}
+
+// maxStale returns the max-stale directive value of a request, and false
+// if it is missing or invalid. Without a value, any staleness is accepted.
+func (cc cacheControl) maxStale() (time.Duration, bool) {
+	v, ok := cc["max-stale"]
+	if !ok {
+		return 0, false
+	}
+	if v == "" {
+		return time.Duration(math.MaxInt64), true
+	}
+	seconds, err := strconv.Atoi(v)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// withMaxAge returns the Cache-Control header value with its max-age
+// directive set to the given duration, rounded down to the second.
+func withMaxAge(value string, maxAge time.Duration) string {
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	directives := []string{}
+	for _, d := range strings.Split(value, ",") {
+		d = strings.TrimSpace(d)
+		if d == "" || strings.HasPrefix(strings.ToLower(d), "max-age") {
+			continue
+		}
+		directives = append(directives, d)
+	}
+	directives = append(directives, "max-age="+strconv.Itoa(int(maxAge/time.Second)))
+	return strings.Join(directives, ", ")
+}
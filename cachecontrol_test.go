@@ -0,0 +1,303 @@
+package cache
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   cacheControl
+	}{
+		{
+			"no header",
+			nil,
+			cacheControl{},
+		},
+		{
+			"directives with and without values",
+			[]string{`public, Max-Age=60, no-transform, private="Set-Cookie"`},
+			cacheControl{
+				"public":       "",
+				"max-age":      "60",
+				"no-transform": "",
+				"private":      "Set-Cookie",
+			},
+		},
+		{
+			"multiple header values",
+			[]string{"no-store", "must-understand"},
+			cacheControl{
+				"no-store":        "",
+				"must-understand": "",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for _, v := range tt.values {
+				header.Add("Cache-Control", v)
+			}
+			if got := parseCacheControl(header); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCacheControl() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithMaxAge(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		maxAge time.Duration
+		want   string
+	}{
+		{"empty value", "", 90 * time.Second, "max-age=90"},
+		{"replaces max-age", "public, max-age=3600, must-revalidate", 1500 * time.Millisecond, "public, must-revalidate, max-age=1"},
+		{"negative max-age", "public", -time.Second, "public, max-age=0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withMaxAge(tt.value, tt.maxAge); got != tt.want {
+				t.Errorf("withMaxAge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxStale(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOk bool
+	}{
+		{"missing", "max-age=60", 0, false},
+		{"with value", "max-stale=60", time.Minute, true},
+		{"without value", "max-stale", time.Duration(math.MaxInt64), true},
+		{"invalid value", "max-stale=soon", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseDirectives([]string{tt.value}).maxStale()
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("maxStale() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestSharedMaxAge(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOk bool
+	}{
+		{"missing", "public", 0, false},
+		{"max-age", "max-age=60", time.Minute, true},
+		{"s-maxage over max-age", "max-age=60, s-maxage=120", 2 * time.Minute, true},
+		{"invalid s-maxage", "max-age=60, s-maxage=soon", time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseDirectives([]string{tt.value}).sharedMaxAge()
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("sharedMaxAge() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestMiddlewareRequestCacheControl(t *testing.T) {
+	tests := []struct {
+		name         string
+		respect      bool
+		cacheControl string
+		wantBody     string
+		wantCached   string
+	}{
+		{
+			"serves cached response without request Cache-Control",
+			true,
+			"",
+			"cached",
+			"cached",
+		},
+		{
+			"fetches and stores fresh response under no-cache",
+			true,
+			"no-cache",
+			"fresh",
+			"fresh",
+		},
+		{
+			"fetches fresh response without storing it under no-store",
+			true,
+			"no-store",
+			"fresh",
+			"cached",
+		},
+		{
+			"ignores no-cache unless enabled",
+			false,
+			"no-cache",
+			"cached",
+			"cached",
+		},
+		{
+			"ignores no-store unless enabled",
+			false,
+			"no-store",
+			"cached",
+			"cached",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := generateKey("http://foo.bar/test-1", []string{})
+			adapter := &adapterMock{
+				store: map[uint64][]byte{
+					key: Response{
+						Value:      []byte("cached"),
+						Expiration: time.Now().Add(1 * time.Minute),
+					}.Bytes(),
+				},
+			}
+			client, _ := NewClient(
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1*time.Minute),
+				ClientWithRespectRequestCacheControl(tt.respect),
+			)
+			handler := func(c echo.Context) error {
+				return c.String(http.StatusOK, "fresh")
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+			if tt.cacheControl != "" {
+				r.Header.Set("Cache-Control", tt.cacheControl)
+			}
+			w := httptest.NewRecorder()
+			client.Middleware()(handler)(echo.New().NewContext(r, w))
+
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("*Client.Middleware() = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+			b, _ := adapter.Get(key)
+			if got := string(BytesToResponse(b).Value); got != tt.wantCached {
+				t.Errorf("cached value = %v, want %v", got, tt.wantCached)
+			}
+		})
+	}
+}
+
+func TestMiddlewareResponseCacheControl(t *testing.T) {
+	tests := []struct {
+		name         string
+		enabled      bool
+		cacheControl string
+		wantStored   bool
+		wantTTL      time.Duration
+	}{
+		{"uses client TTL without directive", true, "", true, time.Minute},
+		{"uses max-age", true, "max-age=600", true, 10 * time.Minute},
+		{"prefers s-maxage", true, "max-age=600, s-maxage=1200", true, 20 * time.Minute},
+		{"skips no-store", true, "no-store", false, 0},
+		{"skips private", true, "private, max-age=600", false, 0},
+		{"ignores directives unless enabled", false, "no-store, max-age=600", true, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := &adapterMock{store: map[uint64][]byte{}}
+			client, _ := NewClient(
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1*time.Minute),
+				ClientWithCacheControl(tt.enabled),
+			)
+			handler := func(c echo.Context) error {
+				if tt.cacheControl != "" {
+					c.Response().Header().Set("Cache-Control", tt.cacheControl)
+				}
+				return c.String(http.StatusOK, "value")
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+			client.Middleware()(handler)(echo.New().NewContext(r, httptest.NewRecorder()))
+
+			b, ok := adapter.Get(generateKey("http://foo.bar/test-1", []string{}))
+			if ok != tt.wantStored {
+				t.Fatalf("*Client.Middleware() stored = %v, want %v", ok, tt.wantStored)
+			}
+			if !ok {
+				return
+			}
+			response := BytesToResponse(b)
+			if got := response.Expiration.Sub(response.Created); got != tt.wantTTL {
+				t.Errorf("*Client.Middleware() TTL = %v, want %v", got, tt.wantTTL)
+			}
+		})
+	}
+}
+
+func TestMiddlewareMinTTL(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		wantStored   bool
+		wantTTL      time.Duration
+	}{
+		{"raises max-age=1 to the minimum", "max-age=1", true, 10 * time.Second},
+		{"keeps longer max-age", "max-age=600", true, 10 * time.Minute},
+		{"raises client TTL to the minimum", "", true, 10 * time.Second},
+		{"still skips no-store", "no-store", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := &adapterMock{store: map[uint64][]byte{}}
+			client, _ := NewClient(
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1*time.Second),
+				ClientWithCacheControl(true),
+				ClientWithMinTTL(10*time.Second),
+			)
+			handler := func(c echo.Context) error {
+				if tt.cacheControl != "" {
+					c.Response().Header().Set("Cache-Control", tt.cacheControl)
+				}
+				return c.String(http.StatusOK, "value")
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+			client.Middleware()(handler)(echo.New().NewContext(r, httptest.NewRecorder()))
+
+			b, ok := adapter.Get(generateKey("http://foo.bar/test-1", []string{}))
+			if ok != tt.wantStored {
+				t.Fatalf("*Client.Middleware() stored = %v, want %v", ok, tt.wantStored)
+			}
+			if !ok {
+				return
+			}
+			response := BytesToResponse(b)
+			if got := response.Expiration.Sub(response.Created); got != tt.wantTTL {
+				t.Errorf("*Client.Middleware() TTL = %v, want %v", got, tt.wantTTL)
+			}
+		})
+	}
+
+	if _, err := NewClient(
+		ClientWithAdapter(&adapterMock{}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithMinTTL(-1),
+	); err == nil {
+		t.Error("NewClient() error = nil with a negative minimum TTL, want an error")
+	}
+}
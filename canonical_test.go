@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		name string
+		URL  string
+		want string
+	}{
+		{"decodes unreserved characters", "http://foo.bar/path/%7Euser", "http://foo.bar/path/~user"},
+		{"uppercases reserved characters", "http://foo.bar/a%2fb", "http://foo.bar/a%2Fb"},
+		{"removes dot segments", "http://foo.bar/a/./b/../c", "http://foo.bar/a/c"},
+		{"removes encoded dot segments", "http://foo.bar/a/%2E%2E/c", "http://foo.bar/c"},
+		{"keeps trailing slash", "http://foo.bar/a/b/..", "http://foo.bar/a/"},
+		{"stops at root", "http://foo.bar/../a", "http://foo.bar/a"},
+		{"sorts query parameters", "http://foo.bar/a?b=2&a=1", "http://foo.bar/a?a=1&b=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := canonicalURL(u).String(); got != tt.want {
+				t.Errorf("canonicalURL(%v) = %v, want %v", tt.URL, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareURLCanonicalization(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		first     string
+		second    string
+		wantCache string
+	}{
+		{"shares percent-encoded unreserved characters", true, "/path/%7Euser", "/path/~user", "HIT"},
+		{"shares lowercase hex", true, "/a%2fb", "/a%2Fb", "HIT"},
+		{"shares dot segments", true, "/a/./b/../c", "/a/c", "HIT"},
+		{"shares reordered query", true, "/a?b=2&a=1", "/a?a=1&b=2", "HIT"},
+		{"keeps different paths apart", true, "/a/b", "/a/c", ""},
+		{"splits equivalent encodings unless enabled", false, "/path/%7Euser", "/path/~user", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := NewClient(
+				ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+				ClientWithTTL(1*time.Minute),
+				ClientWithQueryParamSort(false),
+				ClientWithURLCanonicalization(tt.enabled),
+			)
+			received := ""
+			handler := client.Middleware()(func(c echo.Context) error {
+				received = c.Request().URL.EscapedPath()
+				return c.String(http.StatusOK, "value")
+			})
+			serve := func(path string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(http.MethodGet, "http://foo.bar"+path, nil)
+				w := httptest.NewRecorder()
+				handler(echo.New().NewContext(r, w))
+				return w
+			}
+
+			serve(tt.first)
+			if want, _ := url.Parse("http://foo.bar" + tt.first); received != want.EscapedPath() {
+				t.Errorf("handler received path %v, want %v unchanged", received, want.EscapedPath())
+			}
+			if got := serve(tt.second).Header().Get("X-Cache"); got != tt.wantCache {
+				t.Errorf("*Client.Middleware() X-Cache of %s after %s = %v, want %v", tt.second, tt.first, got, tt.wantCache)
+			}
+		})
+	}
+}
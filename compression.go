@@ -0,0 +1,155 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"time"
+)
+
+// compressedAdapter gzips the cached response values before handing them
+// to the wrapped adapter, flagging the responses as compressed. Responses
+// without the flag, e.g. cached before compression was enabled, are
+// served as is.
+type compressedAdapter struct {
+	adapter Adapter
+	encode  func(r Response) []byte
+}
+
+// compress returns the gzipped value, and false if compressing it does not
+// make it smaller.
+func compress(value []byte) ([]byte, bool) {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write(value); err != nil {
+		return nil, false
+	}
+	if err := w.Close(); err != nil {
+		return nil, false
+	}
+	if b.Len() >= len(value) {
+		return nil, false
+	}
+	return b.Bytes(), true
+}
+
+func decompress(value []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(value))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// Get implements the Adapter interface Get method. A compressed response
+// that can't be decompressed is released and treated as a miss.
+func (a *compressedAdapter) Get(key uint64) ([]byte, bool) {
+	return a.GetCtx(context.Background(), key)
+}
+
+// GetCtx implements the ContextAdapter interface GetCtx method.
+func (a *compressedAdapter) GetCtx(ctx context.Context, key uint64) ([]byte, bool) {
+	b, ok := adapterGetCtx(a.adapter, ctx, key)
+	return a.expand(ctx, key, b, ok)
+}
+
+// GetLayer implements the LayeredAdapter interface GetLayer method.
+func (a *compressedAdapter) GetLayer(key uint64) ([]byte, string, bool) {
+	b, layer, ok := adapterGetLayer(a.adapter, key)
+	b, ok = a.expand(context.Background(), key, b, ok)
+	return b, layer, ok
+}
+
+// expand decompresses the cached response of the key, if found and
+// compressed, releasing it if it can't be.
+func (a *compressedAdapter) expand(ctx context.Context, key uint64, b []byte, ok bool) ([]byte, bool) {
+	if !ok {
+		return nil, false
+	}
+	r, err := decodeResponse(b)
+	if err != nil || !r.Compressed {
+		return b, true
+	}
+	value, err := decompress(r.Value)
+	if err != nil {
+		adapterReleaseCtx(a.adapter, ctx, key)
+		return nil, false
+	}
+	r.Value = value
+	r.Compressed = false
+	return a.encode(r), true
+}
+
+// Set implements the Adapter interface Set method. Responses already
+// encoded by the handler, with a Content-Encoding header, and the ones
+// gzip does not make smaller are stored as is.
+func (a *compressedAdapter) Set(key uint64, response []byte, expiration time.Time) {
+	a.SetCtx(context.Background(), key, response, expiration)
+}
+
+// SetCtx implements the ContextAdapter interface SetCtx method.
+func (a *compressedAdapter) SetCtx(ctx context.Context, key uint64, response []byte, expiration time.Time) {
+	adapterSetCtx(a.adapter, ctx, key, a.shrink(response), expiration)
+}
+
+// SetWithTags implements the TaggedAdapter interface SetWithTags method.
+func (a *compressedAdapter) SetWithTags(ctx context.Context, key uint64, response []byte, expiration time.Time, tags []string, cost int) {
+	adapterSetWithTags(a.adapter, ctx, key, a.shrink(response), expiration, tags, cost)
+}
+
+// shrink returns the encoded response with its value compressed, or as is
+// if it is not worth it.
+func (a *compressedAdapter) shrink(response []byte) []byte {
+	r, err := decodeResponse(response)
+	if err != nil || len(r.Value) == 0 || r.Header.Get("Content-Encoding") != "" {
+		return response
+	}
+	value, ok := compress(r.Value)
+	if !ok {
+		return response
+	}
+	r.Value = value
+	r.Compressed = true
+	return a.encode(r)
+}
+
+// Release implements the Adapter interface Release method.
+func (a *compressedAdapter) Release(key uint64) {
+	a.adapter.Release(key)
+}
+
+// ReleaseCtx implements the ContextAdapter interface ReleaseCtx method.
+func (a *compressedAdapter) ReleaseCtx(ctx context.Context, key uint64) {
+	adapterReleaseCtx(a.adapter, ctx, key)
+}
+
+// Purge implements the Adapter interface Purge method.
+func (a *compressedAdapter) Purge() error {
+	return a.adapter.Purge()
+}
@@ -0,0 +1,219 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestCompressedAdapter(t *testing.T) {
+	expiration := time.Now().Add(1 * time.Minute)
+	value := []byte(strings.Repeat("<p>value</p>", 100))
+
+	newAdapter := func(store map[uint64][]byte) Adapter {
+		client, err := NewClient(
+			ClientWithAdapter(&adapterMock{store: store}),
+			ClientWithTTL(1*time.Minute),
+			ClientWithCompression(true),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client.adapter
+	}
+
+	tests := []struct {
+		name           string
+		response       Response
+		wantCompressed bool
+	}{
+		{
+			"compresses response value",
+			Response{Value: value, Expiration: expiration},
+			true,
+		},
+		{
+			"does not compress encoded response value",
+			Response{
+				Value:      value,
+				Header:     http.Header{"Content-Encoding": []string{"br"}},
+				Expiration: expiration,
+			},
+			false,
+		},
+		{
+			"does not compress value gzip does not shrink",
+			Response{Value: []byte("value 1"), Expiration: expiration},
+			false,
+		},
+		{
+			"does not compress empty value",
+			Response{StatusCode: http.StatusNoContent, Expiration: expiration},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := map[uint64][]byte{}
+			a := newAdapter(store)
+			a.Set(1, tt.response.Bytes(), expiration)
+
+			stored := BytesToResponse(store[1])
+			if stored.Compressed != tt.wantCompressed {
+				t.Errorf("Set() compressed = %v, want %v", stored.Compressed, tt.wantCompressed)
+			}
+			if tt.wantCompressed && len(stored.Value) >= len(tt.response.Value) {
+				t.Errorf("Set() stored %v bytes, want less than %v", len(stored.Value), len(tt.response.Value))
+			}
+
+			b, ok := a.Get(1)
+			if !ok {
+				t.Fatal("Get() should be a hit")
+			}
+			got := BytesToResponse(b)
+			if got.Compressed || !bytes.Equal(got.Value, tt.response.Value) {
+				t.Errorf("Get() = %q, compressed %v, want %q", got.Value, got.Compressed, tt.response.Value)
+			}
+		})
+	}
+
+	t.Run("serves response cached without compression", func(t *testing.T) {
+		store := map[uint64][]byte{
+			1: Response{Value: value, Expiration: expiration}.Bytes(),
+		}
+		b, ok := newAdapter(store).Get(1)
+		if got := BytesToResponse(b).Value; !ok || !bytes.Equal(got, value) {
+			t.Errorf("Get() = %q, %v, want the uncompressed value", got, ok)
+		}
+	})
+
+	t.Run("treats corrupted response as a miss", func(t *testing.T) {
+		store := map[uint64][]byte{
+			1: Response{Value: value, Expiration: expiration, Compressed: true}.Bytes(),
+		}
+		if _, ok := newAdapter(store).Get(1); ok {
+			t.Error("Get() of a corrupted response should be a miss")
+		}
+		if _, ok := store[1]; ok {
+			t.Error("Get() of a corrupted response should release it")
+		}
+	})
+}
+
+func TestMiddlewareCompression(t *testing.T) {
+	body := strings.Repeat(`{"value":1}`, 100)
+	store := map[uint64][]byte{}
+	client, _ := NewClient(
+		ClientWithAdapter(&adapterMock{store: store}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithCompression(true),
+	)
+	calls := 0
+	handler := client.Middleware()(func(c echo.Context) error {
+		calls++
+		c.Response().Header().Set("Content-Length", strconv.Itoa(len(body)))
+		return c.String(http.StatusOK, body)
+	})
+
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+		w = httptest.NewRecorder()
+		handler(echo.New().NewContext(r, w))
+	}
+
+	if calls != 1 {
+		t.Errorf("*Client.Middleware() handler calls = %v, want 1", calls)
+	}
+	for _, b := range store {
+		if r := BytesToResponse(b); !r.Compressed {
+			t.Errorf("*Client.Middleware() stored an uncompressed response")
+		}
+	}
+	if w.Header().Get("X-Cache") != string(CacheStatusHit) {
+		t.Errorf("*Client.Middleware() X-Cache = %v, want HIT", w.Header().Get("X-Cache"))
+	}
+	if w.Body.String() != body {
+		t.Errorf("*Client.Middleware() body = %v, want %v", w.Body.String(), body)
+	}
+	if got := w.Header().Get("Content-Length"); got != strconv.Itoa(len(body)) {
+		t.Errorf("*Client.Middleware() Content-Length = %v, want %v", got, len(body))
+	}
+}
+
+// optionalAdapterMock implements every optional interface the adapter
+// wrappers must keep: it honors the context cancellation, reports its
+// statistics and labels its layer.
+type optionalAdapterMock struct {
+	statsAdapterMock
+}
+
+func (a *optionalAdapterMock) GetCtx(ctx context.Context, key uint64) ([]byte, bool) {
+	if ctx.Err() != nil {
+		return nil, false
+	}
+	return a.Get(key)
+}
+
+func (a *optionalAdapterMock) SetCtx(ctx context.Context, key uint64, response []byte, expiration time.Time) {
+	if ctx.Err() == nil {
+		a.Set(key, response, expiration)
+	}
+}
+
+func (a *optionalAdapterMock) ReleaseCtx(ctx context.Context, key uint64) {
+	if ctx.Err() == nil {
+		a.Release(key)
+	}
+}
+
+func (a *optionalAdapterMock) GetLayer(key uint64) ([]byte, string, bool) {
+	b, ok := a.Get(key)
+	return b, "mock", ok
+}
+
+func TestMiddlewareCompressionOptionalInterfaces(t *testing.T) {
+	body := strings.Repeat(`{"value":1}`, 100)
+	adapter := &optionalAdapterMock{statsAdapterMock{adapterMock{store: map[uint64][]byte{}}}}
+	client, _ := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithCompression(true),
+		ClientWithDebug(true),
+	)
+	handler := client.Middleware()(func(c echo.Context) error {
+		return c.String(http.StatusOK, body)
+	})
+	serve := func(ctx context.Context) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+		handler(echo.New().NewContext(r, w))
+		return w
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	serve(cancelled)
+	if len(adapter.store) != 0 {
+		t.Errorf("*Client.Middleware() stored %v responses with a cancelled context, want 0", len(adapter.store))
+	}
+
+	serve(context.Background())
+	w := serve(context.Background())
+	if got := w.Header().Get("X-Cache-Layer"); got != "mock" {
+		t.Errorf("*Client.Middleware() X-Cache-Layer = %q, want mock", got)
+	}
+	if w.Body.String() != body {
+		t.Errorf("*Client.Middleware() body = %v, want %v", w.Body.String(), body)
+	}
+	if stats := client.Stats(); stats.Entries == nil || *stats.Entries != 1 {
+		t.Errorf("*Client.Stats() Entries = %v, want 1", stats.Entries)
+	}
+}
@@ -0,0 +1,179 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"strconv"
+	"time"
+)
+
+// contentHeaders are the response headers part of the content key, along
+// with the status code and the value.
+var contentHeaders = []string{"Content-Type", "Content-Encoding", "Content-Language"}
+
+// contentAdapter stores the cached response values under a key derived
+// from their content, shared by the cached responses with the same
+// content, which only point to it. A value is kept in the wrapped adapter
+// as long as the last response stored pointing to it, even when they are
+// released earlier. There is no bookkeeping in the client, so the values
+// can be shared by the instances sharing the wrapped adapter.
+type contentAdapter struct {
+	adapter Adapter
+	encode  func(r Response) []byte
+
+	// variantsOnly restricts the sharing to the Vary variants of the same
+	// request URL, other responses are stored as is.
+	variantsOnly bool
+}
+
+func newContentAdapter(adapter Adapter, encode func(r Response) []byte) *contentAdapter {
+	return &contentAdapter{
+		adapter: adapter,
+		encode:  encode,
+	}
+}
+
+// contentDigest returns the SHA-256 digest of the content of the response,
+// its status code, content headers and value.
+func contentDigest(r Response) []byte {
+	headers := []string{strconv.Itoa(r.StatusCode)}
+	for _, h := range contentHeaders {
+		headers = append(headers, h+":"+r.Header.Get(h))
+	}
+	digest := sha256.Sum256(keyBytes("content:", headers, r.Value))
+	return digest[:]
+}
+
+// contentKey returns the key the value of the response is stored under,
+// the first 64 bits of its content digest.
+func contentKey(r Response) uint64 {
+	return binary.BigEndian.Uint64(contentDigest(r))
+}
+
+// Get implements the Adapter interface Get method. A cached response
+// whose value is gone is released and treated as a miss.
+func (a *contentAdapter) Get(key uint64) ([]byte, bool) {
+	return a.GetCtx(context.Background(), key)
+}
+
+// GetCtx implements the ContextAdapter interface GetCtx method.
+func (a *contentAdapter) GetCtx(ctx context.Context, key uint64) ([]byte, bool) {
+	b, ok := adapterGetCtx(a.adapter, ctx, key)
+	return a.resolve(ctx, key, b, ok)
+}
+
+// GetLayer implements the LayeredAdapter interface GetLayer method.
+func (a *contentAdapter) GetLayer(key uint64) ([]byte, string, bool) {
+	b, layer, ok := adapterGetLayer(a.adapter, key)
+	b, ok = a.resolve(context.Background(), key, b, ok)
+	return b, layer, ok
+}
+
+// resolve returns the cached response of the key, if found, with the value
+// it points to, releasing it if the value is gone or is another content
+// stored under the same key.
+func (a *contentAdapter) resolve(ctx context.Context, key uint64, b []byte, ok bool) ([]byte, bool) {
+	if !ok {
+		return nil, false
+	}
+	r := BytesToResponse(b)
+	if r.ContentKey == 0 {
+		return b, true
+	}
+
+	b, ok = adapterGetCtx(a.adapter, ctx, r.ContentKey)
+	content := BytesToResponse(b)
+	if !ok || !bytes.Equal(content.ContentDigest, r.ContentDigest) {
+		adapterReleaseCtx(a.adapter, ctx, key)
+		return nil, false
+	}
+	r.Value = content.Value
+	r.ContentDigest = nil
+	return a.encode(r), true
+}
+
+// Set implements the Adapter interface Set method. The response value is
+// stored under its content key, unless it is empty, or the response is
+// not a Vary variant when only variants are shared.
+func (a *contentAdapter) Set(key uint64, response []byte, expiration time.Time) {
+	a.SetCtx(context.Background(), key, response, expiration)
+}
+
+// SetCtx implements the ContextAdapter interface SetCtx method.
+func (a *contentAdapter) SetCtx(ctx context.Context, key uint64, response []byte, expiration time.Time) {
+	a.SetWithTags(ctx, key, response, expiration, nil, 0)
+}
+
+// SetWithTags implements the TaggedAdapter interface SetWithTags method.
+// The tags and cost go along the response, not the value shared by others.
+func (a *contentAdapter) SetWithTags(ctx context.Context, key uint64, response []byte, expiration time.Time, tags []string, cost int) {
+	r := BytesToResponse(response)
+	if len(r.Value) == 0 || (a.variantsOnly && r.VaryKey == 0) {
+		adapterSetWithTags(a.adapter, ctx, key, response, expiration, tags, cost)
+		return
+	}
+
+	digest := contentDigest(r)
+	ck := binary.BigEndian.Uint64(digest)
+	if a.variantsOnly {
+		ck = fnvHash(keyBytes("variant:"+KeyAsString(r.VaryKey)+":"+KeyAsString(ck), nil, nil))
+	}
+	// The value lives as long as the last response pointing to it. It is
+	// stored again if the wrapped adapter evicted it, or to extend it. The
+	// response keeps its value if another content has the same key.
+	b, ok := adapterGetCtx(a.adapter, ctx, ck)
+	content := BytesToResponse(b)
+	if ok && !bytes.Equal(content.ContentDigest, digest) {
+		adapterSetWithTags(a.adapter, ctx, key, response, expiration, tags, cost)
+		return
+	}
+	if !ok || content.Expiration.Before(expiration) {
+		adapterSetCtx(a.adapter, ctx, ck, a.encode(Response{Value: r.Value, Expiration: expiration, ContentDigest: digest}), expiration)
+	}
+	r.ContentKey = ck
+	r.ContentDigest = digest
+	r.Value = nil
+	adapterSetWithTags(a.adapter, ctx, key, a.encode(r), expiration, tags, cost)
+}
+
+// Release implements the Adapter interface Release method. The value the
+// response points to expires on its own.
+func (a *contentAdapter) Release(key uint64) {
+	a.adapter.Release(key)
+}
+
+// ReleaseCtx implements the ContextAdapter interface ReleaseCtx method.
+func (a *contentAdapter) ReleaseCtx(ctx context.Context, key uint64) {
+	adapterReleaseCtx(a.adapter, ctx, key)
+}
+
+// Purge implements the Adapter interface Purge method.
+func (a *contentAdapter) Purge() error {
+	return a.adapter.Purge()
+}
@@ -0,0 +1,190 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddlewareContentAddressedStorage(t *testing.T) {
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	client, _ := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithContentAddressedStorage(true),
+	)
+	calls := 0
+	handler := client.Middleware()(func(c echo.Context) error {
+		calls++
+		if c.Request().URL.Path == "/other" {
+			return c.String(http.StatusOK, "other body")
+		}
+		return c.String(http.StatusOK, "shared body")
+	})
+	get := func(URL string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, URL, nil)
+		w := httptest.NewRecorder()
+		handler(echo.New().NewContext(r, w))
+		return w
+	}
+	stored := func(value string) int {
+		adapter.Lock()
+		defer adapter.Unlock()
+		n := 0
+		for _, b := range adapter.store {
+			if bytes.Contains(b, []byte(value)) {
+				n++
+			}
+		}
+		return n
+	}
+
+	urls := []string{"http://foo.bar/test-1", "http://foo.bar/test-2", "http://foo.bar/other"}
+	for _, URL := range urls {
+		get(URL)
+	}
+	for _, URL := range urls {
+		w := get(URL)
+		if got := w.Header().Get("X-Cache"); got != "HIT" {
+			t.Errorf("X-Cache of %s = %v, want HIT", URL, got)
+		}
+		want := "shared body"
+		if URL == "http://foo.bar/other" {
+			want = "other body"
+		}
+		if w.Body.String() != want {
+			t.Errorf("*Client.Middleware() body of %s = %v, want %v", URL, w.Body.String(), want)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("handler calls = %v, want 3", calls)
+	}
+	if got := stored("shared body"); got != 1 {
+		t.Errorf("shared body stored %v times, want once", got)
+	}
+	if got := stored("other body"); got != 1 {
+		t.Errorf("other body stored %v times, want once", got)
+	}
+
+	client.Release("http://foo.bar/test-1")
+	if got := stored("shared body"); got != 1 {
+		t.Errorf("shared body stored %v times after releasing one of its responses, want once", got)
+	}
+	if w := get("http://foo.bar/test-2"); w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("X-Cache of the response still pointing to the shared body = %v, want HIT", w.Header().Get("X-Cache"))
+	}
+	client.Release("http://foo.bar/test-2")
+	if w := get("http://foo.bar/test-2"); w.Header().Get("X-Cache") == "HIT" {
+		t.Errorf("X-Cache of a released response = HIT, want a miss")
+	}
+}
+
+func TestContentAdapterExpiration(t *testing.T) {
+	adapter := &expirationAdapterMock{adapterMock: adapterMock{store: map[uint64][]byte{}}, expirations: map[uint64]time.Time{}}
+	a := newContentAdapter(adapter, Response.Bytes)
+	now := time.Now()
+	set := func(key uint64, expiration time.Time) {
+		a.Set(key, Response{Value: []byte("shared body"), Expiration: expiration}.Bytes(), expiration)
+	}
+	ck := contentKey(Response{Value: []byte("shared body")})
+
+	set(1, now.Add(2*time.Minute))
+	set(2, now.Add(1*time.Minute))
+	if got := adapter.expirations[ck]; !got.Equal(now.Add(2 * time.Minute)) {
+		t.Errorf("shared value expiration = %v, want the latest response one %v", got, now.Add(2*time.Minute))
+	}
+	set(3, now.Add(3*time.Minute))
+	if got := adapter.expirations[ck]; !got.Equal(now.Add(3 * time.Minute)) {
+		t.Errorf("shared value expiration = %v, want extended to %v", got, now.Add(3*time.Minute))
+	}
+
+	adapter.Release(ck)
+	if _, ok := a.Get(1); ok {
+		t.Error("Get() of a response whose value was evicted should be a miss")
+	}
+	if _, ok := adapter.store[1]; ok {
+		t.Error("Get() of a response whose value was evicted should release it")
+	}
+	if b, ok := a.Get(2); ok {
+		t.Errorf("Get() = %s, want a miss", b)
+	}
+	set(4, now.Add(1*time.Minute))
+	if b, ok := a.Get(4); !ok || string(BytesToResponse(b).Value) != "shared body" {
+		t.Errorf("Get() = %s, %v, want the value stored again", b, ok)
+	}
+}
+
+func TestContentAdapterCollision(t *testing.T) {
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	a := newContentAdapter(adapter, Response.Bytes)
+	expiration := time.Now().Add(1 * time.Minute)
+	set := func(key uint64, value string) {
+		a.Set(key, Response{Value: []byte(value), Expiration: expiration}.Bytes(), expiration)
+	}
+	get := func(key uint64) (string, bool) {
+		b, ok := a.Get(key)
+		return string(BytesToResponse(b).Value), ok
+	}
+	ck := contentKey(Response{Value: []byte("shared body")})
+	forged := Response{Value: []byte("other body"), Expiration: expiration, ContentDigest: contentDigest(Response{Value: []byte("other body")})}
+
+	set(1, "shared body")
+	adapter.Set(ck, forged.Bytes(), expiration)
+	if value, ok := get(1); ok {
+		t.Errorf("Get() of a response whose key holds another content = %v, want a miss", value)
+	}
+	if _, ok := adapter.store[1]; ok {
+		t.Error("Get() of a response whose key holds another content should release it")
+	}
+
+	set(2, "shared body")
+	if value, ok := get(2); !ok || value != "shared body" {
+		t.Errorf("Get() = %v, %v, want the value stored with the response", value, ok)
+	}
+	if value := string(BytesToResponse(adapter.store[ck]).Value); value != "other body" {
+		t.Errorf("content stored under the key = %v, want the first one kept", value)
+	}
+}
+
+func TestMiddlewareContentAddressedOptionalInterfaces(t *testing.T) {
+	adapter := &optionalAdapterMock{statsAdapterMock{adapterMock{store: map[uint64][]byte{}}}}
+	client, _ := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithContentAddressedStorage(true),
+		ClientWithDebug(true),
+	)
+	handler := client.Middleware()(func(c echo.Context) error {
+		return c.String(http.StatusOK, "shared body")
+	})
+	serve := func(ctx context.Context) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+		handler(echo.New().NewContext(r, w))
+		return w
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	serve(cancelled)
+	if len(adapter.store) != 0 {
+		t.Errorf("*Client.Middleware() stored %v values with a cancelled context, want 0", len(adapter.store))
+	}
+
+	serve(context.Background())
+	w := serve(context.Background())
+	if got := w.Header().Get("X-Cache-Layer"); got != "mock" {
+		t.Errorf("*Client.Middleware() X-Cache-Layer = %q, want mock", got)
+	}
+	if w.Body.String() != "shared body" {
+		t.Errorf("*Client.Middleware() body = %v, want shared body", w.Body.String())
+	}
+	if stats := client.Stats(); stats.Entries == nil || *stats.Entries != 2 {
+		t.Errorf("*Client.Stats() Entries = %v, want 2", stats.Entries)
+	}
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type ctxKey struct{}
+
+type contextAdapterMock struct {
+	adapterMock
+	values []interface{}
+}
+
+func (a *contextAdapterMock) GetCtx(ctx context.Context, key uint64) ([]byte, bool) {
+	a.values = append(a.values, ctx.Value(ctxKey{}))
+	return a.Get(key)
+}
+
+func (a *contextAdapterMock) SetCtx(ctx context.Context, key uint64, response []byte, expiration time.Time) {
+	a.values = append(a.values, ctx.Value(ctxKey{}))
+	a.Set(key, response, expiration)
+}
+
+func (a *contextAdapterMock) ReleaseCtx(ctx context.Context, key uint64) {
+	a.values = append(a.values, ctx.Value(ctxKey{}))
+	a.Release(key)
+}
+
+func TestMiddlewareContextAdapter(t *testing.T) {
+	adapter := &contextAdapterMock{adapterMock: adapterMock{store: map[uint64][]byte{}}}
+	client, _ := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithRefreshKey("rk"),
+	)
+	handler := client.Middleware()(func(c echo.Context) error {
+		return c.String(http.StatusOK, "value")
+	})
+
+	for _, URL := range []string{"http://foo.bar/test", "http://foo.bar/test", "http://foo.bar/test?rk=true"} {
+		r := httptest.NewRequest(http.MethodGet, URL, nil)
+		r = r.WithContext(context.WithValue(r.Context(), ctxKey{}, "request"))
+		handler(echo.New().NewContext(r, httptest.NewRecorder()))
+	}
+
+	// The miss lookup and store, the hit lookup, the refresh release and
+	// store.
+	if len(adapter.values) != 5 {
+		t.Errorf("adapter context calls = %v, want 5", len(adapter.values))
+	}
+	for _, v := range adapter.values {
+		if v != "request" {
+			t.Errorf("adapter context value = %v, want the request context", v)
+		}
+	}
+}
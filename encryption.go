@@ -0,0 +1,186 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// encryptionVersion prefixes the encrypted values, followed by the key
+// fingerprint and the nonce. Version 1 values were not bound to their
+// cache key, they are treated as misses.
+const encryptionVersion byte = 2
+
+const fingerprintSize = 4
+
+type encryptionKey struct {
+	fingerprint []byte
+	aead        cipher.AEAD
+}
+
+// encryptedAdapter encrypts the cached responses with AES-GCM before
+// handing them to the wrapped adapter. The cache key is authenticated
+// along the response, so a value can't be moved to another key.
+type encryptedAdapter struct {
+	adapter Adapter
+	keys    []encryptionKey
+}
+
+func newEncryptionKey(key []byte) (encryptionKey, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return encryptionKey{}, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return encryptionKey{}, err
+	}
+	sum := sha256.Sum256(key)
+
+	return encryptionKey{
+		fingerprint: sum[:fingerprintSize],
+		aead:        aead,
+	}, nil
+}
+
+// associatedData returns the data authenticated along the value of a key.
+func associatedData(key uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, key)
+	return b
+}
+
+func (a *encryptedAdapter) encrypt(key uint64, plaintext []byte) ([]byte, error) {
+	k := a.keys[0]
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	prefix := append([]byte{encryptionVersion}, k.fingerprint...)
+	prefix = append(prefix, nonce...)
+	return k.aead.Seal(prefix, nonce, plaintext, associatedData(key)), nil
+}
+
+func (a *encryptedAdapter) decrypt(key uint64, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 1+fingerprintSize || ciphertext[0] != encryptionVersion {
+		return nil, errors.New("unknown encrypted value version")
+	}
+	fingerprint := ciphertext[1 : 1+fingerprintSize]
+	for _, k := range a.keys {
+		if !bytes.Equal(fingerprint, k.fingerprint) {
+			continue
+		}
+		rest := ciphertext[1+fingerprintSize:]
+		if len(rest) < k.aead.NonceSize() {
+			return nil, errors.New("encrypted value is too short")
+		}
+		nonce := rest[:k.aead.NonceSize()]
+		return k.aead.Open(nil, nonce, rest[k.aead.NonceSize():], associatedData(key))
+	}
+
+	return nil, errors.New("unknown encryption key")
+}
+
+// Get implements the Adapter interface Get method. A cached response that
+// can't be decrypted is released and treated as a miss.
+func (a *encryptedAdapter) Get(key uint64) ([]byte, bool) {
+	return a.GetCtx(context.Background(), key)
+}
+
+// GetCtx implements the ContextAdapter interface GetCtx method.
+func (a *encryptedAdapter) GetCtx(ctx context.Context, key uint64) ([]byte, bool) {
+	b, ok := adapterGetCtx(a.adapter, ctx, key)
+	return a.open(ctx, key, b, ok)
+}
+
+// GetLayer implements the LayeredAdapter interface GetLayer method.
+func (a *encryptedAdapter) GetLayer(key uint64) ([]byte, string, bool) {
+	b, layer, ok := adapterGetLayer(a.adapter, key)
+	b, ok = a.open(context.Background(), key, b, ok)
+	return b, layer, ok
+}
+
+// open decrypts the cached response of the key, if found, releasing it if
+// it can't be.
+func (a *encryptedAdapter) open(ctx context.Context, key uint64, b []byte, ok bool) ([]byte, bool) {
+	if !ok {
+		return nil, false
+	}
+	plaintext, err := a.decrypt(key, b)
+	if err != nil {
+		adapterReleaseCtx(a.adapter, ctx, key)
+		return nil, false
+	}
+	return plaintext, true
+}
+
+// Set implements the Adapter interface Set method. The response is not
+// cached if it can't be encrypted.
+func (a *encryptedAdapter) Set(key uint64, response []byte, expiration time.Time) {
+	a.SetCtx(context.Background(), key, response, expiration)
+}
+
+// SetCtx implements the ContextAdapter interface SetCtx method.
+func (a *encryptedAdapter) SetCtx(ctx context.Context, key uint64, response []byte, expiration time.Time) {
+	ciphertext, err := a.encrypt(key, response)
+	if err != nil {
+		return
+	}
+	adapterSetCtx(a.adapter, ctx, key, ciphertext, expiration)
+}
+
+// SetWithTags implements the TaggedAdapter interface SetWithTags method.
+func (a *encryptedAdapter) SetWithTags(ctx context.Context, key uint64, response []byte, expiration time.Time, tags []string, cost int) {
+	ciphertext, err := a.encrypt(key, response)
+	if err != nil {
+		return
+	}
+	adapterSetWithTags(a.adapter, ctx, key, ciphertext, expiration, tags, cost)
+}
+
+// Release implements the Adapter interface Release method.
+func (a *encryptedAdapter) Release(key uint64) {
+	a.adapter.Release(key)
+}
+
+// ReleaseCtx implements the ContextAdapter interface ReleaseCtx method.
+func (a *encryptedAdapter) ReleaseCtx(ctx context.Context, key uint64) {
+	adapterReleaseCtx(a.adapter, ctx, key)
+}
+
+// Purge implements the Adapter interface Purge method.
+func (a *encryptedAdapter) Purge() error {
+	return a.adapter.Purge()
+}
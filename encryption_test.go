@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestEncryptedAdapter(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	otherKey := []byte("fedcba9876543210fedcba9876543210")
+	expiration := time.Now().Add(1 * time.Minute)
+
+	newAdapter := func(store map[uint64][]byte, keys ...[]byte) Adapter {
+		client, err := NewClient(
+			ClientWithAdapter(&adapterMock{store: store}),
+			ClientWithTTL(1*time.Minute),
+			ClientWithEncryption(keys[0], keys[1:]...),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client.adapter
+	}
+
+	t.Run("round-trips encrypted response", func(t *testing.T) {
+		store := map[uint64][]byte{}
+		a := newAdapter(store, key)
+		a.Set(1, []byte("value 1"), expiration)
+
+		if bytes.Contains(store[1], []byte("value 1")) {
+			t.Error("Set() stored the response in plaintext")
+		}
+		if store[1][0] != encryptionVersion {
+			t.Errorf("Set() version prefix = %v, want %v", store[1][0], encryptionVersion)
+		}
+		if got, ok := a.Get(1); !ok || string(got) != "value 1" {
+			t.Errorf("Get() = %s, %v, want value 1, true", got, ok)
+		}
+	})
+
+	t.Run("decrypts response of a previous key", func(t *testing.T) {
+		store := map[uint64][]byte{}
+		newAdapter(store, otherKey).Set(1, []byte("value 1"), expiration)
+
+		a := newAdapter(store, key, otherKey)
+		if got, ok := a.Get(1); !ok || string(got) != "value 1" {
+			t.Errorf("Get() = %s, %v, want value 1, true", got, ok)
+		}
+	})
+
+	t.Run("treats wrong key as a miss", func(t *testing.T) {
+		store := map[uint64][]byte{}
+		newAdapter(store, otherKey).Set(1, []byte("value 1"), expiration)
+
+		a := newAdapter(store, key)
+		if _, ok := a.Get(1); ok {
+			t.Error("Get() with a wrong key should be a miss")
+		}
+		if _, ok := store[1]; ok {
+			t.Error("Get() with a wrong key should release the response")
+		}
+	})
+
+	t.Run("treats response moved to another key as a miss", func(t *testing.T) {
+		store := map[uint64][]byte{}
+		a := newAdapter(store, key)
+		a.Set(1, []byte("value 1"), expiration)
+		store[2] = store[1]
+
+		if _, ok := a.Get(2); ok {
+			t.Error("Get() of a response moved to another key should be a miss")
+		}
+	})
+
+	t.Run("treats tampered response as a miss", func(t *testing.T) {
+		store := map[uint64][]byte{}
+		a := newAdapter(store, key)
+		a.Set(1, []byte("value 1"), expiration)
+		store[1][len(store[1])-1] ^= 0xff
+
+		if _, ok := a.Get(1); ok {
+			t.Error("Get() of a tampered response should be a miss")
+		}
+	})
+}
+
+func TestEncryptedAdapterOptionalInterfaces(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	adapter := &contextAdapterMock{adapterMock: adapterMock{store: map[uint64][]byte{}}}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithEncryption(key),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := client.Middleware()(func(c echo.Context) error {
+		return c.String(http.StatusOK, "value")
+	})
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+		r = r.WithContext(context.WithValue(r.Context(), ctxKey{}, "request"))
+		handler(echo.New().NewContext(r, httptest.NewRecorder()))
+	}
+	if len(adapter.values) == 0 {
+		t.Error("adapter context calls = 0, want the request context passed through")
+	}
+	for _, v := range adapter.values {
+		if v != "request" {
+			t.Errorf("adapter context value = %v, want the request context", v)
+		}
+	}
+
+	client, err = NewClient(
+		ClientWithAdapter(&statsAdapterMock{adapterMock: adapterMock{store: map[uint64][]byte{}}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithEncryption(key),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := client.Stats(); stats.Entries == nil {
+		t.Error("*Client.Stats() Entries = nil, want the adapter statistics")
+	}
+}
+
+func TestClientWithEncryption(t *testing.T) {
+	if _, err := NewClient(
+		ClientWithAdapter(&adapterMock{}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithEncryption([]byte("short")),
+	); err == nil {
+		t.Error("NewClient() with an invalid encryption key should fail")
+	}
+}
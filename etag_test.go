@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		etag        string
+		want        bool
+	}{
+		{"missing", "", `"v1"`, false},
+		{"same", `"v1"`, `"v1"`, true},
+		{"different", `"v2"`, `"v1"`, false},
+		{"in list", `"v2", "v1"`, `"v1"`, true},
+		{"weak", `W/"v1"`, `"v1"`, true},
+		{"any", "*", `"v1"`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagMatches(tt.ifNoneMatch, tt.etag); got != tt.want {
+				t.Errorf("etagMatches(%q, %q) = %v, want %v", tt.ifNoneMatch, tt.etag, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareETag(t *testing.T) {
+	tests := []struct {
+		name        string
+		handlerETag string
+	}{
+		{"generates strong ETag", ""},
+		{"keeps handler ETag", `"handler"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := &adapterMock{store: map[uint64][]byte{}}
+			client, _ := NewClient(
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1*time.Minute),
+				ClientWithETag(true),
+			)
+			handler := func(c echo.Context) error {
+				if tt.handlerETag != "" {
+					c.Response().Header().Set("ETag", tt.handlerETag)
+				}
+				return c.String(http.StatusOK, "value")
+			}
+			serve := func(ifNoneMatch string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+				if ifNoneMatch != "" {
+					r.Header.Set("If-None-Match", ifNoneMatch)
+				}
+				w := httptest.NewRecorder()
+				client.Middleware()(handler)(echo.New().NewContext(r, w))
+				return w
+			}
+
+			serve("")
+			w := serve("")
+			etag := w.Header().Get("ETag")
+			if etag == "" || (tt.handlerETag != "" && etag != tt.handlerETag) {
+				t.Fatalf("*Client.Middleware() ETag = %q, want %q or a generated one", etag, tt.handlerETag)
+			}
+			if w.Code != http.StatusOK || w.Body.String() != "value" {
+				t.Errorf("*Client.Middleware() = %v %v, want 200 value", w.Code, w.Body.String())
+			}
+
+			w = serve(etag)
+			if w.Code != http.StatusNotModified {
+				t.Errorf("*Client.Middleware() status = %v with matching If-None-Match, want 304", w.Code)
+			}
+			if w.Body.Len() != 0 || w.Header().Get("Content-Length") != "" {
+				t.Errorf("*Client.Middleware() 304 body = %q, Content-Length = %q, want none", w.Body.String(), w.Header().Get("Content-Length"))
+			}
+			if got := w.Header().Get("ETag"); got != etag {
+				t.Errorf("*Client.Middleware() 304 ETag = %q, want %q", got, etag)
+			}
+
+			w = serve(`"other"`)
+			if w.Code != http.StatusOK || w.Body.String() != "value" {
+				t.Errorf("*Client.Middleware() = %v %v with other If-None-Match, want 200 value", w.Code, w.Body.String())
+			}
+
+			if got := client.Stats().Hits; got != 3 {
+				t.Errorf("*Client.Stats() hits = %v, want 3 counting the 304", got)
+			}
+		})
+	}
+}
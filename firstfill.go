@@ -0,0 +1,112 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// startFill reports whether a background fill of the key can start, false
+// if one is already in progress.
+func (c *Client) startFill(key uint64) bool {
+	c.fillMutex.Lock()
+	defer c.fillMutex.Unlock()
+	if c.filling == nil {
+		c.filling = map[uint64]struct{}{}
+	}
+	if _, ok := c.filling[key]; ok {
+		return false
+	}
+	c.filling[key] = struct{}{}
+	return true
+}
+
+func (c *Client) finishFill(key uint64) {
+	c.fillMutex.Lock()
+	defer c.fillMutex.Unlock()
+	delete(c.filling, key)
+}
+
+// fillAsync calls the handler in the background with a copy of the
+// request, detached from it, and caches its response. Only one fill per
+// key runs at a time.
+func (c *Client) fillAsync(ctx echo.Context, next echo.HandlerFunc, key uint64) {
+	if !c.startFill(key) {
+		return
+	}
+
+	fc, buf := c.detach(ctx, key)
+	go func() {
+		defer c.finishFill(key)
+		c.fillDetached(fc, buf, next, key, nil)
+	}()
+}
+
+// detach returns a copy of the context for the base key, with a copy of
+// the request detached from it, whose response is buffered.
+func (c *Client) detach(ctx echo.Context, key uint64) (echo.Context, *bufferedResponseWriter) {
+	buf := &bufferedResponseWriter{header: http.Header{}}
+	fc := ctx.Echo().NewContext(ctx.Request().Clone(context.Background()), buf)
+	fc.SetPath(ctx.Path())
+	fc.SetParamNames(ctx.ParamNames()...)
+	fc.SetParamValues(ctx.ParamValues()...)
+	if plan, ok := ctx.Get(cachePlanContextKey).(*CachePlan); ok {
+		fc.Set(cachePlanContextKey, plan)
+	}
+	if stringKey, ok := ctx.Get(stringKeyContextKey).(string); ok {
+		fc.Set(stringKeyContextKey, stringKey)
+	}
+	c.captureVary(fc, key)
+	if c.authLeakGuard {
+		c.guardAuthLeak(fc)
+	}
+	if c.surrogateControl {
+		c.captureSurrogateControl(fc)
+	}
+	if c.ttlHeader != "" {
+		c.captureTTLHeader(fc)
+	}
+	return fc, buf
+}
+
+// fillDetached calls the handler with the detached context and caches its
+// buffered response.
+func (c *Client) fillDetached(fc echo.Context, buf *bufferedResponseWriter, next echo.HandlerFunc, key uint64, previous *Response) {
+	start := time.Now()
+	if err := next(fc); err != nil {
+		fc.Error(err)
+	}
+	c.measureCost(fc, start)
+
+	statusCode := buf.statusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	c.storeResponse(fc, key, statusCode, buf.header, buf.body.Bytes(), previous)
+}
@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddlewareAsyncFirstFill(t *testing.T) {
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	client, _ := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithAsyncFirstFill(func(c echo.Context) error {
+			c.Response().Header().Set("Retry-After", "1")
+			return c.String(http.StatusAccepted, "placeholder")
+		}),
+	)
+	release := make(chan struct{})
+	var calls int32
+	handler := func(c echo.Context) error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return c.String(http.StatusOK, "value")
+	}
+	serve := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+		w := httptest.NewRecorder()
+		client.Middleware()(handler)(echo.New().NewContext(r, w))
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		w := serve()
+		if w.Code != http.StatusAccepted || w.Body.String() != "placeholder" {
+			t.Errorf("*Client.Middleware() = %v %v during fill, want 202 placeholder", w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("Retry-After"); got != "1" {
+			t.Errorf("*Client.Middleware() Retry-After = %v during fill, want 1", got)
+		}
+	}
+
+	close(release)
+	key := generateKey("http://foo.bar/test-1", []string{})
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := adapter.Get(key); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("*Client.Middleware() did not fill the cache in the background")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	w := serve()
+	if w.Code != http.StatusOK || w.Body.String() != "value" {
+		t.Errorf("*Client.Middleware() = %v %v after fill, want 200 value", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("X-Cache"); got != "HIT" {
+		t.Errorf("*Client.Middleware() X-Cache = %v after fill, want HIT", got)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("handler calls = %v, want 1", got)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// defaultGenerationRefresh is how long the generation read from the
+// adapter is used before being read again.
+const defaultGenerationRefresh = time.Second
+
+// GenerationAdapter is implemented by the adapters able to store a cache
+// generation counter shared by every instance.
+type GenerationAdapter interface {
+	// Generation returns the value of the counter stored under the given
+	// key, zero if it is missing.
+	Generation(key string) (int64, error)
+
+	// IncrGeneration increments the counter stored under the given key
+	// and returns its new value.
+	IncrGeneration(key string) (int64, error)
+}
+
+// generation returns the current cache generation, read from the adapter
+// once the last value read is older than the refresh interval. The last
+// value is kept while the adapter fails.
+func (c *Client) generation() int64 {
+	c.generationMutex.Lock()
+	defer c.generationMutex.Unlock()
+
+	refresh := c.generationRefresh
+	if refresh == 0 {
+		refresh = defaultGenerationRefresh
+	}
+	if time.Since(c.generationReadAt) >= refresh {
+		if g, err := c.generations.Generation(c.generationKey); err == nil {
+			c.generationValue = g
+		}
+		c.generationReadAt = time.Now()
+	}
+	return c.generationValue
+}
+
+// BumpGeneration increments the cache generation set by
+// ClientWithGenerationCounter, so every response cached before misses.
+// The other instances see the new generation once they read it again.
+func (c *Client) BumpGeneration() error {
+	if c.generations == nil {
+		return errors.New("cache client generation counter is not set")
+	}
+	g, err := c.generations.IncrGeneration(c.generationKey)
+	if err != nil {
+		return err
+	}
+
+	c.generationMutex.Lock()
+	c.generationValue = g
+	c.generationReadAt = time.Now()
+	c.generationMutex.Unlock()
+	return nil
+}
@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type generationAdapterMock struct {
+	adapterMock
+	generations map[string]int64
+}
+
+func (a *generationAdapterMock) Generation(key string) (int64, error) {
+	a.Lock()
+	defer a.Unlock()
+	return a.generations[key], nil
+}
+
+func (a *generationAdapterMock) IncrGeneration(key string) (int64, error) {
+	a.Lock()
+	defer a.Unlock()
+	a.generations[key]++
+	return a.generations[key], nil
+}
+
+func TestMiddlewareGenerationCounter(t *testing.T) {
+	adapter := &generationAdapterMock{
+		adapterMock: adapterMock{store: map[uint64][]byte{}},
+		generations: map[string]int64{},
+	}
+	newClient := func() *Client {
+		client, err := NewClient(
+			ClientWithAdapter(adapter),
+			ClientWithTTL(1*time.Minute),
+			ClientWithGenerationCounter("generation"),
+		)
+		if err != nil {
+			t.Fatalf("NewClient() error = %v", err)
+		}
+		return client
+	}
+	bumping, other := newClient(), newClient()
+	other.generationRefresh = 10 * time.Millisecond
+
+	calls := 0
+	handler := func(c echo.Context) error {
+		calls++
+		return c.String(http.StatusOK, "value")
+	}
+	get := func(client *Client, URL string) string {
+		r := httptest.NewRequest(http.MethodGet, URL, nil)
+		w := httptest.NewRecorder()
+		client.Middleware()(handler)(echo.New().NewContext(r, w))
+		return w.Header().Get("X-Cache")
+	}
+
+	for _, URL := range []string{"http://foo.bar/test-1", "http://foo.bar/test-2"} {
+		get(bumping, URL)
+		if got := get(bumping, URL); got != "HIT" {
+			t.Errorf("X-Cache of %s = %v, want HIT", URL, got)
+		}
+		if got := get(other, URL); got != "HIT" {
+			t.Errorf("X-Cache of %s on other client = %v, want HIT", URL, got)
+		}
+	}
+
+	if err := bumping.BumpGeneration(); err != nil {
+		t.Fatalf("BumpGeneration() error = %v", err)
+	}
+	calls = 0
+	for _, URL := range []string{"http://foo.bar/test-1", "http://foo.bar/test-2"} {
+		if got := get(bumping, URL); got != "" {
+			t.Errorf("X-Cache of %s after BumpGeneration() = %v, want a miss", URL, got)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("handler calls after BumpGeneration() = %v, want 2", calls)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if got := get(other, "http://foo.bar/test-1"); got != "HIT" {
+		t.Errorf("X-Cache on other client after refresh = %v, want HIT of the new generation", got)
+	}
+	if calls != 2 {
+		t.Errorf("handler calls on other client = %v, want 2", calls)
+	}
+}
+
+func TestNewClientGenerationCounter(t *testing.T) {
+	if _, err := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithGenerationCounter("generation"),
+	); err == nil {
+		t.Errorf("NewClient() error = nil with an adapter without generation counters, want an error")
+	}
+
+	client, _ := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+	)
+	if err := client.BumpGeneration(); err == nil {
+		t.Errorf("BumpGeneration() error = nil without generation counter, want an error")
+	}
+}
@@ -3,7 +3,9 @@ module github.com/rishikesh-parspec/echo-http-cache
 go 1.14
 
 require (
+	github.com/alicebob/miniredis/v2 v2.14.1
 	github.com/allegro/bigcache v1.2.1-0.20190218064605-e24eb225f156
+	github.com/cespare/xxhash/v2 v2.1.1
 	github.com/dgryski/go-rendezvous v0.0.0-20200624174652-8d2f3be8b2d9 // indirect
 	github.com/go-redis/cache/v8 v8.0.0-beta.11
 	github.com/go-redis/redis/v8 v8.0.0-beta.5
@@ -12,9 +14,9 @@ require (
 	github.com/mattn/go-colorable v0.1.7 // indirect
 	github.com/valyala/fasttemplate v1.1.1 // indirect
 	github.com/vmihailenco/msgpack/v5 v5.0.0-beta.1 // indirect
-	go.opentelemetry.io/otel v0.7.0 // indirect
+	go.opentelemetry.io/otel v0.7.0
 	golang.org/x/net v0.0.0-20200625001655-4c5254603344 // indirect
-	golang.org/x/sync v0.0.0-20200625203802-6e8e738ad208 // indirect
+	golang.org/x/sync v0.0.0-20200625203802-6e8e738ad208
 	golang.org/x/sys v0.0.0-20200625212154-ddb9806d33ae // indirect
 	golang.org/x/text v0.3.3 // indirect
 	google.golang.org/protobuf v1.25.0 // indirect
@@ -0,0 +1,73 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// TTLInfinity is the histogram bucket of the cached responses whose
+// remaining TTL is above every bucket, including the ones that never
+// expire.
+const TTLInfinity = time.Duration(math.MaxInt64)
+
+// TTLHistogramAdapter is implemented by the adapters able to iterate over
+// their cached responses to report the distribution of remaining TTLs.
+type TTLHistogramAdapter interface {
+	// TTLHistogram counts the cached responses by remaining TTL. Each
+	// response is counted in the smallest bucket greater or equal to its
+	// remaining TTL, or in TTLInfinity.
+	TTLHistogram(buckets []time.Duration) map[time.Duration]int
+}
+
+// BuildTTLHistogram counts the given remaining TTLs into the buckets, as
+// described by TTLHistogramAdapter. Every bucket is present in the result.
+func BuildTTLHistogram(buckets []time.Duration, ttls []time.Duration) map[time.Duration]int {
+	sorted := append([]time.Duration{}, buckets...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	histogram := make(map[time.Duration]int, len(sorted)+1)
+	for _, b := range sorted {
+		histogram[b] = 0
+	}
+	histogram[TTLInfinity] = 0
+
+	for _, ttl := range ttls {
+		i := sort.Search(len(sorted), func(i int) bool {
+			return sorted[i] >= ttl
+		})
+		if i == len(sorted) {
+			histogram[TTLInfinity]++
+		} else {
+			histogram[sorted[i]]++
+		}
+	}
+
+	return histogram
+}
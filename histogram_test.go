@@ -0,0 +1,30 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildTTLHistogram(t *testing.T) {
+	buckets := []time.Duration{time.Hour, time.Minute, 10 * time.Minute}
+	ttls := []time.Duration{
+		30 * time.Second,
+		time.Minute,
+		2 * time.Minute,
+		9 * time.Minute,
+		30 * time.Minute,
+		2 * time.Hour,
+		TTLInfinity,
+	}
+
+	want := map[time.Duration]int{
+		time.Minute:      2,
+		10 * time.Minute: 2,
+		time.Hour:        1,
+		TTLInfinity:      2,
+	}
+	if got := BuildTTLHistogram(buckets, ttls); !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildTTLHistogram() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"time"
+)
+
+// Locker is implemented by the adapters able to lock a cache key across
+// every instance sharing the cache, so that a single instance fills it.
+type Locker interface {
+	// Lock tries to lock the given key until the TTL elapses. It returns
+	// false if the key is locked by someone else, otherwise the function
+	// releasing the lock.
+	Lock(key uint64, ttl time.Duration) (unlock func(), ok bool)
+}
+
+// SingleflightPolicy is what the requests waiting for another request to
+// fill a key do once the singleflight timeout elapses.
+type SingleflightPolicy int
+
+const (
+	// SingleflightProceed runs the handler, as the filling request does.
+	SingleflightProceed SingleflightPolicy = iota
+
+	// SingleflightUnavailable responds with 503 Service Unavailable.
+	SingleflightUnavailable
+)
+
+// awaitFill waits, until the lock TTL or the singleflight timeout
+// elapses, for the lock holder to cache the response for the given key.
+// It returns false if the response was not cached in time.
+func (c *Client) awaitFill(key uint64) (Response, bool) {
+	wait := c.lockTTL
+	if c.singleflightTimeout > 0 && c.singleflightTimeout < wait {
+		wait = c.singleflightTimeout
+	}
+	interval := wait / 10
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		if b, ok := c.adapter.Get(key); ok {
+			response := BytesToResponse(b)
+			if len(response.Vary) == 0 && c.isFresh(response) {
+				return response, true
+			}
+		}
+	}
+	return Response{}, false
+}
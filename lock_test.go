@@ -0,0 +1,175 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type lockerMock struct {
+	adapterMock
+	locked bool
+}
+
+func (l *lockerMock) Lock(key uint64, ttl time.Duration) (func(), bool) {
+	return func() {}, !l.locked
+}
+
+func TestMiddlewareDistributedLock(t *testing.T) {
+	tests := []struct {
+		name      string
+		locked    bool
+		wantCalls int
+	}{
+		{
+			"fills unlocked key",
+			false,
+			1,
+		},
+		{
+			"fills locked key once the lock holder is too slow",
+			true,
+			1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			handler := func(c echo.Context) error {
+				calls++
+				return c.String(http.StatusOK, "value")
+			}
+			client, _ := NewClient(
+				ClientWithAdapter(&lockerMock{adapterMock: adapterMock{store: map[uint64][]byte{}}, locked: tt.locked}),
+				ClientWithTTL(1*time.Minute),
+				ClientWithDistributedLock(50*time.Millisecond),
+			)
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+			w := httptest.NewRecorder()
+			client.Middleware()(handler)(echo.New().NewContext(r, w))
+
+			if calls != tt.wantCalls {
+				t.Errorf("handler calls = %v, want %v", calls, tt.wantCalls)
+			}
+			if w.Body.String() != "value" {
+				t.Errorf("*Client.Middleware() = %v, want value", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestClientWithDistributedLock(t *testing.T) {
+	tests := []struct {
+		name    string
+		adapter Adapter
+		ttl     time.Duration
+		wantErr bool
+	}{
+		{"locker adapter", &lockerMock{}, time.Second, false},
+		{"adapter without locks", &adapterMock{}, time.Second, true},
+		{"invalid ttl", &lockerMock{}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewClient(
+				ClientWithAdapter(tt.adapter),
+				ClientWithTTL(1*time.Minute),
+				ClientWithDistributedLock(tt.ttl),
+			)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMiddlewareSingleflightTimeout(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    SingleflightPolicy
+		fillAfter time.Duration
+		wantCalls int
+		wantCode  int
+	}{
+		{
+			"proceeds to the handler once the slow lock holder times out",
+			SingleflightProceed,
+			0,
+			1,
+			http.StatusOK,
+		},
+		{
+			"responds 503 once the slow lock holder times out",
+			SingleflightUnavailable,
+			0,
+			0,
+			http.StatusServiceUnavailable,
+		},
+		{
+			"serves the response filled before the timeout",
+			SingleflightUnavailable,
+			5 * time.Millisecond,
+			0,
+			http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			handler := func(c echo.Context) error {
+				calls++
+				return c.String(http.StatusOK, "value")
+			}
+			adapter := &lockerMock{adapterMock: adapterMock{store: map[uint64][]byte{}}, locked: true}
+			client, err := NewClient(
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1*time.Minute),
+				ClientWithDistributedLock(10*time.Second),
+				ClientWithSingleflightTimeout(50*time.Millisecond),
+				ClientWithSingleflightTimeoutPolicy(tt.policy),
+			)
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			if tt.fillAfter > 0 {
+				go func() {
+					time.Sleep(tt.fillAfter)
+					adapter.Set(generateKey("http://foo.bar/test", []string{}), Response{
+						Value:      []byte("filled"),
+						Expiration: time.Now().Add(time.Minute),
+					}.Bytes(), time.Now().Add(time.Minute))
+				}()
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+			w := httptest.NewRecorder()
+			start := time.Now()
+			err = client.Middleware()(handler)(echo.New().NewContext(r, w))
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("waited %v, want the singleflight timeout", elapsed)
+			}
+
+			code := w.Code
+			if he, ok := err.(*echo.HTTPError); ok {
+				code = he.Code
+			}
+			if code != tt.wantCode {
+				t.Errorf("*Client.Middleware() code = %v, want %v", code, tt.wantCode)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("handler calls = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+
+	if _, err := NewClient(
+		ClientWithAdapter(&lockerMock{}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithSingleflightTimeout(time.Second),
+	); err == nil {
+		t.Errorf("NewClient() error = nil with a singleflight timeout without singleflight or distributed lock, want an error")
+	}
+}
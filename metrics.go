@@ -0,0 +1,97 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/api/kv"
+	"go.opentelemetry.io/otel/api/metric"
+	"go.opentelemetry.io/otel/api/unit"
+)
+
+// instruments are the OpenTelemetry instruments the cache decisions are
+// recorded with.
+type instruments struct {
+	hits      metric.Int64Counter
+	misses    metric.Int64Counter
+	stores    metric.Int64Counter
+	duration  metric.Float64ValueRecorder
+	evictions metric.Int64SumObserver
+}
+
+func (c *Client) newInstruments(meter metric.Meter) (*instruments, error) {
+	var err error
+	i := &instruments{}
+	if i.hits, err = meter.NewInt64Counter("http_cache.hits",
+		metric.WithDescription("Cached responses served")); err != nil {
+		return nil, err
+	}
+	if i.misses, err = meter.NewInt64Counter("http_cache.misses",
+		metric.WithDescription("Requests run through the handler to be cached")); err != nil {
+		return nil, err
+	}
+	if i.stores, err = meter.NewInt64Counter("http_cache.stores",
+		metric.WithDescription("Responses cached")); err != nil {
+		return nil, err
+	}
+	if i.duration, err = meter.NewFloat64ValueRecorder("http_cache.operation.duration",
+		metric.WithDescription("Duration of the adapter operations"),
+		metric.WithUnit(unit.Milliseconds)); err != nil {
+		return nil, err
+	}
+	// The adapter evictions are observed from the adapter statistics, if
+	// it reports them.
+	if i.evictions, err = meter.NewInt64SumObserver("http_cache.evictions",
+		func(ctx context.Context, result metric.Int64ObserverResult) {
+			if c.stats != nil {
+				result.Observe(c.stats.AdapterStats().Evictions)
+			}
+		},
+		metric.WithDescription("Cached responses evicted by the adapter")); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// recordStore counts a cached response, and records it if a meter is set.
+func (c *Client) recordStore(ctx echo.Context) {
+	atomic.AddInt64(&c.sets, 1)
+	if c.instruments != nil {
+		c.instruments.stores.Add(ctx.Request().Context(), 1)
+	}
+}
+
+// recordDuration records the duration of the adapter operation started at
+// start, if a meter is set.
+func (c *Client) recordDuration(ctx echo.Context, operation string, start time.Time) {
+	if c.instruments != nil {
+		ms := float64(time.Since(start)) / float64(time.Millisecond)
+		c.instruments.duration.Record(ctx.Request().Context(), ms, kv.String("operation", operation))
+	}
+}
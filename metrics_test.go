@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/api/kv"
+	"go.opentelemetry.io/otel/api/metric"
+)
+
+// meterMock is an in-memory meter implementation, recording the sum and
+// count of the measurements of each instrument.
+type meterMock struct {
+	sync.Mutex
+	sums    map[string]float64
+	counts  map[string]int
+	runners map[*instrumentMock]metric.AsyncSingleRunner
+}
+
+type instrumentMock struct {
+	meter      *meterMock
+	descriptor metric.Descriptor
+}
+
+func (i *instrumentMock) Implementation() interface{}             { return i }
+func (i *instrumentMock) Descriptor() metric.Descriptor           { return i.descriptor }
+func (i *instrumentMock) Bind([]kv.KeyValue) metric.BoundSyncImpl { return nil }
+
+func (i *instrumentMock) RecordOne(ctx context.Context, number metric.Number, labels []kv.KeyValue) {
+	i.meter.record(i.descriptor, number)
+}
+
+func newMeterMock() *meterMock {
+	return &meterMock{
+		sums:    map[string]float64{},
+		counts:  map[string]int{},
+		runners: map[*instrumentMock]metric.AsyncSingleRunner{},
+	}
+}
+
+func (m *meterMock) record(d metric.Descriptor, number metric.Number) {
+	m.Lock()
+	defer m.Unlock()
+	if d.NumberKind() == metric.Int64NumberKind {
+		m.sums[d.Name()] += float64(number.AsInt64())
+	} else {
+		m.sums[d.Name()] += number.AsFloat64()
+	}
+	m.counts[d.Name()]++
+}
+
+func (m *meterMock) RecordBatch(ctx context.Context, labels []kv.KeyValue, ms ...metric.Measurement) {
+	for _, measurement := range ms {
+		measurement.SyncImpl().RecordOne(ctx, measurement.Number(), labels)
+	}
+}
+
+func (m *meterMock) NewSyncInstrument(d metric.Descriptor) (metric.SyncImpl, error) {
+	return &instrumentMock{meter: m, descriptor: d}, nil
+}
+
+func (m *meterMock) NewAsyncInstrument(d metric.Descriptor, runner metric.AsyncRunner) (metric.AsyncImpl, error) {
+	m.Lock()
+	defer m.Unlock()
+	i := &instrumentMock{meter: m, descriptor: d}
+	m.runners[i] = runner.(metric.AsyncSingleRunner)
+	return i, nil
+}
+
+// collect runs the asynchronous instruments callbacks.
+func (m *meterMock) collect() {
+	m.Lock()
+	runners := m.runners
+	m.Unlock()
+	for i, runner := range runners {
+		runner.Run(context.Background(), i, func(labels []kv.KeyValue, observations ...metric.Observation) {
+			for _, o := range observations {
+				m.record(o.AsyncImpl().Descriptor(), o.Number())
+			}
+		})
+	}
+}
+
+func TestClientWithMeter(t *testing.T) {
+	impl := newMeterMock()
+	client, err := NewClient(
+		ClientWithAdapter(&statsAdapterMock{adapterMock{store: map[uint64][]byte{}}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithMeter(metric.WrapMeterImpl(impl, "test")),
+	)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	handler := client.Middleware()(func(c echo.Context) error {
+		return c.String(http.StatusOK, "value")
+	})
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+		handler(echo.New().NewContext(r, httptest.NewRecorder()))
+	}
+	impl.collect()
+
+	sums := map[string]float64{
+		"http_cache.hits":      2,
+		"http_cache.misses":    1,
+		"http_cache.stores":    1,
+		"http_cache.evictions": 3,
+	}
+	for name, want := range sums {
+		if got := impl.sums[name]; got != want {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+	// The lookups of the three requests and the store of the first one.
+	if got := impl.counts["http_cache.operation.duration"]; got != 4 {
+		t.Errorf("http_cache.operation.duration recorded %v times, want 4", got)
+	}
+}
+
+func TestClientWithoutMeter(t *testing.T) {
+	client, _ := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+	)
+	if client.instruments != nil {
+		t.Errorf("instruments are set without meter")
+	}
+	handler := client.Middleware()(func(c echo.Context) error {
+		return c.String(http.StatusOK, "value")
+	})
+	r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+	handler(echo.New().NewContext(r, httptest.NewRecorder()))
+}
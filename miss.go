@@ -0,0 +1,90 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// MissReason is why a request was not served from the cache.
+type MissReason string
+
+// Miss reasons of the requests not served from the cache.
+const (
+	// MissNotCached is the reason of a request without cached response.
+	MissNotCached MissReason = "not_cached"
+
+	// MissExpired is the reason of a request whose cached response is
+	// expired and can't be served stale.
+	MissExpired MissReason = "expired"
+
+	// MissRefresh is the reason of a request refreshing its cached
+	// response with the refresh key.
+	MissRefresh MissReason = "refresh"
+
+	// MissNoCache is the reason of a request with the no-cache directive.
+	MissNoCache MissReason = "no_cache"
+
+	// MissNoStore is the reason of a request with the no-store directive.
+	MissNoStore MissReason = "no_store"
+
+	// MissExcludedPath is the reason of a request to a path not allowed to
+	// be cached.
+	MissExcludedPath MissReason = "excluded_path"
+
+	// MissExcludedRequest is the reason of a request excluded by the query
+	// predicate or the cache plan.
+	MissExcludedRequest MissReason = "excluded_request"
+
+	// MissMethodNotCacheable is the reason of a request whose method is
+	// not cacheable.
+	MissMethodNotCacheable MissReason = "method_not_cacheable"
+
+	// MissUnreadableBody is the reason of a POST request whose body could
+	// not be read to compute the cache key.
+	MissUnreadableBody MissReason = "unreadable_body"
+
+	// MissOverloaded is the reason of a request exceeding the concurrent
+	// buffers, or timing out waiting for the lock holder.
+	MissOverloaded MissReason = "overloaded"
+)
+
+const missReasonContextKey = "echo-http-cache.miss-reason"
+
+// missBecause records why the request is not served from the cache.
+func missBecause(ctx echo.Context, reason MissReason) {
+	ctx.Set(missReasonContextKey, reason)
+}
+
+// missReason returns why the request was not served from the cache, false
+// if it was or if no reason was recorded.
+func missReason(ctx echo.Context) (MissReason, bool) {
+	switch cacheStatus(ctx) {
+	case CacheStatusHit, CacheStatusStale:
+		return "", false
+	}
+	reason, ok := ctx.Get(missReasonContextKey).(MissReason)
+	return reason, ok
+}
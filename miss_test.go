@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddlewareOnMiss(t *testing.T) {
+	key := generateKey("http://foo.bar/test-1", []string{})
+
+	tests := []struct {
+		name       string
+		opts       []ClientOption
+		expiration time.Duration
+		method     string
+		url        string
+		header     string
+		overloaded bool
+		wantReason MissReason
+	}{
+		{
+			"reports a response never cached",
+			nil,
+			0,
+			http.MethodGet,
+			"http://foo.bar/test-2",
+			"",
+			false,
+			MissNotCached,
+		},
+		{
+			"reports an expired response",
+			nil,
+			-1 * time.Minute,
+			http.MethodGet,
+			"http://foo.bar/test-1",
+			"",
+			false,
+			MissExpired,
+		},
+		{
+			"reports a refreshed response",
+			[]ClientOption{ClientWithRefreshKey("rk")},
+			1 * time.Minute,
+			http.MethodGet,
+			"http://foo.bar/test-1?rk=true",
+			"",
+			false,
+			MissRefresh,
+		},
+		{
+			"reports a request no-cache directive",
+			[]ClientOption{ClientWithRespectRequestCacheControl(true)},
+			1 * time.Minute,
+			http.MethodGet,
+			"http://foo.bar/test-1",
+			"no-cache",
+			false,
+			MissNoCache,
+		},
+		{
+			"reports a request no-store directive",
+			[]ClientOption{ClientWithRespectRequestCacheControl(true)},
+			1 * time.Minute,
+			http.MethodGet,
+			"http://foo.bar/test-1",
+			"no-store",
+			false,
+			MissNoStore,
+		},
+		{
+			"reports an excluded path",
+			[]ClientOption{ClientWithRestrictedPaths([]string{"/test-1"})},
+			1 * time.Minute,
+			http.MethodGet,
+			"http://foo.bar/test-1",
+			"",
+			false,
+			MissExcludedPath,
+		},
+		{
+			"reports a method not cacheable",
+			nil,
+			1 * time.Minute,
+			http.MethodDelete,
+			"http://foo.bar/test-1",
+			"",
+			false,
+			MissMethodNotCacheable,
+		},
+		{
+			"reports too many concurrent buffers",
+			[]ClientOption{ClientWithMaxConcurrentBuffers(1)},
+			0,
+			http.MethodGet,
+			"http://foo.bar/test-2",
+			"",
+			true,
+			MissOverloaded,
+		},
+		{
+			"does not report a hit",
+			nil,
+			1 * time.Minute,
+			http.MethodGet,
+			"http://foo.bar/test-1",
+			"",
+			false,
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := map[uint64][]byte{}
+			if tt.expiration != 0 {
+				store[key] = Response{
+					Value:      []byte("cached"),
+					Expiration: time.Now().Add(tt.expiration),
+				}.Bytes()
+			}
+			var got MissReason
+			client, err := NewClient(append([]ClientOption{
+				ClientWithAdapter(&adapterMock{store: store}),
+				ClientWithTTL(1 * time.Minute),
+				ClientWithOnMiss(func(c echo.Context, reason MissReason) {
+					got = reason
+				}),
+			}, tt.opts...)...)
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			if tt.overloaded {
+				client.acquireBuffer()
+			}
+			handler := client.Middleware()(func(c echo.Context) error {
+				return c.String(http.StatusOK, "fresh")
+			})
+
+			r := httptest.NewRequest(tt.method, tt.url, nil)
+			if tt.header != "" {
+				r.Header.Set("Cache-Control", tt.header)
+			}
+			handler(echo.New().NewContext(r, httptest.NewRecorder()))
+
+			if got != tt.wantReason {
+				t.Errorf("*Client.Middleware() miss reason = %q, want %q", got, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"mime"
+	"net/http"
+)
+
+// defaultPoisonSizeRatio is the default ratio between the sizes of a
+// cached response and the response overwriting it above which the
+// overwrite is suspicious.
+const defaultPoisonSizeRatio = 10
+
+// suspiciousOverwrite reports whether overwriting the previous cached
+// response with the given one looks like cache poisoning: its content
+// type changed, or its size changed by more than the configured ratio.
+func (c *Client) suspiciousOverwrite(previous *Response, header http.Header, value []byte) bool {
+	if mediaType(previous.Header) != mediaType(header) {
+		return true
+	}
+
+	small, large := int64(len(previous.Value)), int64(len(value))
+	if previous.StreamSize > 0 {
+		small = previous.StreamSize
+	}
+	if small > large {
+		small, large = large, small
+	}
+	if small == 0 {
+		small = 1
+	}
+	ratio := c.poisonSizeRatio
+	if ratio == 0 {
+		ratio = defaultPoisonSizeRatio
+	}
+	return float64(large)/float64(small) > ratio
+}
+
+func mediaType(header http.Header) string {
+	mt, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return header.Get("Content-Type")
+	}
+	return mt
+}
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddlewarePoisonGuard(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		contentType string
+		body        string
+		wantStored  string
+	}{
+		{
+			"allows similar overwrite",
+			"http://foo.bar/test",
+			echo.MIMETextPlainCharsetUTF8,
+			"value 2",
+			"value 2",
+		},
+		{
+			"rejects drastically larger overwrite",
+			"http://foo.bar/test",
+			echo.MIMETextPlainCharsetUTF8,
+			strings.Repeat("poisoned ", 100),
+			"value 1",
+		},
+		{
+			"rejects drastically smaller overwrite",
+			"http://foo.bar/test",
+			echo.MIMETextPlainCharsetUTF8,
+			"",
+			"value 1",
+		},
+		{
+			"rejects content type change",
+			"http://foo.bar/test",
+			echo.MIMETextHTMLCharsetUTF8,
+			"value 2",
+			"value 1",
+		},
+		{
+			"allows explicit refresh",
+			"http://foo.bar/test?rk=true",
+			echo.MIMETextHTMLCharsetUTF8,
+			strings.Repeat("poisoned ", 100),
+			strings.Repeat("poisoned ", 100),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := generateKey("http://foo.bar/test", []string{})
+			header := http.Header{}
+			header.Set("Content-Type", echo.MIMETextPlainCharsetUTF8)
+			adapter := &adapterMock{
+				store: map[uint64][]byte{
+					key: Response{
+						Value:      []byte("value 1"),
+						Header:     header,
+						Expiration: time.Now().Add(-1 * time.Minute),
+					}.Bytes(),
+				},
+			}
+			client, _ := NewClient(
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1*time.Minute),
+				ClientWithRefreshKey("rk"),
+				ClientWithPoisonGuard(true),
+				ClientWithPoisonGuardSizeRatio(4),
+			)
+			handler := func(c echo.Context) error {
+				return c.Blob(http.StatusOK, tt.contentType, []byte(tt.body))
+			}
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			w := httptest.NewRecorder()
+			client.Middleware()(handler)(echo.New().NewContext(r, w))
+
+			if w.Body.String() != tt.body {
+				t.Errorf("*Client.Middleware() = %v, want %v", w.Body.String(), tt.body)
+			}
+			got := string(BytesToResponse(adapter.store[key]).Value)
+			if got != tt.wantStored {
+				t.Errorf("stored response = %v, want %v", got, tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestClientWithPoisonGuardSizeRatio(t *testing.T) {
+	tests := []struct {
+		name    string
+		ratio   float64
+		wantErr bool
+	}{
+		{"valid ratio", 2, false},
+		{"ratio of one", 1, true},
+		{"negative ratio", -1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewClient(
+				ClientWithAdapter(&adapterMock{}),
+				ClientWithTTL(1*time.Minute),
+				ClientWithPoisonGuardSizeRatio(tt.ratio),
+			)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
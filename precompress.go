@@ -0,0 +1,148 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// precompressors are the content encodings the variants can be
+// precompressed with.
+var precompressors = map[string]func(w io.Writer) (io.WriteCloser, error){
+	"gzip": func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	},
+	"deflate": func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, flate.DefaultCompression)
+	},
+}
+
+// variantKey returns the cache key of the response variant compressed
+// with the given encoding.
+func (c *Client) variantKey(key uint64, encoding string) uint64 {
+	return c.generateKey(KeyAsString(key), []string{";" + encoding}, nil)
+}
+
+// negotiateEncoding picks the best match for the given Accept-Encoding
+// header among the precompressed variants. It returns an empty string
+// when the identity response is preferred.
+func (c *Client) negotiateEncoding(acceptEncoding string) string {
+	type weightedCoding struct {
+		coding string
+		q      float64
+	}
+
+	codings := []weightedCoding{}
+	identity := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					v = 0
+				}
+				q = v
+			}
+		}
+		if coding == "identity" {
+			identity = q
+			continue
+		}
+		if q <= 0 {
+			continue
+		}
+		codings = append(codings, weightedCoding{coding, q})
+	}
+	sort.SliceStable(codings, func(i, j int) bool {
+		return codings[i].q > codings[j].q
+	})
+
+	for _, w := range codings {
+		if w.q < identity {
+			return ""
+		}
+		if w.coding == "*" {
+			return c.precompress[0]
+		}
+		for _, e := range c.precompress {
+			if w.coding == e {
+				return e
+			}
+		}
+	}
+	return ""
+}
+
+// storeVariants caches the precompressed variants of the response. A
+// variant is skipped when it is not smaller than the identity response,
+// since it would only take more storage. Responses with no-transform are
+// never compressed.
+func (c *Client) storeVariants(ctx echo.Context, key uint64, response Response) {
+	if len(c.precompress) == 0 || response.Header.Get("Content-Encoding") != "" ||
+		parseCacheControl(response.Header).has("no-transform") ||
+		response.StatusCode == http.StatusNoContent || response.StatusCode == http.StatusNotModified {
+		return
+	}
+
+	for _, encoding := range c.precompress {
+		buf := new(bytes.Buffer)
+		w, err := precompressors[encoding](buf)
+		if err != nil {
+			continue
+		}
+		if _, err := w.Write(response.Value); err != nil {
+			continue
+		}
+		if err := w.Close(); err != nil || buf.Len() >= len(response.Value) {
+			continue
+		}
+
+		variant := response
+		variant.Value = buf.Bytes()
+		variant.Header = response.Header.Clone()
+		variant.Header.Set("Content-Encoding", encoding)
+		variant.Header.Add("Vary", "Accept-Encoding")
+		variant.Header.Del("Content-Length")
+		variantKey := c.variantKey(key, encoding)
+		c.setCtx(ctx.Request().Context(), variantKey, variant, variant.Expiration)
+		c.indexKey(variantKey, ctx.Request().URL.String(), variant.Expiration)
+	}
+}
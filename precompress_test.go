@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddlewarePrecompressVariants(t *testing.T) {
+	body := strings.Repeat("compressible value ", 50)
+	calls := 0
+	handler := func(c echo.Context) error {
+		calls++
+		return c.String(http.StatusOK, body)
+	}
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	client, _ := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithPrecompressVariants([]string{"gzip", "deflate"}),
+	)
+	mw := client.Middleware()(handler)
+	e := echo.New()
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		wantEncoding   string
+		wantCache      string
+	}{
+		{
+			"miss populates the variants",
+			"gzip",
+			"",
+			"",
+		},
+		{
+			"serves gzip variant",
+			"gzip, deflate",
+			"gzip",
+			"HIT",
+		},
+		{
+			"serves deflate variant by preference",
+			"gzip;q=0.5, deflate",
+			"deflate",
+			"HIT",
+		},
+		{
+			"serves identity response",
+			"",
+			"",
+			"HIT",
+		},
+		{
+			"serves identity response when preferred",
+			"gzip;q=0.5, identity",
+			"",
+			"HIT",
+		},
+		{
+			"serves identity response for unknown encoding",
+			"br",
+			"",
+			"HIT",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			mw(e.NewContext(r, w))
+
+			if got := w.Header().Get("X-Cache"); got != tt.wantCache {
+				t.Errorf("*Client.Middleware() X-Cache = %v, want %v", got, tt.wantCache)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Errorf("*Client.Middleware() Content-Encoding = %v, want %v", got, tt.wantEncoding)
+			}
+			got := w.Body.Bytes()
+			if tt.wantEncoding == "gzip" {
+				zr, err := gzip.NewReader(bytes.NewReader(got))
+				if err != nil {
+					t.Fatalf("gzip.NewReader() error = %v", err)
+				}
+				got, _ = ioutil.ReadAll(zr)
+			}
+			if tt.wantEncoding != "deflate" && string(got) != body {
+				t.Errorf("*Client.Middleware() = %v, want %v", string(got), body)
+			}
+		})
+	}
+
+	if calls != 1 {
+		t.Errorf("handler calls = %v, want 1", calls)
+	}
+	if len(adapter.store) != 3 {
+		t.Errorf("stored entries = %v, want 3", len(adapter.store))
+	}
+}
+
+func TestMiddlewarePrecompressSkipsLargerVariants(t *testing.T) {
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	client, _ := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithPrecompressVariants([]string{"gzip"}),
+	)
+	handler := func(c echo.Context) error {
+		return c.String(http.StatusOK, "tiny")
+	}
+	r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+	client.Middleware()(handler)(echo.New().NewContext(r, httptest.NewRecorder()))
+
+	if len(adapter.store) != 1 {
+		t.Errorf("stored entries = %v, want 1", len(adapter.store))
+	}
+}
+
+func TestMiddlewarePrecompressNoTransform(t *testing.T) {
+	body := strings.Repeat("compressible value ", 50)
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	client, _ := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithPrecompressVariants([]string{"gzip"}),
+	)
+	handler := func(c echo.Context) error {
+		c.Response().Header().Set("Cache-Control", "no-transform")
+		return c.String(http.StatusOK, body)
+	}
+	mw := client.Middleware()(handler)
+	e := echo.New()
+
+	for _, wantCache := range []string{"", "HIT"} {
+		r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		mw(e.NewContext(r, w))
+
+		if got := w.Header().Get("X-Cache"); got != wantCache {
+			t.Errorf("*Client.Middleware() X-Cache = %v, want %v", got, wantCache)
+		}
+		if got := w.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("*Client.Middleware() Content-Encoding = %v, want none", got)
+		}
+		if w.Body.String() != body {
+			t.Errorf("*Client.Middleware() = %v, want %v", w.Body.String(), body)
+		}
+	}
+	if len(adapter.store) != 1 {
+		t.Errorf("stored entries = %v, want 1", len(adapter.store))
+	}
+}
+
+func TestClientWithPrecompressVariants(t *testing.T) {
+	tests := []struct {
+		name      string
+		encodings []string
+		wantErr   bool
+	}{
+		{"supported encodings", []string{"gzip", "deflate"}, false},
+		{"unsupported encoding", []string{"br"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewClient(
+				ClientWithAdapter(&adapterMock{}),
+				ClientWithTTL(1*time.Minute),
+				ClientWithPrecompressVariants(tt.encodings),
+			)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
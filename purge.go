@@ -0,0 +1,235 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxIndexKeys is the number of keys the URL index holds at most. Once
+// full, the expired keys are pruned, then arbitrary ones if needed.
+const maxIndexKeys = 100000
+
+// indexEntry is the URL of the request a cached response was stored for,
+// and when the adapter may drop it.
+type indexEntry struct {
+	URL        string
+	expiration time.Time
+}
+
+// indexKey records the URL of the request the cached response of the key
+// was stored for, until the given expiration, to release it by URL.
+func (c *Client) indexKey(key uint64, URL string, expiration time.Time) {
+	c.indexMutex.Lock()
+	defer c.indexMutex.Unlock()
+	if c.index == nil {
+		c.index = map[uint64]indexEntry{}
+	}
+	if _, ok := c.index[key]; !ok && len(c.index) >= maxIndexKeys {
+		c.pruneIndex()
+	}
+	c.index[key] = indexEntry{URL: URL, expiration: c.storedUntil(expiration)}
+}
+
+// pruneIndex removes the expired keys from the index, and arbitrary ones
+// if it is still full. The index mutex must be held.
+func (c *Client) pruneIndex() {
+	now := time.Now()
+	for key, e := range c.index {
+		if !e.expiration.After(now) {
+			delete(c.index, key)
+		}
+	}
+	for key := range c.index {
+		if len(c.index) < maxIndexKeys {
+			break
+		}
+		delete(c.index, key)
+	}
+}
+
+// unindexKey removes the key from the index, once its response is released
+// or missed.
+func (c *Client) unindexKey(key uint64) {
+	c.indexMutex.Lock()
+	delete(c.index, key)
+	c.indexMutex.Unlock()
+}
+
+// releaseWhere releases the cached responses whose URL satisfies the
+// filter, and returns how many were released.
+func (c *Client) releaseWhere(filter func(u *url.URL) bool) int {
+	c.indexMutex.Lock()
+	keys := []uint64{}
+	for key, e := range c.index {
+		u, err := url.Parse(e.URL)
+		if err != nil || filter(u) {
+			keys = append(keys, key)
+			delete(c.index, key)
+		}
+	}
+	c.indexMutex.Unlock()
+
+	released := 0
+	for _, key := range keys {
+		if _, ok := c.adapter.Get(key); ok {
+			released++
+		}
+		c.adapter.Release(key)
+		atomic.AddInt64(&c.releases, 1)
+	}
+	return released
+}
+
+// Release frees the cached responses of the given URL, whatever the
+// request headers or body they vary on. It returns how many were
+// released. The responses varying on request headers or body are found
+// in an index of the responses stored by this instance only, so the ones
+// stored by other instances sharing the adapter are not released.
+func (c *Client) Release(URL string) int {
+	u, err := url.Parse(URL)
+	if err != nil {
+		return 0
+	}
+	c.canonicalizeURL(u)
+	target := u.String()
+
+	key := c.urlKey(u)
+	released := c.releaseWhere(func(u *url.URL) bool {
+		return u.String() == target
+	})
+	if _, ok := c.adapter.Get(key); ok {
+		c.adapter.Release(key)
+		atomic.AddInt64(&c.releases, 1)
+		released++
+	}
+	return released
+}
+
+// Invalidate frees the cached response of the given request, its key
+// computed the same way as by the middleware, e.g. to bust the cached GET
+// of a record once it is updated. The responses of a request varying on
+// its headers are freed for every header value. The keys set by cache
+// plans are not known outside of the middleware, use InvalidateByKey for
+// them.
+func (c *Client) Invalidate(r *http.Request) error {
+	keyed := r.Clone(r.Context())
+	c.canonicalizeURL(keyed.URL)
+	method := c.effectiveMethod(keyed)
+	var body []byte
+	if method == http.MethodPost && r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		keyed.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	key := c.requestKey(keyed, c.keyHeaders(keyed, method, nil), body)
+
+	if b, ok := c.getCtx(r.Context(), key); ok {
+		if names := BytesToResponse(b).Vary; len(names) > 0 {
+			c.releaseKey(r.Context(), c.varyKey(keyed, key, names))
+		}
+	}
+	c.releaseKey(r.Context(), key)
+	return nil
+}
+
+// InvalidateByKey frees the cached response of the given cache key, e.g.
+// a key set by a cache plan.
+func (c *Client) InvalidateByKey(key uint64) {
+	c.releaseKey(context.Background(), key)
+}
+
+// ReleaseMatching frees the cached responses whose URL path matches the
+// given path.Match pattern, e.g. /products/*. It returns how many were
+// released. The responses are found in an index of the responses stored
+// by this instance only, so the ones stored by other instances sharing
+// the adapter are not released.
+func (c *Client) ReleaseMatching(pattern string) (int, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return 0, err
+	}
+	return c.releaseWhere(func(u *url.URL) bool {
+		ok, _ := path.Match(pattern, u.Path)
+		return ok
+	}), nil
+}
+
+// Purge frees the entire cache.
+func (c *Client) Purge() error {
+	c.indexMutex.Lock()
+	c.index = nil
+	c.indexMutex.Unlock()
+	return c.adapter.Purge()
+}
+
+// PurgeHandler returns an echo handler invalidating cached responses, for
+// the requests satisfying the authorized predicate. It releases the
+// responses of the url parameter, the ones whose path matches the
+// pattern parameter, or every response if the all parameter is true, and
+// responds with how many were released as JSON. The url and pattern
+// parameters only release the responses this instance knows about, see
+// Release and ReleaseMatching.
+func (c *Client) PurgeHandler(authorized func(ctx echo.Context) bool) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		if authorized == nil || !authorized(ctx) {
+			return echo.NewHTTPError(http.StatusForbidden)
+		}
+
+		released := 0
+		switch {
+		case ctx.FormValue("all") == "true":
+			if err := c.Purge(); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+			}
+			return ctx.JSON(http.StatusOK, map[string]interface{}{"purged": true})
+		case ctx.FormValue("url") != "":
+			released = c.Release(ctx.FormValue("url"))
+		case ctx.FormValue("pattern") != "":
+			n, err := c.ReleaseMatching(ctx.FormValue("pattern"))
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			released = n
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, "url, pattern or all parameter is required")
+		}
+
+		return ctx.JSON(http.StatusOK, map[string]interface{}{"released": released})
+	}
+}
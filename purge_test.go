@@ -0,0 +1,326 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestPurgeHandler(t *testing.T) {
+	urls := []string{
+		"http://foo.bar/products/1",
+		"http://foo.bar/products/2?b=2&a=1",
+		"http://foo.bar/users/1",
+	}
+
+	tests := []struct {
+		name         string
+		query        string
+		authorized   bool
+		wantCode     int
+		wantReleased int
+		wantCached   []string
+	}{
+		{
+			"purges a single url",
+			"url=http://foo.bar/products/2?a=1%26b=2",
+			true,
+			http.StatusOK,
+			1,
+			[]string{"http://foo.bar/products/1", "http://foo.bar/users/1"},
+		},
+		{
+			"purges a pattern",
+			"pattern=/products/*",
+			true,
+			http.StatusOK,
+			2,
+			[]string{"http://foo.bar/users/1"},
+		},
+		{
+			"purges everything",
+			"all=true",
+			true,
+			http.StatusOK,
+			0,
+			[]string{},
+		},
+		{
+			"rejects unauthorized request",
+			"all=true",
+			false,
+			http.StatusForbidden,
+			0,
+			urls,
+		},
+		{
+			"rejects invalid pattern",
+			"pattern=[",
+			true,
+			http.StatusBadRequest,
+			0,
+			urls,
+		},
+		{
+			"rejects missing target",
+			"",
+			true,
+			http.StatusBadRequest,
+			0,
+			urls,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := &adapterMock{store: map[uint64][]byte{}}
+			client, _ := NewClient(
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1*time.Minute),
+			)
+			handler := func(c echo.Context) error {
+				return c.String(http.StatusOK, "value")
+			}
+			mw := client.Middleware()(handler)
+			e := echo.New()
+			for _, u := range urls {
+				mw(e.NewContext(httptest.NewRequest(http.MethodGet, u, nil), httptest.NewRecorder()))
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "http://foo.bar/cache/purge?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			err := client.PurgeHandler(func(c echo.Context) bool {
+				return tt.authorized
+			})(e.NewContext(r, w))
+
+			code := w.Code
+			if he, ok := err.(*echo.HTTPError); ok {
+				code = he.Code
+			}
+			if code != tt.wantCode {
+				t.Fatalf("PurgeHandler() code = %v, want %v", code, tt.wantCode)
+			}
+			if code == http.StatusOK && tt.wantReleased > 0 {
+				got := map[string]int{}
+				json.Unmarshal(w.Body.Bytes(), &got)
+				if got["released"] != tt.wantReleased {
+					t.Errorf("PurgeHandler() released = %v, want %v", got["released"], tt.wantReleased)
+				}
+			}
+
+			cached := []string{}
+			for _, u := range urls {
+				w := httptest.NewRecorder()
+				mw(e.NewContext(httptest.NewRequest(http.MethodGet, u, nil), w))
+				if w.Header().Get("X-Cache") == "HIT" {
+					cached = append(cached, u)
+				}
+			}
+			sort.Strings(cached)
+			if len(cached) != len(tt.wantCached) {
+				t.Fatalf("cached urls = %v, want %v", cached, tt.wantCached)
+			}
+			for i := range cached {
+				if cached[i] != tt.wantCached[i] {
+					t.Errorf("cached urls = %v, want %v", cached, tt.wantCached)
+				}
+			}
+		})
+	}
+}
+
+type purgeErrorAdapterMock struct {
+	adapterMock
+}
+
+func (a *purgeErrorAdapterMock) Purge() error {
+	return errors.New("purge error")
+}
+
+func TestPurgeHandlerError(t *testing.T) {
+	client, _ := NewClient(
+		ClientWithAdapter(&purgeErrorAdapterMock{adapterMock{store: map[uint64][]byte{}}}),
+		ClientWithTTL(1*time.Minute),
+	)
+	handler := client.PurgeHandler(func(ctx echo.Context) bool { return true })
+
+	r := httptest.NewRequest(http.MethodPost, "http://foo.bar/purge?all=true", nil)
+	err := handler(echo.New().NewContext(r, httptest.NewRecorder()))
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != http.StatusInternalServerError {
+		t.Errorf("PurgeHandler() error = %v, want a %v HTTP error", err, http.StatusInternalServerError)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	get := func(tenant, language string) func() *http.Request {
+		return func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/records/1?b=2&a=1", nil)
+			r.Header.Set("X-Tenant", tenant)
+			r.Header.Set("Accept-Language", language)
+			return r
+		}
+	}
+	post := func(body string) func() *http.Request {
+		return func() *http.Request {
+			return httptest.NewRequest(http.MethodPost, "http://foo.bar/search", strings.NewReader(body))
+		}
+	}
+
+	tests := []struct {
+		name    string
+		opts    []ClientOption
+		vary    string
+		request func() *http.Request
+		other   func() *http.Request
+	}{
+		{
+			"invalidates a GET request",
+			nil,
+			"",
+			get("", ""),
+			nil,
+		},
+		{
+			"invalidates the request of a header value only",
+			[]ClientOption{ClientWithHeaders([]string{"X-Tenant"})},
+			"",
+			get("a", ""),
+			get("b", ""),
+		},
+		{
+			"invalidates a request varying on its headers",
+			[]ClientOption{ClientWithVary(true)},
+			"Accept-Language",
+			get("", "fr"),
+			nil,
+		},
+		{
+			"invalidates the request of a POST body only",
+			[]ClientOption{ClientWithMethods([]string{http.MethodGet, http.MethodPost})},
+			"",
+			post("q=1"),
+			post("q=2"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient(append([]ClientOption{
+				ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+				ClientWithTTL(1 * time.Minute),
+			}, tt.opts...)...)
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			calls := 0
+			handler := client.Middleware()(func(c echo.Context) error {
+				calls++
+				if tt.vary != "" {
+					c.Response().Header().Set("Vary", tt.vary)
+				}
+				return c.String(http.StatusOK, "value")
+			})
+			serve := func(r *http.Request) {
+				handler(echo.New().NewContext(r, httptest.NewRecorder()))
+			}
+
+			serve(tt.request())
+			if tt.other != nil {
+				serve(tt.other())
+			}
+			want := calls
+
+			if err := client.Invalidate(tt.request()); err != nil {
+				t.Fatalf("*Client.Invalidate() error = %v", err)
+			}
+			serve(tt.request())
+			if calls != want+1 {
+				t.Errorf("*Client.Invalidate() handler calls = %v, want %v", calls, want+1)
+			}
+			if tt.other != nil {
+				serve(tt.other())
+				if calls != want+1 {
+					t.Errorf("*Client.Invalidate() released another request, handler calls = %v, want %v", calls, want+1)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidateByKey(t *testing.T) {
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	client, _ := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+	)
+	handler := client.Middleware()(func(c echo.Context) error {
+		return c.String(http.StatusOK, "value")
+	})
+	r := httptest.NewRequest(http.MethodGet, "http://foo.bar/records/1", nil)
+	handler(echo.New().NewContext(r, httptest.NewRecorder()))
+
+	key := generateKey("http://foo.bar/records/1", []string{})
+	if _, ok := adapter.Get(key); !ok {
+		t.Fatal("*Client.Middleware() did not cache the response")
+	}
+	client.InvalidateByKey(key)
+	if _, ok := adapter.Get(key); ok {
+		t.Error("*Client.InvalidateByKey() did not release the response")
+	}
+}
+
+func TestIndexPruning(t *testing.T) {
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	client, _ := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+	)
+	handler := client.Middleware()(func(c echo.Context) error {
+		return c.String(http.StatusOK, "value")
+	})
+	get := func(URL string) {
+		r := httptest.NewRequest(http.MethodGet, URL, nil)
+		handler(echo.New().NewContext(r, httptest.NewRecorder()))
+	}
+	indexed := func() int {
+		client.indexMutex.Lock()
+		defer client.indexMutex.Unlock()
+		return len(client.index)
+	}
+
+	get("http://foo.bar/test-1")
+	get("http://foo.bar/test-2")
+	if got := indexed(); got != 2 {
+		t.Fatalf("indexed keys = %v, want 2", got)
+	}
+	client.Invalidate(httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil))
+	if got := indexed(); got != 1 {
+		t.Errorf("indexed keys after Invalidate() = %v, want 1", got)
+	}
+
+	// The adapter evicted the response behind the client's back.
+	adapter.Purge()
+	r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-2", nil)
+	client.Middleware()(func(c echo.Context) error {
+		if got := indexed(); got != 0 {
+			t.Errorf("indexed keys after a miss = %v, want 0", got)
+		}
+		return nil
+	})(echo.New().NewContext(r, httptest.NewRecorder()))
+
+	client.indexMutex.Lock()
+	for key := uint64(1); key <= maxIndexKeys; key++ {
+		client.index[key] = indexEntry{URL: "http://foo.bar/old", expiration: time.Now().Add(-time.Second)}
+	}
+	client.index[0] = indexEntry{URL: "http://foo.bar/live", expiration: time.Now().Add(time.Minute)}
+	client.indexMutex.Unlock()
+	client.indexKey(maxIndexKeys+1, "http://foo.bar/new", time.Now().Add(time.Minute))
+	if got := indexed(); got != 2 {
+		t.Errorf("indexed keys once full = %v, want the 2 live ones", got)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// ReadOnlyAdapter wraps an Adapter and, while in read-only mode, ignores
+// every write to it. Cached responses are still served.
+type ReadOnlyAdapter struct {
+	adapter  Adapter
+	readOnly int32
+}
+
+// ReadOnly wraps the given adapter in read-only mode. Writes can be
+// resumed at runtime with SetReadOnly(false).
+func ReadOnly(a Adapter) *ReadOnlyAdapter {
+	return &ReadOnlyAdapter{
+		adapter:  a,
+		readOnly: 1,
+	}
+}
+
+// SetReadOnly turns the read-only mode on or off. It is safe to call
+// concurrently, e.g. from an admin endpoint.
+func (a *ReadOnlyAdapter) SetReadOnly(readOnly bool) {
+	var v int32
+	if readOnly {
+		v = 1
+	}
+	atomic.StoreInt32(&a.readOnly, v)
+}
+
+// IsReadOnly reports whether the read-only mode is on.
+func (a *ReadOnlyAdapter) IsReadOnly() bool {
+	return atomic.LoadInt32(&a.readOnly) == 1
+}
+
+// Get implements the Adapter interface Get method.
+func (a *ReadOnlyAdapter) Get(key uint64) ([]byte, bool) {
+	return a.adapter.Get(key)
+}
+
+// Set implements the Adapter interface Set method. It is a no-op in
+// read-only mode.
+func (a *ReadOnlyAdapter) Set(key uint64, response []byte, expiration time.Time) {
+	if a.IsReadOnly() {
+		return
+	}
+	a.adapter.Set(key, response, expiration)
+}
+
+// SetWithTags implements the TaggedAdapter interface SetWithTags method. It
+// is a no-op in read-only mode.
+func (a *ReadOnlyAdapter) SetWithTags(ctx context.Context, key uint64, response []byte, expiration time.Time, tags []string, cost int) {
+	if a.IsReadOnly() {
+		return
+	}
+	adapterSetWithTags(a.adapter, ctx, key, response, expiration, tags, cost)
+}
+
+// Release implements the Adapter interface Release method. It is a no-op
+// in read-only mode.
+func (a *ReadOnlyAdapter) Release(key uint64) {
+	if a.IsReadOnly() {
+		return
+	}
+	a.adapter.Release(key)
+}
+
+// Purge implements the Adapter interface Purge method. It is a no-op in
+// read-only mode.
+func (a *ReadOnlyAdapter) Purge() error {
+	if a.IsReadOnly() {
+		return nil
+	}
+	return a.adapter.Purge()
+}
@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadOnlyAdapter(t *testing.T) {
+	mock := &adapterMock{
+		store: map[uint64][]byte{
+			1: []byte("value 1"),
+			2: []byte("value 2"),
+		},
+	}
+	a := ReadOnly(mock)
+	expiration := time.Now().Add(1 * time.Minute)
+
+	if !a.IsReadOnly() {
+		t.Fatal("ReadOnly() should start in read-only mode")
+	}
+
+	if b, ok := a.Get(1); !ok || string(b) != "value 1" {
+		t.Errorf("Get() = %s, %v, want value 1, true", b, ok)
+	}
+
+	a.Set(3, []byte("value 3"), expiration)
+	if _, ok := mock.store[3]; ok {
+		t.Error("Set() should be ignored in read-only mode")
+	}
+	a.Release(1)
+	if _, ok := mock.store[1]; !ok {
+		t.Error("Release() should be ignored in read-only mode")
+	}
+	a.Purge()
+	if len(mock.store) != 2 {
+		t.Errorf("Purge() should be ignored in read-only mode, store length = %v", len(mock.store))
+	}
+
+	a.SetReadOnly(false)
+	if a.IsReadOnly() {
+		t.Fatal("SetReadOnly(false) should turn read-only mode off")
+	}
+
+	a.Set(3, []byte("value 3"), expiration)
+	if b, ok := a.Get(3); !ok || string(b) != "value 3" {
+		t.Errorf("Set() should resume writes, Get() = %s, %v", b, ok)
+	}
+	a.Release(1)
+	if _, ok := a.Get(1); ok {
+		t.Error("Release() should resume releasing")
+	}
+	a.Purge()
+	if len(mock.store) != 0 {
+		t.Errorf("Purge() should resume purging, store length = %v", len(mock.store))
+	}
+}
@@ -0,0 +1,146 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// bufferedResponseWriter holds the handler response instead of writing it
+// to the client.
+type bufferedResponseWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) WriteHeader(code int) {
+	w.statusCode = code
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+// hasValidators reports whether the cached response can be revalidated.
+func hasValidators(r Response) bool {
+	return r.Header.Get("ETag") != "" || r.Header.Get("Last-Modified") != ""
+}
+
+// bufferHandler calls the handler, holding its response. The echo
+// response is reset so it can still be written afterwards.
+func bufferHandler(c echo.Context, next echo.HandlerFunc) (*bufferedResponseWriter, error) {
+	res := c.Response()
+	writer := res.Writer
+	buf := &bufferedResponseWriter{header: http.Header{}}
+	res.Writer = buf
+
+	err := next(c)
+
+	res.Writer = writer
+	res.Committed = false
+	res.Status = http.StatusOK
+	res.Size = 0
+
+	return buf, err
+}
+
+// revalidate forwards the validators of the expired cached response to
+// the handler. A 304 Not Modified refreshes the cached response, an error
+// serves the stale cached response, any other response replaces it. The
+// validators are only sent to the handler, on a copy of the request, so
+// the cached response is not answered as not modified to a client that
+// did not ask for it.
+func (c *Client) revalidate(ctx echo.Context, next echo.HandlerFunc, key uint64, response Response) error {
+	if !c.acquireBuffer() {
+		decide(ctx, key, DecisionBypass, "too many concurrent buffers")
+		missBecause(ctx, MissOverloaded)
+		return next(ctx)
+	}
+	defer c.releaseBuffer()
+
+	req := ctx.Request()
+	conditional := req.Clone(req.Context())
+	if etag := response.Header.Get("ETag"); etag != "" && req.Header.Get("If-None-Match") == "" {
+		conditional.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := response.Header.Get("Last-Modified"); lastModified != "" && req.Header.Get("If-Modified-Since") == "" {
+		conditional.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	start := time.Now()
+	ctx.SetRequest(conditional)
+	buf, err := bufferHandler(ctx, next)
+	ctx.SetRequest(req)
+	c.measureCost(ctx, start)
+	if err != nil || buf.statusCode >= http.StatusInternalServerError {
+		c.hit(ctx, key, "stale after revalidation error")
+		return c.writeResponse(ctx, key, response, true)
+	}
+
+	if buf.statusCode == http.StatusNotModified && c.invalidated(ctx) {
+		decide(ctx, key, DecisionSkipped, "invalidated by handler")
+		defer c.releaseKey(ctx.Request().Context(), key)
+		return c.writeResponse(ctx, key, response, false)
+	}
+	if buf.statusCode == http.StatusNotModified {
+		now := time.Now()
+		response.Expiration = now.Add(c.withMinTTL(c.entryTTL(c.pathTTL(ctx.Path(), ctx.Request().URL.Path), &response, response.Value)))
+		response.LastAccess = now
+		response.Frequency++
+		c.refreshStream(key, response)
+		c.setCtx(ctx.Request().Context(), key, response, response.Expiration)
+
+		c.hit(ctx, key, "revalidated")
+		return c.writeResponse(ctx, key, response, false)
+	}
+
+	c.miss(ctx)
+	previous := response
+	c.releaseCtx(ctx.Request().Context(), key)
+	header := ctx.Response().Header()
+	for k, v := range buf.header {
+		header[k] = v
+	}
+	statusCode := buf.statusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	ctx.Response().WriteHeader(statusCode)
+	if _, err := ctx.Response().Write(buf.body.Bytes()); err != nil {
+		return err
+	}
+
+	c.storeResponse(ctx, key, statusCode, buf.header, buf.body.Bytes(), &previous)
+	return nil
+}
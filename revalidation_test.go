@@ -0,0 +1,491 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddlewareRevalidation(t *testing.T) {
+	etagHeader := func(etag string) http.Header {
+		h := http.Header{}
+		h.Set("ETag", etag)
+		return h
+	}
+	adapter := &adapterMock{
+		store: map[uint64][]byte{
+			14974843192121052621: Response{
+				Value:      []byte("value 1"),
+				Header:     etagHeader(`"v1"`),
+				Expiration: time.Now().Add(-1 * time.Minute),
+			}.Bytes(),
+			14974839893586167988: Response{
+				Value:      []byte("value 2"),
+				Header:     etagHeader(`"v0"`),
+				Expiration: time.Now().Add(-1 * time.Minute),
+			}.Bytes(),
+		},
+	}
+	client, _ := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithRevalidation(true),
+	)
+	handler := func(c echo.Context) error {
+		if c.Request().Header.Get("If-None-Match") == `"v1"` {
+			return c.NoContent(http.StatusNotModified)
+		}
+		c.Response().Header().Set("ETag", `"v1"`)
+		return c.String(http.StatusOK, "new value")
+	}
+	mw := client.Middleware()(handler)
+	e := echo.New()
+
+	tests := []struct {
+		name      string
+		url       string
+		key       uint64
+		wantBody  string
+		wantCache string
+	}{
+		{
+			"refreshes not modified response",
+			"http://foo.bar/test-1",
+			14974843192121052621,
+			"value 1",
+			"HIT",
+		},
+		{
+			"replaces modified response",
+			"http://foo.bar/test-2",
+			14974839893586167988,
+			"new value",
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			w := httptest.NewRecorder()
+			mw(e.NewContext(r, w))
+
+			if w.Code != http.StatusOK {
+				t.Errorf("*Client.Middleware() code = %v, want 200", w.Code)
+			}
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("*Client.Middleware() = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+			if got := w.Header().Get("X-Cache"); got != tt.wantCache {
+				t.Errorf("*Client.Middleware() X-Cache = %v, want %v", got, tt.wantCache)
+			}
+
+			response := BytesToResponse(adapter.store[tt.key])
+			if !response.Expiration.After(time.Now()) {
+				t.Errorf("cached response expiration = %v, want refreshed", response.Expiration)
+			}
+			if string(response.Value) != tt.wantBody {
+				t.Errorf("cached response = %s, want %v", response.Value, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestMiddlewareRevalidationETag(t *testing.T) {
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		wantCode    int
+		wantBody    string
+	}{
+		{
+			"serves the cached body to a plain request",
+			"",
+			http.StatusOK,
+			"value 1",
+		},
+		{
+			"answers not modified to a conditional request",
+			`"v1"`,
+			http.StatusNotModified,
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set("ETag", `"v1"`)
+			adapter := &adapterMock{
+				store: map[uint64][]byte{
+					generateKey("http://foo.bar/test-1", []string{}): Response{
+						Value:      []byte("value 1"),
+						Header:     header,
+						ETag:       `"v1"`,
+						Expiration: time.Now().Add(-1 * time.Minute),
+					}.Bytes(),
+				},
+			}
+			client, _ := NewClient(
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1*time.Minute),
+				ClientWithRevalidation(true),
+				ClientWithETag(true),
+			)
+			handler := func(c echo.Context) error {
+				if c.Request().Header.Get("If-None-Match") == `"v1"` {
+					return c.NoContent(http.StatusNotModified)
+				}
+				c.Response().Header().Set("ETag", `"v1"`)
+				return c.String(http.StatusOK, "value 1")
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+			client.Middleware()(handler)(echo.New().NewContext(r, w))
+
+			if w.Code != tt.wantCode {
+				t.Errorf("*Client.Middleware() code = %v, want %v", w.Code, tt.wantCode)
+			}
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("*Client.Middleware() = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+			if got := r.Header.Get("If-None-Match"); got != tt.ifNoneMatch {
+				t.Errorf("request If-None-Match = %v, want %v", got, tt.ifNoneMatch)
+			}
+		})
+	}
+}
+
+func TestMiddlewareStaleTransform(t *testing.T) {
+	header := http.Header{}
+	header.Set("ETag", `"v1"`)
+	noTransformHeader := header.Clone()
+	noTransformHeader.Set("Cache-Control", "no-transform")
+	client, _ := NewClient(
+		ClientWithAdapter(&adapterMock{
+			store: map[uint64][]byte{
+				14974843192121052621: Response{
+					Value:      []byte("value 1"),
+					Header:     header,
+					Expiration: time.Now().Add(1 * time.Minute),
+				}.Bytes(),
+				14974839893586167988: Response{
+					Value:      []byte("value 2"),
+					Header:     header,
+					Expiration: time.Now().Add(-1 * time.Minute),
+				}.Bytes(),
+				generateKey("http://foo.bar/test-3", []string{}): Response{
+					Value:      []byte("value 3"),
+					Header:     noTransformHeader,
+					Expiration: time.Now().Add(-1 * time.Minute),
+				}.Bytes(),
+			},
+		}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithRevalidation(true),
+		ClientWithStaleTransform(func(body []byte) []byte {
+			return append([]byte("[stale] "), body...)
+		}),
+	)
+	handler := func(c echo.Context) error {
+		return c.String(http.StatusServiceUnavailable, "unavailable")
+	}
+	mw := client.Middleware()(handler)
+	e := echo.New()
+
+	tests := []struct {
+		name      string
+		url       string
+		wantBody  string
+		wantCache string
+	}{
+		{
+			"serves fresh response untouched",
+			"http://foo.bar/test-1",
+			"value 1",
+			"HIT",
+		},
+		{
+			"transforms stale response served on error",
+			"http://foo.bar/test-2",
+			"[stale] value 2",
+			"STALE",
+		},
+		{
+			"serves no-transform stale response untouched",
+			"http://foo.bar/test-3",
+			"value 3",
+			"STALE",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			w := httptest.NewRecorder()
+			mw(e.NewContext(r, w))
+
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("*Client.Middleware() = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+			if got := w.Header().Get("X-Cache"); got != tt.wantCache {
+				t.Errorf("*Client.Middleware() X-Cache = %v, want %v", got, tt.wantCache)
+			}
+			if got := w.Header().Get("Content-Length"); got != fmt.Sprint(len(tt.wantBody)) {
+				t.Errorf("*Client.Middleware() Content-Length = %v, want %v", got, len(tt.wantBody))
+			}
+		})
+	}
+}
+
+func TestMiddlewareInvalidateContextKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		contextKey  string
+		opts        []ClientOption
+		revalidate  bool
+		wantStored  bool
+		wantHandler string
+	}{
+		{
+			"handler invalidates with the default key",
+			"cache_invalidate",
+			nil,
+			false,
+			false,
+			"new value",
+		},
+		{
+			"handler invalidates with a custom key",
+			"drop",
+			[]ClientOption{ClientWithInvalidateContextKey("drop")},
+			false,
+			false,
+			"new value",
+		},
+		{
+			"other key does not invalidate",
+			"other",
+			nil,
+			false,
+			true,
+			"new value",
+		},
+		{
+			"handler invalidates while revalidating",
+			"cache_invalidate",
+			[]ClientOption{ClientWithRevalidation(true)},
+			true,
+			false,
+			"value 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := generateKey("http://foo.bar/test", []string{})
+			header := http.Header{}
+			header.Set("ETag", `"v1"`)
+			adapter := &adapterMock{
+				store: map[uint64][]byte{
+					key: Response{
+						Value:      []byte("value 1"),
+						Header:     header,
+						Expiration: time.Now().Add(-1 * time.Minute),
+					}.Bytes(),
+				},
+			}
+			opts := append([]ClientOption{
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1 * time.Minute),
+			}, tt.opts...)
+			client, _ := NewClient(opts...)
+			handler := func(c echo.Context) error {
+				c.Set(tt.contextKey, true)
+				if tt.revalidate {
+					return c.NoContent(http.StatusNotModified)
+				}
+				return c.String(http.StatusOK, "new value")
+			}
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+			w := httptest.NewRecorder()
+			client.Middleware()(handler)(echo.New().NewContext(r, w))
+
+			if w.Body.String() != tt.wantHandler {
+				t.Errorf("*Client.Middleware() = %v, want %v", w.Body.String(), tt.wantHandler)
+			}
+			if _, ok := adapter.store[key]; ok != tt.wantStored {
+				t.Errorf("stored = %v, want %v", ok, tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestMiddlewareMaxStale(t *testing.T) {
+	header := http.Header{}
+	header.Set("ETag", `"v1"`)
+	mustRevalidateHeader := header.Clone()
+	mustRevalidateHeader.Set("Cache-Control", "must-revalidate")
+
+	tests := []struct {
+		name         string
+		header       http.Header
+		cacheControl string
+		wantBody     string
+		wantCache    string
+		wantWarning  string
+	}{
+		{
+			"serves response stale by 30s under max-stale=60",
+			header,
+			"max-stale=60",
+			"cached",
+			"STALE",
+			`110 - "Response is Stale"`,
+		},
+		{
+			"serves response stale by 30s under max-stale without value",
+			header,
+			"max-stale",
+			"cached",
+			"STALE",
+			`110 - "Response is Stale"`,
+		},
+		{
+			"revalidates response stale by 30s under max-stale=10",
+			header,
+			"max-stale=10",
+			"revalidated",
+			"",
+			"",
+		},
+		{
+			"revalidates response stale by 30s without max-stale",
+			header,
+			"",
+			"revalidated",
+			"",
+			"",
+		},
+		{
+			"revalidates must-revalidate response under max-stale=60",
+			mustRevalidateHeader,
+			"max-stale=60",
+			"revalidated",
+			"",
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := NewClient(
+				ClientWithAdapter(&adapterMock{
+					store: map[uint64][]byte{
+						generateKey("http://foo.bar/test-1", []string{}): Response{
+							Value:      []byte("cached"),
+							Header:     tt.header,
+							Expiration: time.Now().Add(-30 * time.Second),
+						}.Bytes(),
+					},
+				}),
+				ClientWithTTL(1*time.Minute),
+				ClientWithRevalidation(true),
+			)
+			handler := func(c echo.Context) error {
+				return c.String(http.StatusOK, "revalidated")
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+			if tt.cacheControl != "" {
+				r.Header.Set("Cache-Control", tt.cacheControl)
+			}
+			w := httptest.NewRecorder()
+			client.Middleware()(handler)(echo.New().NewContext(r, w))
+
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("*Client.Middleware() = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+			if got := w.Header().Get("X-Cache"); got != tt.wantCache {
+				t.Errorf("*Client.Middleware() X-Cache = %v, want %v", got, tt.wantCache)
+			}
+			if got := w.Header().Get("Warning"); got != tt.wantWarning {
+				t.Errorf("*Client.Middleware() Warning = %v, want %v", got, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestMiddlewareLoadShedding(t *testing.T) {
+	mustRevalidateHeader := http.Header{}
+	mustRevalidateHeader.Set("Cache-Control", "must-revalidate")
+
+	tests := []struct {
+		name      string
+		inflight  int
+		stale     time.Duration
+		header    http.Header
+		wantBody  string
+		wantCache string
+	}{
+		{"calls handler under load", 1, 30 * time.Second, nil, "fresh", ""},
+		{"serves stale response over load", 2, 30 * time.Second, nil, "cached", "STALE"},
+		{"calls handler for response beyond the allowance", 2, 2 * time.Minute, nil, "fresh", ""},
+		{"calls handler for must-revalidate response", 2, 30 * time.Second, mustRevalidateHeader, "fresh", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := NewClient(
+				ClientWithAdapter(&adapterMock{
+					store: map[uint64][]byte{
+						generateKey("http://foo.bar/test-1", []string{}): Response{
+							Value:      []byte("cached"),
+							Header:     tt.header,
+							Expiration: time.Now().Add(-tt.stale),
+						}.Bytes(),
+					},
+				}),
+				ClientWithTTL(1*time.Minute),
+				ClientWithLoadShedding(2, 1*time.Minute),
+			)
+			started := sync.WaitGroup{}
+			release := make(chan struct{})
+			handler := client.Middleware()(func(c echo.Context) error {
+				if c.Request().URL.Path == "/slow" {
+					started.Done()
+					<-release
+				}
+				return c.String(http.StatusOK, "fresh")
+			})
+
+			done := sync.WaitGroup{}
+			for i := 0; i < tt.inflight; i++ {
+				started.Add(1)
+				done.Add(1)
+				go func() {
+					defer done.Done()
+					r := httptest.NewRequest(http.MethodGet, "http://foo.bar/slow", nil)
+					handler(echo.New().NewContext(r, httptest.NewRecorder()))
+				}()
+			}
+			started.Wait()
+
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+			w := httptest.NewRecorder()
+			handler(echo.New().NewContext(r, w))
+			close(release)
+			done.Wait()
+
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("*Client.Middleware() = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+			if got := w.Header().Get("X-Cache"); got != tt.wantCache {
+				t.Errorf("*Client.Middleware() X-Cache = %v, want %v", got, tt.wantCache)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// flight is the response of the handler shared with the requests coalesced
+// by ClientWithSingleflight.
+type flight struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+	err        error
+	handlerErr error
+	panic      interface{}
+
+	// shared reports whether the response was cached for every request
+	// of the key, so it can be sent to the coalesced requests.
+	shared bool
+
+	// done is closed once the response is recorded.
+	done chan struct{}
+}
+
+// flightGroup holds the in-flight fills of the keys coalesced by
+// ClientWithSingleflight.
+type flightGroup struct {
+	mutex   sync.Mutex
+	flights map[uint64]*flight
+}
+
+// join returns the in-flight fill of the key, or starts one if there is
+// none, reporting whether it did.
+func (g *flightGroup) join(key uint64) (*flight, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if f, ok := g.flights[key]; ok {
+		return f, false
+	}
+	if g.flights == nil {
+		g.flights = make(map[uint64]*flight)
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	return f, true
+}
+
+// land removes the fill of the key and wakes up its waiting requests.
+func (g *flightGroup) land(key uint64, f *flight) {
+	g.mutex.Lock()
+	delete(g.flights, key)
+	g.mutex.Unlock()
+	close(f.done)
+}
+
+// coalesce calls fill for the first of the concurrent requests of the key
+// only. The others wait for it, then are sent its response if it was
+// cached for every request of the key. Otherwise, e.g. if it is private,
+// varies by request headers or is an error, they call fill themselves.
+// The waiting requests give up when their context is done, and once the
+// singleflight timeout elapses, if any, act on the singleflight policy.
+func (c *Client) coalesce(ctx echo.Context, next echo.HandlerFunc, key uint64, fill func(next echo.HandlerFunc) error) error {
+	f, leader := c.group.join(key)
+	if leader {
+		c.lead(ctx, next, fill, f)
+		c.group.land(key, f)
+		if f.panic != nil {
+			panic(f.panic)
+		}
+		return f.err
+	}
+
+	var timeout <-chan time.Time
+	if c.singleflightTimeout > 0 {
+		timer := time.NewTimer(c.singleflightTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-f.done:
+	case <-timeout:
+		if c.singleflightPolicy == SingleflightUnavailable {
+			decide(ctx, key, DecisionBypass, "singleflight timeout")
+			missBecause(ctx, MissOverloaded)
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "cache fill in progress")
+		}
+		return fill(next)
+	case <-ctx.Request().Context().Done():
+		return ctx.Request().Context().Err()
+	}
+	if !f.shared {
+		return fill(next)
+	}
+
+	c.miss(ctx)
+	decide(ctx, key, DecisionMiss, "coalesced with in-flight request")
+	header := ctx.Response().Header()
+	shared, trailer := splitTrailers(f.header)
+	for k, v := range shared {
+		if k != "X-Cache" {
+			header[k] = append([]string{}, v...)
+		}
+	}
+	ctx.Response().WriteHeader(f.statusCode)
+	if _, err := ctx.Response().Write(f.body); err != nil {
+		return err
+	}
+	writeTrailers(header, trailer)
+	return nil
+}
+
+// lead calls fill, recording in f the response written, the error returned
+// by the handler and whether the response was cached for every request of
+// the key. A panic is recovered so the waiting requests are not left
+// blocked, and raised again by the caller.
+func (c *Client) lead(ctx echo.Context, next echo.HandlerFunc, fill func(next echo.HandlerFunc) error, f *flight) {
+	defer func() {
+		if r := recover(); r != nil {
+			f.panic = r
+			f.handlerErr = fmt.Errorf("cache client handler panic: %v", r)
+			f.shared = false
+		}
+	}()
+
+	body := new(bytes.Buffer)
+	writer := &bodyDumpResponseWriter{Writer: io.MultiWriter(ctx.Response().Writer, body), ResponseWriter: ctx.Response().Writer}
+	ctx.Response().Writer = writer
+	f.err = fill(func(ctx echo.Context) error {
+		f.handlerErr = next(ctx)
+		return f.handlerErr
+	})
+
+	f.statusCode = writer.statusCode
+	if f.statusCode == 0 {
+		f.statusCode = http.StatusOK
+	}
+	f.header = ctx.Response().Header().Clone()
+	f.body = body.Bytes()
+	_, varies := ctx.Get(varyContextKey).([]string)
+	f.shared = f.err == nil && f.handlerErr == nil && !varies && ctx.Get(decisionContextKey) == DecisionStored
+}
@@ -0,0 +1,237 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddlewareSingleflight(t *testing.T) {
+	const requests = 3
+
+	tests := []struct {
+		name         string
+		singleflight bool
+		cacheControl string
+		err          error
+		wantCode     int
+		wantBody     string
+		wantCalls    int32
+	}{
+		{
+			"coalesces the requests of a key",
+			true,
+			"",
+			nil,
+			http.StatusOK,
+			"value",
+			1,
+		},
+		{
+			"calls the handler for the waiting requests on error",
+			true,
+			"",
+			echo.NewHTTPError(http.StatusGatewayTimeout, "upstream timeout"),
+			http.StatusGatewayTimeout,
+			"{\"message\":\"upstream timeout\"}\n",
+			requests,
+		},
+		{
+			"does not share private responses",
+			true,
+			"private",
+			nil,
+			http.StatusOK,
+			"secret for %v",
+			requests,
+		},
+		{
+			"does not share no-store responses",
+			true,
+			"no-store",
+			nil,
+			http.StatusOK,
+			"secret for %v",
+			requests,
+		},
+		{
+			"calls the handler for every request when disabled",
+			false,
+			"",
+			nil,
+			http.StatusOK,
+			"value",
+			requests,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := NewClient(
+				ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+				ClientWithTTL(1*time.Minute),
+				ClientWithSingleflight(tt.singleflight),
+				ClientWithCacheControl(true),
+			)
+			release := make(chan struct{})
+			var calls int32
+			handler := func(c echo.Context) error {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				if tt.err != nil {
+					return tt.err
+				}
+				if tt.cacheControl != "" {
+					c.Response().Header().Set("Cache-Control", tt.cacheControl)
+					return c.String(http.StatusOK, "secret for "+c.Request().Header.Get("X-User"))
+				}
+				return c.String(http.StatusOK, "value")
+			}
+
+			var wg sync.WaitGroup
+			var started int32
+			recorders := make([]*httptest.ResponseRecorder, requests)
+			for i := range recorders {
+				recorders[i] = httptest.NewRecorder()
+				wg.Add(1)
+				go func(w *httptest.ResponseRecorder, user int) {
+					defer wg.Done()
+					atomic.AddInt32(&started, 1)
+					r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+					r.Header.Set("X-User", strconv.Itoa(user))
+					client.Middleware()(handler)(echo.New().NewContext(r, w))
+				}(recorders[i], i)
+			}
+			deadline := time.Now().Add(time.Second)
+			for atomic.LoadInt32(&started) < requests {
+				if time.Now().After(deadline) {
+					t.Fatal("*Client.Middleware() requests did not start")
+				}
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(10 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("*Client.Middleware() handler calls = %v, want %v", got, tt.wantCalls)
+			}
+			for i, w := range recorders {
+				wantBody := tt.wantBody
+				if strings.Contains(wantBody, "%v") {
+					wantBody = fmt.Sprintf(wantBody, i)
+				}
+				if w.Code != tt.wantCode || w.Body.String() != wantBody {
+					t.Errorf("*Client.Middleware() = %v %q, want %v %q", w.Code, w.Body.String(), tt.wantCode, wantBody)
+				}
+			}
+		})
+	}
+}
+
+func TestMiddlewareSingleflightCancelled(t *testing.T) {
+	client, _ := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithSingleflight(true),
+	)
+	release := make(chan struct{})
+	defer close(release)
+	handler := func(c echo.Context) error {
+		<-release
+		return c.String(http.StatusOK, "value")
+	}
+	go func() {
+		r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+		client.Middleware()(handler)(echo.New().NewContext(r, httptest.NewRecorder()))
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil).WithContext(ctx)
+	err := client.Middleware()(handler)(echo.New().NewContext(r, httptest.NewRecorder()))
+	if err != context.DeadlineExceeded {
+		t.Errorf("*Client.Middleware() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestMiddlewareSingleflightTimeoutInProcess(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    SingleflightPolicy
+		wantCalls int32
+		wantCode  int
+	}{
+		{
+			"proceeds to the handler once the slow request times out",
+			SingleflightProceed,
+			2,
+			http.StatusOK,
+		},
+		{
+			"responds 503 once the slow request times out",
+			SingleflightUnavailable,
+			1,
+			http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient(
+				ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+				ClientWithTTL(1*time.Minute),
+				ClientWithSingleflight(true),
+				ClientWithSingleflightTimeout(20*time.Millisecond),
+				ClientWithSingleflightTimeoutPolicy(tt.policy),
+			)
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			var calls int32
+			release := make(chan struct{})
+			defer close(release)
+			slow := func(c echo.Context) error {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return c.String(http.StatusOK, "slow")
+			}
+			handler := func(c echo.Context) error {
+				atomic.AddInt32(&calls, 1)
+				return c.String(http.StatusOK, "value")
+			}
+			go func() {
+				r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+				client.Middleware()(slow)(echo.New().NewContext(r, httptest.NewRecorder()))
+			}()
+			time.Sleep(10 * time.Millisecond)
+
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+			w := httptest.NewRecorder()
+			start := time.Now()
+			err = client.Middleware()(handler)(echo.New().NewContext(r, w))
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("waited %v, want the singleflight timeout", elapsed)
+			}
+
+			code := w.Code
+			if he, ok := err.(*echo.HTTPError); ok {
+				code = he.Code
+			}
+			if code != tt.wantCode {
+				t.Errorf("*Client.Middleware() code = %v, want %v", code, tt.wantCode)
+			}
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("handler calls = %v, want %v", got, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// servesStaleWhileRevalidate reports whether the expired cached response
+// is stale within the stale-while-revalidate window, if set.
+func (c *Client) servesStaleWhileRevalidate(response Response) bool {
+	return c.staleWhileRevalidate > 0 && c.servableStale(response, c.staleWhileRevalidate)
+}
+
+// revalidateAsync calls the handler in the background with a copy of the
+// request, detached from it, and caches its response in place of the
+// stale one. Only one refresh per key runs at a time.
+func (c *Client) revalidateAsync(ctx echo.Context, next echo.HandlerFunc, key uint64, previous Response) {
+	base, _ := ctx.Get(cacheKeyContextKey).(uint64)
+	fc, buf := c.detach(ctx, base)
+	c.refreshes.DoChan(strconv.FormatUint(key, 10), func() (interface{}, error) {
+		c.fillDetached(fc, buf, next, key, &previous)
+		return nil, nil
+	})
+}
+
+// storedUntil returns when the adapter may drop a response expiring at the
+// given date, kept through the stale-while-revalidate window.
+func (c *Client) storedUntil(expiration time.Time) time.Time {
+	return expiration.Add(c.staleWhileRevalidate)
+}
@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddlewareStaleWhileRevalidate(t *testing.T) {
+	mustRevalidateHeader := http.Header{}
+	mustRevalidateHeader.Set("Cache-Control", "must-revalidate")
+	key := generateKey("http://foo.bar/test-1", []string{})
+
+	tests := []struct {
+		name       string
+		expiration time.Duration
+		header     http.Header
+		wantBody   string
+		wantCache  string
+	}{
+		{"serves fresh response", 1 * time.Minute, nil, "cached", "HIT"},
+		{"serves stale response within the window", -30 * time.Second, nil, "cached", "STALE"},
+		{"calls handler beyond the window", -2 * time.Minute, nil, "fresh", ""},
+		{"calls handler for must-revalidate response", -30 * time.Second, mustRevalidateHeader, "fresh", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := &adapterMock{
+				store: map[uint64][]byte{
+					key: Response{
+						Value:      []byte("cached"),
+						Header:     tt.header,
+						Expiration: time.Now().Add(tt.expiration),
+					}.Bytes(),
+				},
+			}
+			client, _ := NewClient(
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1*time.Minute),
+				ClientWithStaleWhileRevalidate(1*time.Minute),
+			)
+			var calls int32
+			handler := client.Middleware()(func(c echo.Context) error {
+				atomic.AddInt32(&calls, 1)
+				return c.String(http.StatusOK, "fresh")
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+			w := httptest.NewRecorder()
+			handler(echo.New().NewContext(r, w))
+
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("*Client.Middleware() body = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+			if got := w.Header().Get("X-Cache"); got != tt.wantCache {
+				t.Errorf("*Client.Middleware() X-Cache = %v, want %v", got, tt.wantCache)
+			}
+			if tt.wantCache != "STALE" {
+				return
+			}
+			deadline := time.Now().Add(time.Second)
+			for {
+				if b, ok := adapter.Get(key); ok && string(BytesToResponse(b).Value) == "fresh" {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("*Client.Middleware() did not refresh the stale response in the background")
+				}
+				time.Sleep(time.Millisecond)
+			}
+			if got := atomic.LoadInt32(&calls); got != 1 {
+				t.Errorf("*Client.Middleware() handler calls = %v, want 1", got)
+			}
+		})
+	}
+}
+
+func TestMiddlewareStaleWhileRevalidateOnce(t *testing.T) {
+	key := generateKey("http://foo.bar/test-1", []string{})
+	adapter := &adapterMock{
+		store: map[uint64][]byte{
+			key: Response{
+				Value:      []byte("cached"),
+				Expiration: time.Now().Add(-30 * time.Second),
+			}.Bytes(),
+		},
+	}
+	client, _ := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithStaleWhileRevalidate(1*time.Minute),
+	)
+	release := make(chan struct{})
+	var calls int32
+	handler := client.Middleware()(func(c echo.Context) error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return c.String(http.StatusOK, "fresh")
+	})
+
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+		w := httptest.NewRecorder()
+		handler(echo.New().NewContext(r, w))
+		if w.Body.String() != "cached" {
+			t.Errorf("*Client.Middleware() body = %v during refresh, want cached", w.Body.String())
+		}
+	}
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if b, ok := adapter.Get(key); ok && string(BytesToResponse(b).Value) == "fresh" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("*Client.Middleware() did not refresh the stale response in the background")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("*Client.Middleware() handler calls = %v, want 1", got)
+	}
+}
+
+type expirationAdapterMock struct {
+	adapterMock
+	expirations map[uint64]time.Time
+}
+
+func (a *expirationAdapterMock) Set(key uint64, response []byte, expiration time.Time) {
+	a.adapterMock.Set(key, response, expiration)
+	a.Lock()
+	defer a.Unlock()
+	a.expirations[key] = expiration
+}
+
+func TestStaleWhileRevalidateStorage(t *testing.T) {
+	adapter := &expirationAdapterMock{
+		adapterMock: adapterMock{store: map[uint64][]byte{}},
+		expirations: map[uint64]time.Time{},
+	}
+	client, _ := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithStaleWhileRevalidate(30*time.Second),
+	)
+	handler := client.Middleware()(func(c echo.Context) error {
+		return c.String(http.StatusOK, "fresh")
+	})
+	r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+	handler(echo.New().NewContext(r, httptest.NewRecorder()))
+
+	key := generateKey("http://foo.bar/test-1", []string{})
+	b, ok := adapter.Get(key)
+	if !ok {
+		t.Fatal("*Client.Middleware() did not cache the response")
+	}
+	want := BytesToResponse(b).Expiration.Add(30 * time.Second)
+	if got := adapter.expirations[key]; !got.Equal(want) {
+		t.Errorf("adapter expiration = %v, want %v", got, want)
+	}
+}
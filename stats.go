@@ -0,0 +1,112 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AdapterStats is the statistics data structure reported by adapters.
+type AdapterStats struct {
+	// Entries is the number of cached responses.
+	Entries int
+
+	// Evictions is the number of cached responses evicted to make room.
+	Evictions int64
+
+	// SizeBytes is the size of the cached responses.
+	SizeBytes int64
+}
+
+// StatsAdapter is implemented by the adapters reporting statistics.
+type StatsAdapter interface {
+	// AdapterStats returns the adapter statistics.
+	AdapterStats() AdapterStats
+}
+
+// Stats is the cache statistics data structure returned by the stats
+// handler. Sets counts the responses cached, Releases the responses
+// released by the client, e.g. refreshed or purged. The adapter statistics
+// are omitted if the adapter does not report them. The client counters
+// are atomic and always on.
+type Stats struct {
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	Sets      int64   `json:"sets"`
+	Releases  int64   `json:"releases"`
+	HitRatio  float64 `json:"hit_ratio"`
+	Entries   *int    `json:"entries,omitempty"`
+	Evictions *int64  `json:"evictions,omitempty"`
+	SizeBytes *int64  `json:"size_bytes,omitempty"`
+}
+
+// hit counts a cache hit, and sets it as the caching decision.
+func (c *Client) hit(ctx echo.Context, key uint64, reason string) {
+	atomic.AddInt64(&c.hits, 1)
+	if c.instruments != nil {
+		c.instruments.hits.Add(ctx.Request().Context(), 1)
+	}
+	decide(ctx, key, DecisionHit, reason)
+}
+
+// miss counts a cache miss.
+func (c *Client) miss(ctx echo.Context) {
+	atomic.AddInt64(&c.misses, 1)
+	if c.instruments != nil {
+		c.instruments.misses.Add(ctx.Request().Context(), 1)
+	}
+}
+
+// Stats returns the cache statistics.
+func (c *Client) Stats() Stats {
+	stats := Stats{
+		Hits:     atomic.LoadInt64(&c.hits),
+		Misses:   atomic.LoadInt64(&c.misses),
+		Sets:     atomic.LoadInt64(&c.sets),
+		Releases: atomic.LoadInt64(&c.releases),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+	if c.stats != nil {
+		as := c.stats.AdapterStats()
+		stats.Entries = &as.Entries
+		stats.Evictions = &as.Evictions
+		stats.SizeBytes = &as.SizeBytes
+	}
+
+	return stats
+}
+
+// StatsHandler returns an echo handler responding with the cache
+// statistics as JSON, e.g. to be mounted at /cache/stats.
+func (c *Client) StatsHandler() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		return ctx.JSON(http.StatusOK, c.Stats())
+	}
+}
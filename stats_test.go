@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type statsAdapterMock struct {
+	adapterMock
+}
+
+func (a *statsAdapterMock) AdapterStats() AdapterStats {
+	a.Lock()
+	defer a.Unlock()
+	return AdapterStats{Entries: len(a.store), Evictions: 3, SizeBytes: 42}
+}
+
+func TestStatsHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		adapter Adapter
+		want    map[string]interface{}
+	}{
+		{
+			"reports client and adapter stats",
+			&statsAdapterMock{adapterMock{store: map[uint64][]byte{}}},
+			map[string]interface{}{
+				"hits":       float64(2),
+				"misses":     float64(1),
+				"sets":       float64(1),
+				"releases":   float64(0),
+				"hit_ratio":  float64(2) / 3,
+				"entries":    float64(1),
+				"evictions":  float64(3),
+				"size_bytes": float64(42),
+			},
+		},
+		{
+			"reports client stats without adapter stats",
+			&adapterMock{store: map[uint64][]byte{}},
+			map[string]interface{}{
+				"hits":      float64(2),
+				"misses":    float64(1),
+				"sets":      float64(1),
+				"releases":  float64(0),
+				"hit_ratio": float64(2) / 3,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := NewClient(
+				ClientWithAdapter(tt.adapter),
+				ClientWithTTL(1*time.Minute),
+			)
+			handler := func(c echo.Context) error {
+				return c.String(http.StatusOK, "value")
+			}
+			mw := client.Middleware()(handler)
+			e := echo.New()
+			for i := 0; i < 3; i++ {
+				r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+				mw(e.NewContext(r, httptest.NewRecorder()))
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/cache/stats", nil)
+			w := httptest.NewRecorder()
+			if err := client.StatsHandler()(e.NewContext(r, w)); err != nil {
+				t.Fatalf("StatsHandler() error = %v", err)
+			}
+
+			got := map[string]interface{}{}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("StatsHandler() invalid JSON %v: %v", w.Body.String(), err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("StatsHandler() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	client, _ := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithRefreshKey("rk"),
+	)
+	mw := client.Middleware()(func(c echo.Context) error {
+		return c.String(http.StatusOK, "value")
+	})
+	for _, URL := range []string{
+		"http://foo.bar/test-1",
+		"http://foo.bar/test-1",
+		"http://foo.bar/test-1?rk=true",
+		"http://foo.bar/test-2",
+	} {
+		r := httptest.NewRequest(http.MethodGet, URL, nil)
+		mw(echo.New().NewContext(r, httptest.NewRecorder()))
+	}
+	client.Release("http://foo.bar/test-2")
+
+	got := client.Stats()
+	want := Stats{Hits: 1, Misses: 3, Sets: 3, Releases: 2, HitRatio: 0.25}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// CacheStatus is whether a response was served from the cache, as given
+// by the X-Cache header.
+type CacheStatus string
+
+// Cache statuses of the responses.
+const (
+	// CacheStatusHit is the status of a fresh cached response, or of a
+	// 304 Not Modified answering for it.
+	CacheStatusHit CacheStatus = "HIT"
+
+	// CacheStatusStale is the status of a stale cached response.
+	CacheStatusStale CacheStatus = "STALE"
+
+	// CacheStatusMiss is the status of a response of the handler, looked
+	// up in the cache first.
+	CacheStatusMiss CacheStatus = "MISS"
+
+	// CacheStatusBypass is the status of a response of the handler, not
+	// looked up in the cache.
+	CacheStatusBypass CacheStatus = "BYPASS"
+)
+
+const decisionContextKey = "echo-http-cache.decision"
+
+// cacheStatus returns the cache status of the request, from the X-Cache
+// header of the cached responses or from the caching decision.
+func cacheStatus(ctx echo.Context) CacheStatus {
+	switch status := CacheStatus(ctx.Response().Header().Get("X-Cache")); status {
+	case CacheStatusHit, CacheStatusStale:
+		return status
+	}
+	switch ctx.Get(decisionContextKey) {
+	case DecisionHit:
+		return CacheStatusHit
+	case DecisionBypass:
+		return CacheStatusBypass
+	}
+	return CacheStatusMiss
+}
+
+// trackStatus sets the X-Cache header of the responses of the handler, if
+// enabled, and calls the hook with the cache status and the on miss
+// callback with the miss reason once the request is served, if set. It
+// returns the function to defer until then.
+func (c *Client) trackStatus(ctx echo.Context) func() {
+	if c.statusHeader {
+		ctx.Response().Before(func() {
+			if ctx.Response().Header().Get("X-Cache") == "" {
+				ctx.Response().Header().Set("X-Cache", string(cacheStatus(ctx)))
+			}
+		})
+	}
+	return func() {
+		if c.hook != nil {
+			c.hook(ctx, cacheStatus(ctx))
+		}
+		if c.onMiss != nil {
+			if reason, ok := missReason(ctx); ok {
+				c.onMiss(ctx, reason)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddlewareStatus(t *testing.T) {
+	var statuses []CacheStatus
+	client, _ := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithETag(true),
+		ClientWithRespectRequestCacheControl(true),
+		ClientWithStatusHeader(true),
+		ClientWithHook(func(c echo.Context, status CacheStatus) {
+			statuses = append(statuses, status)
+		}),
+	)
+	handler := client.Middleware()(func(c echo.Context) error {
+		return c.String(http.StatusOK, "value")
+	})
+	serve := func(method, cacheControl, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "http://foo.bar/test-1", nil)
+		r.Header.Set("Cache-Control", cacheControl)
+		r.Header.Set("If-None-Match", ifNoneMatch)
+		w := httptest.NewRecorder()
+		handler(echo.New().NewContext(r, w))
+		return w
+	}
+
+	etag := ""
+	tests := []struct {
+		name         string
+		method       string
+		cacheControl string
+		ifNoneMatch  bool
+		wantCode     int
+		want         CacheStatus
+	}{
+		{"misses first request", http.MethodGet, "", false, http.StatusOK, CacheStatusMiss},
+		{"hits cached response", http.MethodGet, "", false, http.StatusOK, CacheStatusHit},
+		{"hits with 304 Not Modified", http.MethodGet, "", true, http.StatusNotModified, CacheStatusHit},
+		{"bypasses request no-store", http.MethodGet, "no-store", false, http.StatusOK, CacheStatusBypass},
+		{"misses request no-cache", http.MethodGet, "no-cache", false, http.StatusOK, CacheStatusMiss},
+		{"bypasses uncacheable method", http.MethodDelete, "", false, http.StatusOK, CacheStatusBypass},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ifNoneMatch := ""
+			if tt.ifNoneMatch {
+				ifNoneMatch = etag
+			}
+			w := serve(tt.method, tt.cacheControl, ifNoneMatch)
+			if w.Header().Get("ETag") != "" {
+				etag = w.Header().Get("ETag")
+			}
+
+			if w.Code != tt.wantCode {
+				t.Errorf("*Client.Middleware() status code = %v, want %v", w.Code, tt.wantCode)
+			}
+			if got := w.Header().Get("X-Cache"); got != string(tt.want) {
+				t.Errorf("*Client.Middleware() X-Cache = %v, want %v", got, tt.want)
+			}
+			if len(statuses) != i+1 || statuses[i] != tt.want {
+				t.Errorf("hook statuses = %v, want %v last", statuses, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareStatusHeaderDisabled(t *testing.T) {
+	var statuses []CacheStatus
+	client, _ := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithHook(func(c echo.Context, status CacheStatus) {
+			statuses = append(statuses, status)
+		}),
+	)
+	handler := client.Middleware()(func(c echo.Context) error {
+		return c.String(http.StatusOK, "value")
+	})
+
+	wantHeaders := []string{"", "HIT"}
+	for i, want := range []CacheStatus{CacheStatusMiss, CacheStatusHit} {
+		r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+		w := httptest.NewRecorder()
+		handler(echo.New().NewContext(r, w))
+		if got := w.Header().Get("X-Cache"); got != wantHeaders[i] {
+			t.Errorf("*Client.Middleware() X-Cache = %v, want %v", got, wantHeaders[i])
+		}
+		if statuses[i] != want {
+			t.Errorf("hook status = %v, want %v", statuses[i], want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// StreamAdapter is implemented by the adapters able to store large cached
+// response values apart from the response, and stream them back without
+// loading them in memory at once.
+type StreamAdapter interface {
+	// SetStream stores the response value read from the given reader for
+	// a given key until an expiration date.
+	SetStream(key uint64, value io.Reader, expiration time.Time) error
+
+	// GetStream returns a reader of the response value stored for a given
+	// key. It also returns true or false, whether it exists or not.
+	GetStream(key uint64) (io.ReadCloser, bool)
+}
+
+// errStreamMissing is returned when the streamed value of a cached
+// response is gone, e.g. evicted apart from the response.
+var errStreamMissing = errors.New("cache: streamed response value is missing")
+
+// storeStream stores the response value apart, if it is large enough to
+// be streamed, and returns the response without it.
+func (c *Client) storeStream(key uint64, response Response) Response {
+	if c.streamThreshold == 0 || len(response.Value) < c.streamThreshold {
+		return response
+	}
+	sa := c.adapter.(StreamAdapter)
+	if err := sa.SetStream(key, bytes.NewReader(response.Value), c.storedUntil(response.Expiration)); err != nil {
+		return response
+	}
+	response.StreamSize = int64(len(response.Value))
+	response.Value = nil
+	return response
+}
+
+// openStream returns a reader of the value of the cached response, stored
+// apart or not.
+func (c *Client) openStream(key uint64, response Response) (io.ReadCloser, error) {
+	if response.StreamSize == 0 {
+		return ioutil.NopCloser(bytes.NewReader(response.Value)), nil
+	}
+	sa, ok := c.adapter.(StreamAdapter)
+	if !ok {
+		return nil, errStreamMissing
+	}
+	r, ok := sa.GetStream(key)
+	if !ok {
+		return nil, errStreamMissing
+	}
+	return r, nil
+}
+
+// refreshStream stores again the value of the cached response stored
+// apart, for it to expire with the refreshed response. The value is
+// streamed from the adapter back to it.
+func (c *Client) refreshStream(key uint64, response Response) {
+	if response.StreamSize == 0 {
+		return
+	}
+	sa, ok := c.adapter.(StreamAdapter)
+	if !ok {
+		return
+	}
+	if r, ok := sa.GetStream(key); ok {
+		defer r.Close()
+		sa.SetStream(key, r, c.storedUntil(response.Expiration))
+	}
+}
@@ -0,0 +1,48 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+const surrogateContextKey = "echo-http-cache.surrogate"
+
+// captureSurrogateControl keeps the Surrogate-Control header values of the
+// response for storeResponse, and strips them from the response unless
+// they are forwarded.
+func (c *Client) captureSurrogateControl(ctx echo.Context) {
+	ctx.Response().Before(func() {
+		header := ctx.Response().Header()
+		values := header.Values("Surrogate-Control")
+		if len(values) == 0 {
+			return
+		}
+		ctx.Set(surrogateContextKey, parseDirectives(values))
+		if !c.forwardSurrogate {
+			header.Del("Surrogate-Control")
+		}
+	})
+}
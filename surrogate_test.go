@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddlewareSurrogateControl(t *testing.T) {
+	tests := []struct {
+		name             string
+		forward          bool
+		surrogateControl string
+		wantTTL          time.Duration
+		wantStored       bool
+		wantHeader       string
+	}{
+		{
+			"surrogate max-age overrides ttl",
+			false,
+			"max-age=3600",
+			time.Hour,
+			true,
+			"",
+		},
+		{
+			"surrogate no-store prevents caching",
+			false,
+			"no-store",
+			0,
+			false,
+			"",
+		},
+		{
+			"without surrogate control uses ttl",
+			false,
+			"",
+			time.Minute,
+			true,
+			"",
+		},
+		{
+			"forwards surrogate control",
+			true,
+			"max-age=3600",
+			time.Hour,
+			true,
+			"max-age=3600",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := &adapterMock{store: map[uint64][]byte{}}
+			client, _ := NewClient(
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1*time.Minute),
+				ClientWithSurrogateControl(true),
+				ClientWithForwardSurrogateControl(tt.forward),
+			)
+			handler := func(c echo.Context) error {
+				c.Response().Header().Set("Cache-Control", "max-age=10")
+				if tt.surrogateControl != "" {
+					c.Response().Header().Set("Surrogate-Control", tt.surrogateControl)
+				}
+				return c.String(http.StatusOK, "value")
+			}
+			mw := client.Middleware()(handler)
+			e := echo.New()
+
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+			w := httptest.NewRecorder()
+			mw(e.NewContext(r, w))
+
+			if got := w.Header().Get("Surrogate-Control"); got != tt.wantHeader {
+				t.Errorf("*Client.Middleware() Surrogate-Control = %v, want %v", got, tt.wantHeader)
+			}
+			if got := w.Header().Get("Cache-Control"); got != "max-age=10" {
+				t.Errorf("*Client.Middleware() Cache-Control = %v, want max-age=10", got)
+			}
+
+			b, ok := adapter.store[generateKey("http://foo.bar/test", []string{})]
+			if ok != tt.wantStored {
+				t.Fatalf("stored = %v, want %v", ok, tt.wantStored)
+			}
+			if !ok {
+				return
+			}
+			response := BytesToResponse(b)
+			if got := response.Expiration.Sub(response.Created); got != tt.wantTTL {
+				t.Errorf("stored ttl = %v, want %v", got, tt.wantTTL)
+			}
+
+			r = httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+			w = httptest.NewRecorder()
+			mw(e.NewContext(r, w))
+			if got := w.Header().Get("X-Cache"); got != "HIT" {
+				t.Errorf("*Client.Middleware() X-Cache = %v, want HIT", got)
+			}
+			if got := w.Header().Get("Surrogate-Control"); got != tt.wantHeader {
+				t.Errorf("cached Surrogate-Control = %v, want %v", got, tt.wantHeader)
+			}
+		})
+	}
+}
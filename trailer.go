@@ -0,0 +1,76 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"net/http"
+	"strings"
+)
+
+// hasTrailers reports whether the response declares trailers, with the
+// Trailer header, or sets some with the http.TrailerPrefix.
+func hasTrailers(header http.Header) bool {
+	if header.Get("Trailer") != "" {
+		return true
+	}
+	for k := range header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitTrailers returns the response header without the trailer values,
+// set after the body, and the trailer values apart. The Trailer header
+// declaring them is kept.
+func splitTrailers(header http.Header) (http.Header, http.Header) {
+	if !hasTrailers(header) {
+		return header, nil
+	}
+	header = header.Clone()
+	trailer := http.Header{}
+	for _, name := range splitNames(strings.Join(header.Values("Trailer"), ","), true) {
+		if v, ok := header[name]; ok {
+			trailer[name] = v
+			delete(header, name)
+		}
+	}
+	for k, v := range header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			trailer[k] = v
+			delete(header, k)
+		}
+	}
+	return header, trailer
+}
+
+// writeTrailers sets the trailer values once the body is written, for
+// them to be sent after it.
+func writeTrailers(header, trailer http.Header) {
+	for k, v := range trailer {
+		header[k] = append([]string{}, v...)
+	}
+}
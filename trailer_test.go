@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddlewareTrailers(t *testing.T) {
+	declared := func(c echo.Context) error {
+		c.Response().Header().Set("Trailer", "X-Checksum")
+		if err := c.String(http.StatusOK, "value"); err != nil {
+			return err
+		}
+		c.Response().Header().Set("X-Checksum", "abc")
+		return nil
+	}
+	prefixed := func(c echo.Context) error {
+		if err := c.String(http.StatusOK, "value"); err != nil {
+			return err
+		}
+		c.Response().Header().Set(http.TrailerPrefix+"X-Checksum", "abc")
+		return nil
+	}
+	plain := func(c echo.Context) error {
+		return c.String(http.StatusOK, "value")
+	}
+
+	tests := []struct {
+		name        string
+		trailers    bool
+		handler     echo.HandlerFunc
+		wantCached  bool
+		wantTrailer string
+	}{
+		{"does not cache declared trailers by default", false, declared, false, ""},
+		{"does not cache prefixed trailers by default", false, prefixed, false, ""},
+		{"caches responses without trailers", false, plain, true, ""},
+		{"caches and replays declared trailers", true, declared, true, "abc"},
+		{"caches and replays prefixed trailers", true, prefixed, true, "abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := NewClient(
+				ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+				ClientWithTTL(1*time.Minute),
+				ClientWithTrailers(tt.trailers),
+			)
+			calls := 0
+			handler := client.Middleware()(func(c echo.Context) error {
+				calls++
+				return tt.handler(c)
+			})
+
+			var w *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test-1", nil)
+				w = httptest.NewRecorder()
+				handler(echo.New().NewContext(r, w))
+			}
+
+			if cached := calls == 1; cached != tt.wantCached {
+				t.Errorf("*Client.Middleware() cached = %v, want %v", cached, tt.wantCached)
+			}
+			if w.Body.String() != "value" {
+				t.Errorf("*Client.Middleware() body = %v, want value", w.Body.String())
+			}
+			if !tt.wantCached {
+				return
+			}
+			res := w.Result()
+			if got := res.Trailer.Get("X-Checksum"); got != tt.wantTrailer {
+				t.Errorf("*Client.Middleware() trailer = %q, want %q", got, tt.wantTrailer)
+			}
+			if got := res.Header.Get("X-Checksum"); got != "" {
+				t.Errorf("*Client.Middleware() header X-Checksum = %q, want none", got)
+			}
+		})
+	}
+}
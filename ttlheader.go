@@ -0,0 +1,51 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const ttlHeaderContextKey = "echo-http-cache.ttl-header"
+
+// captureTTLHeader keeps the TTL set by the handler in the TTL header of
+// the response for storeResponse, and strips the header from the response.
+// Values other than a positive number of seconds are ignored.
+func (c *Client) captureTTLHeader(ctx echo.Context) {
+	ctx.Response().Before(func() {
+		header := ctx.Response().Header()
+		value := header.Get(c.ttlHeader)
+		if value == "" {
+			return
+		}
+		header.Del(c.ttlHeader)
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds > 0 {
+			ctx.Set(ttlHeaderContextKey, time.Duration(seconds)*time.Second)
+		}
+	})
+}
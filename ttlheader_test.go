@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddlewareTTLHeader(t *testing.T) {
+	tests := []struct {
+		name         string
+		ttlHeader    string
+		cacheControl string
+		plan         *CachePlan
+		wantTTL      time.Duration
+	}{
+		{
+			"header ttl overrides client ttl",
+			"120",
+			"",
+			nil,
+			2 * time.Minute,
+		},
+		{
+			"header ttl overrides cache control max-age",
+			"120",
+			"max-age=10",
+			nil,
+			2 * time.Minute,
+		},
+		{
+			"without header uses cache control max-age",
+			"",
+			"max-age=10",
+			nil,
+			10 * time.Second,
+		},
+		{
+			"invalid header ttl is ignored",
+			"soon",
+			"",
+			nil,
+			time.Minute,
+		},
+		{
+			"non-positive header ttl is ignored",
+			"0",
+			"",
+			nil,
+			time.Minute,
+		},
+		{
+			"cache plan ttl overrides header ttl",
+			"120",
+			"",
+			&CachePlan{TTL: time.Hour},
+			time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := &adapterMock{store: map[uint64][]byte{}}
+			opts := []ClientOption{
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1 * time.Minute),
+				ClientWithCacheControl(true),
+				ClientWithTTLHeader("x-cache-ttl"),
+			}
+			if tt.plan != nil {
+				opts = append(opts, ClientWithCachePlan(func(c echo.Context) (*CachePlan, bool) {
+					return tt.plan, true
+				}))
+			}
+			client, _ := NewClient(opts...)
+			handler := func(c echo.Context) error {
+				if tt.cacheControl != "" {
+					c.Response().Header().Set("Cache-Control", tt.cacheControl)
+				}
+				if tt.ttlHeader != "" {
+					c.Response().Header().Set("X-Cache-TTL", tt.ttlHeader)
+				}
+				return c.String(http.StatusOK, "value")
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+			w := httptest.NewRecorder()
+			client.Middleware()(handler)(echo.New().NewContext(r, w))
+
+			if got := w.Header().Get("X-Cache-TTL"); got != "" {
+				t.Errorf("*Client.Middleware() X-Cache-TTL = %v, want none", got)
+			}
+			if len(adapter.store) != 1 {
+				t.Fatalf("*Client.Middleware() stored %v responses, want 1", len(adapter.store))
+			}
+			for _, b := range adapter.store {
+				response := BytesToResponse(b)
+				if got := response.Expiration.Sub(response.Created); got != tt.wantTTL {
+					t.Errorf("*Client.Middleware() TTL = %v, want %v", got, tt.wantTTL)
+				}
+				if got := response.Header.Get("X-Cache-TTL"); got != "" {
+					t.Errorf("*Client.Middleware() stored X-Cache-TTL = %v, want none", got)
+				}
+			}
+		})
+	}
+
+	if _, err := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithTTLHeader(""),
+	); err == nil {
+		t.Error("NewClient() with an empty ttl header should fail")
+	}
+}
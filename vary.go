@@ -0,0 +1,111 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// VaryHeader is the response header the handler declares the request
+// headers and classifiers the response varies on with, as a comma
+// separated list of names. It is stripped from the response.
+const VaryHeader = "X-Cache-Vary"
+
+const (
+	varyContextKey     = "echo-http-cache.vary"
+	varyAnyContextKey  = "echo-http-cache.vary-any"
+	cacheKeyContextKey = "echo-http-cache.key"
+)
+
+// captureVary strips the vary header declared by the handler from the
+// response, and keeps the declared names for storeResponse. If the
+// standard Vary header is honored, the request headers it names are kept
+// too, and Vary: * marks the response as not cacheable.
+func (c *Client) captureVary(ctx echo.Context, key uint64) {
+	ctx.Set(cacheKeyContextKey, key)
+	ctx.Response().Before(func() {
+		header := ctx.Response().Header()
+		names := splitNames(header.Get(VaryHeader), false)
+		header.Del(VaryHeader)
+		if c.vary {
+			for _, name := range splitNames(strings.Join(header.Values("Vary"), ","), true) {
+				if name == "*" {
+					ctx.Set(varyAnyContextKey, true)
+					return
+				}
+				if !containsName(names, name) {
+					names = append(names, name)
+				}
+			}
+		}
+		if len(names) > 0 {
+			ctx.Set(varyContextKey, names)
+		}
+	})
+}
+
+// splitNames splits a comma separated list of names, canonicalized as
+// header names if asked.
+func splitNames(value string, canonical bool) []string {
+	names := []string{}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if canonical {
+			name = http.CanonicalHeaderKey(name)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// containsName reports whether the names contain the given one, ignoring
+// case.
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// varyKey returns the cache key of the response varying on the given
+// request headers or classifiers.
+func (c *Client) varyKey(r *http.Request, key uint64, names []string) uint64 {
+	values := []string{}
+	for _, name := range names {
+		value := r.Header.Get(name)
+		if classifier, ok := c.classifiers[strings.ToLower(name)]; ok {
+			value = classifier(r)
+		}
+		values = append(values, ";"+name+"="+value)
+	}
+	return c.generateKey(KeyAsString(key), values, nil)
+}
@@ -0,0 +1,197 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddlewareOriginVary(t *testing.T) {
+	calls := 0
+	handler := func(c echo.Context) error {
+		calls++
+		c.Response().Header().Set(VaryHeader, "X-Tenant, device")
+		return c.String(http.StatusOK, fmt.Sprintf("value %v", calls))
+	}
+	client, _ := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithClassifier("device", func(r *http.Request) string {
+			if strings.Contains(r.UserAgent(), "Mobile") {
+				return "mobile"
+			}
+			return "desktop"
+		}),
+	)
+	mw := client.Middleware()(handler)
+	e := echo.New()
+
+	tests := []struct {
+		name      string
+		tenant    string
+		userAgent string
+		wantBody  string
+		wantCache string
+	}{
+		{
+			"miss declares the vary set",
+			"a",
+			"Firefox",
+			"value 1",
+			"",
+		},
+		{
+			"hits same tenant and device",
+			"a",
+			"Chrome",
+			"value 1",
+			"HIT",
+		},
+		{
+			"misses other tenant",
+			"b",
+			"Firefox",
+			"value 2",
+			"",
+		},
+		{
+			"misses other device",
+			"a",
+			"Mobile Safari",
+			"value 3",
+			"",
+		},
+		{
+			"hits other tenant",
+			"b",
+			"Chrome",
+			"value 2",
+			"HIT",
+		},
+		{
+			"hits other device",
+			"a",
+			"Mobile Chrome",
+			"value 3",
+			"HIT",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+			r.Header.Set("X-Tenant", tt.tenant)
+			r.Header.Set("User-Agent", tt.userAgent)
+			w := httptest.NewRecorder()
+			mw(e.NewContext(r, w))
+
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("*Client.Middleware() = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+			if got := w.Header().Get("X-Cache"); got != tt.wantCache {
+				t.Errorf("*Client.Middleware() X-Cache = %v, want %v", got, tt.wantCache)
+			}
+			if got := w.Header().Get(VaryHeader); got != "" {
+				t.Errorf("*Client.Middleware() %v = %v, want stripped", VaryHeader, got)
+			}
+		})
+	}
+}
+
+func TestClientWithClassifier(t *testing.T) {
+	tests := []struct {
+		name       string
+		classifier string
+		fn         func(r *http.Request) string
+		wantErr    bool
+	}{
+		{"valid classifier", "device", func(r *http.Request) string { return "" }, false},
+		{"empty name", "", func(r *http.Request) string { return "" }, true},
+		{"nil function", "device", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewClient(
+				ClientWithAdapter(&adapterMock{}),
+				ClientWithTTL(1*time.Minute),
+				ClientWithClassifier(tt.classifier, tt.fn),
+			)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMiddlewareStandardVary(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		vary      string
+		encodings []string
+		wantBody  []string
+		wantCache []string
+	}{
+		{
+			"varies on the request headers named by Vary",
+			true,
+			"accept-encoding",
+			[]string{"gzip", "identity", "gzip", "identity"},
+			[]string{"value 1 gzip", "value 2 identity", "value 1 gzip", "value 2 identity"},
+			[]string{"", "", "HIT", "HIT"},
+		},
+		{
+			"never caches Vary: *",
+			true,
+			"*",
+			[]string{"gzip", "gzip"},
+			[]string{"value 1 gzip", "value 2 gzip"},
+			[]string{"", ""},
+		},
+		{
+			"ignores Vary unless enabled",
+			false,
+			"Accept-Encoding",
+			[]string{"gzip", "identity"},
+			[]string{"value 1 gzip", "value 1 gzip"},
+			[]string{"", "HIT"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			handler := func(c echo.Context) error {
+				calls++
+				c.Response().Header().Set("Vary", tt.vary)
+				return c.String(http.StatusOK, fmt.Sprintf("value %v %s", calls, c.Request().Header.Get("Accept-Encoding")))
+			}
+			client, _ := NewClient(
+				ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+				ClientWithTTL(1*time.Minute),
+				ClientWithVary(tt.enabled),
+			)
+			mw := client.Middleware()(handler)
+
+			for i, encoding := range tt.encodings {
+				r := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+				r.Header.Set("Accept-Encoding", encoding)
+				w := httptest.NewRecorder()
+				mw(echo.New().NewContext(r, w))
+
+				if w.Body.String() != tt.wantBody[i] {
+					t.Errorf("*Client.Middleware() request %v = %v, want %v", i, w.Body.String(), tt.wantBody[i])
+				}
+				if got := w.Header().Get("X-Cache"); got != tt.wantCache[i] {
+					t.Errorf("*Client.Middleware() request %v X-Cache = %v, want %v", i, got, tt.wantCache[i])
+				}
+				if got := w.Header().Get("Vary"); got != tt.vary {
+					t.Errorf("*Client.Middleware() request %v Vary = %v, want %v", i, got, tt.vary)
+				}
+			}
+		})
+	}
+}
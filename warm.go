@@ -0,0 +1,115 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// warm fetches the critical URLs in the background and caches their
+// responses as for GET requests without headers. The warmed channel is
+// closed once they are all fetched.
+func (c *Client) warm() {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for _, URL := range c.criticalURLs {
+		wg.Add(1)
+		go func(URL string) {
+			defer wg.Done()
+			if err := c.warmURL(URL); err != nil {
+				mutex.Lock()
+				if c.warmErr == nil {
+					c.warmErr = err
+				}
+				mutex.Unlock()
+			}
+		}(URL)
+	}
+
+	go func() {
+		wg.Wait()
+		close(c.warmed)
+	}()
+}
+
+// warmURL fetches the given URL and caches its response, if it is
+// cacheable.
+func (c *Client) warmURL(URL string) error {
+	res, err := c.warmFetch(URL)
+	if err != nil {
+		return fmt.Errorf("cache client failed to warm %s: %v", URL, err)
+	}
+	defer res.Body.Close()
+	value, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("cache client failed to warm %s: %v", URL, err)
+	}
+	if !c.cacheableStatusCode(res.StatusCode, parseCacheControl(res.Header)) {
+		return nil
+	}
+	if len(value) == 0 && c.skipEmptyBody {
+		return nil
+	}
+
+	u, _ := url.Parse(URL)
+	c.canonicalizeURL(u)
+	key, stringKey := c.urlKeys(u)
+	now := time.Now()
+	response := c.storeStream(key, Response{
+		Value:      value,
+		Header:     withoutHopByHop(res.Header),
+		StatusCode: res.StatusCode,
+		Expiration: now.Add(c.withMinTTL(c.entryTTL(c.pathTTL("", u.Path), nil, value))),
+		Created:    now,
+		LastAccess: now,
+		Frequency:  1,
+		Key:        stringKey,
+	})
+	c.adapter.Set(key, c.encode(response), c.storedUntil(response.Expiration))
+	atomic.AddInt64(&c.sets, 1)
+	c.indexKey(key, u.String(), response.Expiration)
+	return nil
+}
+
+// WaitWarm blocks until the critical URLs set by ClientWithCriticalURLs
+// are fetched and cached, or the context is done. It returns the first
+// fetch error, if any. It returns immediately without critical URLs.
+func (c *Client) WaitWarm(ctx context.Context) error {
+	if c.warmed == nil {
+		return nil
+	}
+	select {
+	case <-c.warmed:
+		return c.warmErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestWaitWarm(t *testing.T) {
+	release := make(chan struct{})
+	fetch := func(URL string) (*http.Response, error) {
+		<-release
+		if strings.HasSuffix(URL, "/fail") {
+			return nil, errors.New("unreachable")
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/plain"}},
+			Body:       ioutil.NopCloser(strings.NewReader("warm " + URL)),
+		}, nil
+	}
+
+	tests := []struct {
+		name    string
+		URLs    []string
+		wantErr bool
+	}{
+		{
+			"caches the critical URLs",
+			[]string{"http://foo.bar/a", "http://foo.bar/b?y=2&x=1"},
+			false,
+		},
+		{
+			"returns the fetch errors",
+			[]string{"http://foo.bar/a", "http://foo.bar/fail"},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release = make(chan struct{})
+			client, err := NewClient(
+				ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+				ClientWithTTL(time.Minute),
+				ClientWithCriticalURLs(tt.URLs, fetch),
+			)
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			if err := client.WaitWarm(ctx); err != context.DeadlineExceeded {
+				t.Errorf("WaitWarm() error = %v before the fetches complete, want %v", err, context.DeadlineExceeded)
+			}
+
+			close(release)
+			if err := client.WaitWarm(context.Background()); (err != nil) != tt.wantErr {
+				t.Fatalf("WaitWarm() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			calls := 0
+			handler := client.Middleware()(func(c echo.Context) error {
+				calls++
+				return c.String(http.StatusOK, "fresh")
+			})
+			for _, URL := range []string{"http://foo.bar/a", "http://foo.bar/b?x=1&y=2"} {
+				r := httptest.NewRequest(http.MethodGet, URL, nil)
+				w := httptest.NewRecorder()
+				handler(echo.New().NewContext(r, w))
+				if got := w.Header().Get("X-Cache"); got != "HIT" {
+					t.Errorf("X-Cache of %s = %v, want HIT", URL, got)
+				}
+				if !strings.HasPrefix(w.Body.String(), "warm ") {
+					t.Errorf("*Client.Middleware() body of %s = %v, want the warmed response", URL, w.Body.String())
+				}
+			}
+			if calls != 0 {
+				t.Errorf("handler calls = %v, want 0", calls)
+			}
+		})
+	}
+}
+
+func TestWaitWarmWithoutCriticalURLs(t *testing.T) {
+	client, _ := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(time.Minute),
+	)
+	if err := client.WaitWarm(context.Background()); err != nil {
+		t.Errorf("WaitWarm() error = %v, want nil", err)
+	}
+}